@@ -0,0 +1,264 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifact
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/craftslab/metalflow/model"
+)
+
+const (
+	maxName = 255
+)
+
+// Artifacts keeps files content-addressed by their SHA-256 in projects, for
+// the workers to download through URLs signed for Ttl, without a token. The
+// artifacts are read and added within the model.Scope of ctx, like nodes.
+type Artifacts interface {
+	Add(ctx context.Context, project uint, name, creator string, r io.Reader) (*Artifact, error)
+	Get(ctx context.Context, project uint, sum string) (*Artifact, error)
+	Query(ctx context.Context) ([]Artifact, error)
+	Read(ctx context.Context, project uint, sum string) (*Artifact, []byte, error)
+	URL(ctx context.Context, project uint, sum string) (string, error)
+	Verify(project uint, sum, expires, signature string) error
+}
+
+// Config keeps the content in Blobs, and the artifacts in Store. Url is the
+// base URL of the API the URLs of artifacts are signed under with Secret,
+// which shall be shared by all the masters.
+type Config struct {
+	Blobs   Blobs
+	MaxSize int64
+	Secret  []byte
+	Store   Store
+	Ttl     time.Duration
+	Url     string
+}
+
+// Artifact is a file kept by its SHA-256 in a project. Url is signed when it
+// is got.
+type Artifact struct {
+	CreatedAt time.Time `json:"createdAt"`
+	Creator   string    `json:"creator"`
+	Name      string    `json:"name"`
+	Project   uint      `gorm:"primaryKey;autoIncrement:false" json:"project"`
+	Sha256    string    `gorm:"primaryKey" json:"sha256"`
+	Size      int64     `json:"size"`
+	Url       string    `gorm:"-" json:"url,omitempty"`
+}
+
+type artifacts struct {
+	config *Config
+}
+
+var (
+	ErrNotFound  = errors.New("invalid sha256")
+	ErrSignature = errors.New("invalid signature")
+	ErrTooLarge  = errors.New("artifact too large")
+)
+
+func New(config *Config) Artifacts {
+	return &artifacts{
+		config: config,
+	}
+}
+
+func DefaultConfig() *Config {
+	secret := make([]byte, 32)
+	_, _ = rand.Read(secret)
+
+	return &Config{
+		Blobs:   NewMemoryBlobs(),
+		MaxSize: 32 << 20,
+		Secret:  secret,
+		Store:   NewMemoryStore(),
+		Ttl:     time.Hour,
+		Url:     "http://127.0.0.1:9080/api/v1",
+	}
+}
+
+// Add returns the artifact of the same content in the project if any, which
+// keeps its name. Only the maintainers of the project may add.
+func (a *artifacts) Add(ctx context.Context, project uint, name, creator string, r io.Reader) (*Artifact, error) {
+	if strings.ContainsAny(name, "/\\") || len(name) > maxName {
+		return nil, errors.New("invalid name")
+	}
+
+	if _, err := model.GetProject(ctx, project); err != nil {
+		return nil, err
+	}
+
+	if !model.ScopeOf(ctx).CanWrite(project) {
+		return nil, model.ErrForbidden
+	}
+
+	buf, err := ioutil.ReadAll(io.LimitReader(r, a.config.MaxSize+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read")
+	}
+
+	if int64(len(buf)) > a.config.MaxSize {
+		return nil, ErrTooLarge
+	}
+
+	h := sha256.Sum256(buf)
+	sum := hex.EncodeToString(h[:])
+
+	if v, err := a.Get(ctx, project, sum); err == nil {
+		return v, nil
+	}
+
+	if name == "" {
+		name = sum
+	}
+
+	if err := a.config.Blobs.Put(ctx, sum, buf); err != nil {
+		return nil, errors.Wrap(err, "failed to put blob")
+	}
+
+	v := &Artifact{
+		CreatedAt: time.Now(),
+		Creator:   creator,
+		Name:      name,
+		Project:   project,
+		Sha256:    sum,
+		Size:      int64(len(buf)),
+	}
+
+	if err := a.config.Store.AddArtifact(ctx, v); err != nil {
+		return nil, errors.Wrap(err, "failed to add")
+	}
+
+	v.Url = a.sign(project, sum)
+
+	return v, nil
+}
+
+// Get returns the artifact if the project may be read.
+func (a *artifacts) Get(ctx context.Context, project uint, sum string) (*Artifact, error) {
+	if !model.ScopeOf(ctx).CanRead(project) {
+		return nil, ErrNotFound
+	}
+
+	v, err := a.config.Store.GetArtifact(ctx, project, sum)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get")
+	}
+
+	if v == nil {
+		return nil, ErrNotFound
+	}
+
+	v.Url = a.sign(project, sum)
+
+	return v, nil
+}
+
+// Query returns the artifacts of the projects which may be read, without URL.
+func (a *artifacts) Query(ctx context.Context) ([]Artifact, error) {
+	all, err := a.config.Store.QueryArtifact(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s := model.ScopeOf(ctx)
+	buf := make([]Artifact, 0, len(all))
+
+	for _, v := range all {
+		if s.CanRead(v.Project) {
+			buf = append(buf, v)
+		}
+	}
+
+	return buf, nil
+}
+
+// Read returns the content of the artifact, once checked against its sum. It
+// serves the signed URLs, so it does not check the scope.
+func (a *artifacts) Read(ctx context.Context, project uint, sum string) (*Artifact, []byte, error) {
+	v, err := a.config.Store.GetArtifact(ctx, project, sum)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to get")
+	}
+
+	if v == nil {
+		return nil, nil, ErrNotFound
+	}
+
+	buf, err := a.config.Blobs.Get(ctx, sum)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to get blob")
+	}
+
+	if h := sha256.Sum256(buf); hex.EncodeToString(h[:]) != sum {
+		return nil, nil, errors.New("checksum mismatch")
+	}
+
+	return v, buf, nil
+}
+
+func (a *artifacts) URL(ctx context.Context, project uint, sum string) (string, error) {
+	v, err := a.Get(ctx, project, sum)
+	if err != nil {
+		return "", err
+	}
+
+	return v.Url, nil
+}
+
+func (a *artifacts) Verify(project uint, sum, expires, signature string) error {
+	t, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > t {
+		return ErrSignature
+	}
+
+	buf, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(buf, a.mac(project, sum, expires)) {
+		return ErrSignature
+	}
+
+	return nil
+}
+
+// sign returns the URL of the content of the artifact of sum in project, valid
+// for Ttl.
+func (a *artifacts) sign(project uint, sum string) string {
+	expires := strconv.FormatInt(time.Now().Add(a.config.Ttl).Unix(), 10)
+
+	q := url.Values{}
+	q.Set("expires", expires)
+	q.Set("project", strconv.FormatUint(uint64(project), 10))
+	q.Set("signature", hex.EncodeToString(a.mac(project, sum, expires)))
+
+	return strings.TrimSuffix(a.config.Url, "/") + "/artifacts/" + sum + "/content?" + q.Encode()
+}
+
+func (a *artifacts) mac(project uint, sum, expires string) []byte {
+	h := hmac.New(sha256.New, a.config.Secret)
+	_, _ = h.Write([]byte(strconv.FormatUint(uint64(project), 10) + ":" + sum + ":" + expires))
+
+	return h.Sum(nil)
+}
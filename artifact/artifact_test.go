@@ -0,0 +1,113 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifact
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/craftslab/metalflow/model"
+)
+
+const (
+	sum = "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+)
+
+func TestArtifacts(t *testing.T) {
+	ctx := model.WithScope(context.Background(), &model.Scope{All: true})
+
+	c := DefaultConfig()
+	c.Blobs = NewDiskBlobs(t.TempDir())
+	c.MaxSize = 8
+
+	a := New(c)
+
+	v, err := a.Add(ctx, 1, "foo.txt", "admin", strings.NewReader("foo"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, sum, v.Sha256)
+	assert.Equal(t, int64(3), v.Size)
+
+	// The same content is kept once per project, with its first name.
+	v, err = a.Add(ctx, 1, "bar.txt", "john", strings.NewReader("foo"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "foo.txt", v.Name)
+
+	_, err = a.Add(ctx, 1, "big", "admin", bytes.NewReader(make([]byte, 9)))
+	assert.Equal(t, ErrTooLarge, err)
+
+	_, err = a.Add(ctx, 1, "../foo", "admin", strings.NewReader("foo"))
+	assert.NotEqual(t, nil, err)
+
+	_, err = a.Get(ctx, 1, strings.Repeat("0", 64))
+	assert.Equal(t, ErrNotFound, err)
+
+	v, err = a.Add(ctx, 0, "bar.txt", "admin", strings.NewReader("foo"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "bar.txt", v.Name)
+
+	buf, err := a.Query(ctx)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(buf))
+
+	// Members only see and add the artifacts of their projects.
+	viewer := model.WithScope(ctx, &model.Scope{Projects: map[uint]string{1: model.ProjectRoleViewer}})
+
+	buf, err = a.Query(viewer)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(buf))
+	assert.Equal(t, uint(1), buf[0].Project)
+
+	_, err = a.Get(viewer, 0, sum)
+	assert.Equal(t, ErrNotFound, err)
+
+	_, err = a.Add(viewer, 1, "baz.txt", "john", strings.NewReader("baz"))
+	assert.Equal(t, model.ErrForbidden, err)
+
+	_, err = a.Add(ctx, 9, "baz.txt", "admin", strings.NewReader("baz"))
+	assert.Equal(t, model.ErrProjectNotFound, err)
+
+	_, content, err := a.Read(ctx, 1, sum)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "foo", string(content))
+
+	s, err := a.URL(ctx, 1, sum)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, strings.HasPrefix(s, "http://127.0.0.1:9080/api/v1/artifacts/"+sum+"/content?"))
+
+	u, err := url.Parse(s)
+	assert.Equal(t, nil, err)
+
+	q := u.Query()
+
+	assert.Equal(t, "1", q.Get("project"))
+
+	err = a.Verify(1, sum, q.Get("expires"), q.Get("signature"))
+	assert.Equal(t, nil, err)
+
+	err = a.Verify(0, sum, q.Get("expires"), q.Get("signature"))
+	assert.Equal(t, ErrSignature, err)
+
+	err = a.Verify(1, strings.Repeat("0", 64), q.Get("expires"), q.Get("signature"))
+	assert.Equal(t, ErrSignature, err)
+
+	err = a.Verify(1, sum, "1", q.Get("signature"))
+	assert.Equal(t, ErrSignature, err)
+
+	err = New(DefaultConfig()).Verify(1, sum, q.Get("expires"), q.Get("signature"))
+	assert.Equal(t, ErrSignature, err)
+}
@@ -0,0 +1,175 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifact
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/craftslab/metalflow/postgres"
+)
+
+// Blobs keeps the content of the artifacts by their SHA-256.
+type Blobs interface {
+	Put(ctx context.Context, sum string, buf []byte) error
+	Get(ctx context.Context, sum string) ([]byte, error)
+}
+
+// blob is the large object keeping the content of sum in PostgreSQL.
+type blob struct {
+	Oid    uint32 `gorm:"type:oid"`
+	Sha256 string `gorm:"primaryKey"`
+}
+
+type content struct {
+	Data []byte
+}
+
+type diskBlobs struct {
+	dir string
+}
+
+type memoryBlobs struct {
+	blobs map[string][]byte
+	mutex sync.RWMutex
+}
+
+type postgresBlobs struct {
+	postgres postgres.Postgres
+}
+
+func (blob) TableName() string {
+	return "artifact_blobs"
+}
+
+// NewDiskBlobs keeps the content of sum at {DIR}/{SUM[:2]}/{SUM}.
+func NewDiskBlobs(dir string) Blobs {
+	return &diskBlobs{dir: dir}
+}
+
+func NewMemoryBlobs() Blobs {
+	return &memoryBlobs{
+		blobs: map[string][]byte{},
+	}
+}
+
+// NewPostgresBlobs keeps the content in large objects, whose oids are kept
+// in the artifact_blobs table.
+func NewPostgresBlobs(p postgres.Postgres) (Blobs, error) {
+	if err := p.Migrate(&blob{}); err != nil {
+		return nil, errors.Wrap(err, "failed to migrate blob")
+	}
+
+	return &postgresBlobs{postgres: p}, nil
+}
+
+func (d *diskBlobs) Put(_ context.Context, sum string, buf []byte) error {
+	name := d.path(sum)
+
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return errors.Wrap(err, "failed to make dir")
+	}
+
+	// Written aside first, so that a partial file is never read.
+	f, err := ioutil.TempFile(filepath.Dir(name), sum+".*")
+	if err != nil {
+		return errors.Wrap(err, "failed to create")
+	}
+
+	defer func() { _ = os.Remove(f.Name()) }()
+
+	if _, err := f.Write(buf); err != nil {
+		_ = f.Close()
+		return errors.Wrap(err, "failed to write")
+	}
+
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "failed to close")
+	}
+
+	if err := os.Rename(f.Name(), name); err != nil {
+		return errors.Wrap(err, "failed to rename")
+	}
+
+	return nil
+}
+
+func (d *diskBlobs) Get(_ context.Context, sum string) ([]byte, error) {
+	buf, err := ioutil.ReadFile(d.path(sum))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read")
+	}
+
+	return buf, nil
+}
+
+func (d *diskBlobs) path(sum string) string {
+	return filepath.Join(d.dir, sum[:2], sum)
+}
+
+func (m *memoryBlobs) Put(_ context.Context, sum string, buf []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.blobs[sum] = buf
+
+	return nil
+}
+
+func (m *memoryBlobs) Get(_ context.Context, sum string) ([]byte, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	buf, ok := m.blobs[sum]
+	if !ok {
+		return nil, errors.New("invalid sha256")
+	}
+
+	return buf, nil
+}
+
+func (p *postgresBlobs) Put(ctx context.Context, sum string, buf []byte) error {
+	var b blob
+
+	// The large object is only created for new content.
+	p.postgres.WithContext(ctx).Raw(&b, `INSERT INTO artifact_blobs (sha256, oid)
+SELECT ?, lo_from_bytea(0, ?) WHERE NOT EXISTS (SELECT 1 FROM artifact_blobs WHERE sha256 = ?)
+RETURNING sha256`, sum, buf, sum)
+
+	if b.Sha256 == "" {
+		var c blob
+		p.postgres.WithContext(ctx).Read(&c, "sha256 = ?", sum)
+		if c.Sha256 == "" {
+			return errors.New("failed to put")
+		}
+	}
+
+	return nil
+}
+
+func (p *postgresBlobs) Get(ctx context.Context, sum string) ([]byte, error) {
+	var c content
+
+	p.postgres.WithContext(ctx).Raw(&c, `SELECT lo_get(oid) AS data FROM artifact_blobs WHERE sha256 = ?`, sum)
+
+	if c.Data == nil {
+		return nil, errors.New("failed to get")
+	}
+
+	return c.Data, nil
+}
@@ -0,0 +1,153 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifact
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/craftslab/metalflow/postgres"
+)
+
+// Store persists the artifacts. GetArtifact returns nil if not found.
+type Store interface {
+	AddArtifact(ctx context.Context, artifact *Artifact) error
+	GetArtifact(ctx context.Context, project uint, sum string) (*Artifact, error)
+	QueryArtifact(ctx context.Context) ([]Artifact, error)
+}
+
+type memoryStore struct {
+	artifacts map[memoryKey]Artifact
+	mutex     sync.RWMutex
+}
+
+type memoryKey struct {
+	project uint
+	sum     string
+}
+
+type postgresStore struct {
+	postgres postgres.Postgres
+}
+
+func (Artifact) TableName() string {
+	return "artifacts"
+}
+
+func NewMemoryStore() Store {
+	return &memoryStore{
+		artifacts: map[memoryKey]Artifact{},
+	}
+}
+
+func NewPostgresStore(p postgres.Postgres) (Store, error) {
+	if err := p.Migrate(&Artifact{}); err != nil {
+		return nil, errors.Wrap(err, "failed to migrate artifact")
+	}
+
+	// The artifacts kept by their SHA-256 alone, before they had a project,
+	// are left in the default project 0.
+	var x struct{}
+	p.Raw(&x, `DO $$ BEGIN
+IF (SELECT count(*) FROM information_schema.key_column_usage
+WHERE table_name = 'artifacts' AND constraint_name = 'artifacts_pkey') = 1 THEN
+ALTER TABLE artifacts DROP CONSTRAINT artifacts_pkey, ADD PRIMARY KEY (project, sha256);
+END IF;
+END $$`)
+
+	return &postgresStore{postgres: p}, nil
+}
+
+func (m *memoryStore) AddArtifact(_ context.Context, artifact *Artifact) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	k := memoryKey{project: artifact.Project, sum: artifact.Sha256}
+
+	if _, ok := m.artifacts[k]; !ok {
+		m.artifacts[k] = *artifact
+	}
+
+	return nil
+}
+
+func (m *memoryStore) GetArtifact(_ context.Context, project uint, sum string) (*Artifact, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	a, ok := m.artifacts[memoryKey{project: project, sum: sum}]
+	if !ok {
+		return nil, nil
+	}
+
+	return &a, nil
+}
+
+func (m *memoryStore) QueryArtifact(_ context.Context) ([]Artifact, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	buf := make([]Artifact, 0, len(m.artifacts))
+
+	for _, v := range m.artifacts {
+		buf = append(buf, v)
+	}
+
+	sort.Slice(buf, func(i, j int) bool {
+		return buf[i].CreatedAt.After(buf[j].CreatedAt)
+	})
+
+	return buf, nil
+}
+
+func (p *postgresStore) AddArtifact(ctx context.Context, artifact *Artifact) error {
+	var a Artifact
+
+	p.postgres.WithContext(ctx).Raw(&a, `INSERT INTO artifacts (project, sha256, name, size, creator, created_at)
+VALUES (?, ?, ?, ?, ?, ?)
+ON CONFLICT (project, sha256) DO UPDATE SET sha256 = EXCLUDED.sha256
+RETURNING sha256`, artifact.Project, artifact.Sha256, artifact.Name, artifact.Size, artifact.Creator, artifact.CreatedAt)
+
+	if a.Sha256 == "" {
+		return errors.New("failed to create")
+	}
+
+	return nil
+}
+
+func (p *postgresStore) GetArtifact(ctx context.Context, project uint, sum string) (*Artifact, error) {
+	buf := make([]Artifact, 0, 1)
+
+	p.postgres.WithContext(ctx).Query(&buf, &postgres.Filter{
+		Cond:   "project = ? AND sha256 = ?",
+		Values: []interface{}{project, sum},
+		Limit:  1,
+	})
+
+	if len(buf) == 0 {
+		return nil, nil
+	}
+
+	return &buf[0], nil
+}
+
+func (p *postgresStore) QueryArtifact(ctx context.Context) ([]Artifact, error) {
+	buf := make([]Artifact, 0)
+
+	p.postgres.WithContext(ctx).Query(&buf, &postgres.Filter{Order: "created_at desc"})
+
+	return buf, nil
+}
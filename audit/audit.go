@@ -0,0 +1,233 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/craftslab/metalflow/logger"
+)
+
+const (
+	OutcomeFailure = "failure"
+	OutcomeSuccess = "success"
+
+	Anonymous = "anonymous"
+
+	afterKey  = "audit.after"
+	beforeKey = "audit.before"
+)
+
+type Entry struct {
+	Id        uint      `gorm:"primarykey" json:"id"`
+	Time      time.Time `gorm:"index" json:"time"`
+	Actor     string    `gorm:"index" json:"actor"`
+	Action    string    `gorm:"index" json:"action"`
+	Target    string    `gorm:"index" json:"target"`
+	RequestId string    `gorm:"index" json:"requestId"`
+	SourceIp  string    `json:"sourceIp"`
+	Before    string    `json:"before,omitempty"`
+	After     string    `json:"after,omitempty"`
+	Diff      string    `json:"diff,omitempty"`
+	Status    int       `json:"status"`
+	Outcome   string    `gorm:"index" json:"outcome"`
+}
+
+type Filter struct {
+	Actor     string
+	Action    string
+	Target    string
+	RequestId string
+	Outcome   string
+	Since     time.Time
+	Until     time.Time
+	Limit     int
+}
+
+type Change struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// Store persists audit entries. It is append-only: entries are never updated
+// or deleted once written.
+type Store interface {
+	Append(ctx context.Context, entry *Entry) error
+	Query(ctx context.Context, filter *Filter) ([]Entry, error)
+}
+
+func (Entry) TableName() string {
+	return "audit_entries"
+}
+
+// SetBefore records the state of the target before a mutating handler runs.
+func SetBefore(ctx *gin.Context, val interface{}) {
+	ctx.Set(beforeKey, val)
+}
+
+// SetAfter records the state of the target after a mutating handler runs.
+func SetAfter(ctx *gin.Context, val interface{}) {
+	ctx.Set(afterKey, val)
+}
+
+// Middleware appends an entry to store for every non-GET request once its
+// handler has completed, and for the GET ones rejected as unauthorized,
+// forbidden or rate limited. identity returns the authenticated actor.
+func Middleware(store Store, identity func(*gin.Context) string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Next()
+
+		switch ctx.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			if !rejected(ctx.Writer.Status()) {
+				return
+			}
+		}
+
+		entry := &Entry{
+			Time:      time.Now().UTC(),
+			Actor:     identity(ctx),
+			Action:    ctx.Request.Method + " " + ctx.FullPath(),
+			Target:    ctx.Request.URL.Path,
+			RequestId: logger.RequestId(ctx.Request.Context()),
+			SourceIp:  ctx.ClientIP(),
+			Status:    ctx.Writer.Status(),
+			Outcome:   OutcomeSuccess,
+		}
+
+		if entry.Actor == "" {
+			entry.Actor = Anonymous
+		}
+
+		if entry.Status >= http.StatusBadRequest || len(ctx.Errors) != 0 {
+			entry.Outcome = OutcomeFailure
+		}
+
+		before, _ := ctx.Get(beforeKey)
+		after, _ := ctx.Get(afterKey)
+
+		entry.Before = marshal(before)
+		entry.After = marshal(after)
+		entry.Diff = marshal(Diff(before, after))
+
+		if err := store.Append(ctx.Request.Context(), entry); err != nil {
+			logger.Error(ctx.Request.Context(), "failed to append audit entry", "error", err)
+		}
+	}
+}
+
+func rejected(status int) bool {
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests:
+		return true
+	}
+
+	return false
+}
+
+// Diff returns the top-level fields which differ between the JSON encodings
+// of before and after.
+func Diff(before, after interface{}) map[string]Change {
+	b := fields(before)
+	a := fields(after)
+
+	buf := map[string]Change{}
+
+	for k, v := range b {
+		if n, ok := a[k]; !ok || !reflect.DeepEqual(v, n) {
+			buf[k] = Change{Old: v, New: a[k]}
+		}
+	}
+
+	for k, v := range a {
+		if _, ok := b[k]; !ok {
+			buf[k] = Change{Old: nil, New: v}
+		}
+	}
+
+	if len(buf) == 0 {
+		return nil
+	}
+
+	return buf
+}
+
+func fields(val interface{}) map[string]interface{} {
+	if val == nil {
+		return nil
+	}
+
+	buf, err := json.Marshal(val)
+	if err != nil {
+		return nil
+	}
+
+	var m map[string]interface{}
+
+	if err := json.Unmarshal(buf, &m); err != nil {
+		return map[string]interface{}{"": val}
+	}
+
+	return m
+}
+
+func marshal(val interface{}) string {
+	if val == nil || (reflect.ValueOf(val).Kind() == reflect.Map && reflect.ValueOf(val).Len() == 0) {
+		return ""
+	}
+
+	buf, err := json.Marshal(val)
+	if err != nil {
+		return ""
+	}
+
+	return string(buf)
+}
+
+func (f *Filter) match(e *Entry) bool {
+	if f.Actor != "" && f.Actor != e.Actor {
+		return false
+	}
+
+	if f.Action != "" && f.Action != e.Action {
+		return false
+	}
+
+	if f.Target != "" && f.Target != e.Target {
+		return false
+	}
+
+	if f.RequestId != "" && f.RequestId != e.RequestId {
+		return false
+	}
+
+	if f.Outcome != "" && f.Outcome != e.Outcome {
+		return false
+	}
+
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+
+	if !f.Until.IsZero() && !e.Time.Before(f.Until) {
+		return false
+	}
+
+	return true
+}
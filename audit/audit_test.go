@@ -0,0 +1,80 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/craftslab/metalflow/logger"
+)
+
+type node struct {
+	Comments string `json:"comments"`
+	Region   string `json:"region"`
+}
+
+func TestDiff(t *testing.T) {
+	d := Diff(nil, nil)
+	assert.Equal(t, 0, len(d))
+
+	d = Diff(node{Comments: "a", Region: "Xian"}, node{Comments: "b", Region: "Xian"})
+	assert.Equal(t, 1, len(d))
+	assert.Equal(t, "a", d["comments"].Old)
+	assert.Equal(t, "b", d["comments"].New)
+
+	d = Diff(nil, node{Region: "Xian"})
+	assert.Equal(t, 2, len(d))
+	assert.Equal(t, nil, d["region"].Old)
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	store := NewMemoryStore()
+
+	r := gin.New()
+	r.Use(logger.RequestIdMiddleware())
+	r.Use(Middleware(store, func(*gin.Context) string { return "admin" }))
+	r.GET("/nodes/:id", func(ctx *gin.Context) { ctx.Status(http.StatusOK) })
+	r.PUT("/nodes/:id", func(ctx *gin.Context) {
+		SetBefore(ctx, node{Region: "Xian"})
+		SetAfter(ctx, node{Region: "Shanghai"})
+		ctx.Status(http.StatusOK)
+	})
+	r.DELETE("/nodes/:id", func(ctx *gin.Context) { ctx.Status(http.StatusNotFound) })
+
+	for _, method := range []string{"GET", "PUT", "DELETE"} {
+		req, _ := http.NewRequest(method, "/nodes/1", nil)
+		req.Header.Set("X-Request-ID", method)
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	entries, err := store.Query(context.Background(), &Filter{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(entries))
+
+	assert.Equal(t, "DELETE /nodes/:id", entries[0].Action)
+	assert.Equal(t, OutcomeFailure, entries[0].Outcome)
+
+	assert.Equal(t, "admin", entries[1].Actor)
+	assert.Equal(t, "PUT", entries[1].RequestId)
+	assert.Equal(t, "/nodes/1", entries[1].Target)
+	assert.Equal(t, OutcomeSuccess, entries[1].Outcome)
+	assert.Equal(t, `{"region":{"old":"Xian","new":"Shanghai"}}`, entries[1].Diff)
+}
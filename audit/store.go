@@ -0,0 +1,172 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/craftslab/metalflow/postgres"
+)
+
+const (
+	defaultLimit = 100
+	maxLimit     = 10000
+)
+
+type memoryStore struct {
+	entries []Entry
+	mutex   sync.RWMutex
+}
+
+type postgresStore struct {
+	postgres postgres.Postgres
+}
+
+// NewMemoryStore returns a store which keeps entries in process memory, for
+// running without a database.
+func NewMemoryStore() Store {
+	return &memoryStore{}
+}
+
+// NewPostgresStore returns a store which keeps entries in PostgreSQL, where
+// a trigger rejects any UPDATE, DELETE or TRUNCATE of them.
+func NewPostgresStore(p postgres.Postgres) (Store, error) {
+	if err := p.Migrate(&Entry{}); err != nil {
+		return nil, errors.Wrap(err, "failed to migrate")
+	}
+
+	var x struct{}
+	p.Raw(&x, `CREATE OR REPLACE FUNCTION audit_entries_append_only() RETURNS trigger AS $$
+BEGIN
+RAISE EXCEPTION 'audit entries are append-only';
+END $$ LANGUAGE plpgsql`)
+	p.Raw(&x, `DO $$ BEGIN
+IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'audit_entries_append_only') THEN
+CREATE TRIGGER audit_entries_append_only BEFORE UPDATE OR DELETE OR TRUNCATE ON audit_entries
+FOR EACH STATEMENT EXECUTE PROCEDURE audit_entries_append_only();
+END IF;
+END $$`)
+
+	var t struct {
+		Count int
+	}
+
+	p.Raw(&t, "SELECT count(*) AS count FROM pg_trigger WHERE tgname = 'audit_entries_append_only'")
+
+	if t.Count == 0 {
+		return nil, errors.New("failed to create trigger")
+	}
+
+	return &postgresStore{postgres: p}, nil
+}
+
+func (m *memoryStore) Append(_ context.Context, entry *Entry) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	entry.Id = uint(len(m.entries)) + 1
+	m.entries = append(m.entries, *entry)
+
+	return nil
+}
+
+func (m *memoryStore) Query(_ context.Context, filter *Filter) ([]Entry, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	limit := limitOf(filter)
+	buf := make([]Entry, 0)
+
+	for i := len(m.entries) - 1; i >= 0 && len(buf) < limit; i-- {
+		if filter.match(&m.entries[i]) {
+			buf = append(buf, m.entries[i])
+		}
+	}
+
+	return buf, nil
+}
+
+func (p *postgresStore) Append(ctx context.Context, entry *Entry) error {
+	p.postgres.WithContext(ctx).Create(entry)
+
+	if entry.Id == 0 {
+		return errors.New("failed to create")
+	}
+
+	return nil
+}
+
+func (p *postgresStore) Query(ctx context.Context, filter *Filter) ([]Entry, error) {
+	var cond []string
+	var values []interface{}
+
+	add := func(c string, v interface{}) {
+		cond = append(cond, c)
+		values = append(values, v)
+	}
+
+	if filter.Actor != "" {
+		add("actor = ?", filter.Actor)
+	}
+
+	if filter.Action != "" {
+		add("action = ?", filter.Action)
+	}
+
+	if filter.Target != "" {
+		add("target = ?", filter.Target)
+	}
+
+	if filter.RequestId != "" {
+		add("request_id = ?", filter.RequestId)
+	}
+
+	if filter.Outcome != "" {
+		add("outcome = ?", filter.Outcome)
+	}
+
+	if !filter.Since.IsZero() {
+		add("time >= ?", filter.Since)
+	}
+
+	if !filter.Until.IsZero() {
+		add("time < ?", filter.Until)
+	}
+
+	buf := make([]Entry, 0)
+
+	p.postgres.WithContext(ctx).Query(&buf, &postgres.Filter{
+		Cond:   strings.Join(cond, " AND "),
+		Values: values,
+		Order:  "id desc",
+		Limit:  limitOf(filter),
+	})
+
+	return buf, nil
+}
+
+func limitOf(filter *Filter) int {
+	if filter.Limit <= 0 {
+		return defaultLimit
+	}
+
+	if filter.Limit > maxLimit {
+		return maxLimit
+	}
+
+	return filter.Limit
+}
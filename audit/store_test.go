@@ -0,0 +1,53 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	now := time.Now()
+
+	for i, actor := range []string{"admin", "john", "admin"} {
+		err := store.Append(ctx, &Entry{
+			Time:    now.Add(time.Duration(i) * time.Minute),
+			Actor:   actor,
+			Outcome: OutcomeSuccess,
+		})
+		assert.Equal(t, nil, err)
+	}
+
+	entries, err := store.Query(ctx, &Filter{Actor: "admin"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, uint(3), entries[0].Id)
+
+	entries, _ = store.Query(ctx, &Filter{Since: now.Add(time.Minute)})
+	assert.Equal(t, 2, len(entries))
+
+	entries, _ = store.Query(ctx, &Filter{Until: now.Add(time.Minute)})
+	assert.Equal(t, 1, len(entries))
+
+	entries, _ = store.Query(ctx, &Filter{Limit: 1})
+	assert.Equal(t, 1, len(entries))
+
+	entries, _ = store.Query(ctx, &Filter{Outcome: OutcomeFailure})
+	assert.Equal(t, 0, len(entries))
+}
@@ -13,10 +13,20 @@
 package auth
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	jwt "github.com/appleboy/gin-jwt/v2"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/pkg/errors"
 
 	"github.com/craftslab/metalflow/model"
@@ -24,23 +34,55 @@ import (
 )
 
 const (
+	enrollKey   = "enroll"
 	identityKey = "id"
-	key         = "metalflow"
+	loginKey    = "login"
+	projectsKey = "projects"
+	roleKey     = "role"
 	realm       = "metalflow"
+	maxLogin    = 1 << 16
 	maxRefresh  = time.Hour
 	timeout     = time.Hour
 )
 
+const (
+	CodeInvalidCredentials = "auth.invalid_credentials"
+	CodeInvalidOtp         = "auth.invalid_otp"
+)
+
+var (
+	versionPrefix = regexp.MustCompile(`^/api/v[0-9]+`)
+)
+
 type Auth interface {
 	Init() error
 	Middleware() *jwt.GinJWTMiddleware
+	Login(ctx *gin.Context)
+	LoginOtp(ctx *gin.Context)
+	LoginSubject(ctx *gin.Context) string
+	MfaSubject(ctx *gin.Context) string
+	OidcLogin(ctx *gin.Context)
+	OidcCallback(ctx *gin.Context)
+	TotpEnroll(ctx *gin.Context)
+	TotpVerify(ctx *gin.Context)
+	TotpDisable(ctx *gin.Context)
 }
 
+// Config of the auth. Secret signs the tokens, the MFA tokens and the OIDC
+// state, so it shall be the same on the masters of a cluster.
 type Config struct {
+	Authenticator Authenticator
+	Oidc          *Oidc
+	Secret        []byte
+	Totp          *Totp
+	TotpStore     TotpStore
 }
 
 type auth struct {
+	config     *Config
 	middleware *jwt.GinJWTMiddleware
+	oidc       *oidcProvider
+	sealer     sealer
 }
 
 type login struct {
@@ -48,62 +90,109 @@ type login struct {
 	Password string `form:"password" json:"password" binding:"required"`
 }
 
+// user is the identity in a metalflow token. With enroll set it is only
+// authorized to enroll TOTP, as required for its role.
 type user struct {
+	enroll   bool
+	projects map[uint]string
+	role     string
 	username string
 }
 
 func New(config *Config) Auth {
 	return &auth{
+		config:     config,
 		middleware: nil,
+		oidc:       nil,
+		sealer:     nil,
 	}
 }
 
 func DefaultConfig() *Config {
-	return &Config{}
+	secret := make([]byte, 32)
+	_, _ = rand.Read(secret)
+
+	return &Config{
+		Authenticator: NewLocalAuthenticator(),
+		Oidc:          DefaultOidc(),
+		Secret:        secret,
+		Totp:          DefaultTotp(),
+		TotpStore:     NewMemoryTotpStore(),
+	}
 }
 
 func (a *auth) Init() error {
 	var err error
 
+	if len(a.config.Secret) == 0 {
+		return errors.New("missing secret")
+	}
+
+	a.sealer = newSealer(a.config.Secret)
+
+	if a.config.Oidc != nil && a.config.Oidc.Issuer != "" {
+		if a.oidc, err = newOidcProvider(context.Background(), a.config.Oidc, a.sealer); err != nil {
+			return errors.Wrap(err, "failed to init oidc")
+		}
+	}
+
 	a.middleware, err = jwt.New(&jwt.GinJWTMiddleware{
 		IdentityKey: identityKey,
-		Key:         []byte(key),
+		Key:         a.config.Secret,
 		MaxRefresh:  maxRefresh,
 		Realm:       realm,
 		Timeout:     timeout,
 		Authenticator: func(c *gin.Context) (interface{}, error) {
-			var l login
-			if e := c.ShouldBind(&l); e != nil {
-				return "", jwt.ErrMissingLoginValues
-			}
-			a, e := model.QueryAccount(l.Username)
-			if e != nil {
-				return "", jwt.ErrFailedAuthentication
-			}
-			if a.Username == l.Username && a.Password == l.Password {
-				return &user{
-					username: l.Username,
-				}, nil
+			// Set by Login, LoginOtp or OidcCallback once the user is verified.
+			if v, ok := c.Get(loginKey); ok {
+				return v, nil
 			}
 			return nil, jwt.ErrFailedAuthentication
 		},
 		Authorizator: func(data interface{}, c *gin.Context) bool {
-			if v, ok := data.(*user); ok && v.username == "admin" {
+			v, ok := data.(*user)
+			if !ok {
+				return false
+			}
+			if p := routePath(c.FullPath()); p == totpPath || strings.HasPrefix(p, totpPath+"/") {
 				return true
 			}
-			return false
+			return !v.enroll && v.role != ""
 		},
 		IdentityHandler: func(ctx *gin.Context) interface{} {
 			claims := jwt.ExtractClaims(ctx)
+			enroll, _ := claims[enrollKey].(bool)
+			role, _ := claims[roleKey].(string)
+			projects := map[uint]string{}
+			if v, ok := claims[projectsKey].(map[string]interface{}); ok {
+				for id, r := range v {
+					if n, err := strconv.ParseUint(id, 10, 64); err == nil {
+						projects[uint(n)], _ = r.(string)
+					}
+				}
+			}
 			return &user{
+				enroll:   enroll,
+				projects: projects,
+				role:     role,
 				username: claims[identityKey].(string),
 			}
 		},
 		PayloadFunc: func(data interface{}) jwt.MapClaims {
 			if v, ok := data.(*user); ok {
-				return jwt.MapClaims{
+				projects := map[string]string{}
+				for id, r := range v.projects {
+					projects[strconv.FormatUint(uint64(id), 10)] = r
+				}
+				claims := jwt.MapClaims{
 					identityKey: v.username,
+					projectsKey: projects,
+					roleKey:     v.role,
 				}
+				if v.enroll {
+					claims[enrollKey] = true
+				}
+				return claims
 			}
 			return jwt.MapClaims{}
 		},
@@ -111,6 +200,10 @@ func (a *auth) Init() error {
 		TokenHeadName: "Bearer",
 		TokenLookup:   "header: Authorization, query: token, cookie: jwt",
 		Unauthorized: func(ctx *gin.Context, code int, msg string) {
+			if msg == jwt.ErrFailedAuthentication.Error() {
+				util.NewError(ctx, code, util.WithCode(CodeInvalidCredentials, jwt.ErrFailedAuthentication))
+				return
+			}
 			util.NewError(ctx, code, errors.New(msg))
 		},
 	})
@@ -129,3 +222,109 @@ func (a *auth) Init() error {
 func (a *auth) Middleware() *jwt.GinJWTMiddleware {
 	return a.middleware
 }
+
+// LoginSubject returns the username of a login request as Login binds it, for
+// the rate limits.
+func (a *auth) LoginSubject(ctx *gin.Context) string {
+	var l login
+	_ = peek(ctx, &l)
+
+	return l.Username
+}
+
+// peek binds the request to v like the handlers do, leaving the body for them
+// to bind again.
+func peek(ctx *gin.Context, v interface{}) error {
+	r := *ctx.Request
+
+	if ctx.Request.Body != nil {
+		buf, err := ioutil.ReadAll(io.LimitReader(ctx.Request.Body, maxLogin))
+		if err != nil {
+			return errors.Wrap(err, "failed to read")
+		}
+		ctx.Request.Body = ioutil.NopCloser(bytes.NewReader(buf))
+		r.Body = ioutil.NopCloser(bytes.NewReader(buf))
+	}
+
+	return binding.Default(r.Method, ctx.ContentType()).Bind(&r, v)
+}
+
+// routePath returns the path of a route without the version of the API it is
+// mounted under, so that the routes and their deprecated aliases match alike.
+func routePath(full string) string {
+	return versionPrefix.ReplaceAllString(full, "")
+}
+
+// Identity returns the username authenticated by the JWT middleware, or an
+// empty string for anonymous requests.
+func Identity(ctx *gin.Context) string {
+	if v, ok := ctx.Get(identityKey); ok {
+		if u, ok := v.(*user); ok {
+			return u.username
+		}
+	}
+
+	return ""
+}
+
+// Role returns the role of the user of the request.
+func Role(ctx *gin.Context) string {
+	if v, ok := ctx.Get(identityKey); ok {
+		if u, ok := v.(*user); ok {
+			return u.role
+		}
+	}
+
+	return ""
+}
+
+// Scope limits the model calls of the request to the projects of the user,
+// unless it is an admin, so it shall follow the JWT middleware.
+func Scope() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		s := &model.Scope{}
+
+		if v, ok := ctx.Get(identityKey); ok {
+			if u, ok := v.(*user); ok {
+				s.All = u.role == model.RoleAdmin
+				s.Projects = u.projects
+				s.Role = u.role
+				s.Username = u.username
+			}
+		}
+
+		ctx.Request = ctx.Request.WithContext(model.WithScope(ctx.Request.Context(), s))
+		ctx.Next()
+	}
+}
+
+// RequireAdmin forbids the users other than admins, so it shall follow the
+// JWT middleware.
+func RequireAdmin() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if v, ok := ctx.Get(identityKey); ok {
+			if u, ok := v.(*user); ok && u.role == model.RoleAdmin {
+				ctx.Next()
+				return
+			}
+		}
+
+		util.NewError(ctx, http.StatusForbidden, model.ErrForbidden)
+		ctx.Abort()
+	}
+}
+
+// RequireAdminOrSelf forbids the users other than admins, but on the routes of
+// their own account, whose id parameter is "self".
+func RequireAdminOrSelf() gin.HandlerFunc {
+	admin := RequireAdmin()
+
+	return func(ctx *gin.Context) {
+		if ctx.Param("id") == "self" && Identity(ctx) != "" {
+			ctx.Next()
+			return
+		}
+
+		admin(ctx)
+	}
+}
@@ -0,0 +1,72 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/craftslab/metalflow/model"
+)
+
+// Authenticator verifies the password of a user logging in and returns the
+// role granted to it.
+type Authenticator interface {
+	Authenticate(ctx context.Context, username, password string) (string, error)
+}
+
+type RoleMapping struct {
+	Group string
+	Role  string
+}
+
+type localAuthenticator struct{}
+
+var (
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// NewLocalAuthenticator authenticates against the accounts of the model.
+func NewLocalAuthenticator() Authenticator {
+	return &localAuthenticator{}
+}
+
+func (l *localAuthenticator) Authenticate(ctx context.Context, username, password string) (string, error) {
+	// The caller is not known yet, hence the unlimited scope.
+	a, err := model.QueryAccount(model.WithScope(ctx, &model.Scope{All: true}), username)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to query account")
+	}
+
+	if a.Username == "" || !a.CheckPassword(password) {
+		return "", ErrInvalidCredentials
+	}
+
+	return a.Role, nil
+}
+
+// mapRole returns the role of the first mapping whose group is in groups, or
+// an empty string if none matches.
+func mapRole(mappings []RoleMapping, groups []string) string {
+	for _, m := range mappings {
+		for _, g := range groups {
+			if strings.EqualFold(g, m.Group) {
+				return m.Role
+			}
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,205 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/craftslab/metalflow/model"
+	"github.com/craftslab/metalflow/tracing"
+)
+
+// Ldap searches the user matching UserFilter under BaseDn, binding as BindDn
+// if set, and then binds as the user to verify the password. The role is
+// mapped from the groups of the user, which are its memberOf values and, if
+// GroupFilter is set, the GroupAttribute of the groups matching it under
+// GroupBaseDn.
+type Ldap struct {
+	BaseDn             string
+	BindDn             string
+	BindPassword       string
+	CaFile             string
+	DefaultRole        string
+	GroupAttribute     string
+	GroupBaseDn        string
+	GroupFilter        string
+	InsecureSkipVerify bool
+	Roles              []RoleMapping
+	StartTls           bool
+	Timeout            time.Duration
+	Url                string
+	UserFilter         string
+}
+
+type ldapAuthenticator struct {
+	config *Ldap
+	tls    *tls.Config
+}
+
+func DefaultLdap() *Ldap {
+	return &Ldap{
+		DefaultRole:    model.RoleUser,
+		GroupAttribute: "cn",
+		GroupFilter:    "(member=%s)",
+		Timeout:        5 * time.Second,
+		Url:            "ldap://127.0.0.1:389",
+		UserFilter:     "(uid=%s)",
+	}
+}
+
+func NewLdapAuthenticator(config *Ldap) (Authenticator, error) {
+	u, err := url.Parse(config.Url)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse url")
+	}
+
+	c := &tls.Config{
+		InsecureSkipVerify: config.InsecureSkipVerify,
+		ServerName:         u.Hostname(),
+	}
+
+	if config.CaFile != "" {
+		buf, err := ioutil.ReadFile(config.CaFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read ca")
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(buf) {
+			return nil, errors.New("invalid ca " + config.CaFile)
+		}
+	}
+
+	return &ldapAuthenticator{
+		config: config,
+		tls:    c,
+	}, nil
+}
+
+func (l *ldapAuthenticator) Authenticate(ctx context.Context, username, password string) (string, error) {
+	_, span := tracing.Start(ctx, "auth.ldap", attribute.String("ldap.url", l.config.Url))
+
+	role, err := l.authenticate(username, password)
+	if err != ErrInvalidCredentials {
+		tracing.End(span, err)
+	} else {
+		tracing.End(span, nil)
+	}
+
+	return role, err
+}
+
+func (l *ldapAuthenticator) authenticate(username, password string) (string, error) {
+	// An empty password would be an unauthenticated bind, which succeeds.
+	if username == "" || password == "" {
+		return "", ErrInvalidCredentials
+	}
+
+	conn, err := l.dial()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to dial")
+	}
+
+	defer conn.Close()
+
+	if err := l.bind(conn); err != nil {
+		return "", errors.Wrap(err, "failed to bind")
+	}
+
+	res, err := conn.Search(ldap.NewSearchRequest(l.config.BaseDn,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		fmt.Sprintf(l.config.UserFilter, ldap.EscapeFilter(username)),
+		[]string{"dn", "memberOf"}, nil))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to search user")
+	}
+
+	if len(res.Entries) != 1 {
+		return "", ErrInvalidCredentials
+	}
+
+	entry := res.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return "", ErrInvalidCredentials
+		}
+		return "", errors.Wrap(err, "failed to bind user")
+	}
+
+	groups := entry.GetAttributeValues("memberOf")
+
+	if l.config.GroupFilter != "" {
+		if err := l.bind(conn); err != nil {
+			return "", errors.Wrap(err, "failed to rebind")
+		}
+		base := l.config.GroupBaseDn
+		if base == "" {
+			base = l.config.BaseDn
+		}
+		res, err := conn.Search(ldap.NewSearchRequest(base,
+			ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+			fmt.Sprintf(l.config.GroupFilter, ldap.EscapeFilter(entry.DN)),
+			[]string{l.config.GroupAttribute}, nil))
+		if err != nil {
+			return "", errors.Wrap(err, "failed to search groups")
+		}
+		for _, item := range res.Entries {
+			groups = append(groups, item.GetAttributeValues(l.config.GroupAttribute)...)
+		}
+	}
+
+	if role := mapRole(l.config.Roles, groups); role != "" {
+		return role, nil
+	}
+
+	return l.config.DefaultRole, nil
+}
+
+func (l *ldapAuthenticator) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(l.config.Url,
+		ldap.DialWithDialer(&net.Dialer{Timeout: l.config.Timeout}),
+		ldap.DialWithTLSConfig(l.tls))
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetTimeout(l.config.Timeout)
+
+	if l.config.StartTls {
+		if err := conn.StartTLS(l.tls); err != nil {
+			conn.Close()
+			return nil, errors.Wrap(err, "failed to start tls")
+		}
+	}
+
+	return conn, nil
+}
+
+func (l *ldapAuthenticator) bind(conn *ldap.Conn) error {
+	if l.config.BindDn == "" {
+		return nil
+	}
+
+	return conn.Bind(l.config.BindDn, l.config.BindPassword)
+}
@@ -0,0 +1,166 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	ldapserver "github.com/vjeantet/ldapserver"
+)
+
+var (
+	ldapGroups = map[string][]string{
+		"cn=metalflow-admins,ou=groups,dc=example,dc=com": {"uid=jane,ou=people,dc=example,dc=com"},
+	}
+
+	ldapUsers = map[string]string{
+		"cn=reader,dc=example,dc=com":          "reader",
+		"uid=jane,ou=people,dc=example,dc=com": "jane",
+		"uid=joe,ou=people,dc=example,dc=com":  "joe",
+	}
+)
+
+// newLdapServer starts an in-process LDAP server which only accepts binds
+// after StartTLS, and returns its address.
+func newLdapServer(config *tls.Config) string {
+	ldapserver.Logger = ldapserver.DiscardingLogger
+
+	routes := ldapserver.NewRouteMux()
+
+	routes.Extended(func(w ldapserver.ResponseWriter, m *ldapserver.Message) {
+		conn := tls.Server(m.Client.GetConn(), config)
+		res := ldapserver.NewExtendedResponse(ldapserver.LDAPResultSuccess)
+		res.SetResponseName(ldapserver.NoticeOfStartTLS)
+		w.Write(res)
+		if err := conn.Handshake(); err != nil {
+			return
+		}
+		m.Client.SetConn(conn)
+	}).RequestName(ldapserver.NoticeOfStartTLS)
+
+	routes.Bind(func(w ldapserver.ResponseWriter, m *ldapserver.Message) {
+		r := m.GetBindRequest()
+		res := ldapserver.NewBindResponse(ldapserver.LDAPResultSuccess)
+		_, secure := m.Client.GetConn().(*tls.Conn)
+		if pass, ok := ldapUsers[string(r.Name())]; !secure || !ok || pass != string(r.AuthenticationSimple()) {
+			res.SetResultCode(ldapserver.LDAPResultInvalidCredentials)
+		}
+		w.Write(res)
+	})
+
+	routes.Search(func(w ldapserver.ResponseWriter, m *ldapserver.Message) {
+		r := m.GetSearchRequest()
+		filter := r.FilterString()
+		if strings.HasPrefix(filter, "(member=") {
+			for dn, members := range ldapGroups {
+				for _, item := range members {
+					if strings.Contains(filter, item) {
+						e := ldapserver.NewSearchResultEntry(dn)
+						e.AddAttribute("cn", "metalflow-admins")
+						w.Write(e)
+					}
+				}
+			}
+		} else {
+			for dn := range ldapUsers {
+				if strings.HasPrefix(dn, "uid=") && strings.Contains(filter, "("+strings.Split(dn, ",")[0]+")") {
+					w.Write(ldapserver.NewSearchResultEntry(dn))
+				}
+			}
+		}
+		w.Write(ldapserver.NewSearchResultDoneResponse(ldapserver.LDAPResultSuccess))
+	})
+
+	s := ldapserver.NewServer()
+	s.Handle(routes)
+
+	addr := make(chan string)
+
+	go func() {
+		_ = s.ListenAndServe("127.0.0.1:0", func(s *ldapserver.Server) {
+			addr <- s.Listener.Addr().String()
+		})
+	}()
+
+	return <-addr
+}
+
+func TestLdap(t *testing.T) {
+	ts := httptest.NewUnstartedServer(nil)
+	ts.StartTLS()
+	ts.Close()
+
+	f, err := ioutil.TempFile("", "ca")
+	assert.Equal(t, nil, err)
+	defer func() { _ = os.Remove(f.Name()) }()
+
+	_ = pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	_ = f.Close()
+
+	c := DefaultLdap()
+	c.BaseDn = "dc=example,dc=com"
+	c.BindDn = "cn=reader,dc=example,dc=com"
+	c.BindPassword = "reader"
+	c.CaFile = f.Name()
+	c.Roles = []RoleMapping{{Group: "metalflow-admins", Role: "admin"}}
+	c.StartTls = true
+	c.Url = "ldap://" + newLdapServer(ts.TLS)
+
+	a, err := NewLdapAuthenticator(c)
+	assert.Equal(t, nil, err)
+
+	role, err := a.Authenticate(context.Background(), "jane", "jane")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "admin", role)
+
+	role, err = a.Authenticate(context.Background(), "joe", "joe")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "user", role)
+
+	_, err = a.Authenticate(context.Background(), "joe", "jane")
+	assert.Equal(t, ErrInvalidCredentials, err)
+
+	_, err = a.Authenticate(context.Background(), "joe", "")
+	assert.Equal(t, ErrInvalidCredentials, err)
+
+	_, err = a.Authenticate(context.Background(), "nobody", "nobody")
+	assert.Equal(t, ErrInvalidCredentials, err)
+
+	_, err = a.Authenticate(context.Background(), "*", "jane")
+	assert.Equal(t, ErrInvalidCredentials, err)
+
+	c.StartTls = false
+
+	_, err = a.Authenticate(context.Background(), "jane", "jane")
+	assert.NotEqual(t, nil, err)
+	assert.NotEqual(t, ErrInvalidCredentials, err)
+}
+
+func TestLocal(t *testing.T) {
+	a := NewLocalAuthenticator()
+
+	role, err := a.Authenticate(context.Background(), "admin", "admin")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "admin", role)
+
+	_, err = a.Authenticate(context.Background(), "admin", "john")
+	assert.Equal(t, ErrInvalidCredentials, err)
+}
@@ -0,0 +1,316 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+
+	"github.com/craftslab/metalflow/logger"
+	"github.com/craftslab/metalflow/model"
+	"github.com/craftslab/metalflow/util"
+)
+
+const (
+	oidcCookie = "oidc"
+	oidcMaxAge = 10 * time.Minute
+)
+
+// Oidc logs users in through the authorization code flow with PKCE against
+// Issuer, and is disabled if Issuer is empty. The users are bound to their
+// accounts by the iss and sub claims, the new accounts being named after
+// UsernameClaim. The role is that of the first of Roles whose group is in
+// GroupsClaim, or else DefaultRole, which shall not be admin.
+type Oidc struct {
+	ClientId      string
+	ClientSecret  string
+	CreateAccount bool
+	DefaultRole   string
+	GroupsClaim   string
+	Issuer        string
+	RedirectUrl   string
+	Roles         []RoleMapping
+	Scopes        []string
+	UsernameClaim string
+}
+
+type oidcState struct {
+	Expire   int64  `json:"expire"`
+	Nonce    string `json:"nonce"`
+	State    string `json:"state"`
+	Verifier string `json:"verifier"`
+}
+
+type oidcProvider struct {
+	config   *Oidc
+	oauth2   oauth2.Config
+	sealer   sealer
+	verifier *oidc.IDTokenVerifier
+}
+
+func DefaultOidc() *Oidc {
+	return &Oidc{
+		DefaultRole:   model.RoleUser,
+		GroupsClaim:   "groups",
+		Scopes:        []string{"profile", "email", "groups"},
+		UsernameClaim: "preferred_username",
+	}
+}
+
+func newOidcProvider(ctx context.Context, config *Oidc, s sealer) (*oidcProvider, error) {
+	if config.DefaultRole == model.RoleAdmin {
+		return nil, errors.New("invalid default role " + config.DefaultRole)
+	}
+
+	p, err := oidc.NewProvider(ctx, config.Issuer)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to discover issuer")
+	}
+
+	return &oidcProvider{
+		config: config,
+		oauth2: oauth2.Config{
+			ClientID:     config.ClientId,
+			ClientSecret: config.ClientSecret,
+			Endpoint:     p.Endpoint(),
+			RedirectURL:  config.RedirectUrl,
+			Scopes:       append([]string{oidc.ScopeOpenID}, config.Scopes...),
+		},
+		sealer:   s,
+		verifier: p.Verifier(&oidc.Config{ClientID: config.ClientId}),
+	}, nil
+}
+
+// OidcLogin redirects to the issuer, keeping the state, nonce and PKCE code
+// verifier in a signed cookie for the callback.
+func (a *auth) OidcLogin(ctx *gin.Context) {
+	if a.oidc == nil {
+		util.NewError(ctx, http.StatusNotFound, errors.New("OIDC not configured"))
+		return
+	}
+
+	s := oidcState{
+		Expire:   time.Now().Add(oidcMaxAge).Unix(),
+		Nonce:    random(),
+		State:    random(),
+		Verifier: random(),
+	}
+
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.SetCookie(oidcCookie, a.sealer.seal(oidcCookie, s), int(oidcMaxAge.Seconds()), oidcPath(ctx), "", ctx.Request.TLS != nil, true)
+
+	challenge := sha256.Sum256([]byte(s.Verifier))
+
+	ctx.Redirect(http.StatusFound, a.oidc.oauth2.AuthCodeURL(s.State,
+		oidc.Nonce(s.Nonce),
+		oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:])),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256")))
+}
+
+// OidcCallback exchanges the authorization code, verifies the ID token and
+// continues like Login does after checking the password.
+func (a *auth) OidcCallback(ctx *gin.Context) {
+	if a.oidc == nil {
+		util.NewError(ctx, http.StatusNotFound, errors.New("OIDC not configured"))
+		return
+	}
+
+	ctx.SetCookie(oidcCookie, "", -1, oidcPath(ctx), "", ctx.Request.TLS != nil, true)
+
+	u, err := a.oidc.callback(ctx)
+	if err != nil {
+		logger.Warn(ctx.Request.Context(), "OIDC login failed", "error", err)
+		util.NewError(ctx, http.StatusUnauthorized, errors.New("OIDC login failed"))
+		return
+	}
+
+	a.login(ctx, u)
+}
+
+func (p *oidcProvider) callback(ctx *gin.Context) (*user, error) {
+	if e := ctx.Query("error"); e != "" {
+		return nil, errors.New(e + ": " + ctx.Query("error_description"))
+	}
+
+	s, err := p.state(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid state")
+	}
+
+	if ctx.Query("state") != s.State {
+		return nil, errors.New("state mismatch")
+	}
+
+	c := ctx.Request.Context()
+
+	token, err := p.oauth2.Exchange(c, ctx.Query("code"), oauth2.SetAuthURLParam("code_verifier", s.Verifier))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to exchange code")
+	}
+
+	raw, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("missing id token")
+	}
+
+	idToken, err := p.verifier.Verify(c, raw)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to verify id token")
+	}
+
+	if idToken.Nonce != s.Nonce {
+		return nil, errors.New("nonce mismatch")
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, errors.Wrap(err, "failed to parse claims")
+	}
+
+	return p.account(c, claims)
+}
+
+// account maps the claims to the local account bound to their issuer and
+// subject, creating it if allowed. The username claim is only used to name a
+// new account, since users may often set it, so it is never linked to an
+// account with a password or of an admin. The role is only taken from the
+// groups, or else is the default one.
+func (p *oidcProvider) account(ctx context.Context, claims map[string]interface{}) (*user, error) {
+	iss, _ := claims["iss"].(string)
+	sub, _ := claims["sub"].(string)
+	if iss == "" || sub == "" {
+		return nil, errors.New("missing claim iss or sub")
+	}
+
+	role := p.role(claims[p.config.GroupsClaim])
+	if role == "" {
+		role = p.config.DefaultRole
+	}
+
+	// The caller is not known yet, hence the unlimited scope.
+	ctx = model.WithScope(ctx, &model.Scope{All: true})
+
+	a, err := model.QuerySubjectAccount(ctx, iss, sub)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query account")
+	}
+
+	if a.Username == "" {
+		if a, err = p.link(ctx, claims, iss, sub, role); err != nil {
+			return nil, err
+		}
+	}
+
+	return &user{
+		role:     role,
+		username: a.Username,
+	}, nil
+}
+
+// link binds the account named by the username claim to the subject, which
+// is only allowed for accounts without password created by single sign-on,
+// or creates it.
+func (p *oidcProvider) link(ctx context.Context, claims map[string]interface{}, iss, sub, role string) (model.Account, error) {
+	name, _ := claims[p.config.UsernameClaim].(string)
+	if name == "" {
+		return model.Account{}, errors.New("missing claim " + p.config.UsernameClaim)
+	}
+
+	a, err := model.QueryAccount(ctx, name)
+	if err != nil {
+		return model.Account{}, errors.Wrap(err, "failed to query account")
+	}
+
+	if a.Username != "" {
+		if a, err = model.LinkAccount(ctx, name, iss, sub); err != nil {
+			return model.Account{}, errors.Wrap(err, "failed to link account "+name)
+		}
+		logger.Info(ctx, "account linked", "username", name, "issuer", iss, "subject", sub)
+		return a, nil
+	}
+
+	if !p.config.CreateAccount {
+		return model.Account{}, errors.New("account not found " + name)
+	}
+
+	email, _ := claims["email"].(string)
+	fullName, _ := claims["name"].(string)
+
+	if a, err = model.AddAccount(ctx, model.Account{
+		Displayname: fullName,
+		Email:       email,
+		Issuer:      iss,
+		Name:        fullName,
+		Role:        role,
+		Subject:     sub,
+		Username:    name,
+	}); err != nil {
+		return model.Account{}, errors.Wrap(err, "failed to add account")
+	}
+
+	logger.Info(ctx, "account created", "username", name, "role", role)
+
+	return a, nil
+}
+
+func (p *oidcProvider) role(claim interface{}) string {
+	var groups []string
+
+	switch v := claim.(type) {
+	case string:
+		groups = strings.Fields(v)
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+	}
+
+	return mapRole(p.config.Roles, groups)
+}
+
+func (p *oidcProvider) state(ctx *gin.Context) (*oidcState, error) {
+	cookie, err := ctx.Cookie(oidcCookie)
+	if err != nil {
+		return nil, errors.Wrap(err, "missing cookie")
+	}
+
+	var s oidcState
+	if err := p.sealer.unseal(oidcCookie, cookie, &s); err != nil {
+		return nil, err
+	}
+
+	if time.Now().Unix() > s.Expire {
+		return nil, errors.New("expired")
+	}
+
+	return &s, nil
+}
+
+// oidcPath scopes the state cookie to the directory login and callback are
+// mounted under, e.g. /api/v1/auth/oidc, so it reaches the callback under
+// every prefix.
+func oidcPath(ctx *gin.Context) string {
+	return path.Dir(ctx.Request.URL.Path)
+}
@@ -0,0 +1,275 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/craftslab/metalflow/model"
+)
+
+type stubProvider struct {
+	claims    map[string]interface{}
+	challenge string
+	key       *rsa.PrivateKey
+	nonce     string
+	server    *httptest.Server
+}
+
+func newStubProvider(t *testing.T) *stubProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Equal(t, nil, err)
+
+	s := &stubProvider{key: key}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                s.server.URL,
+			"authorization_endpoint":                s.server.URL + "/authorize",
+			"token_endpoint":                        s.server.URL + "/token",
+			"jwks_uri":                              s.server.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"alg": "RS256",
+				"use": "sig",
+				"kid": "stub",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if r.PostForm.Get("code") != "code" || base64.RawURLEncoding.EncodeToString(sum[:]) != s.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		claims := jwt.MapClaims{
+			"iss":   s.server.URL,
+			"aud":   "metalflow",
+			"sub":   "1234",
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"iat":   time.Now().Unix(),
+			"nonce": s.nonce,
+		}
+		for k, v := range s.claims {
+			claims[k] = v
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "stub"
+		raw, _ := token.SignedString(key)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     raw,
+		})
+	})
+
+	s.server = httptest.NewServer(mux)
+
+	return s
+}
+
+func TestOidc(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	s := newStubProvider(t)
+	defer s.server.Close()
+
+	c := DefaultConfig()
+	c.Oidc.ClientId = "metalflow"
+	c.Oidc.CreateAccount = true
+	c.Oidc.Issuer = s.server.URL
+	c.Oidc.RedirectUrl = "http://127.0.0.1:9080/auth/oidc/callback"
+	c.Oidc.Roles = []RoleMapping{{Group: "metalflow-admins", Role: "admin"}}
+
+	a := New(c)
+	err := a.Init()
+	assert.Equal(t, nil, err)
+
+	r := gin.New()
+	r.GET("/auth/oidc/login", a.OidcLogin)
+	r.GET("/auth/oidc/callback", a.OidcCallback)
+	r.GET("/config", a.Middleware().MiddlewareFunc(), RequireAdmin(), func(ctx *gin.Context) {
+		ctx.String(http.StatusOK, Identity(ctx))
+	})
+
+	login := func(claims map[string]interface{}) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/auth/oidc/login", nil)
+		r.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusFound, rec.Code)
+
+		u, _ := url.Parse(rec.Header().Get("Location"))
+		assert.Equal(t, s.server.URL+"/authorize", u.Scheme+"://"+u.Host+u.Path)
+		assert.Equal(t, "S256", u.Query().Get("code_challenge_method"))
+
+		s.challenge = u.Query().Get("code_challenge")
+		s.claims = claims
+		s.nonce = u.Query().Get("nonce")
+
+		cookie := rec.Header().Get("Set-Cookie")
+
+		rec = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/auth/oidc/callback?code=code&state="+u.Query().Get("state"), nil)
+		req.Header.Set("Cookie", strings.Split(cookie, ";")[0])
+		r.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	get := func(rec *httptest.ResponseRecorder) int {
+		var resp struct {
+			Token string `json:"token"`
+		}
+		_ = json.NewDecoder(rec.Body).Decode(&resp)
+		rec = httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/config", nil)
+		req.Header.Set("Authorization", "Bearer "+resp.Token)
+		r.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// The accounts with a password, e.g. that of the admin, are never linked.
+	rec := login(map[string]interface{}{"preferred_username": "admin"})
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// A new account gets the default role, which is not an admin.
+	rec = login(map[string]interface{}{"preferred_username": "alice", "sub": "alice", "email": "alice@example.com"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, http.StatusForbidden, get(rec))
+
+	// The account is bound to the subject, not to the username claim.
+	rec = login(map[string]interface{}{"preferred_username": "admin", "sub": "alice"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = login(map[string]interface{}{"preferred_username": "alice", "sub": "mallory"})
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// Groups are mapped to roles, which are not kept with the account.
+	rec = login(map[string]interface{}{"preferred_username": "bob", "sub": "bob", "groups": []string{"metalflow-admins"}})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, http.StatusOK, get(rec))
+
+	rec = login(map[string]interface{}{"preferred_username": "bob", "sub": "bob"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, http.StatusForbidden, get(rec))
+
+	// Accounts created by single sign-on before they were bound are linked.
+	_, err = model.AddAccount(model.WithScope(context.Background(), &model.Scope{All: true}), model.Account{Role: model.RoleUser, Username: "dave"})
+	assert.Equal(t, nil, err)
+
+	rec = login(map[string]interface{}{"preferred_username": "dave", "sub": "dave"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = login(map[string]interface{}{"preferred_username": "dave", "sub": "eve"})
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = login(map[string]interface{}{})
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// The state must match the cookie.
+	rec = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/auth/oidc/callback?code=code&state=forged", nil)
+	r.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	c.Oidc.CreateAccount = false
+	rec = login(map[string]interface{}{"preferred_username": "carol", "sub": "carol"})
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = login(map[string]interface{}{"preferred_username": "alice", "sub": "alice"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	c.Oidc.DefaultRole = model.RoleAdmin
+	assert.NotEqual(t, nil, New(c).Init())
+}
+
+func TestOidcVersioned(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	s := newStubProvider(t)
+	defer s.server.Close()
+
+	r := gin.New()
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	c := DefaultConfig()
+	c.Oidc.ClientId = "metalflow"
+	c.Oidc.CreateAccount = true
+	c.Oidc.Issuer = s.server.URL
+	c.Oidc.RedirectUrl = ts.URL + "/api/v1/auth/oidc/callback"
+
+	a := New(c)
+	err := a.Init()
+	assert.Equal(t, nil, err)
+
+	v1 := r.Group("/api/v1")
+	v1.GET("/auth/oidc/login", a.OidcLogin)
+	v1.GET("/auth/oidc/callback", a.OidcCallback)
+
+	// The jar only sends the state cookie where a browser would.
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+		Jar: jar,
+	}
+
+	resp, err := client.Get(ts.URL + "/api/v1/auth/oidc/login")
+	assert.Equal(t, nil, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+
+	u, _ := url.Parse(resp.Header.Get("Location"))
+	s.challenge = u.Query().Get("code_challenge")
+	s.claims = map[string]interface{}{"preferred_username": "frank", "sub": "frank"}
+	s.nonce = u.Query().Get("nonce")
+
+	resp, err = client.Get(ts.URL + "/api/v1/auth/oidc/callback?code=code&state=" + u.Query().Get("state"))
+	assert.Equal(t, nil, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
@@ -0,0 +1,116 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// sealer signs values with a key derived from the secret of the auth, which
+// is shared by the masters of a cluster.
+type sealer []byte
+
+func newSealer(secret []byte) sealer {
+	h := hmac.New(sha256.New, secret)
+	_, _ = h.Write([]byte("seal"))
+
+	return h.Sum(nil)
+}
+
+// seal encodes v and signs it for purpose, so that a value sealed for one
+// purpose, e.g. the OIDC state, cannot be passed off as another.
+func (s sealer) seal(purpose string, v interface{}) string {
+	buf, _ := json.Marshal(v)
+	val := base64.RawURLEncoding.EncodeToString(buf)
+
+	return val + "." + s.sign(purpose+"."+val)
+}
+
+func (s sealer) unseal(purpose, sealed string, v interface{}) error {
+	i := strings.LastIndex(sealed, ".")
+	if i < 0 || !hmac.Equal([]byte(s.sign(purpose+"."+sealed[:i])), []byte(sealed[i+1:])) {
+		return errors.New("invalid signature")
+	}
+
+	buf, err := base64.RawURLEncoding.DecodeString(sealed[:i])
+	if err != nil {
+		return errors.Wrap(err, "failed to decode")
+	}
+
+	if err := json.Unmarshal(buf, v); err != nil {
+		return errors.Wrap(err, "failed to unmarshal")
+	}
+
+	return nil
+}
+
+func (s sealer) sign(val string) string {
+	h := hmac.New(sha256.New, s)
+	_, _ = h.Write([]byte(val))
+
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// encrypt encrypts val with AES-GCM under a key derived from secret, so that
+// the value cannot be read nor altered without it.
+func encrypt(secret []byte, val string) string {
+	aead := newAead(secret)
+
+	nonce := make([]byte, aead.NonceSize())
+	_, _ = rand.Read(nonce)
+
+	return encrypted + base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(val), nil))
+}
+
+func decrypt(secret []byte, val string) (string, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(val, encrypted))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to decode")
+	}
+
+	aead := newAead(secret)
+	if len(buf) < aead.NonceSize() {
+		return "", errors.New("invalid ciphertext")
+	}
+
+	plain, err := aead.Open(nil, buf[:aead.NonceSize()], buf[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to decrypt")
+	}
+
+	return string(plain), nil
+}
+
+func newAead(secret []byte) cipher.AEAD {
+	sum := sha256.Sum256(secret)
+	block, _ := aes.NewCipher(sum[:])
+	aead, _ := cipher.NewGCM(block)
+
+	return aead
+}
+
+func random() string {
+	buf := make([]byte, 32)
+	_, _ = rand.Read(buf)
+
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
@@ -0,0 +1,31 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSealer(t *testing.T) {
+	s := newSealer([]byte("metalflow-auth"))
+	sealed := s.seal(mfaPurpose, mfaToken{Username: "admin"})
+
+	var m mfaToken
+	assert.Equal(t, nil, s.unseal(mfaPurpose, sealed, &m))
+	assert.Equal(t, "admin", m.Username)
+
+	assert.NotEqual(t, nil, s.unseal(oidcCookie, sealed, &m))
+	assert.NotEqual(t, nil, newSealer([]byte("metalflow")).unseal(mfaPurpose, sealed, &m))
+}
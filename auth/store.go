@@ -0,0 +1,200 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/craftslab/metalflow/postgres"
+)
+
+// TotpStore keeps the TOTP enrollments by username, which works for the
+// accounts of any authenticator. Get returns nil if the user has not enrolled.
+//
+// Advance puts the enrollment with LastStep set to step, and Consume with
+// RecoveryCodes set to codes, only if no later step was accepted or the codes
+// were not changed meanwhile. Both return false otherwise, so that a code is
+// accepted once even if checked concurrently.
+type TotpStore interface {
+	Get(ctx context.Context, username string) (*Enrollment, error)
+	Put(ctx context.Context, enrollment *Enrollment) error
+	Advance(ctx context.Context, enrollment *Enrollment, step int64) (bool, error)
+	Consume(ctx context.Context, enrollment *Enrollment, codes string) (bool, error)
+	Delete(ctx context.Context, username string) error
+}
+
+// Enrollment is enabled once the first code is verified. LastStep is the
+// time step of the last accepted code, which cannot be replayed, and
+// RecoveryCodes the comma separated hashes of the unused recovery codes.
+type Enrollment struct {
+	Username      string `gorm:"primaryKey"`
+	Secret        string
+	Enabled       bool
+	LastStep      int64
+	RecoveryCodes string
+	UpdatedAt     time.Time
+}
+
+type memoryTotpStore struct {
+	enrollments map[string]Enrollment
+	mutex       sync.RWMutex
+}
+
+type postgresTotpStore struct {
+	postgres postgres.Postgres
+}
+
+func (Enrollment) TableName() string {
+	return "totp_enrollments"
+}
+
+func NewMemoryTotpStore() TotpStore {
+	return &memoryTotpStore{
+		enrollments: map[string]Enrollment{},
+	}
+}
+
+func NewPostgresTotpStore(p postgres.Postgres) (TotpStore, error) {
+	if err := p.Migrate(&Enrollment{}); err != nil {
+		return nil, errors.Wrap(err, "failed to migrate")
+	}
+
+	return &postgresTotpStore{postgres: p}, nil
+}
+
+func (m *memoryTotpStore) Get(_ context.Context, username string) (*Enrollment, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	e, ok := m.enrollments[username]
+	if !ok {
+		return nil, nil
+	}
+
+	return &e, nil
+}
+
+func (m *memoryTotpStore) Put(_ context.Context, enrollment *Enrollment) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	enrollment.UpdatedAt = time.Now()
+	m.enrollments[enrollment.Username] = *enrollment
+
+	return nil
+}
+
+func (m *memoryTotpStore) Advance(_ context.Context, enrollment *Enrollment, step int64) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	e, ok := m.enrollments[enrollment.Username]
+	if !ok || !e.Enabled || e.LastStep >= step {
+		return false, nil
+	}
+
+	e.LastStep = step
+	e.Secret = enrollment.Secret
+	e.UpdatedAt = time.Now()
+	m.enrollments[e.Username] = e
+
+	return true, nil
+}
+
+func (m *memoryTotpStore) Consume(_ context.Context, enrollment *Enrollment, codes string) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	e, ok := m.enrollments[enrollment.Username]
+	if !ok || !e.Enabled || e.RecoveryCodes != enrollment.RecoveryCodes {
+		return false, nil
+	}
+
+	e.RecoveryCodes = codes
+	e.Secret = enrollment.Secret
+	e.UpdatedAt = time.Now()
+	m.enrollments[e.Username] = e
+
+	return true, nil
+}
+
+func (m *memoryTotpStore) Delete(_ context.Context, username string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.enrollments, username)
+
+	return nil
+}
+
+// Get reads from the primary, since a replica may lag behind a code just
+// accepted.
+func (p *postgresTotpStore) Get(ctx context.Context, username string) (*Enrollment, error) {
+	var e Enrollment
+
+	p.postgres.WithContext(ctx).Raw(&e, "SELECT * FROM totp_enrollments WHERE username = ?", username)
+
+	if e.Username == "" {
+		return nil, nil
+	}
+
+	return &e, nil
+}
+
+func (p *postgresTotpStore) Put(ctx context.Context, enrollment *Enrollment) error {
+	var e Enrollment
+
+	p.postgres.WithContext(ctx).Raw(&e, `INSERT INTO totp_enrollments (username, secret, enabled, last_step, recovery_codes, updated_at)
+VALUES (?, ?, ?, ?, ?, ?)
+ON CONFLICT (username) DO UPDATE SET
+secret = EXCLUDED.secret, enabled = EXCLUDED.enabled, last_step = EXCLUDED.last_step,
+recovery_codes = EXCLUDED.recovery_codes, updated_at = EXCLUDED.updated_at
+RETURNING username`, enrollment.Username, enrollment.Secret, enrollment.Enabled, enrollment.LastStep,
+		enrollment.RecoveryCodes, time.Now())
+
+	if e.Username == "" {
+		return errors.New("failed to put")
+	}
+
+	return nil
+}
+
+func (p *postgresTotpStore) Advance(ctx context.Context, enrollment *Enrollment, step int64) (bool, error) {
+	var e Enrollment
+
+	p.postgres.WithContext(ctx).Raw(&e, `UPDATE totp_enrollments SET last_step = ?, secret = ?, updated_at = ?
+WHERE username = ? AND enabled AND last_step < ?
+RETURNING username`, step, enrollment.Secret, time.Now(), enrollment.Username, step)
+
+	return e.Username != "", nil
+}
+
+func (p *postgresTotpStore) Consume(ctx context.Context, enrollment *Enrollment, codes string) (bool, error) {
+	var e Enrollment
+
+	p.postgres.WithContext(ctx).Raw(&e, `UPDATE totp_enrollments SET recovery_codes = ?, secret = ?, updated_at = ?
+WHERE username = ? AND enabled AND recovery_codes = ?
+RETURNING username`, codes, enrollment.Secret, time.Now(), enrollment.Username, enrollment.RecoveryCodes)
+
+	return e.Username != "", nil
+}
+
+func (p *postgresTotpStore) Delete(ctx context.Context, username string) error {
+	p.postgres.WithContext(ctx).Delete(&Enrollment{}, "username = ?", username)
+
+	return nil
+}
@@ -0,0 +1,452 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/skip2/go-qrcode"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/craftslab/metalflow/logger"
+	"github.com/craftslab/metalflow/model"
+	"github.com/craftslab/metalflow/util"
+)
+
+const (
+	encrypted     = "aes:"
+	mfaMaxAge     = 5 * time.Minute
+	mfaPurpose    = "mfa"
+	qrSize        = 256
+	recoveryCount = 10
+	totpDigits    = 6
+	totpPath      = "/auth/totp"
+	totpPeriod    = 30
+)
+
+// Totp requires the users with one of RequiredRoles to enroll before they can
+// call anything but the enrollment API. Skew is the number of time steps a
+// code may be off by, and Key encrypts the secrets in the TotpStore.
+type Totp struct {
+	Issuer        string
+	Key           []byte
+	RequiredRoles []string
+	Skew          int64
+}
+
+type mfaToken struct {
+	Expire   int64  `json:"expire"`
+	Role     string `json:"role"`
+	Username string `json:"username"`
+}
+
+type otpRequest struct {
+	MfaToken string `form:"mfaToken" json:"mfaToken"`
+	Otp      string `form:"otp" json:"otp" binding:"required"`
+}
+
+var (
+	b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+func DefaultTotp() *Totp {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+
+	return &Totp{
+		Issuer:        "metalflow",
+		Key:           key,
+		RequiredRoles: []string{},
+		Skew:          1,
+	}
+}
+
+func (t *Totp) required(role string) bool {
+	for _, item := range t.RequiredRoles {
+		if item == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+// secret returns the secret of e, which is kept in plain text if it was
+// enrolled before the secrets were encrypted.
+func (t *Totp) secret(e *Enrollment) (string, error) {
+	if !strings.HasPrefix(e.Secret, encrypted) {
+		return e.Secret, nil
+	}
+
+	return decrypt(t.Key, e.Secret)
+}
+
+// Login checks the password. If the user has enrolled, it answers with a
+// token for the second step at /auth/login/otp instead of a metalflow token.
+func (a *auth) Login(ctx *gin.Context) {
+	var l login
+	if err := ctx.ShouldBind(&l); err != nil {
+		util.NewError(ctx, http.StatusUnauthorized, jwt.ErrMissingLoginValues)
+		return
+	}
+
+	role, err := a.config.Authenticator.Authenticate(ctx.Request.Context(), l.Username, l.Password)
+	if err != nil {
+		if err != ErrInvalidCredentials {
+			logger.Error(ctx.Request.Context(), "failed to authenticate", "username", l.Username, "error", err)
+		}
+		util.NewError(ctx, http.StatusUnauthorized, util.WithCode(CodeInvalidCredentials, jwt.ErrFailedAuthentication))
+		return
+	}
+
+	a.login(ctx, &user{role: role, username: l.Username})
+}
+
+// LoginOtp completes a login with a TOTP or recovery code.
+func (a *auth) LoginOtp(ctx *gin.Context) {
+	var r otpRequest
+	if err := ctx.ShouldBind(&r); err != nil {
+		util.NewError(ctx, http.StatusUnauthorized, jwt.ErrMissingLoginValues)
+		return
+	}
+
+	var t mfaToken
+	if err := a.sealer.unseal(mfaPurpose, r.MfaToken, &t); err != nil || time.Now().Unix() > t.Expire {
+		util.NewError(ctx, http.StatusUnauthorized, util.WithCode(CodeInvalidCredentials, errors.New("invalid mfa token")))
+		return
+	}
+
+	if err := a.check(ctx.Request.Context(), t.Username, r.Otp); err != nil {
+		util.NewError(ctx, http.StatusUnauthorized, util.WithCode(CodeInvalidOtp, err))
+		return
+	}
+
+	a.issue(ctx, &user{role: t.Role, username: t.Username})
+}
+
+// MfaSubject returns the username of the valid MFA token of a request as
+// LoginOtp binds it, or an empty string, for the rate limits.
+func (a *auth) MfaSubject(ctx *gin.Context) string {
+	var r otpRequest
+	_ = peek(ctx, &r)
+
+	var t mfaToken
+	if err := a.sealer.unseal(mfaPurpose, r.MfaToken, &t); err != nil || time.Now().Unix() > t.Expire {
+		return ""
+	}
+
+	return t.Username
+}
+
+// TotpEnroll generates a new secret for the user, which is enabled by
+// TotpVerify.
+func (a *auth) TotpEnroll(ctx *gin.Context) {
+	c := ctx.Request.Context()
+	name := Identity(ctx)
+
+	e, err := a.config.TotpStore.Get(c, name)
+	if err != nil {
+		util.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	if e != nil && e.Enabled {
+		util.NewError(ctx, http.StatusConflict, errors.New("already enrolled"))
+		return
+	}
+
+	buf := make([]byte, 20)
+	_, _ = rand.Read(buf)
+	secret := b32.EncodeToString(buf)
+
+	if err := a.config.TotpStore.Put(c, &Enrollment{Secret: encrypt(a.config.Totp.Key, secret), Username: name}); err != nil {
+		util.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	uri := otpauth(a.config.Totp.Issuer, name, secret)
+
+	png, err := qrcode.Encode(uri, qrcode.Medium, qrSize)
+	if err != nil {
+		util.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"qr":     "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+		"secret": secret,
+		"uri":    uri,
+	})
+}
+
+// TotpVerify enables the enrollment with its first code and returns the
+// recovery codes, which are shown only once.
+func (a *auth) TotpVerify(ctx *gin.Context) {
+	var r otpRequest
+	if err := ctx.ShouldBind(&r); err != nil {
+		util.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	c := ctx.Request.Context()
+
+	e, err := a.config.TotpStore.Get(c, Identity(ctx))
+	if err != nil {
+		util.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	if e == nil {
+		util.NewError(ctx, http.StatusNotFound, errors.New("not enrolled"))
+		return
+	}
+
+	if e.Enabled {
+		util.NewError(ctx, http.StatusConflict, errors.New("already enrolled"))
+		return
+	}
+
+	secret, err := a.config.Totp.secret(e)
+	if err != nil {
+		util.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	step := validate(secret, r.Otp, time.Now(), a.config.Totp.Skew)
+	if step < 0 {
+		util.NewError(ctx, http.StatusUnauthorized, util.WithCode(CodeInvalidOtp, errors.New("invalid code")))
+		return
+	}
+
+	codes := make([]string, recoveryCount)
+	hashes := make([]string, recoveryCount)
+
+	for i := range codes {
+		buf := make([]byte, 6)
+		_, _ = rand.Read(buf)
+		s := strings.ToLower(b32.EncodeToString(buf))
+		codes[i] = s[:5] + "-" + s[5:]
+		hashes[i] = hash(codes[i])
+	}
+
+	e.Enabled = true
+	e.LastStep = step
+	e.RecoveryCodes = strings.Join(hashes, ",")
+
+	if err := a.config.TotpStore.Put(c, e); err != nil {
+		util.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"recoveryCodes": codes,
+	})
+}
+
+// TotpDisable removes the enrollment, given a valid code.
+func (a *auth) TotpDisable(ctx *gin.Context) {
+	var r otpRequest
+	if err := ctx.ShouldBind(&r); err != nil {
+		util.NewError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	c := ctx.Request.Context()
+	name := Identity(ctx)
+
+	if err := a.check(c, name, r.Otp); err != nil {
+		util.NewError(ctx, http.StatusUnauthorized, util.WithCode(CodeInvalidOtp, err))
+		return
+	}
+
+	if err := a.config.TotpStore.Delete(c, name); err != nil {
+		util.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// login issues a metalflow token, or an MFA token if the user has enrolled.
+func (a *auth) login(ctx *gin.Context, u *user) {
+	e, err := a.config.TotpStore.Get(ctx.Request.Context(), u.username)
+	if err != nil {
+		util.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	if e != nil && e.Enabled {
+		ctx.JSON(http.StatusAccepted, gin.H{
+			"code":    http.StatusAccepted,
+			"message": "second factor required",
+			"mfaToken": a.sealer.seal(mfaPurpose, mfaToken{
+				Expire:   time.Now().Add(mfaMaxAge).Unix(),
+				Role:     u.role,
+				Username: u.username,
+			}),
+		})
+		return
+	}
+
+	u.enroll = a.config.Totp.required(u.role)
+
+	a.issue(ctx, u)
+}
+
+func (a *auth) issue(ctx *gin.Context, u *user) {
+	projects, err := model.QueryMembership(ctx.Request.Context(), u.username)
+	if err != nil {
+		util.NewError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	u.projects = projects
+
+	ctx.Set(loginKey, u)
+	a.middleware.LoginHandler(ctx)
+}
+
+// check accepts a TOTP code newer than the last one used, or consumes a
+// recovery code.
+func (a *auth) check(ctx context.Context, username, otp string) error {
+	e, err := a.config.TotpStore.Get(ctx, username)
+	if err != nil {
+		return errors.Wrap(err, "failed to get enrollment")
+	}
+
+	if e == nil || !e.Enabled {
+		return errors.New("not enrolled")
+	}
+
+	secret, err := a.config.Totp.secret(e)
+	if err != nil {
+		return errors.Wrap(err, "failed to get secret")
+	}
+
+	// Puts the secret encrypted, even if it was enrolled in plain text.
+	e.Secret = encrypt(a.config.Totp.Key, secret)
+
+	if step := validate(secret, otp, time.Now(), a.config.Totp.Skew); step > e.LastStep {
+		ok, err := a.config.TotpStore.Advance(ctx, e, step)
+		if err != nil {
+			return errors.Wrap(err, "failed to put enrollment")
+		}
+		if !ok {
+			return errors.New("code already used")
+		}
+		return nil
+	}
+
+	// Recovery codes are longer than TOTP codes, which spares the slow hashes
+	// on every wrong TOTP code.
+	otp = strings.ToLower(strings.TrimSpace(otp))
+	if len(otp) == totpDigits {
+		return errors.New("invalid code")
+	}
+
+	codes := strings.Split(e.RecoveryCodes, ",")
+
+	for i := range codes {
+		if codes[i] != "" && verify(codes[i], otp) {
+			ok, err := a.config.TotpStore.Consume(ctx, e, strings.Join(append(codes[:i:i], codes[i+1:]...), ","))
+			if err != nil {
+				return errors.Wrap(err, "failed to put enrollment")
+			}
+			if !ok {
+				return errors.New("code already used")
+			}
+			logger.Warn(ctx, "recovery code used", "username", username, "left", len(codes)-1)
+			return nil
+		}
+	}
+
+	return errors.New("invalid code")
+}
+
+// validate returns the time step of code within skew steps of t, or -1.
+func validate(secret, code string, t time.Time, skew int64) int64 {
+	key, err := b32.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return -1
+	}
+
+	now := t.Unix() / totpPeriod
+
+	for step := now - skew; step <= now+skew; step++ {
+		if subtle.ConstantTimeCompare([]byte(hotp(key, step)), []byte(code)) == 1 {
+			return step
+		}
+	}
+
+	return -1
+}
+
+// hotp computes the code of counter as in RFC 4226.
+func hotp(key []byte, counter int64) string {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(counter))
+
+	h := hmac.New(sha1.New, key)
+	_, _ = h.Write(buf)
+	sum := h.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0xf
+	val := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, val%1000000)
+}
+
+func otpauth(issuer, username, secret string) string {
+	v := url.Values{}
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("issuer", issuer)
+	v.Set("period", fmt.Sprint(totpPeriod))
+	v.Set("secret", secret)
+
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+username) + "?" + v.Encode()
+}
+
+// hash hashes a recovery code with bcrypt.
+func hash(code string) string {
+	buf, _ := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+	return string(buf)
+}
+
+// verify compares code with its hash, which is a bare SHA-256 if the code
+// was issued before the recovery codes were hashed with bcrypt.
+func verify(hash, code string) bool {
+	if !strings.HasPrefix(hash, "$2") {
+		sum := sha256.Sum256([]byte(code))
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(hex.EncodeToString(sum[:]))) == 1
+	}
+
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(code)) == nil
+}
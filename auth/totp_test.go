@@ -0,0 +1,233 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHotp(t *testing.T) {
+	// RFC 6238 test vectors, truncated to 6 digits.
+	key := []byte("12345678901234567890")
+
+	assert.Equal(t, "287082", hotp(key, 59/totpPeriod))
+	assert.Equal(t, "081804", hotp(key, 1111111109/totpPeriod))
+
+	secret := b32.EncodeToString(key)
+	now := time.Unix(1111111109, 0)
+
+	assert.Equal(t, int64(1111111109/totpPeriod), validate(secret, "081804", now, 1))
+	assert.Equal(t, int64(-1), validate(secret, "081804", now.Add(time.Minute+time.Second), 1))
+	assert.Equal(t, int64(-1), validate(secret, "08180", now, 1))
+}
+
+func request(body string) *gin.Context {
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request, _ = http.NewRequest("POST", "/auth/login", strings.NewReader(body))
+	ctx.Request.Header.Set("Content-Type", "application/json")
+
+	return ctx
+}
+
+func TestLoginSubject(t *testing.T) {
+	a := New(DefaultConfig())
+
+	// The username is bound like Login does, whatever the case of the key.
+	ctx := request(`{"Username":"admin","password":"admin"}`)
+	assert.Equal(t, "admin", a.LoginSubject(ctx))
+
+	var l login
+	err := ctx.ShouldBind(&l)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "admin", l.Password)
+
+	ctx = request("username=john&password=john")
+	ctx.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	assert.Equal(t, "john", a.LoginSubject(ctx))
+}
+
+func TestSecret(t *testing.T) {
+	c := DefaultConfig()
+	a := New(c).(*auth)
+	ctx := context.Background()
+
+	secret := b32.EncodeToString([]byte("12345678901234567890"))
+	otp := hotp([]byte("12345678901234567890"), time.Now().Unix()/totpPeriod)
+
+	// The secrets enrolled in plain text are encrypted once used.
+	_ = c.TotpStore.Put(ctx, &Enrollment{Enabled: true, Secret: secret, Username: "john"})
+	assert.Equal(t, nil, a.check(ctx, "john", otp))
+
+	e, _ := c.TotpStore.Get(ctx, "john")
+	assert.Equal(t, true, strings.HasPrefix(e.Secret, encrypted))
+
+	s, err := c.Totp.secret(e)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, secret, s)
+
+	c.Totp.Key = []byte("other")
+	_, err = c.Totp.secret(e)
+	assert.NotEqual(t, nil, err)
+}
+
+func TestCheck(t *testing.T) {
+	c := DefaultConfig()
+	a := New(c).(*auth)
+	ctx := context.Background()
+
+	secret := b32.EncodeToString([]byte("12345678901234567890"))
+	otp := hotp([]byte("12345678901234567890"), time.Now().Unix()/totpPeriod)
+
+	// Legacy recovery codes were hashed with bare SHA-256.
+	_ = c.TotpStore.Put(ctx, &Enrollment{
+		Enabled:       true,
+		RecoveryCodes: "b321d5a912fbd6ca15b24d154a15643db08e94d6e7cb2180dc9d41d0be4f4343," + hash("ccccc-ccccc"),
+		Secret:        secret,
+		Username:      "john",
+	})
+
+	// A code is accepted once, even if checked concurrently.
+	for _, code := range []string{otp, "AAAAA-AAAAA"} {
+		var ok int32
+		var wg sync.WaitGroup
+
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if a.check(ctx, "john", code) == nil {
+					atomic.AddInt32(&ok, 1)
+				}
+			}()
+		}
+
+		wg.Wait()
+		assert.Equal(t, int32(1), ok)
+	}
+
+	assert.NotEqual(t, nil, a.check(ctx, "john", "bbbbb-bbbbb"))
+
+	e, _ := c.TotpStore.Get(ctx, "john")
+	assert.Equal(t, false, strings.Contains(e.RecoveryCodes, ","))
+}
+
+func TestTotp(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	c := DefaultConfig()
+	c.Totp.RequiredRoles = []string{"admin"}
+
+	a := New(c)
+	err := a.Init()
+	assert.Equal(t, nil, err)
+
+	r := gin.New()
+	r.POST("/auth/login", a.Login)
+	r.POST("/auth/login/otp", a.LoginOtp)
+	tp := r.Group("/auth/totp")
+	tp.Use(a.Middleware().MiddlewareFunc())
+	tp.POST("", a.TotpEnroll)
+	tp.POST("verify", a.TotpVerify)
+	tp.DELETE("", a.TotpDisable)
+	r.GET("/nodes", a.Middleware().MiddlewareFunc(), func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+
+	do := func(method, path, token string, body interface{}) (int, map[string]interface{}) {
+		buf, _ := json.Marshal(body)
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, bytes.NewReader(buf))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		r.ServeHTTP(rec, req)
+		resp := map[string]interface{}{}
+		_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+
+	login := map[string]string{"username": "admin", "password": "admin"}
+
+	// Without enrollment the token only allows to enroll.
+	code, resp := do("POST", "/auth/login", "", login)
+	assert.Equal(t, http.StatusOK, code)
+	token := resp["token"].(string)
+
+	code, _ = do("GET", "/nodes", token, nil)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	code, resp = do("POST", "/auth/totp", token, nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, strings.HasPrefix(resp["uri"].(string), "otpauth://totp/metalflow:admin?"))
+	assert.Equal(t, true, strings.HasPrefix(resp["qr"].(string), "data:image/png;base64,"))
+
+	key, _ := b32.DecodeString(resp["secret"].(string))
+	otp := hotp(key, time.Now().Unix()/totpPeriod)
+
+	e, _ := c.TotpStore.Get(context.Background(), "admin")
+	assert.Equal(t, true, strings.HasPrefix(e.Secret, encrypted))
+	assert.Equal(t, false, strings.Contains(e.Secret, resp["secret"].(string)))
+
+	code, _ = do("POST", "/auth/totp/verify", token, map[string]string{"otp": "000000"})
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, resp = do("POST", "/auth/totp/verify", token, map[string]string{"otp": otp})
+	assert.Equal(t, http.StatusOK, code)
+	recovery := resp["recoveryCodes"].([]interface{})
+	assert.Equal(t, recoveryCount, len(recovery))
+
+	e, _ = c.TotpStore.Get(context.Background(), "admin")
+	assert.Equal(t, true, strings.HasPrefix(e.RecoveryCodes, "$2a$"))
+
+	// Once enrolled the login takes a second step.
+	code, resp = do("POST", "/auth/login", "", login)
+	assert.Equal(t, http.StatusAccepted, code)
+	mfa := resp["mfaToken"].(string)
+	assert.Equal(t, "admin", a.MfaSubject(request(`{"MfaToken":"`+mfa+`","otp":"000000"}`)))
+	assert.Equal(t, "", a.MfaSubject(request(`{"mfaToken":"`+mfa+`x"}`)))
+
+	code, _ = do("POST", "/auth/login/otp", "", map[string]string{"mfaToken": mfa, "otp": otp})
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, _ = do("POST", "/auth/login/otp", "", map[string]string{"mfaToken": mfa + "x", "otp": recovery[0].(string)})
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, resp = do("POST", "/auth/login/otp", "", map[string]string{"mfaToken": mfa, "otp": recovery[0].(string)})
+	assert.Equal(t, http.StatusOK, code)
+	token = resp["token"].(string)
+
+	code, _ = do("GET", "/nodes", token, nil)
+	assert.Equal(t, http.StatusOK, code)
+
+	code, _ = do("POST", "/auth/login/otp", "", map[string]string{"mfaToken": mfa, "otp": recovery[0].(string)})
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, _ = do("DELETE", "/auth/totp", token, map[string]string{"otp": recovery[1].(string)})
+	assert.Equal(t, http.StatusNoContent, code)
+
+	code, _ = do("POST", "/auth/login", "", login)
+	assert.Equal(t, http.StatusOK, code)
+}
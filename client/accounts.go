@@ -0,0 +1,91 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	"github.com/craftslab/metalflow/model"
+)
+
+const (
+	mergePatch = "application/merge-patch+json"
+)
+
+func (c *client) GetAccount(ctx context.Context, accountId uint) (*model.Account, error) {
+	var a model.Account
+
+	if err := c.call(ctx, &request{method: http.MethodGet, path: "/accounts/" + id(accountId)}, &a); err != nil {
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+// GetSelfAccount returns the account of the user logged in.
+func (c *client) GetSelfAccount(ctx context.Context) (*model.Account, error) {
+	var a model.Account
+
+	if err := c.call(ctx, &request{method: http.MethodGet, path: "/accounts/self"}, &a); err != nil {
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+func (c *client) QueryAccount(ctx context.Context, q string) (*model.Account, error) {
+	var a model.Account
+
+	r := &request{method: http.MethodGet, path: "/accounts/", query: map[string]string{"q": q}}
+	if err := c.call(ctx, r, &a); err != nil {
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+// PatchAccount applies patch, e.g. a map or model.AccountFields, as a JSON
+// merge patch to the account at version.
+func (c *client) PatchAccount(ctx context.Context, accountId, version uint, patch interface{}) (*model.Account, error) {
+	var a model.Account
+
+	r, err := mergePatchRequest("/accounts/"+id(accountId), version, patch)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.call(ctx, r, &a); err != nil {
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+func mergePatchRequest(path string, version uint, patch interface{}) (*request, error) {
+	buf, err := json.Marshal(patch)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode patch")
+	}
+
+	return &request{
+		body:        buf,
+		contentType: mergePatch,
+		header:      ifMatch(version),
+		method:      http.MethodPatch,
+		path:        path,
+	}, nil
+}
@@ -0,0 +1,64 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"github.com/craftslab/metalflow/artifact"
+)
+
+// AddArtifact uploads the content of r to project, or returns the artifact of
+// the same content in it if any.
+func (c *client) AddArtifact(ctx context.Context, project uint, name string, r io.Reader) (*artifact.Artifact, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read content")
+	}
+
+	req := &request{
+		body:        buf,
+		contentType: "application/octet-stream",
+		method:      http.MethodPost,
+		path:        "/artifacts/",
+		query:       map[string]string{"name": name, "project": strconv.FormatUint(uint64(project), 10)},
+	}
+
+	return c.artifact(ctx, req)
+}
+
+// GetArtifact returns the artifact of project, whose Url the content is
+// downloaded from without a token while it is valid.
+func (c *client) GetArtifact(ctx context.Context, project uint, sum string) (*artifact.Artifact, error) {
+	return c.artifact(ctx, &request{
+		method: http.MethodGet,
+		path:   "/artifacts/" + sum,
+		query:  map[string]string{"project": strconv.FormatUint(uint64(project), 10)},
+	})
+}
+
+func (c *client) artifact(ctx context.Context, r *request) (*artifact.Artifact, error) {
+	var a artifact.Artifact
+
+	if err := c.call(ctx, r, &a); err != nil {
+		return nil, err
+	}
+
+	return &a, nil
+}
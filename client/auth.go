@@ -0,0 +1,154 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrOtpRequired = errors.New("second factor required")
+)
+
+// token is the answer of a login or refresh, or the token for the second
+// step of a login with MfaToken.
+type token struct {
+	Expire   time.Time `json:"expire"`
+	MfaToken string    `json:"mfaToken"`
+	Token    string    `json:"token"`
+}
+
+// Login logs in with a password. If the user has enrolled TOTP, it returns
+// ErrOtpRequired, and LoginOtp completes the login.
+func (c *client) Login(ctx context.Context, username, password string) error {
+	r, err := jsonRequest(http.MethodPost, "/auth/login", map[string]string{"username": username, "password": password})
+	if err != nil {
+		return err
+	}
+
+	return c.login(ctx, r)
+}
+
+// LoginOtp completes a login with a TOTP or recovery code.
+func (c *client) LoginOtp(ctx context.Context, otp string) error {
+	c.mutex.Lock()
+	mfa := c.mfa
+	c.mutex.Unlock()
+
+	r, err := jsonRequest(http.MethodPost, "/auth/login/otp", map[string]string{"mfaToken": mfa, "otp": otp})
+	if err != nil {
+		return err
+	}
+
+	return c.login(ctx, r)
+}
+
+func (c *client) login(ctx context.Context, r *request) error {
+	rsp, err := c.send(ctx, r, "")
+	if err != nil {
+		return err
+	}
+
+	var t token
+	if err := decode(rsp, &t); err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if rsp.StatusCode == http.StatusAccepted {
+		c.mfa = t.MfaToken
+		return ErrOtpRequired
+	}
+
+	c.expire = t.Expire
+	c.mfa = ""
+	c.token = t.Token
+
+	return nil
+}
+
+// Refresh renews the token before it expires.
+func (c *client) Refresh(ctx context.Context) error {
+	t, _ := c.Token()
+
+	rsp, err := c.send(ctx, &request{method: http.MethodGet, path: "/auth/refresh"}, t)
+	if err != nil {
+		return err
+	}
+
+	var buf token
+	if err := decode(rsp, &buf); err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.expire = buf.Expire
+	c.token = buf.Token
+
+	return nil
+}
+
+// Token returns the token the requests are sent with, and its expiry if
+// known, e.g. to keep them for later.
+func (c *client) Token() (string, time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.token, c.expire
+}
+
+// authorize returns the token to send a request with, logging in first if
+// there is none, or refreshing it if it is about to expire. An expired token
+// is refreshed too, within the refresh period of the master, failing which
+// the client logs in again.
+func (c *client) authorize(ctx context.Context) (string, error) {
+	c.mutex.Lock()
+	t, expire := c.token, c.expire
+	c.mutex.Unlock()
+
+	if t == "" {
+		if c.config.Username == "" {
+			return "", nil
+		}
+		if err := c.Login(ctx, c.config.Username, c.config.Password); err != nil {
+			return "", errors.Wrap(err, "failed to login")
+		}
+		t, _ = c.Token()
+		return t, nil
+	}
+
+	if expire.IsZero() || time.Until(expire) > c.config.RenewBefore {
+		return t, nil
+	}
+
+	if err := c.Refresh(ctx); err != nil {
+		if c.config.Username == "" {
+			return "", errors.Wrap(err, "failed to refresh")
+		}
+		if err := c.Login(ctx, c.config.Username, c.config.Password); err != nil {
+			return "", errors.Wrap(err, "failed to login")
+		}
+	}
+
+	t, _ = c.Token()
+
+	return t, nil
+}
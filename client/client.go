@@ -0,0 +1,337 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/craftslab/metalflow/artifact"
+	"github.com/craftslab/metalflow/exec"
+	"github.com/craftslab/metalflow/flow"
+	"github.com/craftslab/metalflow/model"
+	"github.com/craftslab/metalflow/util"
+)
+
+const (
+	basePath = "/api/v1"
+)
+
+// Client is a typed client of the metalflow REST API. With Username set it
+// logs in by itself, and renews its token before it expires. Updates take the
+// version the item was read at, to fail with version.conflict if it changed
+// since, or zero to overwrite it anyway.
+type Client interface {
+	Login(ctx context.Context, username, password string) error
+	LoginOtp(ctx context.Context, otp string) error
+	Refresh(ctx context.Context) error
+	Token() (string, time.Time)
+
+	GetAccount(ctx context.Context, id uint) (*model.Account, error)
+	GetSelfAccount(ctx context.Context) (*model.Account, error)
+	QueryAccount(ctx context.Context, q string) (*model.Account, error)
+	PatchAccount(ctx context.Context, id, version uint, patch interface{}) (*model.Account, error)
+
+	GetNode(ctx context.Context, id uint) (*model.Node, error)
+	QueryNode(ctx context.Context, q, selector string) ([]model.Node, error)
+	PatchNode(ctx context.Context, id, version uint, patch interface{}) (*model.Node, error)
+	SetLabels(ctx context.Context, id, version uint, labels map[string]string) (*model.Node, error)
+	SetLifecycle(ctx context.Context, id, version uint, state, reason string) (*model.Node, error)
+	EnterMaintenance(ctx context.Context, id, version uint, reason string, until time.Time) (*model.Node, error)
+	ExitMaintenance(ctx context.Context, id, version uint) (*model.Node, error)
+	QueryTransition(ctx context.Context, id uint) ([]model.Transition, error)
+	AddArtifact(ctx context.Context, project uint, name string, r io.Reader) (*artifact.Artifact, error)
+	GetArtifact(ctx context.Context, project uint, sum string) (*artifact.Artifact, error)
+
+	Exec(ctx context.Context, id uint, command string, fn func(exec.Event)) (*exec.Execution, error)
+	QueryExec(ctx context.Context, id uint) ([]exec.Execution, error)
+	DelNode(ctx context.Context, id, version uint) (*model.Node, error)
+	ImportNode(ctx context.Context, nodes []model.Node, dryRun bool) (*model.ImportReport, error)
+	ImportNodeData(ctx context.Context, format string, r io.Reader, dryRun bool) (*model.ImportReport, error)
+	ExportNode(ctx context.Context, format, selector string, w io.Writer) error
+
+	SubmitFlow(ctx context.Context, spec *flow.Spec) (*flow.Flow, error)
+	GetFlow(ctx context.Context, id uint) (*flow.Status, error)
+	QueryFlow(ctx context.Context) ([]flow.Flow, error)
+	PauseFlow(ctx context.Context, id uint) (*flow.Flow, error)
+	ResumeFlow(ctx context.Context, id uint) (*flow.Flow, error)
+	AbortFlow(ctx context.Context, id uint) (*flow.Flow, error)
+
+	GetServerVersion(ctx context.Context) (string, error)
+}
+
+type Config struct {
+	// Addr is the URL of the master, e.g. http://127.0.0.1:9080.
+	Addr string
+	// Expire is the expiry of Token, if known.
+	Expire     time.Time
+	HttpClient *http.Client
+	Password   string
+	// RenewBefore is how long before its expiry the token is refreshed.
+	RenewBefore time.Duration
+	// Retries is the number of retries of a request rejected as overloaded
+	// or unavailable, or of an idempotent one failing in transit.
+	Retries   int
+	RetryWait time.Duration
+	Token     string
+	Username  string
+}
+
+// Error is a request answered with an error, as described by Problem.
+type Error struct {
+	Problem util.Problem
+}
+
+func (e *Error) Error() string {
+	return e.Problem.Code + ": " + e.Problem.Detail
+}
+
+// Code returns the code of the problem of err, if it is an *Error.
+func Code(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Problem.Code
+	}
+
+	return ""
+}
+
+type client struct {
+	config *Config
+	mutex  sync.Mutex
+	expire time.Time
+	mfa    string
+	token  string
+}
+
+func New(config *Config) Client {
+	return &client{
+		config: config,
+		expire: config.Expire,
+		token:  config.Token,
+	}
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Addr:        "http://127.0.0.1:9080",
+		Expire:      time.Time{},
+		HttpClient:  http.DefaultClient,
+		Password:    "",
+		RenewBefore: 5 * time.Minute,
+		Retries:     3,
+		RetryWait:   500 * time.Millisecond,
+		Token:       "",
+		Username:    "",
+	}
+}
+
+// request is a call of the API, whose body is kept to be sent again on retry.
+type request struct {
+	body        []byte
+	contentType string
+	header      http.Header
+	method      string
+	path        string
+	query       map[string]string
+}
+
+// call sends r with the token, logging in or renewing it as needed, and
+// decodes the response into out unless it is nil.
+func (c *client) call(ctx context.Context, r *request, out interface{}) error {
+	rsp, err := c.open(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	return decode(rsp, out)
+}
+
+// open sends r with the token, logging in or renewing it as needed, and
+// returns the response for the caller to read.
+func (c *client) open(ctx context.Context, r *request) (*http.Response, error) {
+	token, err := c.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp, err := c.send(ctx, r, token)
+	if err != nil {
+		return nil, err
+	}
+
+	// The token may have been revoked or the master restarted with a new key.
+	if rsp.StatusCode == http.StatusUnauthorized && c.config.Username != "" {
+		_ = rsp.Body.Close()
+		if err = c.Login(ctx, c.config.Username, c.config.Password); err != nil {
+			return nil, err
+		}
+		token, _ = c.Token()
+		if rsp, err = c.send(ctx, r, token); err != nil {
+			return nil, err
+		}
+	}
+
+	return rsp, nil
+}
+
+// send sends r with token, retrying as allowed by the config.
+func (c *client) send(ctx context.Context, r *request, token string) (*http.Response, error) {
+	wait := c.config.RetryWait
+
+	for i := 0; ; i++ {
+		rsp, err := c.do(ctx, r, token)
+		if i >= c.config.Retries || !retryable(r.method, rsp, err) {
+			return rsp, err
+		}
+
+		d := wait
+		if rsp != nil {
+			if s, e := strconv.Atoi(rsp.Header.Get("Retry-After")); e == nil {
+				d = time.Duration(s) * time.Second
+			}
+			_, _ = io.Copy(ioutil.Discard, rsp.Body)
+			_ = rsp.Body.Close()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(d):
+		}
+
+		wait *= 2
+	}
+}
+
+func (c *client) do(ctx context.Context, r *request, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, r.method, strings.TrimSuffix(c.config.Addr, "/")+basePath+r.path, bytes.NewReader(r.body))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to new request")
+	}
+
+	q := req.URL.Query()
+	for k, v := range r.query {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	req.URL.RawQuery = q.Encode()
+
+	for k, v := range r.header {
+		req.Header[k] = v
+	}
+
+	if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", "application/json")
+	}
+	if r.body != nil {
+		req.Header.Set("Content-Type", r.contentType)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rsp, err := c.config.HttpClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to send request")
+	}
+
+	return rsp, nil
+}
+
+// retryable tells whether the request is worth sending again: rejections
+// before it was handled always are, failures in transit only if the method
+// is idempotent.
+func retryable(method string, rsp *http.Response, err error) bool {
+	idempotent := method == http.MethodGet || method == http.MethodPut || method == http.MethodDelete
+
+	if err != nil {
+		return idempotent && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+
+	switch rsp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotent
+	}
+
+	return false
+}
+
+func decode(rsp *http.Response, out interface{}) error {
+	defer func() { _ = rsp.Body.Close() }()
+
+	buf, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return errors.Wrap(err, "failed to read response")
+	}
+
+	if rsp.StatusCode >= http.StatusBadRequest {
+		e := &Error{}
+		if json.Unmarshal(buf, &e.Problem) != nil || e.Problem.Code == "" {
+			e.Problem = util.Problem{
+				Code:   "request.failed",
+				Detail: strings.TrimSpace(string(buf)),
+				Status: rsp.StatusCode,
+				Title:  http.StatusText(rsp.StatusCode),
+			}
+		}
+		return e
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(buf, out); err != nil {
+		return errors.Wrap(err, "failed to decode response")
+	}
+
+	return nil
+}
+
+// jsonRequest is a request with v as JSON body.
+func jsonRequest(method, path string, v interface{}) (*request, error) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode request")
+	}
+
+	return &request{body: buf, contentType: "application/json", method: method, path: path}, nil
+}
+
+// ifMatch is the If-Match header of an update of an item at version, or of
+// any version if it is zero.
+func ifMatch(version uint) http.Header {
+	if version == 0 {
+		return http.Header{"If-Match": {"*"}}
+	}
+
+	return http.Header{"If-Match": {`"` + strconv.FormatUint(uint64(version), 10) + `"`}}
+}
+
+func id(v uint) string {
+	return strconv.FormatUint(uint64(v), 10)
+}
@@ -0,0 +1,181 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/craftslab/metalflow/exec"
+	"github.com/craftslab/metalflow/model"
+	"github.com/craftslab/metalflow/router"
+)
+
+func TestClient(t *testing.T) {
+	rc := router.DefaultConfig()
+	rc.Exec.Streamer = exec.StreamerFunc(func(_ context.Context, _, _, command string, fn func(exec.Event) error) (int, error) {
+		_ = fn(exec.Event{Data: "line 1\nline 2\n", Stream: exec.StreamStdout})
+		return 3, fn(exec.Event{Data: command, Stream: exec.StreamStderr})
+	})
+
+	r := router.New(rc)
+	err := r.Init()
+	assert.Equal(t, nil, err)
+
+	srv := httptest.NewServer(r.Handler())
+	defer srv.Close()
+
+	ctx := context.Background()
+
+	cfg := DefaultConfig()
+	cfg.Addr = srv.URL
+	cfg.Password = "admin"
+	cfg.Username = "admin"
+
+	c := New(cfg)
+
+	a, err := c.GetSelfAccount(ctx)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "admin", a.Username)
+	token, expire := c.Token()
+	assert.NotEqual(t, "", token)
+	assert.Equal(t, true, expire.After(time.Now()))
+
+	v, err := c.GetServerVersion(ctx)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, model.Version, v)
+
+	n, err := c.GetNode(ctx, 1)
+	assert.Equal(t, nil, err)
+
+	n, err = c.SetLabels(ctx, 1, n.Version, map[string]string{"env": "prod"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "prod", n.Labels["env"])
+
+	_, err = c.PatchNode(ctx, 1, n.Version-1, map[string]string{"comments": "rack 4"})
+	assert.Equal(t, "version.conflict", Code(err))
+
+	n, err = c.PatchNode(ctx, 1, 0, map[string]string{"comments": "rack 4"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "rack 4", n.Comments)
+
+	nodes, err := c.QueryNode(ctx, "", "env=prod")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(nodes))
+
+	report, err := c.ImportNode(ctx, []model.Node{{Address: "10.0.0.1", Region: "Xian"}}, true)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, report.Created)
+
+	report, err = c.ImportNode(ctx, []model.Node{{Address: "-", Region: "Xian"}}, false)
+	assert.Equal(t, ErrRowsFailed, err)
+	assert.Equal(t, 1, report.Failed)
+
+	var buf strings.Builder
+	err = c.ExportNode(ctx, FormatCsv, "env=prod", &buf)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, strings.HasPrefix(buf.String(), "address,"))
+
+	report, err = c.ImportNodeData(ctx, FormatCsv, strings.NewReader(buf.String()), true)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, report.Updated)
+
+	err = c.ExportNode(ctx, "xml", "", &buf)
+	assert.NotEqual(t, nil, err)
+
+	_, err = c.GetNode(ctx, 100)
+	assert.Equal(t, "node.not_found", Code(err))
+
+	art, err := c.AddArtifact(ctx, 1, "foo.txt", strings.NewReader("foo"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(3), art.Size)
+
+	art, err = c.GetArtifact(ctx, 1, art.Sha256)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "foo.txt", art.Name)
+
+	_, err = c.GetArtifact(ctx, 1, strings.Repeat("0", 64))
+	assert.Equal(t, "artifact.not_found", Code(err))
+
+	var events []exec.Event
+
+	x, err := c.Exec(ctx, 1, "false", func(ev exec.Event) {
+		events = append(events, ev)
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, exec.StateFailed, x.State)
+	assert.Equal(t, 3, x.Code)
+	assert.Equal(t, []exec.Event{
+		{Data: "line 1\nline 2\n", Stream: exec.StreamStdout},
+		{Data: "false", Stream: exec.StreamStderr},
+	}, events)
+
+	executions, err := c.QueryExec(ctx, 1)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(executions))
+
+	_, err = c.Exec(ctx, 100, "uptime", nil)
+	assert.Equal(t, "node.not_found", Code(err))
+
+	_, err = c.QueryFlow(ctx)
+	assert.Equal(t, nil, err)
+
+	// Renew the token on every request.
+	cfg.RenewBefore = 2 * time.Hour
+	_, err = c.GetAccount(ctx, 1)
+	assert.Equal(t, nil, err)
+
+	cfg = DefaultConfig()
+	cfg.Addr = srv.URL
+	err = New(cfg).Login(ctx, "admin", "invalid")
+	assert.Equal(t, "auth.invalid_credentials", Code(err))
+}
+
+func TestRetry(t *testing.T) {
+	n := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		if n < 3 {
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"code":"cluster.no_leader","detail":"no leader","status":503}`))
+			return
+		}
+		_, _ = w.Write([]byte(`"1.0"`))
+	}))
+	defer srv.Close()
+
+	cfg := DefaultConfig()
+	cfg.Addr = srv.URL
+	cfg.RetryWait = time.Millisecond
+	cfg.Token = "token"
+
+	v, err := New(cfg).GetServerVersion(context.Background())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "1.0", v)
+	assert.Equal(t, 3, n)
+
+	n = 0
+	cfg.Retries = 1
+
+	_, err = New(cfg).GetServerVersion(context.Background())
+	assert.Equal(t, "cluster.no_leader", Code(err))
+	assert.Equal(t, 2, n)
+}
@@ -0,0 +1,30 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+// GetServerVersion returns the version of the master, which only admins
+// may read.
+func (c *client) GetServerVersion(ctx context.Context) (string, error) {
+	var v string
+
+	if err := c.call(ctx, &request{method: http.MethodGet, path: "/config/server/version"}, &v); err != nil {
+		return "", err
+	}
+
+	return v, nil
+}
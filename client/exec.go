@@ -0,0 +1,94 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/craftslab/metalflow/exec"
+)
+
+// Exec runs command on the node, calling fn with its output as it is
+// streamed, and returns the execution once the command has exited. A command
+// failing on the node is not an error, but told by the state of the execution.
+func (c *client) Exec(ctx context.Context, nodeId uint, command string, fn func(exec.Event)) (*exec.Execution, error) {
+	r, err := jsonRequest(http.MethodPost, "/nodes/"+id(nodeId)+"/exec", &exec.Request{Command: command})
+	if err != nil {
+		return nil, err
+	}
+
+	r.header = http.Header{"Accept": {"text/event-stream"}}
+
+	rsp, err := c.open(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+
+	if rsp.StatusCode >= http.StatusBadRequest {
+		return nil, decode(rsp, nil)
+	}
+
+	defer func() { _ = rsp.Body.Close() }()
+
+	var name string
+	var data []string
+
+	s := bufio.NewScanner(rsp.Body)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	// The events are lines of fields ended by an empty one.
+	for s.Scan() {
+		line := s.Text()
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			name = strings.TrimPrefix(line, "event:")
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(line, "data:"))
+		case line == "":
+			if name == exec.StreamExit {
+				var x exec.Execution
+				if err := json.Unmarshal([]byte(strings.Join(data, "\n")), &x); err != nil {
+					return nil, errors.Wrap(err, "failed to decode execution")
+				}
+				return &x, nil
+			}
+			if name != "" && fn != nil {
+				fn(exec.Event{Data: strings.Join(data, "\n"), Stream: name})
+			}
+			name, data = "", nil
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read event")
+	}
+
+	return nil, errors.New("stream closed")
+}
+
+// QueryExec returns the commands run on the node, newest first.
+func (c *client) QueryExec(ctx context.Context, nodeId uint) ([]exec.Execution, error) {
+	var buf []exec.Execution
+
+	if err := c.call(ctx, &request{method: http.MethodGet, path: "/nodes/" + id(nodeId) + "/exec"}, &buf); err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
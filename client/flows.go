@@ -0,0 +1,74 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/craftslab/metalflow/flow"
+)
+
+// SubmitFlow submits spec, which is run on the nodes of its project matching
+// its selector.
+func (c *client) SubmitFlow(ctx context.Context, spec *flow.Spec) (*flow.Flow, error) {
+	r, err := jsonRequest(http.MethodPost, "/flows/", spec)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.flow(ctx, r)
+}
+
+// GetFlow returns the flow with the state of its steps on every node.
+func (c *client) GetFlow(ctx context.Context, flowId uint) (*flow.Status, error) {
+	var s flow.Status
+
+	if err := c.call(ctx, &request{method: http.MethodGet, path: "/flows/" + id(flowId)}, &s); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+func (c *client) QueryFlow(ctx context.Context) ([]flow.Flow, error) {
+	var flows []flow.Flow
+
+	if err := c.call(ctx, &request{method: http.MethodGet, path: "/flows/"}, &flows); err != nil {
+		return nil, err
+	}
+
+	return flows, nil
+}
+
+func (c *client) PauseFlow(ctx context.Context, flowId uint) (*flow.Flow, error) {
+	return c.flow(ctx, &request{method: http.MethodPost, path: "/flows/" + id(flowId) + "/pause"})
+}
+
+func (c *client) ResumeFlow(ctx context.Context, flowId uint) (*flow.Flow, error) {
+	return c.flow(ctx, &request{method: http.MethodPost, path: "/flows/" + id(flowId) + "/resume"})
+}
+
+func (c *client) AbortFlow(ctx context.Context, flowId uint) (*flow.Flow, error) {
+	return c.flow(ctx, &request{method: http.MethodPost, path: "/flows/" + id(flowId) + "/abort"})
+}
+
+func (c *client) flow(ctx context.Context, r *request) (*flow.Flow, error) {
+	var f flow.Flow
+
+	if err := c.call(ctx, r, &f); err != nil {
+		return nil, err
+	}
+
+	return &f, nil
+}
@@ -0,0 +1,212 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/craftslab/metalflow/model"
+)
+
+const (
+	FormatCsv  = "csv"
+	FormatJson = "json"
+	FormatYaml = "yaml"
+)
+
+var (
+	ErrRowsFailed = errors.New("rows failed")
+)
+
+var (
+	contentTypes = map[string]string{
+		FormatCsv:  "text/csv",
+		FormatJson: "application/json",
+		FormatYaml: "application/yaml",
+	}
+)
+
+// ImportNode creates the nodes of new addresses and updates the others. If
+// any row fails, nothing is applied and ErrRowsFailed is returned with the
+// report telling why.
+func (c *client) ImportNode(ctx context.Context, nodes []model.Node, dryRun bool) (*model.ImportReport, error) {
+	r, err := jsonRequest(http.MethodPost, "/nodes/import", nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.importNode(ctx, r, dryRun)
+}
+
+// ImportNodeData is ImportNode with the nodes read from r in format, i.e.
+// FormatCsv, FormatJson or FormatYaml.
+func (c *client) ImportNodeData(ctx context.Context, format string, r io.Reader, dryRun bool) (*model.ImportReport, error) {
+	t, ok := contentTypes[format]
+	if !ok {
+		return nil, errors.New("invalid format")
+	}
+
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read nodes")
+	}
+
+	return c.importNode(ctx, &request{body: buf, contentType: t, method: http.MethodPost, path: "/nodes/import"}, dryRun)
+}
+
+// ExportNode writes the nodes matching selector to w in format.
+func (c *client) ExportNode(ctx context.Context, format, selector string, w io.Writer) error {
+	t, ok := contentTypes[format]
+	if !ok {
+		return errors.New("invalid format")
+	}
+
+	r := &request{
+		header: http.Header{"Accept": {t}},
+		method: http.MethodGet,
+		path:   "/nodes/export",
+		query:  map[string]string{"format": format, "selector": selector},
+	}
+
+	rsp, err := c.open(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	if rsp.StatusCode >= http.StatusBadRequest {
+		return decode(rsp, nil)
+	}
+
+	defer func() { _ = rsp.Body.Close() }()
+
+	if _, err := io.Copy(w, rsp.Body); err != nil {
+		return errors.Wrap(err, "failed to read nodes")
+	}
+
+	return nil
+}
+
+func (c *client) importNode(ctx context.Context, r *request, dryRun bool) (*model.ImportReport, error) {
+	var report model.ImportReport
+
+	r.query = map[string]string{"dryRun": strconv.FormatBool(dryRun)}
+
+	if err := c.call(ctx, r, &report); err != nil {
+		var e *Error
+		if !errors.As(err, &e) || e.Problem.Status != http.StatusUnprocessableEntity {
+			return nil, err
+		}
+		// The report is not a problem, so it is left as the detail.
+		if json.Unmarshal([]byte(e.Problem.Detail), &report) != nil {
+			return nil, err
+		}
+		return &report, ErrRowsFailed
+	}
+
+	return &report, nil
+}
+
+func (c *client) GetNode(ctx context.Context, nodeId uint) (*model.Node, error) {
+	return c.node(ctx, &request{method: http.MethodGet, path: "/nodes/" + id(nodeId)})
+}
+
+// QueryNode returns the nodes whose address contains q and whose labels
+// match selector, either of which may be empty.
+func (c *client) QueryNode(ctx context.Context, q, selector string) ([]model.Node, error) {
+	var nodes []model.Node
+
+	r := &request{method: http.MethodGet, path: "/nodes/", query: map[string]string{"q": q, "selector": selector}}
+	if err := c.call(ctx, r, &nodes); err != nil {
+		return nil, err
+	}
+
+	return nodes, nil
+}
+
+// PatchNode applies patch, e.g. a map or model.NodeFields, as a JSON merge
+// patch to the node.
+func (c *client) PatchNode(ctx context.Context, nodeId, version uint, patch interface{}) (*model.Node, error) {
+	r, err := mergePatchRequest("/nodes/"+id(nodeId), version, patch)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.node(ctx, r)
+}
+
+// SetLabels replaces the labels of the node.
+func (c *client) SetLabels(ctx context.Context, nodeId, version uint, labels map[string]string) (*model.Node, error) {
+	return c.nodeJson(ctx, http.MethodPut, "/nodes/"+id(nodeId)+"/labels", version, labels)
+}
+
+func (c *client) SetLifecycle(ctx context.Context, nodeId, version uint, state, reason string) (*model.Node, error) {
+	return c.nodeJson(ctx, http.MethodPut, "/nodes/"+id(nodeId)+"/lifecycle", version, map[string]string{"reason": reason, "state": state})
+}
+
+// EnterMaintenance puts the node in maintenance, until ExitMaintenance or
+// until if it is not zero.
+func (c *client) EnterMaintenance(ctx context.Context, nodeId, version uint, reason string, until time.Time) (*model.Node, error) {
+	body := map[string]interface{}{"reason": reason}
+	if !until.IsZero() {
+		body["until"] = until
+	}
+
+	return c.nodeJson(ctx, http.MethodPut, "/nodes/"+id(nodeId)+"/maintenance", version, body)
+}
+
+func (c *client) ExitMaintenance(ctx context.Context, nodeId, version uint) (*model.Node, error) {
+	return c.node(ctx, &request{header: ifMatch(version), method: http.MethodDelete, path: "/nodes/" + id(nodeId) + "/maintenance"})
+}
+
+func (c *client) QueryTransition(ctx context.Context, nodeId uint) ([]model.Transition, error) {
+	var buf []model.Transition
+
+	if err := c.call(ctx, &request{method: http.MethodGet, path: "/nodes/" + id(nodeId) + "/transitions"}, &buf); err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+func (c *client) DelNode(ctx context.Context, nodeId, version uint) (*model.Node, error) {
+	return c.node(ctx, &request{header: ifMatch(version), method: http.MethodDelete, path: "/nodes/" + id(nodeId)})
+}
+
+func (c *client) node(ctx context.Context, r *request) (*model.Node, error) {
+	var n model.Node
+
+	if err := c.call(ctx, r, &n); err != nil {
+		return nil, err
+	}
+
+	return &n, nil
+}
+
+func (c *client) nodeJson(ctx context.Context, method, path string, version uint, v interface{}) (*model.Node, error) {
+	r, err := jsonRequest(method, path, v)
+	if err != nil {
+		return nil, err
+	}
+
+	r.header = ifMatch(version)
+
+	return c.node(ctx, r)
+}
@@ -0,0 +1,326 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"context"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+
+	"github.com/craftslab/metalflow/etcd"
+	"github.com/craftslab/metalflow/logger"
+	"github.com/craftslab/metalflow/util"
+)
+
+const (
+	CodeNoLeader = "cluster.no_leader"
+
+	forwardedHeader = "X-Metalflow-Forwarded"
+	resignTimeout   = 5 * time.Second
+)
+
+// Cluster elects a leader among the masters sharing Etcd, which alone runs
+// the jobs, while all of them serve the REST API. Without Etcd the master is
+// always the leader.
+type Cluster interface {
+	Start(ctx context.Context) error
+	Stop()
+
+	Status(ctx context.Context) (*Status, error)
+	IsLeader() bool
+	Forward() gin.HandlerFunc
+}
+
+// Job is a loop to run on a single master, such as flow.Engine and
+// schedule.Scheduler. It is stopped when the master loses the leadership, and
+// started again once it is elected again.
+type Job interface {
+	Start(ctx context.Context) error
+	Stop()
+}
+
+// Config identifies the master by Id, the URL the other masters forward the
+// requests for the leader to. Ttl is the time for the others to take over
+// once it stops renewing its lease without resigning, e.g. when it crashes.
+type Config struct {
+	Etcd   etcd.Etcd
+	Id     string
+	Jobs   []Job
+	Prefix string
+	Ttl    time.Duration
+}
+
+type Status struct {
+	Id       string `json:"id"`
+	IsLeader bool   `json:"isLeader"`
+	Leader   string `json:"leader"`
+}
+
+type cluster struct {
+	cancel context.CancelFunc
+	config *Config
+	leader bool
+	mutex  sync.RWMutex
+	wg     sync.WaitGroup
+}
+
+var (
+	ErrNoLeader = errors.New("no leader")
+)
+
+func New(config *Config) Cluster {
+	return &cluster{
+		config: config,
+	}
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Etcd:   nil,
+		Id:     "http://127.0.0.1:9080",
+		Jobs:   []Job{},
+		Prefix: "/metalflow/leader",
+		Ttl:    10 * time.Second,
+	}
+}
+
+func (c *cluster) Start(ctx context.Context) error {
+	if c.config.Etcd == nil {
+		if err := c.startJobs(ctx); err != nil {
+			return err
+		}
+		c.setLeader(true)
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	c.mutex.Lock()
+	c.cancel = cancel
+	c.mutex.Unlock()
+
+	c.wg.Add(1)
+
+	go func() {
+		defer c.wg.Done()
+		c.campaign(ctx)
+	}()
+
+	return nil
+}
+
+// Stop stops the jobs and resigns, so that another master takes over at once.
+func (c *cluster) Stop() {
+	c.mutex.Lock()
+	cancel := c.cancel
+	c.mutex.Unlock()
+
+	if cancel == nil {
+		if c.IsLeader() {
+			c.stopJobs(len(c.config.Jobs))
+			c.setLeader(false)
+		}
+		return
+	}
+
+	cancel()
+	c.wg.Wait()
+}
+
+func (c *cluster) Status(ctx context.Context) (*Status, error) {
+	s := &Status{
+		Id:       c.config.Id,
+		IsLeader: c.IsLeader(),
+	}
+
+	if c.config.Etcd == nil {
+		s.Leader = c.config.Id
+		return s, nil
+	}
+
+	// The leader holds the oldest key under the prefix.
+	resp, err := c.config.Etcd.Client().Get(ctx, c.config.Prefix+"/", clientv3.WithFirstCreate()...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get leader")
+	}
+
+	if len(resp.Kvs) == 0 {
+		return s, ErrNoLeader
+	}
+
+	s.Leader = string(resp.Kvs[0].Value)
+
+	return s, nil
+}
+
+func (c *cluster) IsLeader() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.leader
+}
+
+// Forward proxies the requests to the leader unless the master is the
+// leader, so it shall precede the other handlers of the group.
+func (c *cluster) Forward() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if c.IsLeader() {
+			ctx.Next()
+			return
+		}
+
+		// The leader would forward it back while the leadership changes.
+		if ctx.GetHeader(forwardedHeader) != "" {
+			util.NewError(ctx, http.StatusServiceUnavailable, util.WithCode(CodeNoLeader, ErrNoLeader))
+			ctx.Abort()
+			return
+		}
+
+		s, err := c.Status(ctx.Request.Context())
+		if err != nil {
+			util.NewError(ctx, http.StatusServiceUnavailable, err)
+			ctx.Abort()
+			return
+		}
+
+		u, err := url.Parse(s.Leader)
+		if err != nil {
+			util.NewError(ctx, http.StatusServiceUnavailable, errors.Wrap(err, "invalid leader"))
+			ctx.Abort()
+			return
+		}
+
+		ctx.Request.Header.Set(forwardedHeader, c.config.Id)
+
+		proxy := httputil.NewSingleHostReverseProxy(u)
+		proxy.ErrorHandler = func(w http.ResponseWriter, _ *http.Request, err error) {
+			util.NewError(ctx, http.StatusBadGateway, errors.Wrap(err, "failed to forward"))
+		}
+		proxy.ServeHTTP(ctx.Writer, ctx.Request)
+
+		ctx.Abort()
+	}
+}
+
+// campaign runs the jobs whenever the master is elected, until ctx is done.
+func (c *cluster) campaign(ctx context.Context) {
+	ttl := int(c.config.Ttl / time.Second)
+	if ttl < 1 {
+		ttl = 1
+	}
+
+	for ctx.Err() == nil {
+		session, err := concurrency.NewSession(c.config.Etcd.Client(), concurrency.WithTTL(ttl), concurrency.WithContext(ctx))
+		if err != nil {
+			logger.Error(ctx, "failed to new session", "error", err)
+			c.sleep(ctx)
+			continue
+		}
+
+		if err := c.lead(ctx, session); err != nil {
+			logger.Error(ctx, "failed to lead", "id", c.config.Id, "error", err)
+			c.sleep(ctx)
+		}
+
+		_ = session.Close()
+	}
+}
+
+func (c *cluster) lead(ctx context.Context, session *concurrency.Session) error {
+	election := concurrency.NewElection(session, c.config.Prefix)
+
+	// Stop campaigning once the lease of the session is lost.
+	cctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-session.Done():
+			cancel()
+		case <-cctx.Done():
+		}
+	}()
+
+	if err := election.Campaign(cctx, c.config.Id); err != nil {
+		if cctx.Err() != nil {
+			return nil
+		}
+		return errors.Wrap(err, "failed to campaign")
+	}
+
+	defer func() {
+		rctx, cancel := context.WithTimeout(context.Background(), resignTimeout)
+		defer cancel()
+		_ = election.Resign(rctx)
+	}()
+
+	logger.Info(ctx, "elected leader", "id", c.config.Id)
+
+	if err := c.startJobs(cctx); err != nil {
+		return err
+	}
+
+	c.setLeader(true)
+
+	<-cctx.Done()
+
+	if ctx.Err() == nil {
+		logger.Warn(ctx, "lost leadership", "id", c.config.Id)
+	}
+
+	c.setLeader(false)
+	c.stopJobs(len(c.config.Jobs))
+
+	return nil
+}
+
+// startJobs starts the jobs in order, stopping the started ones if one fails.
+func (c *cluster) startJobs(ctx context.Context) error {
+	for i, job := range c.config.Jobs {
+		if err := job.Start(ctx); err != nil {
+			c.stopJobs(i)
+			return errors.Wrap(err, "failed to start job")
+		}
+	}
+
+	return nil
+}
+
+// stopJobs stops the first n jobs in reverse order.
+func (c *cluster) stopJobs(n int) {
+	for i := n - 1; i >= 0; i-- {
+		c.config.Jobs[i].Stop()
+	}
+}
+
+func (c *cluster) setLeader(leader bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.leader = leader
+}
+
+func (c *cluster) sleep(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(c.config.Ttl):
+	}
+}
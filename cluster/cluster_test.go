@@ -0,0 +1,101 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/craftslab/metalflow/etcd"
+)
+
+type job struct {
+	running int32
+}
+
+func (j *job) Start(_ context.Context) error {
+	atomic.AddInt32(&j.running, 1)
+	return nil
+}
+
+func (j *job) Stop() {
+	atomic.AddInt32(&j.running, -1)
+}
+
+func TestCluster(t *testing.T) {
+	e := etcd.New(context.Background(), etcd.DefaultConfig())
+	err := e.Open()
+	assert.Equal(t, nil, err)
+
+	defer e.Close()
+
+	j := &job{}
+
+	newCluster := func(id string) Cluster {
+		c := DefaultConfig()
+		c.Etcd = e
+		c.Id = id
+		c.Jobs = []Job{j}
+		c.Prefix = "/metalflow/test/leader"
+		return New(c)
+	}
+
+	a := newCluster("http://127.0.0.1:9080")
+	b := newCluster("http://127.0.0.1:9090")
+
+	err = a.Start(context.Background())
+	assert.Equal(t, nil, err)
+
+	assert.Eventually(t, a.IsLeader, 5*time.Second, 10*time.Millisecond)
+
+	err = b.Start(context.Background())
+	assert.Equal(t, nil, err)
+
+	s, err := b.Status(context.Background())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "http://127.0.0.1:9080", s.Leader)
+	assert.Equal(t, false, s.IsLeader)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&j.running))
+
+	// The leader resigns on stop, so the other takes over before its lease expires.
+	a.Stop()
+	assert.Eventually(t, b.IsLeader, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&j.running))
+
+	b.Stop()
+	assert.Equal(t, int32(0), atomic.LoadInt32(&j.running))
+
+	_, err = b.Status(context.Background())
+	assert.Equal(t, ErrNoLeader, err)
+}
+
+func TestLocal(t *testing.T) {
+	j := &job{}
+
+	c := DefaultConfig()
+	c.Jobs = []Job{j}
+
+	l := New(c)
+	err := l.Start(context.Background())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, l.IsLeader())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&j.running))
+
+	l.Stop()
+	assert.Equal(t, false, l.IsLeader())
+	assert.Equal(t, int32(0), atomic.LoadInt32(&j.running))
+}
@@ -15,17 +15,28 @@ package cmd
 import (
 	"context"
 	"io/ioutil"
-	"log"
+	"net"
 	"os"
+	"time"
 
 	"github.com/pkg/errors"
 	"gopkg.in/alecthomas/kingpin.v2"
 	"gopkg.in/yaml.v3"
 
+	"github.com/craftslab/metalflow/artifact"
+	"github.com/craftslab/metalflow/auth"
+	"github.com/craftslab/metalflow/cluster"
 	"github.com/craftslab/metalflow/config"
-	"github.com/craftslab/metalflow/docs"
+	docs "github.com/craftslab/metalflow/docs/v1"
+	"github.com/craftslab/metalflow/etcd"
+	"github.com/craftslab/metalflow/exec"
+	"github.com/craftslab/metalflow/flow"
+	"github.com/craftslab/metalflow/logger"
 	"github.com/craftslab/metalflow/postgres"
+	"github.com/craftslab/metalflow/ratelimit"
 	"github.com/craftslab/metalflow/router"
+	"github.com/craftslab/metalflow/schedule"
+	"github.com/craftslab/metalflow/tracing"
 )
 
 var (
@@ -42,22 +53,57 @@ func Run() error {
 		return errors.Wrap(err, "failed to init config")
 	}
 
+	if err = initLogger(c); err != nil {
+		return errors.Wrap(err, "failed to init logger")
+	}
+
 	if err = initDoc(c); err != nil {
 		return errors.Wrap(err, "failed to init doc")
 	}
 
+	t, err := initTracing(c)
+	if err != nil {
+		return errors.Wrap(err, "failed to init tracing")
+	}
+
+	defer func() {
+		_ = t.Shutdown(context.Background())
+	}()
+
 	p, err := initPostgres(c)
 	if err != nil {
 		return errors.Wrap(err, "failed to init postgres")
 	}
 
-	log.Println("flow running")
+	if err = p.Open(); err != nil {
+		return errors.Wrap(err, "failed to open postgres")
+	}
+
+	defer p.Close()
+
+	e, err := initEtcd(c)
+	if err != nil {
+		return errors.Wrap(err, "failed to init etcd")
+	}
+
+	if err = e.Open(); err != nil {
+		return errors.Wrap(err, "failed to open etcd")
+	}
+
+	defer e.Close()
 
-	if err := runFlow(c, p); err != nil {
+	l, err := initRateLimit(c, p, e)
+	if err != nil {
+		return errors.Wrap(err, "failed to init ratelimit")
+	}
+
+	logger.Info(context.Background(), "flow running", "addr", *listenUrl)
+
+	if err := runFlow(c, p, e, l); err != nil {
 		return errors.Wrap(err, "failed to run flow")
 	}
 
-	log.Println("flow exiting")
+	logger.Info(context.Background(), "flow exiting")
 
 	return nil
 }
@@ -89,15 +135,373 @@ func initConfig(name string) (*config.Config, error) {
 	return c, nil
 }
 
+func initLogger(cfg *config.Config) error {
+	c := logger.DefaultConfig()
+	if c == nil {
+		return errors.New("failed to config")
+	}
+
+	c.Format = stringOr(cfg.Spec.Log.Format, c.Format)
+	c.Level = stringOr(cfg.Spec.Log.Level, c.Level)
+
+	return logger.Init(c)
+}
+
+func initTracing(cfg *config.Config) (tracing.Tracing, error) {
+	c := tracing.DefaultConfig()
+	if c == nil {
+		return nil, errors.New("failed to config")
+	}
+
+	t := cfg.Spec.Tracing
+
+	c.Endpoint = stringOr(t.Endpoint, c.Endpoint)
+	c.Exporter = stringOr(t.Exporter, c.Exporter)
+	c.Insecure = t.Insecure
+	c.ServiceName = stringOr(t.ServiceName, c.ServiceName)
+
+	if t.SampleRatio > 0 {
+		c.SampleRatio = t.SampleRatio
+	}
+
+	tr := tracing.New(context.Background(), c)
+	if err := tr.Init(); err != nil {
+		return nil, errors.Wrap(err, "failed to init")
+	}
+
+	return tr, nil
+}
+
 func initPostgres(cfg *config.Config) (postgres.Postgres, error) {
 	c := postgres.DefaultConfig()
 	if c == nil {
 		return nil, errors.New("failed to config")
 	}
 
+	p := cfg.Spec.Postgres
+
+	c.ApplicationName = stringOr(p.ApplicationName, c.ApplicationName)
+	c.Db = stringOr(p.Db, c.Db)
+	c.Dsn = p.Dsn
+	c.Host = stringOr(p.Host, c.Host)
+	c.Pass = p.Pass
+	c.Port = stringOr(p.Port, c.Port)
+	c.SearchPath = p.SearchPath
+	c.SslCert = p.SslCert
+	c.SslKey = p.SslKey
+	c.SslMode = stringOr(p.SslMode, c.SslMode)
+	c.SslRootCert = p.SslRootCert
+	c.TimeZone = stringOr(p.TimeZone, c.TimeZone)
+	c.User = p.User
+
+	if p.Retry.Count > 0 {
+		c.Retry = p.Retry.Count
+	}
+
+	if p.Retry.Backoff > 0 {
+		c.Backoff = p.Retry.Backoff
+	}
+
+	if p.Retry.MaxBackoff > 0 {
+		c.MaxBackoff = p.Retry.MaxBackoff
+	}
+
+	if p.HealthInterval > 0 {
+		c.HealthInterval = p.HealthInterval
+	}
+
+	if p.PrimaryAfterWrite != nil {
+		c.PrimaryAfterWrite = *p.PrimaryAfterWrite
+	}
+
+	for _, item := range p.Replicas {
+		r := *c
+		r.Dsn = item.Dsn
+		r.Host = stringOr(item.Host, c.Host)
+		r.Port = stringOr(item.Port, c.Port)
+		r.Replicas = nil
+		r.Retry = 0
+		c.Replicas = append(c.Replicas, r)
+	}
+
 	return postgres.New(context.Background(), c), nil
 }
 
+func stringOr(val, def string) string {
+	if val != "" {
+		return val
+	}
+
+	return def
+}
+
+func initEtcd(cfg *config.Config) (etcd.Etcd, error) {
+	c := etcd.DefaultConfig()
+	if c == nil {
+		return nil, errors.New("failed to config")
+	}
+
+	c.Host = stringOr(cfg.Spec.Etcd.Host, c.Host)
+	c.Port = stringOr(cfg.Spec.Etcd.Port, c.Port)
+
+	return etcd.New(context.Background(), c), nil
+}
+
+func initRateLimit(cfg *config.Config, p postgres.Postgres, e etcd.Etcd) (*ratelimit.Config, error) {
+	c := ratelimit.DefaultConfig()
+	if c == nil {
+		return nil, errors.New("failed to config")
+	}
+
+	r := cfg.Spec.RateLimit
+
+	c.Login.Ip = limitOr(r.Login.Ip, c.Login.Ip)
+	c.Login.User = limitOr(r.Login.User, c.Login.User)
+	c.Token = limitOr(r.Token, c.Token)
+
+	if r.Lockout.Threshold > 0 {
+		c.Lockout = ratelimit.Lockout{
+			Base:      r.Lockout.Base,
+			Max:       r.Lockout.Max,
+			Threshold: r.Lockout.Threshold,
+			Window:    r.Lockout.Window,
+		}
+	}
+
+	var err error
+
+	switch r.Backend {
+	case "", "memory":
+		c.Store = ratelimit.NewMemoryStore()
+	case "postgres":
+		if c.Store, err = ratelimit.NewPostgresStore(p); err != nil {
+			return nil, errors.Wrap(err, "failed to new store")
+		}
+	case "etcd":
+		c.Store = ratelimit.NewEtcdStore(e)
+	default:
+		return nil, errors.New("invalid backend " + r.Backend)
+	}
+
+	return c, nil
+}
+
+func limitOr(val config.Limit, def ratelimit.Limit) ratelimit.Limit {
+	if val.Count == 0 || val.Window == 0 {
+		return def
+	}
+
+	return ratelimit.Limit{
+		Count:  val.Count,
+		Window: val.Window,
+	}
+}
+
+// initCluster advertises the master at the listen port of its hostname,
+// unless advertiseUrl is set.
+func initCluster(cfg *config.Config, e etcd.Etcd) (*cluster.Config, error) {
+	c := cluster.DefaultConfig()
+	if c == nil {
+		return nil, errors.New("failed to config")
+	}
+
+	c.Etcd = e
+	c.Id = cfg.Spec.Cluster.AdvertiseUrl
+
+	if c.Id == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get hostname")
+		}
+		_, port, err := net.SplitHostPort(*listenUrl)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid listen url")
+		}
+		c.Id = "http://" + net.JoinHostPort(host, port)
+	}
+
+	if cfg.Spec.Cluster.Ttl > 0 {
+		c.Ttl = cfg.Spec.Cluster.Ttl
+	}
+
+	return c, nil
+}
+
+// initArtifact keeps the content on disk or in PostgreSQL, and signs the URLs
+// of artifacts under the API at url. The secret is required, since the URLs
+// signed by a master shall be valid on the others of the cluster.
+func initArtifact(cfg *config.Config, p postgres.Postgres, url string) (*artifact.Config, error) {
+	c := artifact.DefaultConfig()
+	a := cfg.Spec.Artifact
+
+	switch b := a.Backend; b {
+	case "", "disk":
+		if a.Dir == "" {
+			return nil, errors.New("missing dir")
+		}
+		c.Blobs = artifact.NewDiskBlobs(a.Dir)
+	case "postgres":
+		s, err := artifact.NewPostgresBlobs(p)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to new blobs")
+		}
+		c.Blobs = s
+	default:
+		return nil, errors.New("invalid backend " + b)
+	}
+
+	if a.MaxSizeMb > 0 {
+		c.MaxSize = a.MaxSizeMb << 20
+	}
+
+	if a.Secret == "" {
+		return nil, errors.New("missing secret")
+	}
+
+	c.Secret = []byte(a.Secret)
+
+	if a.UrlTtl > 0 {
+		c.Ttl = a.UrlTtl
+	}
+
+	c.Url = url
+
+	return c, nil
+}
+
+// initExec keeps the default allowlist, letting admins run any command,
+// unless allow is set.
+func initExec(cfg *config.Config, e etcd.Etcd) *exec.Config {
+	c := exec.DefaultConfig()
+	x := cfg.Spec.Exec
+
+	c.Streamer = exec.NewEtcdStreamer(e)
+
+	if len(x.Allow) != 0 {
+		c.Allow = x.Allow
+	}
+
+	if x.Timeout > 0 {
+		c.Timeout = x.Timeout
+	}
+
+	return c
+}
+
+func initAuth(cfg *config.Config) (*auth.Config, error) {
+	c := auth.DefaultConfig()
+	if c == nil {
+		return nil, errors.New("failed to config")
+	}
+
+	switch b := cfg.Spec.Auth.Backend; b {
+	case "", "local":
+		c.Authenticator = auth.NewLocalAuthenticator()
+	case "ldap":
+		a, err := auth.NewLdapAuthenticator(initLdap(cfg))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to new ldap")
+		}
+		c.Authenticator = a
+	default:
+		return nil, errors.New("invalid backend " + b)
+	}
+
+	if cfg.Spec.Auth.Secret == "" {
+		return nil, errors.New("missing secret")
+	}
+
+	c.Secret = []byte(cfg.Spec.Auth.Secret)
+
+	o := cfg.Spec.Auth.Oidc
+
+	c.Oidc.ClientId = o.ClientId
+	c.Oidc.ClientSecret = o.ClientSecret
+	c.Oidc.CreateAccount = o.CreateAccount
+	c.Oidc.DefaultRole = stringOr(o.DefaultRole, c.Oidc.DefaultRole)
+	c.Oidc.GroupsClaim = stringOr(o.GroupsClaim, c.Oidc.GroupsClaim)
+	c.Oidc.Issuer = o.Issuer
+	c.Oidc.RedirectUrl = o.RedirectUrl
+	c.Oidc.UsernameClaim = stringOr(o.UsernameClaim, c.Oidc.UsernameClaim)
+
+	if len(o.Scopes) != 0 {
+		c.Oidc.Scopes = o.Scopes
+	}
+
+	for _, item := range o.Roles {
+		c.Oidc.Roles = append(c.Oidc.Roles, auth.RoleMapping{Group: item.Group, Role: item.Role})
+	}
+
+	t := cfg.Spec.Auth.Totp
+
+	if t.Key == "" {
+		return nil, errors.New("missing totp key")
+	}
+
+	c.Totp.Issuer = stringOr(t.Issuer, c.Totp.Issuer)
+	c.Totp.Key = []byte(t.Key)
+	c.Totp.RequiredRoles = t.RequiredRoles
+
+	if t.Skew > 0 {
+		c.Totp.Skew = t.Skew
+	}
+
+	return c, nil
+}
+
+func initLdap(cfg *config.Config) *auth.Ldap {
+	c := auth.DefaultLdap()
+	l := cfg.Spec.Auth.Ldap
+
+	c.BaseDn = l.BaseDn
+	c.BindDn = l.BindDn
+	c.BindPassword = l.BindPassword
+	c.CaFile = l.CaFile
+	c.DefaultRole = stringOr(l.DefaultRole, c.DefaultRole)
+	c.GroupAttribute = stringOr(l.GroupAttribute, c.GroupAttribute)
+	c.GroupBaseDn = l.GroupBaseDn
+	c.GroupFilter = l.GroupFilter
+	c.InsecureSkipVerify = l.InsecureSkipVerify
+	c.StartTls = l.StartTls
+	c.Url = stringOr(l.Url, c.Url)
+	c.UserFilter = stringOr(l.UserFilter, c.UserFilter)
+
+	if l.Timeout > 0 {
+		c.Timeout = l.Timeout
+	}
+
+	for _, item := range l.Roles {
+		c.Roles = append(c.Roles, auth.RoleMapping{Group: item.Group, Role: item.Role})
+	}
+
+	return c
+}
+
+func initCors(cfg *config.Config) *router.Cors {
+	c := router.DefaultCors()
+
+	policy := func(p config.CorsPolicy) router.CorsPolicy {
+		return router.CorsPolicy{
+			AllowCredentials: p.AllowCredentials,
+			AllowHeaders:     p.AllowHeaders,
+			AllowMethods:     p.AllowMethods,
+			AllowOrigins:     p.AllowOrigins,
+			ExposeHeaders:    p.ExposeHeaders,
+			MaxAge:           p.MaxAge,
+		}
+	}
+
+	o := cfg.Spec.Cors
+
+	c.CorsPolicy = policy(o.CorsPolicy).Inherit(c.CorsPolicy)
+
+	for prefix, p := range o.Groups {
+		c.Groups[prefix] = policy(p)
+	}
+
+	return c
+}
+
 func initDoc(_ *config.Config) error {
 	docs.SwaggerInfo.Version = "1.0"
 	docs.SwaggerInfo.Host = *listenUrl
@@ -106,18 +510,58 @@ func initDoc(_ *config.Config) error {
 	return nil
 }
 
-func runFlow(_ *config.Config, _ postgres.Postgres) error {
+func runFlow(cfg *config.Config, p postgres.Postgres, e etcd.Etcd, l *ratelimit.Config) error {
 	c := router.DefaultConfig()
 	if c == nil {
 		return errors.New("failed to config")
 	}
 
+	a, err := initAuth(cfg)
+	if err != nil {
+		return errors.Wrap(err, "failed to init auth")
+	}
+
+	cl, err := initCluster(cfg, e)
+	if err != nil {
+		return errors.Wrap(err, "failed to init cluster")
+	}
+
+	ar, err := initArtifact(cfg, p, cl.Id+"/api/v1")
+	if err != nil {
+		return errors.Wrap(err, "failed to init artifact")
+	}
+
 	c.Addr = *listenUrl
+	c.Artifact = ar
+	c.Auth = a
+	c.Cluster = cl
+	c.Cors = initCors(cfg)
+	c.Exec = initExec(cfg, e)
+	c.Flow.Dispatcher = flow.NewEtcdDispatcher(e)
+	c.Flow.Fetcher = flow.NewEtcdFetcher(e)
+	c.Postgres = p
+	c.Proxies = cfg.Spec.Api.TrustedProxies
+	c.RateLimit = l
+	c.Schedule.Dispatcher = schedule.NewEtcdDispatcher(e)
+
+	if paths := cfg.Spec.Artifact.Paths; len(paths) != 0 {
+		c.Flow.Paths = paths
+	}
+
+	if s := cfg.Spec.Api.Sunset; s != "" {
+		if c.Sunset, err = time.Parse("2006-01-02", s); err != nil {
+			return errors.Wrap(err, "invalid sunset")
+		}
+	}
 
 	r := router.New(c)
 	if r == nil {
 		return errors.New("failed to new")
 	}
 
+	if err := r.Init(); err != nil {
+		return errors.Wrap(err, "failed to init")
+	}
+
 	return r.Run()
 }
@@ -14,6 +14,7 @@ package cmd
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -31,6 +32,30 @@ func TestInitConfig(t *testing.T) {
 	assert.Equal(t, nil, err)
 }
 
+func TestInitLogger(t *testing.T) {
+	c, err := initConfig("../tests/config.yml")
+	assert.Equal(t, nil, err)
+
+	err = initLogger(c)
+	assert.Equal(t, nil, err)
+
+	c.Spec.Log.Level = "invalid"
+	err = initLogger(c)
+	assert.NotEqual(t, nil, err)
+}
+
+func TestInitTracing(t *testing.T) {
+	c, err := initConfig("../tests/config.yml")
+	assert.Equal(t, nil, err)
+
+	_, err = initTracing(c)
+	assert.Equal(t, nil, err)
+
+	c.Spec.Tracing.Exporter = "invalid"
+	_, err = initTracing(c)
+	assert.NotEqual(t, nil, err)
+}
+
 func TestInitPostgres(t *testing.T) {
 	c, err := initConfig("../tests/config.yml")
 	assert.Equal(t, nil, err)
@@ -46,3 +71,122 @@ func TestInitDoc(t *testing.T) {
 	err = initDoc(c)
 	assert.Equal(t, nil, err)
 }
+
+func TestInitEtcd(t *testing.T) {
+	c, err := initConfig("../tests/config.yml")
+	assert.Equal(t, nil, err)
+
+	_, err = initEtcd(c)
+	assert.Equal(t, nil, err)
+}
+
+func TestInitCluster(t *testing.T) {
+	c, err := initConfig("../tests/config.yml")
+	assert.Equal(t, nil, err)
+
+	c.Spec.Cluster.AdvertiseUrl = "http://10.0.0.1:9080"
+
+	l, err := initCluster(c, nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "http://10.0.0.1:9080", l.Id)
+	assert.Equal(t, 10*time.Second, l.Ttl)
+}
+
+func TestInitRateLimit(t *testing.T) {
+	c, err := initConfig("../tests/config.yml")
+	assert.Equal(t, nil, err)
+
+	l, err := initRateLimit(c, nil, nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(10), l.Login.User.Count)
+
+	c.Spec.RateLimit.Backend = "invalid"
+	_, err = initRateLimit(c, nil, nil)
+	assert.NotEqual(t, nil, err)
+}
+
+func TestInitCors(t *testing.T) {
+	c, err := initConfig("../tests/config.yml")
+	assert.Equal(t, nil, err)
+
+	o := initCors(c)
+	assert.Equal(t, 0, len(o.AllowOrigins))
+	assert.Equal(t, []string{"*"}, o.Groups["/swagger"].AllowOrigins)
+
+	_, err = o.Middleware()
+	assert.Equal(t, nil, err)
+}
+
+func TestInitAuth(t *testing.T) {
+	c, err := initConfig("../tests/config.yml")
+	assert.Equal(t, nil, err)
+
+	a, err := initAuth(c)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "", a.Oidc.Issuer)
+	assert.Equal(t, "admin", a.Oidc.Roles[0].Role)
+	assert.Equal(t, int64(1), a.Totp.Skew)
+	assert.Equal(t, []byte("metalflow-auth"), a.Secret)
+	assert.Equal(t, []byte("metalflow-totp"), a.Totp.Key)
+
+	c.Spec.Auth.Secret = ""
+	_, err = initAuth(c)
+	assert.NotEqual(t, nil, err)
+
+	c.Spec.Auth.Secret = "metalflow-auth"
+
+	c.Spec.Auth.Totp.Key = ""
+	_, err = initAuth(c)
+	assert.NotEqual(t, nil, err)
+
+	c.Spec.Auth.Totp.Key = "metalflow-totp"
+	c.Spec.Auth.Backend = "ldap"
+	_, err = initAuth(c)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "(uid=%s)", initLdap(c).UserFilter)
+
+	c.Spec.Auth.Backend = "invalid"
+	_, err = initAuth(c)
+	assert.NotEqual(t, nil, err)
+
+	// The shipped secrets are empty, to be set by the operator.
+	c, err = initConfig("../config/config.yml")
+	assert.Equal(t, nil, err)
+	_, err = initAuth(c)
+	assert.NotEqual(t, nil, err)
+}
+
+func TestInitExec(t *testing.T) {
+	c, err := initConfig("../tests/config.yml")
+	assert.Equal(t, nil, err)
+
+	e := initExec(c, nil)
+	assert.Equal(t, time.Minute, e.Timeout)
+	assert.Equal(t, []string{"uptime", "df -h", "systemctl status"}, e.Allow["user"])
+}
+
+func TestInitArtifact(t *testing.T) {
+	c, err := initConfig("../tests/config.yml")
+	assert.Equal(t, nil, err)
+
+	a, err := initArtifact(c, nil, "http://127.0.0.1:9080/api/v1")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(32<<20), a.MaxSize)
+	assert.Equal(t, time.Hour, a.Ttl)
+	assert.Equal(t, []byte("metalflow-artifact"), a.Secret)
+
+	c.Spec.Artifact.Secret = ""
+	_, err = initArtifact(c, nil, "")
+	assert.NotEqual(t, nil, err)
+
+	c.Spec.Artifact.Secret = "metalflow-artifact"
+	c.Spec.Artifact.Backend = "invalid"
+	_, err = initArtifact(c, nil, "")
+	assert.NotEqual(t, nil, err)
+
+	// The shipped secret is empty, to be set by the operator.
+	c, err = initConfig("../config/config.yml")
+	assert.Equal(t, nil, err)
+	_, err = initArtifact(c, nil, "")
+	assert.NotEqual(t, nil, err)
+}
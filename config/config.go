@@ -12,6 +12,10 @@
 
 package config
 
+import (
+	"time"
+)
+
 type Config struct {
 	ApiVersion string   `yaml:"apiVersion"`
 	Kind       string   `yaml:"kind"`
@@ -24,8 +28,110 @@ type MetaData struct {
 }
 
 type Spec struct {
-	Etcd     Etcd     `yaml:"etcd"`
-	Postgres Postgres `yaml:"postgres"`
+	Api       Api       `yaml:"api"`
+	Artifact  Artifact  `yaml:"artifact"`
+	Auth      Auth      `yaml:"auth"`
+	Cluster   Cluster   `yaml:"cluster"`
+	Cors      Cors      `yaml:"cors"`
+	Etcd      Etcd      `yaml:"etcd"`
+	Exec      Exec      `yaml:"exec"`
+	Log       Log       `yaml:"log"`
+	Postgres  Postgres  `yaml:"postgres"`
+	RateLimit RateLimit `yaml:"rateLimit"`
+	Tracing   Tracing   `yaml:"tracing"`
+}
+
+type Api struct {
+	Sunset         string   `yaml:"sunset"`
+	TrustedProxies []string `yaml:"trustedProxies"`
+}
+
+type Artifact struct {
+	Backend   string              `yaml:"backend"`
+	Dir       string              `yaml:"dir"`
+	MaxSizeMb int64               `yaml:"maxSizeMb"`
+	Paths     map[string][]string `yaml:"paths"`
+	Secret    string              `yaml:"secret"`
+	UrlTtl    time.Duration       `yaml:"urlTtl"`
+}
+
+type Auth struct {
+	Backend string `yaml:"backend"`
+	Secret  string `yaml:"secret"`
+	Ldap    Ldap   `yaml:"ldap"`
+	Oidc    Oidc   `yaml:"oidc"`
+	Totp    Totp   `yaml:"totp"`
+}
+
+type Cluster struct {
+	AdvertiseUrl string        `yaml:"advertiseUrl"`
+	Ttl          time.Duration `yaml:"ttl"`
+}
+
+type Totp struct {
+	Issuer        string   `yaml:"issuer"`
+	Key           string   `yaml:"key"`
+	RequiredRoles []string `yaml:"requiredRoles"`
+	Skew          int64    `yaml:"skew"`
+}
+
+type Ldap struct {
+	Url                string        `yaml:"url"`
+	StartTls           bool          `yaml:"startTls"`
+	CaFile             string        `yaml:"caFile"`
+	InsecureSkipVerify bool          `yaml:"insecureSkipVerify"`
+	Timeout            time.Duration `yaml:"timeout"`
+	BindDn             string        `yaml:"bindDn"`
+	BindPassword       string        `yaml:"bindPassword"`
+	BaseDn             string        `yaml:"baseDn"`
+	UserFilter         string        `yaml:"userFilter"`
+	GroupBaseDn        string        `yaml:"groupBaseDn"`
+	GroupFilter        string        `yaml:"groupFilter"`
+	GroupAttribute     string        `yaml:"groupAttribute"`
+	Roles              []RoleMapping `yaml:"roles"`
+	DefaultRole        string        `yaml:"defaultRole"`
+}
+
+type Oidc struct {
+	Issuer        string        `yaml:"issuer"`
+	ClientId      string        `yaml:"clientId"`
+	ClientSecret  string        `yaml:"clientSecret"`
+	RedirectUrl   string        `yaml:"redirectUrl"`
+	Scopes        []string      `yaml:"scopes"`
+	UsernameClaim string        `yaml:"usernameClaim"`
+	GroupsClaim   string        `yaml:"groupsClaim"`
+	Roles         []RoleMapping `yaml:"roles"`
+	DefaultRole   string        `yaml:"defaultRole"`
+	CreateAccount bool          `yaml:"createAccount"`
+}
+
+type RoleMapping struct {
+	Group string `yaml:"group"`
+	Role  string `yaml:"role"`
+}
+
+type Cors struct {
+	CorsPolicy `yaml:",inline"`
+	Groups     map[string]CorsPolicy `yaml:"groups"`
+}
+
+type CorsPolicy struct {
+	AllowOrigins     []string      `yaml:"allowOrigins"`
+	AllowMethods     []string      `yaml:"allowMethods"`
+	AllowHeaders     []string      `yaml:"allowHeaders"`
+	ExposeHeaders    []string      `yaml:"exposeHeaders"`
+	AllowCredentials bool          `yaml:"allowCredentials"`
+	MaxAge           time.Duration `yaml:"maxAge"`
+}
+
+type Exec struct {
+	Allow   map[string][]string `yaml:"allow"`
+	Timeout time.Duration       `yaml:"timeout"`
+}
+
+type Log struct {
+	Format string `yaml:"format"`
+	Level  string `yaml:"level"`
 }
 
 type Etcd struct {
@@ -34,11 +140,68 @@ type Etcd struct {
 }
 
 type Postgres struct {
+	Host            string `yaml:"host"`
+	Port            string `yaml:"port"`
+	User            string `yaml:"user"`
+	Pass            string `yaml:"pass"`
+	Db              string `yaml:"db"`
+	Dsn             string `yaml:"dsn"`
+	SslMode         string `yaml:"sslMode"`
+	SslRootCert     string `yaml:"sslRootCert"`
+	SslCert         string `yaml:"sslCert"`
+	SslKey          string `yaml:"sslKey"`
+	TimeZone        string `yaml:"timeZone"`
+	ApplicationName string `yaml:"applicationName"`
+	SearchPath      string `yaml:"searchPath"`
+	Retry           Retry  `yaml:"retry"`
+
+	Replicas          []Replica     `yaml:"replicas"`
+	HealthInterval    time.Duration `yaml:"healthInterval"`
+	PrimaryAfterWrite *bool         `yaml:"primaryAfterWrite"`
+}
+
+type Replica struct {
 	Host string `yaml:"host"`
 	Port string `yaml:"port"`
-	User string `yaml:"user"`
-	Pass string `yaml:"pass"`
-	Db   string `yaml:"db"`
+	Dsn  string `yaml:"dsn"`
+}
+
+type Retry struct {
+	Count      int           `yaml:"count"`
+	Backoff    time.Duration `yaml:"backoff"`
+	MaxBackoff time.Duration `yaml:"maxBackoff"`
+}
+
+type RateLimit struct {
+	Backend string  `yaml:"backend"`
+	Login   Login   `yaml:"login"`
+	Lockout Lockout `yaml:"lockout"`
+	Token   Limit   `yaml:"token"`
+}
+
+type Login struct {
+	Ip   Limit `yaml:"ip"`
+	User Limit `yaml:"user"`
+}
+
+type Limit struct {
+	Count  int64         `yaml:"count"`
+	Window time.Duration `yaml:"window"`
+}
+
+type Lockout struct {
+	Threshold int64         `yaml:"threshold"`
+	Window    time.Duration `yaml:"window"`
+	Base      time.Duration `yaml:"base"`
+	Max       time.Duration `yaml:"max"`
+}
+
+type Tracing struct {
+	Exporter    string  `yaml:"exporter"`
+	Endpoint    string  `yaml:"endpoint"`
+	Insecure    bool    `yaml:"insecure"`
+	SampleRatio float64 `yaml:"sampleRatio"`
+	ServiceName string  `yaml:"serviceName"`
 }
 
 var (
@@ -19,7 +19,6 @@ import (
 	"github.com/gin-gonic/gin"
 
 	"github.com/craftslab/metalflow/model"
-	"github.com/craftslab/metalflow/util"
 )
 
 // GetAccount godoc
@@ -28,30 +27,37 @@ import (
 // @Tags accounts
 // @Accept json
 // @Produce json
-// @Param id path uint true "Account ID"
+// @Param id path string true "Account ID, or self"
+// @Param If-None-Match header string false "ETag"
 // @Success 200 {object} model.Account
-// @Failure 400 {object} util.HTTPError
-// @Failure 404 {object} util.HTTPError
-// @Failure 500 {object} util.HTTPError
+// @Header 200 {string} ETag "Version of the account"
+// @Success 304 "Not Modified"
+// @Failure 400 {object} util.Problem
+// @Failure 404 {object} util.Problem
+// @Failure 500 {object} util.Problem
 // @Router /accounts/{id} [get]
 func (c *controller) GetAccount(ctx *gin.Context) {
 	param := ctx.Param("id")
 
 	if param == "self" {
-		if account, err := model.GetSelfAccount(); err == nil {
-			ctx.JSON(http.StatusOK, account)
+		if account, err := model.GetSelfAccount(ctx.Request.Context()); err == nil {
+			if !notModified(ctx, account.Version) {
+				ctx.JSON(http.StatusOK, account)
+			}
 		} else {
-			util.NewError(ctx, http.StatusNotFound, err)
+			fail(ctx, http.StatusNotFound, err)
 		}
 	} else {
 		if id, err := strconv.ParseUint(param, 10, 64); err == nil {
-			if account, e := model.GetAccount(uint(id)); e == nil {
-				ctx.JSON(http.StatusOK, account)
+			if account, e := model.GetAccount(ctx.Request.Context(), uint(id)); e == nil {
+				if !notModified(ctx, account.Version) {
+					ctx.JSON(http.StatusOK, account)
+				}
 			} else {
-				util.NewError(ctx, http.StatusNotFound, e)
+				fail(ctx, http.StatusNotFound, e)
 			}
 		} else {
-			util.NewError(ctx, http.StatusBadRequest, err)
+			fail(ctx, http.StatusBadRequest, err)
 		}
 	}
 }
@@ -64,18 +70,39 @@ func (c *controller) GetAccount(ctx *gin.Context) {
 // @Produce json
 // @Param q query string true "Username search by q"
 // @Success 200 {object} model.Account
-// @Failure 400 {object} util.HTTPError
-// @Failure 404 {object} util.HTTPError
-// @Failure 500 {object} util.HTTPError
+// @Failure 400 {object} util.Problem
+// @Failure 404 {object} util.Problem
+// @Failure 500 {object} util.Problem
 // @Router /accounts [get]
 func (c *controller) QueryAccount(ctx *gin.Context) {
 	q := ctx.Request.URL.Query().Get("q")
 
-	account, err := model.QueryAccount(q)
+	account, err := model.QueryAccount(ctx.Request.Context(), q)
 	if err != nil {
-		util.NewError(ctx, http.StatusNotFound, err)
+		fail(ctx, http.StatusNotFound, err)
 		return
 	}
 
 	ctx.JSON(http.StatusOK, account)
 }
+
+// accountId returns the ID of the account of the request, resolving "self" to
+// that of the caller.
+func accountId(ctx *gin.Context) (uint, error) {
+	param := ctx.Param("id")
+
+	if param == "self" {
+		account, err := model.GetSelfAccount(ctx.Request.Context())
+		if err != nil {
+			return 0, err
+		}
+		return account.Id, nil
+	}
+
+	id, err := strconv.ParseUint(param, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint(id), nil
+}
@@ -0,0 +1,144 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/craftslab/metalflow/audit"
+)
+
+// AddArtifact godoc
+// @Summary Add artifact
+// @Description Upload a file kept by its SHA-256 in project, or return the artifact of the same content, which only maintainers of the project may do
+// @Tags artifacts
+// @Accept application/octet-stream
+// @Produce json
+// @Param project query uint true "Project ID"
+// @Param name query string false "File name, else the SHA-256"
+// @Param content body string true "Content"
+// @Success 200 {object} artifact.Artifact
+// @Failure 400 {object} util.Problem
+// @Failure 403 {object} util.Problem
+// @Failure 404 {object} util.Problem
+// @Failure 413 {object} util.Problem
+// @Failure 500 {object} util.Problem
+// @Router /artifacts [post]
+func (c *controller) AddArtifact(ctx *gin.Context) {
+	project, err := strconv.ParseUint(ctx.Query("project"), 10, 64)
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	a, err := c.artifact.Add(ctx.Request.Context(), uint(project), ctx.Query("name"), c.identity(ctx), ctx.Request.Body)
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	audit.SetAfter(ctx, a)
+
+	ctx.JSON(http.StatusOK, a)
+}
+
+// GetArtifact godoc
+// @Summary Get artifact by SHA-256
+// @Description Get artifact by SHA-256 in project, with the URL of its content signed for a while
+// @Tags artifacts
+// @Accept json
+// @Produce json
+// @Param sha256 path string true "Artifact SHA-256"
+// @Param project query uint true "Project ID"
+// @Success 200 {object} artifact.Artifact
+// @Failure 400 {object} util.Problem
+// @Failure 404 {object} util.Problem
+// @Failure 500 {object} util.Problem
+// @Router /artifacts/{sha256} [get]
+func (c *controller) GetArtifact(ctx *gin.Context) {
+	project, err := strconv.ParseUint(ctx.Query("project"), 10, 64)
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	a, err := c.artifact.Get(ctx.Request.Context(), uint(project), ctx.Param("sha256"))
+	if err != nil {
+		fail(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, a)
+}
+
+// QueryArtifact godoc
+// @Summary Query artifact
+// @Description Query the artifacts of the projects of the user, newest first
+// @Tags artifacts
+// @Accept json
+// @Produce json
+// @Success 200 {array} artifact.Artifact
+// @Failure 500 {object} util.Problem
+// @Router /artifacts [get]
+func (c *controller) QueryArtifact(ctx *gin.Context) {
+	buf, err := c.artifact.Query(ctx.Request.Context())
+	if err != nil {
+		fail(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, buf)
+}
+
+// GetArtifactContent godoc
+// @Summary Get artifact content
+// @Description Download the content of artifact through its signed URL, without a token
+// @Tags artifacts
+// @Produce application/octet-stream
+// @Param sha256 path string true "Artifact SHA-256"
+// @Param project query uint true "Project ID"
+// @Param expires query int true "Expiry of the signature in Unix seconds"
+// @Param signature query string true "Signature"
+// @Success 200 {string} string
+// @Failure 403 {object} util.Problem
+// @Failure 404 {object} util.Problem
+// @Failure 500 {object} util.Problem
+// @Router /artifacts/{sha256}/content [get]
+func (c *controller) GetArtifactContent(ctx *gin.Context) {
+	sum := ctx.Param("sha256")
+
+	project, err := strconv.ParseUint(ctx.Query("project"), 10, 64)
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := c.artifact.Verify(uint(project), sum, ctx.Query("expires"), ctx.Query("signature")); err != nil {
+		fail(ctx, http.StatusForbidden, err)
+		return
+	}
+
+	a, buf, err := c.artifact.Read(ctx.Request.Context(), uint(project), sum)
+	if err != nil {
+		fail(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Name}))
+	ctx.Header("ETag", `"`+a.Sha256+`"`)
+	ctx.Data(http.StatusOK, "application/octet-stream", buf)
+}
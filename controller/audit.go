@@ -0,0 +1,111 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/craftslab/metalflow/audit"
+)
+
+const (
+	formatJSONLines = "jsonl"
+)
+
+// QueryAudit godoc
+// @Summary Query audit log
+// @Description Query audit log of mutating API calls, newest first
+// @Tags audit
+// @Accept json
+// @Produce json
+// @Produce application/x-ndjson
+// @Param actor query string false "Actor"
+// @Param action query string false "Action, e.g. PUT /nodes/:id"
+// @Param target query string false "Target path"
+// @Param requestId query string false "Request ID"
+// @Param outcome query string false "Outcome" Enums(success, failure)
+// @Param since query string false "Since time (RFC3339)"
+// @Param until query string false "Until time (RFC3339)"
+// @Param limit query int false "Limit"
+// @Param format query string false "Output format" Enums(json, jsonl)
+// @Success 200 {array} audit.Entry
+// @Failure 400 {object} util.Problem
+// @Failure 404 {object} util.Problem
+// @Failure 500 {object} util.Problem
+// @Router /audit [get]
+func (c *controller) QueryAudit(ctx *gin.Context) {
+	filter, err := auditFilter(ctx)
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	entries, err := c.audit.Query(ctx.Request.Context(), filter)
+	if err != nil {
+		fail(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	if ctx.Query("format") != formatJSONLines {
+		ctx.JSON(http.StatusOK, entries)
+		return
+	}
+
+	ctx.Header("Content-Type", "application/x-ndjson")
+	ctx.Status(http.StatusOK)
+
+	encoder := json.NewEncoder(ctx.Writer)
+	for i := range entries {
+		if err := encoder.Encode(&entries[i]); err != nil {
+			return
+		}
+	}
+}
+
+func auditFilter(ctx *gin.Context) (*audit.Filter, error) {
+	var err error
+
+	filter := &audit.Filter{
+		Actor:     ctx.Query("actor"),
+		Action:    ctx.Query("action"),
+		Target:    ctx.Query("target"),
+		RequestId: ctx.Query("requestId"),
+		Outcome:   ctx.Query("outcome"),
+	}
+
+	if v := ctx.Query("since"); v != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, errors.Wrap(err, "invalid since")
+		}
+	}
+
+	if v := ctx.Query("until"); v != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, errors.Wrap(err, "invalid until")
+		}
+	}
+
+	if v := ctx.Query("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil {
+			return nil, errors.Wrap(err, "invalid limit")
+		}
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,38 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetLeader godoc
+// @Summary Get cluster leader
+// @Description Get the master which runs the flows and schedules, and whether it is the one serving the request
+// @Tags cluster
+// @Accept json
+// @Produce json
+// @Success 200 {object} cluster.Status
+// @Failure 503 {object} util.Problem
+// @Router /cluster/leader [get]
+func (c *controller) GetLeader(ctx *gin.Context) {
+	s, err := c.cluster.Status(ctx.Request.Context())
+	if err != nil {
+		fail(ctx, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, s)
+}
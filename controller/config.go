@@ -18,7 +18,6 @@ import (
 	"github.com/gin-gonic/gin"
 
 	"github.com/craftslab/metalflow/model"
-	"github.com/craftslab/metalflow/util"
 )
 
 // GetServerVersion godoc
@@ -28,14 +27,14 @@ import (
 // @Accept json
 // @Produce json
 // @Success 200 {string} model.Version
-// @Failure 400 {object} util.HTTPError
-// @Failure 404 {object} util.HTTPError
-// @Failure 500 {object} util.HTTPError
+// @Failure 400 {object} util.Problem
+// @Failure 404 {object} util.Problem
+// @Failure 500 {object} util.Problem
 // @Router /config/server/version [get]
 func (c *controller) GetServerVersion(ctx *gin.Context) {
-	version, err := model.ServerVersion()
+	version, err := model.ServerVersion(ctx.Request.Context())
 	if err != nil {
-		util.NewError(ctx, http.StatusNotFound, err)
+		fail(ctx, http.StatusNotFound, err)
 		return
 	}
 
@@ -14,33 +14,120 @@ package controller
 
 import (
 	"github.com/gin-gonic/gin"
+
+	"github.com/craftslab/metalflow/artifact"
+	"github.com/craftslab/metalflow/audit"
+	"github.com/craftslab/metalflow/cluster"
+	"github.com/craftslab/metalflow/exec"
+	"github.com/craftslab/metalflow/flow"
+	"github.com/craftslab/metalflow/schedule"
 )
 
 type Controller interface {
 	GetAccount(ctx *gin.Context)
 	QueryAccount(ctx *gin.Context)
+	PatchAccount(ctx *gin.Context)
 
 	GetServerVersion(ctx *gin.Context)
 
+	GetLeader(ctx *gin.Context)
+
 	GetNode(ctx *gin.Context)
 	GetHealth(ctx *gin.Context)
 	GetInfo(ctx *gin.Context)
 	GetPerf(ctx *gin.Context)
 	QueryNode(ctx *gin.Context)
+	PatchNode(ctx *gin.Context)
+	SetLabels(ctx *gin.Context)
+	PatchLabels(ctx *gin.Context)
+	SetLifecycle(ctx *gin.Context)
+	EnterMaintenance(ctx *gin.Context)
+	ExitMaintenance(ctx *gin.Context)
+	QueryTransition(ctx *gin.Context)
 	AddNode(ctx *gin.Context)
 	DelNode(ctx *gin.Context)
+	ImportNode(ctx *gin.Context)
+	ExportNode(ctx *gin.Context)
+	ExecNode(ctx *gin.Context)
+	QueryExec(ctx *gin.Context)
+
+	GetProject(ctx *gin.Context)
+	QueryProject(ctx *gin.Context)
+	AddProject(ctx *gin.Context)
+	QueryMember(ctx *gin.Context)
+	SetMember(ctx *gin.Context)
+	DelMember(ctx *gin.Context)
+
+	QueryAudit(ctx *gin.Context)
+
+	AddArtifact(ctx *gin.Context)
+	GetArtifact(ctx *gin.Context)
+	QueryArtifact(ctx *gin.Context)
+	GetArtifactContent(ctx *gin.Context)
+
+	SubmitFlow(ctx *gin.Context)
+	GetFlow(ctx *gin.Context)
+	QueryFlow(ctx *gin.Context)
+	PauseFlow(ctx *gin.Context)
+	ResumeFlow(ctx *gin.Context)
+	AbortFlow(ctx *gin.Context)
+
+	AddSchedule(ctx *gin.Context)
+	GetSchedule(ctx *gin.Context)
+	QuerySchedule(ctx *gin.Context)
+	UpdateSchedule(ctx *gin.Context)
+	DelSchedule(ctx *gin.Context)
+	QueryScheduleRun(ctx *gin.Context)
 }
 
 type Config struct {
+	Artifact artifact.Artifacts
+	Audit    audit.Store
+	Cluster  cluster.Cluster
+	Exec     exec.Exec
+	Flow     flow.Engine
+	Identity func(*gin.Context) string
+	Role     func(*gin.Context) string
+	Schedule schedule.Scheduler
 }
 
 type controller struct {
+	artifact artifact.Artifacts
+	audit    audit.Store
+	cluster  cluster.Cluster
+	exec     exec.Exec
+	flow     flow.Engine
+	identity func(*gin.Context) string
+	role     func(*gin.Context) string
+	schedule schedule.Scheduler
 }
 
-func New(_ *Config) Controller {
-	return &controller{}
+func New(config *Config) Controller {
+	return &controller{
+		artifact: config.Artifact,
+		audit:    config.Audit,
+		cluster:  config.Cluster,
+		exec:     config.Exec,
+		flow:     config.Flow,
+		identity: config.Identity,
+		role:     config.Role,
+		schedule: config.Schedule,
+	}
 }
 
 func DefaultConfig() *Config {
-	return &Config{}
+	return &Config{
+		Artifact: artifact.New(artifact.DefaultConfig()),
+		Audit:    audit.NewMemoryStore(),
+		Cluster:  cluster.New(cluster.DefaultConfig()),
+		Exec:     exec.New(exec.DefaultConfig()),
+		Flow:     flow.New(flow.DefaultConfig()),
+		Identity: func(*gin.Context) string {
+			return audit.Anonymous
+		},
+		Role: func(*gin.Context) string {
+			return ""
+		},
+		Schedule: schedule.New(schedule.DefaultConfig()),
+	}
 }
@@ -0,0 +1,71 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/craftslab/metalflow/artifact"
+	"github.com/craftslab/metalflow/cluster"
+	"github.com/craftslab/metalflow/exec"
+	"github.com/craftslab/metalflow/flow"
+	"github.com/craftslab/metalflow/model"
+	"github.com/craftslab/metalflow/schedule"
+	"github.com/craftslab/metalflow/util"
+)
+
+// problem is the status and code answered for err.
+type problem struct {
+	err    error
+	status int
+	code   string
+}
+
+var (
+	errNotImplemented = errors.New("not implemented")
+)
+
+var problems = []problem{
+	{model.ErrAccountNotFound, http.StatusNotFound, "account.not_found"},
+	{model.ErrConflict, http.StatusPreconditionFailed, "version.conflict"},
+	{model.ErrForbidden, http.StatusForbidden, "auth.forbidden"},
+	{model.ErrNodeNotFound, http.StatusNotFound, "node.not_found"},
+	{model.ErrProjectNotFound, http.StatusNotFound, "project.not_found"},
+	{model.ErrTransition, http.StatusConflict, "node.invalid_transition"},
+	{artifact.ErrNotFound, http.StatusNotFound, "artifact.not_found"},
+	{artifact.ErrSignature, http.StatusForbidden, "artifact.invalid_signature"},
+	{artifact.ErrTooLarge, http.StatusRequestEntityTooLarge, "artifact.too_large"},
+	{exec.ErrNotAllowed, http.StatusForbidden, "exec.not_allowed"},
+	{flow.ErrNotFound, http.StatusNotFound, "flow.not_found"},
+	{flow.ErrState, http.StatusConflict, "flow.invalid_state"},
+	{schedule.ErrNotFound, http.StatusNotFound, "schedule.not_found"},
+	{cluster.ErrNoLeader, http.StatusServiceUnavailable, cluster.CodeNoLeader},
+	{errMediaType, http.StatusUnsupportedMediaType, "request.unsupported_media_type"},
+	{errNotImplemented, http.StatusNotImplemented, "request.not_implemented"},
+}
+
+// fail answers err with the status and code of its problem, or with status
+// otherwise.
+func fail(ctx *gin.Context, status int, err error) {
+	for _, p := range problems {
+		if errors.Is(err, p.err) {
+			util.NewError(ctx, p.status, util.WithCode(p.code, err))
+			return
+		}
+	}
+
+	util.NewError(ctx, status, err)
+}
@@ -0,0 +1,71 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/craftslab/metalflow/model"
+)
+
+// etag is the entity tag of an item at version.
+func etag(version uint) string {
+	return `"` + strconv.FormatUint(uint64(version), 10) + `"`
+}
+
+// notModified sets the ETag of an item at version, and answers 304 if it
+// matches If-None-Match.
+func notModified(ctx *gin.Context, version uint) bool {
+	tag := etag(version)
+	ctx.Header("ETag", tag)
+
+	for _, item := range strings.Split(ctx.GetHeader("If-None-Match"), ",") {
+		item = strings.TrimPrefix(strings.TrimSpace(item), "W/")
+		if item == tag || item == "*" {
+			ctx.Status(http.StatusNotModified)
+			return true
+		}
+	}
+
+	return false
+}
+
+// precondition requires If-Match, whose version the model compares with the
+// one of the item it updates. It answers 428 without it.
+func precondition(ctx *gin.Context) bool {
+	h := strings.TrimSpace(ctx.GetHeader("If-Match"))
+
+	if h == "" {
+		fail(ctx, http.StatusPreconditionRequired, errors.New("missing If-Match"))
+		return false
+	}
+
+	if h == "*" {
+		return true
+	}
+
+	v, err := strconv.ParseUint(strings.Trim(h, `"`), 10, 64)
+	if err != nil || !strings.HasPrefix(h, `"`) {
+		fail(ctx, http.StatusPreconditionFailed, errors.New("invalid If-Match"))
+		return false
+	}
+
+	ctx.Request = ctx.Request.WithContext(model.WithVersion(ctx.Request.Context(), uint(v)))
+
+	return true
+}
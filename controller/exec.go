@@ -0,0 +1,101 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/craftslab/metalflow/audit"
+	"github.com/craftslab/metalflow/exec"
+)
+
+// ExecNode godoc
+// @Summary Run command on node
+// @Description Run command on node if allowed to the role of the caller, streaming its output as server-sent events named stdout and stderr, followed by the execution named exit
+// @Tags nodes
+// @Accept json
+// @Produce text/event-stream
+// @Param id path uint true "Node ID"
+// @Param request body exec.Request true "Command"
+// @Success 200 {object} exec.Execution
+// @Failure 400 {object} util.Problem
+// @Failure 403 {object} util.Problem
+// @Failure 404 {object} util.Problem
+// @Failure 500 {object} util.Problem
+// @Router /nodes/{id}/exec [post]
+func (c *controller) ExecNode(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	var r exec.Request
+	if err := ctx.ShouldBindJSON(&r); err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	r.Creator = c.identity(ctx)
+	r.Node = uint(id)
+	r.Role = c.role(ctx)
+
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("X-Accel-Buffering", "no")
+
+	x, err := c.exec.Run(ctx.Request.Context(), &r, func(ev exec.Event) error {
+		ctx.SSEvent(ev.Stream, ev.Data)
+		ctx.Writer.Flush()
+		return ctx.Request.Context().Err()
+	})
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	audit.SetAfter(ctx, x)
+
+	ctx.SSEvent(exec.StreamExit, x)
+	ctx.Writer.Flush()
+}
+
+// QueryExec godoc
+// @Summary Query executions of node
+// @Description Query the commands run on node, newest first
+// @Tags nodes
+// @Accept json
+// @Produce json
+// @Param id path uint true "Node ID"
+// @Success 200 {array} exec.Execution
+// @Failure 400 {object} util.Problem
+// @Failure 404 {object} util.Problem
+// @Failure 500 {object} util.Problem
+// @Router /nodes/{id}/exec [get]
+func (c *controller) QueryExec(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	buf, err := c.exec.Query(ctx.Request.Context(), uint(id))
+	if err != nil {
+		fail(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, buf)
+}
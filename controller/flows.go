@@ -0,0 +1,185 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/craftslab/metalflow/audit"
+	"github.com/craftslab/metalflow/flow"
+)
+
+const (
+	maxSpec = 1 << 20
+)
+
+// SubmitFlow godoc
+// @Summary Submit flow
+// @Description Submit a flow spec in YAML or JSON, which is run on the nodes of its project matching its selector
+// @Tags flows
+// @Accept application/yaml
+// @Accept json
+// @Produce json
+// @Param spec body flow.Spec true "Flow spec"
+// @Success 200 {object} flow.Flow
+// @Failure 400 {object} util.Problem
+// @Failure 403 {object} util.Problem
+// @Failure 500 {object} util.Problem
+// @Router /flows [post]
+func (c *controller) SubmitFlow(ctx *gin.Context) {
+	buf, err := ioutil.ReadAll(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxSpec))
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	spec, err := flow.Parse(buf)
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	f, err := c.flow.Submit(ctx.Request.Context(), spec, c.identity(ctx))
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	audit.SetAfter(ctx, f)
+
+	ctx.JSON(http.StatusOK, f)
+}
+
+// GetFlow godoc
+// @Summary Get flow by ID
+// @Description Get flow by ID with the state of its steps on every node
+// @Tags flows
+// @Accept json
+// @Produce json
+// @Param id path uint true "Flow ID"
+// @Success 200 {object} flow.Status
+// @Failure 400 {object} util.Problem
+// @Failure 404 {object} util.Problem
+// @Failure 500 {object} util.Problem
+// @Router /flows/{id} [get]
+func (c *controller) GetFlow(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	s, err := c.flow.Get(ctx.Request.Context(), uint(id))
+	if err != nil {
+		fail(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, s)
+}
+
+// QueryFlow godoc
+// @Summary Query flow
+// @Description Query the flows of the projects of the caller, newest first
+// @Tags flows
+// @Accept json
+// @Produce json
+// @Success 200 {array} flow.Flow
+// @Failure 500 {object} util.Problem
+// @Router /flows [get]
+func (c *controller) QueryFlow(ctx *gin.Context) {
+	flows, err := c.flow.Query(ctx.Request.Context())
+	if err != nil {
+		fail(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, flows)
+}
+
+// PauseFlow godoc
+// @Summary Pause flow
+// @Description Pause flow, letting its running steps finish
+// @Tags flows
+// @Accept json
+// @Produce json
+// @Param id path uint true "Flow ID"
+// @Success 200 {object} flow.Flow
+// @Failure 400 {object} util.Problem
+// @Failure 403 {object} util.Problem
+// @Failure 404 {object} util.Problem
+// @Failure 409 {object} util.Problem
+// @Failure 500 {object} util.Problem
+// @Router /flows/{id}/pause [post]
+func (c *controller) PauseFlow(ctx *gin.Context) {
+	c.controlFlow(ctx, c.flow.Pause)
+}
+
+// ResumeFlow godoc
+// @Summary Resume flow
+// @Description Resume paused flow
+// @Tags flows
+// @Accept json
+// @Produce json
+// @Param id path uint true "Flow ID"
+// @Success 200 {object} flow.Flow
+// @Failure 400 {object} util.Problem
+// @Failure 403 {object} util.Problem
+// @Failure 404 {object} util.Problem
+// @Failure 409 {object} util.Problem
+// @Failure 500 {object} util.Problem
+// @Router /flows/{id}/resume [post]
+func (c *controller) ResumeFlow(ctx *gin.Context) {
+	c.controlFlow(ctx, c.flow.Resume)
+}
+
+// AbortFlow godoc
+// @Summary Abort flow
+// @Description Abort flow, cancelling its running steps
+// @Tags flows
+// @Accept json
+// @Produce json
+// @Param id path uint true "Flow ID"
+// @Success 200 {object} flow.Flow
+// @Failure 400 {object} util.Problem
+// @Failure 403 {object} util.Problem
+// @Failure 404 {object} util.Problem
+// @Failure 409 {object} util.Problem
+// @Failure 500 {object} util.Problem
+// @Router /flows/{id}/abort [post]
+func (c *controller) AbortFlow(ctx *gin.Context) {
+	c.controlFlow(ctx, c.flow.Abort)
+}
+
+func (c *controller) controlFlow(ctx *gin.Context, fn func(context.Context, uint) (*flow.Flow, error)) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	f, err := fn(ctx.Request.Context(), uint(id))
+	if err != nil {
+		fail(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	audit.SetAfter(ctx, f.State)
+
+	ctx.JSON(http.StatusOK, f)
+}
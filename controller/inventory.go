@@ -0,0 +1,308 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/craftslab/metalflow/audit"
+	"github.com/craftslab/metalflow/labels"
+	"github.com/craftslab/metalflow/model"
+)
+
+const (
+	formatCsv  = "csv"
+	formatJson = "json"
+	formatYaml = "yaml"
+
+	maxImport = 8 << 20
+)
+
+var (
+	columns = []string{"address", "asset", "comments", "info", "labels", "project", "region"}
+)
+
+// ImportNode godoc
+// @Summary Import node
+// @Description Create the nodes of new addresses and update the others, in CSV, JSON or YAML. Nothing is applied if any row fails.
+// @Tags nodes
+// @Accept json
+// @Accept text/csv
+// @Accept application/yaml
+// @Produce json
+// @Param format query string false "csv, json or yaml, else taken from Content-Type"
+// @Param dryRun query bool false "Validate only"
+// @Success 200 {object} model.ImportReport
+// @Failure 400 {object} util.Problem
+// @Failure 422 {object} model.ImportReport
+// @Failure 500 {object} util.Problem
+// @Router /nodes/import [post]
+func (c *controller) ImportNode(ctx *gin.Context) {
+	if p := ctx.Param("id"); p != "" && p != "import" {
+		fail(ctx, http.StatusNotFound, errors.New("Page not found"))
+		return
+	}
+
+	format := ctx.Query("format")
+	if format == "" {
+		format = contentFormat(ctx.ContentType())
+	}
+
+	dryRun, _ := strconv.ParseBool(ctx.Query("dryRun"))
+
+	buf, err := decodeNode(format, http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxImport))
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	report, err := model.ImportNode(ctx.Request.Context(), buf, dryRun)
+	if err != nil {
+		fail(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	if report.Failed > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, report)
+		return
+	}
+
+	if !dryRun {
+		audit.SetAfter(ctx, report)
+	}
+
+	ctx.JSON(http.StatusOK, report)
+}
+
+// ExportNode godoc
+// @Summary Export node
+// @Description Export the nodes with their inventory and labels in CSV, JSON or YAML
+// @Tags nodes
+// @Produce json
+// @Produce text/csv
+// @Produce application/yaml
+// @Param format query string false "csv, json or yaml"
+// @Param selector query string false "Label selector"
+// @Success 200 {array} model.Node
+// @Failure 400 {object} util.Problem
+// @Failure 500 {object} util.Problem
+// @Router /nodes/export [get]
+func (c *controller) ExportNode(ctx *gin.Context) {
+	format := ctx.DefaultQuery("format", formatJson)
+
+	selector, err := labels.Parse(ctx.Query("selector"))
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	buf, err := model.QueryNode(ctx.Request.Context(), "", selector)
+	if err != nil {
+		fail(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	var w bytes.Buffer
+
+	if err := encodeNode(format, &w, buf); err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	ctx.Header("Content-Disposition", "attachment; filename=nodes."+format)
+	ctx.Data(http.StatusOK, contentType(format), w.Bytes())
+}
+
+func decodeNode(format string, r io.Reader) ([]model.Node, error) {
+	var buf []model.Node
+
+	switch format {
+	case formatCsv:
+		return decodeCsv(r)
+	case formatJson:
+		if err := json.NewDecoder(r).Decode(&buf); err != nil {
+			return nil, errors.Wrap(err, "failed to decode")
+		}
+	case formatYaml:
+		if err := yaml.NewDecoder(r).Decode(&buf); err != nil {
+			return nil, errors.Wrap(err, "failed to decode")
+		}
+	default:
+		return nil, errors.New("invalid format")
+	}
+
+	return buf, nil
+}
+
+func encodeNode(format string, w io.Writer, buf []model.Node) error {
+	switch format {
+	case formatCsv:
+		return encodeCsv(w, buf)
+	case formatJson:
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		return e.Encode(buf)
+	case formatYaml:
+		e := yaml.NewEncoder(w)
+		defer func() { _ = e.Close() }()
+		return e.Encode(buf)
+	}
+
+	return errors.New("invalid format")
+}
+
+// decodeCsv reads the rows by the column names of the header. Labels are
+// written as k1=v1,k2=v2.
+func decodeCsv(r io.Reader) ([]model.Node, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read header")
+	}
+
+	for _, item := range header {
+		if i := sort.SearchStrings(columns, item); i == len(columns) || columns[i] != item {
+			return nil, errors.Errorf("invalid column %q", item)
+		}
+	}
+
+	var buf []model.Node
+
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read")
+		}
+
+		var n model.Node
+
+		for i, item := range header {
+			switch item {
+			case "address":
+				n.Address = record[i]
+			case "asset":
+				n.Asset = record[i]
+			case "comments":
+				n.Comments = record[i]
+			case "info":
+				n.Info = record[i]
+			case "labels":
+				if n.Labels, err = parseLabels(record[i]); err != nil {
+					return nil, errors.Wrapf(err, "row %d", row)
+				}
+			case "project":
+				if record[i] == "" {
+					break
+				}
+				p, err := strconv.ParseUint(record[i], 10, 64)
+				if err != nil {
+					return nil, errors.Errorf("row %d: invalid project", row)
+				}
+				n.Project = uint(p)
+			case "region":
+				n.Region = record[i]
+			}
+		}
+
+		buf = append(buf, n)
+	}
+
+	return buf, nil
+}
+
+func encodeCsv(w io.Writer, buf []model.Node) error {
+	writer := csv.NewWriter(w)
+
+	if err := writer.Write(columns); err != nil {
+		return errors.Wrap(err, "failed to write")
+	}
+
+	for _, n := range buf {
+		keys := make([]string, 0, len(n.Labels))
+		for k := range n.Labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		l := make([]string, len(keys))
+		for i, k := range keys {
+			l[i] = k + "=" + n.Labels[k]
+		}
+
+		record := []string{n.Address, n.Asset, n.Comments, n.Info, strings.Join(l, ","),
+			strconv.FormatUint(uint64(n.Project), 10), n.Region}
+
+		if err := writer.Write(record); err != nil {
+			return errors.Wrap(err, "failed to write")
+		}
+	}
+
+	writer.Flush()
+
+	return writer.Error()
+}
+
+func parseLabels(s string) (map[string]string, error) {
+	buf := map[string]string{}
+
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.Errorf("invalid label %q", item)
+		}
+		buf[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+
+	return buf, nil
+}
+
+func contentFormat(t string) string {
+	switch t {
+	case "text/csv":
+		return formatCsv
+	case "application/yaml", "application/x-yaml", "text/yaml":
+		return formatYaml
+	}
+
+	return formatJson
+}
+
+func contentType(format string) string {
+	switch format {
+	case formatCsv:
+		return "text/csv; charset=utf-8"
+	case formatYaml:
+		return "application/yaml; charset=utf-8"
+	}
+
+	return "application/json; charset=utf-8"
+}
@@ -0,0 +1,189 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/craftslab/metalflow/audit"
+	"github.com/craftslab/metalflow/model"
+)
+
+type lifecycleRequest struct {
+	Reason string `json:"reason"`
+	State  string `json:"state" binding:"required" enums:"provisioning,active,maintenance,draining,decommissioned,retired"`
+}
+
+type maintenanceRequest struct {
+	Reason string    `json:"reason" binding:"required"`
+	Until  time.Time `json:"until"`
+}
+
+type lifecycleState struct {
+	Lifecycle   string             `json:"lifecycle"`
+	Maintenance *model.Maintenance `json:"maintenance,omitempty"`
+}
+
+// SetLifecycle godoc
+// @Summary Set node lifecycle
+// @Description Move node to the lifecycle state, if allowed from its current one
+// @Tags nodes
+// @Accept json
+// @Produce json
+// @Param id path uint true "Node ID"
+// @Param lifecycle body lifecycleRequest true "Lifecycle"
+// @Param If-Match header string true "ETag of the node, or *"
+// @Success 200 {object} model.Node
+// @Failure 400 {object} util.Problem
+// @Failure 403 {object} util.Problem
+// @Failure 404 {object} util.Problem
+// @Failure 409 {object} util.Problem
+// @Failure 412 {object} util.Problem
+// @Failure 428 {object} util.Problem
+// @Router /nodes/{id}/lifecycle [put]
+func (c *controller) SetLifecycle(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	var r lifecycleRequest
+	if err := ctx.ShouldBindJSON(&r); err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	c.updateLifecycle(ctx, uint(id), func() (model.Node, error) {
+		return model.SetLifecycle(ctx.Request.Context(), uint(id), r.State, r.Reason)
+	})
+}
+
+// EnterMaintenance godoc
+// @Summary Enter node maintenance
+// @Description Put node in maintenance, which suspends the dispatch to it until exited or until the given time
+// @Tags nodes
+// @Accept json
+// @Produce json
+// @Param id path uint true "Node ID"
+// @Param maintenance body maintenanceRequest true "Maintenance"
+// @Param If-Match header string true "ETag of the node, or *"
+// @Success 200 {object} model.Node
+// @Failure 400 {object} util.Problem
+// @Failure 403 {object} util.Problem
+// @Failure 404 {object} util.Problem
+// @Failure 409 {object} util.Problem
+// @Failure 412 {object} util.Problem
+// @Failure 428 {object} util.Problem
+// @Router /nodes/{id}/maintenance [put]
+func (c *controller) EnterMaintenance(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	var r maintenanceRequest
+	if err := ctx.ShouldBindJSON(&r); err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	c.updateLifecycle(ctx, uint(id), func() (model.Node, error) {
+		return model.EnterMaintenance(ctx.Request.Context(), uint(id), r.Reason, r.Until)
+	})
+}
+
+// ExitMaintenance godoc
+// @Summary Exit node maintenance
+// @Description Put node back to active
+// @Tags nodes
+// @Accept json
+// @Produce json
+// @Param id path uint true "Node ID"
+// @Param If-Match header string true "ETag of the node, or *"
+// @Success 200 {object} model.Node
+// @Failure 400 {object} util.Problem
+// @Failure 403 {object} util.Problem
+// @Failure 404 {object} util.Problem
+// @Failure 409 {object} util.Problem
+// @Failure 412 {object} util.Problem
+// @Failure 428 {object} util.Problem
+// @Router /nodes/{id}/maintenance [delete]
+func (c *controller) ExitMaintenance(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	c.updateLifecycle(ctx, uint(id), func() (model.Node, error) {
+		return model.ExitMaintenance(ctx.Request.Context(), uint(id))
+	})
+}
+
+// QueryTransition godoc
+// @Summary Query node transitions
+// @Description Query the lifecycle history of node, oldest first
+// @Tags nodes
+// @Accept json
+// @Produce json
+// @Param id path uint true "Node ID"
+// @Success 200 {array} model.Transition
+// @Failure 400 {object} util.Problem
+// @Failure 404 {object} util.Problem
+// @Router /nodes/{id}/transitions [get]
+func (c *controller) QueryTransition(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	buf, err := model.QueryTransition(ctx.Request.Context(), uint(id))
+	if err != nil {
+		fail(ctx, http.StatusNotFound, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, buf)
+}
+
+func (c *controller) updateLifecycle(ctx *gin.Context, id uint, update func() (model.Node, error)) {
+	if !precondition(ctx) {
+		return
+	}
+
+	before, err := model.GetNode(ctx.Request.Context(), id)
+	if err != nil {
+		fail(ctx, http.StatusNotFound, err)
+		return
+	}
+
+	audit.SetBefore(ctx, lifecycleState{Lifecycle: before.Lifecycle, Maintenance: before.Maintenance})
+
+	node, err := update()
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	audit.SetAfter(ctx, lifecycleState{Lifecycle: node.Lifecycle, Maintenance: node.Maintenance})
+
+	ctx.Header("ETag", etag(node.Version))
+	ctx.JSON(http.StatusOK, node)
+}
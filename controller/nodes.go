@@ -17,9 +17,11 @@ import (
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
 
+	"github.com/craftslab/metalflow/audit"
+	"github.com/craftslab/metalflow/labels"
 	"github.com/craftslab/metalflow/model"
-	"github.com/craftslab/metalflow/util"
 )
 
 // GetNode godoc
@@ -29,22 +31,36 @@ import (
 // @Accept json
 // @Produce json
 // @Param id path uint true "Node ID"
+// @Param If-None-Match header string false "ETag"
 // @Success 200 {object} model.Node
-// @Failure 400 {object} util.HTTPError
-// @Failure 404 {object} util.HTTPError
-// @Failure 500 {object} util.HTTPError
+// @Header 200 {string} ETag "Version of the node"
+// @Success 304 "Not Modified"
+// @Failure 400 {object} util.Problem
+// @Failure 404 {object} util.Problem
+// @Failure 500 {object} util.Problem
 // @Router /nodes/{id} [get]
 func (c *controller) GetNode(ctx *gin.Context) {
 	param := ctx.Param("id")
 
+	if param == "export" {
+		c.ExportNode(ctx)
+		return
+	}
+
 	id, err := strconv.ParseUint(param, 10, 64)
 	if err != nil {
-		util.NewError(ctx, http.StatusBadRequest, err)
+		fail(ctx, http.StatusBadRequest, err)
+		return
 	}
 
-	node, err := model.GetNode(uint(id))
+	node, err := model.GetNode(ctx.Request.Context(), uint(id))
 	if err != nil {
-		util.NewError(ctx, http.StatusNotFound, err)
+		fail(ctx, http.StatusNotFound, err)
+		return
+	}
+
+	if notModified(ctx, node.Version) {
+		return
 	}
 
 	ctx.JSON(http.StatusOK, node)
@@ -58,21 +74,23 @@ func (c *controller) GetNode(ctx *gin.Context) {
 // @Produce json
 // @Param id path uint true "Node ID"
 // @Success 200 {object} model.Node
-// @Failure 400 {object} util.HTTPError
-// @Failure 404 {object} util.HTTPError
-// @Failure 500 {object} util.HTTPError
+// @Failure 400 {object} util.Problem
+// @Failure 404 {object} util.Problem
+// @Failure 500 {object} util.Problem
 // @Router /nodes/{id}/health [get]
 func (c *controller) GetHealth(ctx *gin.Context) {
 	param := ctx.Param("id")
 
 	id, err := strconv.ParseUint(param, 10, 64)
 	if err != nil {
-		util.NewError(ctx, http.StatusBadRequest, err)
+		fail(ctx, http.StatusBadRequest, err)
+		return
 	}
 
-	health, err := model.GetHealth(uint(id))
+	health, err := model.GetHealth(ctx.Request.Context(), uint(id))
 	if err != nil {
-		util.NewError(ctx, http.StatusNotFound, err)
+		fail(ctx, http.StatusNotFound, err)
+		return
 	}
 
 	ctx.JSON(http.StatusOK, health)
@@ -86,21 +104,23 @@ func (c *controller) GetHealth(ctx *gin.Context) {
 // @Produce json
 // @Param id path uint true "Node ID"
 // @Success 200 {object} model.Node
-// @Failure 400 {object} util.HTTPError
-// @Failure 404 {object} util.HTTPError
-// @Failure 500 {object} util.HTTPError
+// @Failure 400 {object} util.Problem
+// @Failure 404 {object} util.Problem
+// @Failure 500 {object} util.Problem
 // @Router /nodes/{id}/info [get]
 func (c *controller) GetInfo(ctx *gin.Context) {
 	param := ctx.Param("id")
 
 	id, err := strconv.ParseUint(param, 10, 64)
 	if err != nil {
-		util.NewError(ctx, http.StatusBadRequest, err)
+		fail(ctx, http.StatusBadRequest, err)
+		return
 	}
 
-	info, err := model.GetInfo(uint(id))
+	info, err := model.GetInfo(ctx.Request.Context(), uint(id))
 	if err != nil {
-		util.NewError(ctx, http.StatusNotFound, err)
+		fail(ctx, http.StatusNotFound, err)
+		return
 	}
 
 	ctx.JSON(http.StatusOK, info)
@@ -114,21 +134,23 @@ func (c *controller) GetInfo(ctx *gin.Context) {
 // @Produce json
 // @Param id path uint true "Node ID"
 // @Success 200 {object} model.Node
-// @Failure 400 {object} util.HTTPError
-// @Failure 404 {object} util.HTTPError
-// @Failure 500 {object} util.HTTPError
+// @Failure 400 {object} util.Problem
+// @Failure 404 {object} util.Problem
+// @Failure 500 {object} util.Problem
 // @Router /nodes/{id}/perf [get]
 func (c *controller) GetPerf(ctx *gin.Context) {
 	param := ctx.Param("id")
 
 	id, err := strconv.ParseUint(param, 10, 64)
 	if err != nil {
-		util.NewError(ctx, http.StatusBadRequest, err)
+		fail(ctx, http.StatusBadRequest, err)
+		return
 	}
 
-	perf, err := model.GetPerf(uint(id))
+	perf, err := model.GetPerf(ctx.Request.Context(), uint(id))
 	if err != nil {
-		util.NewError(ctx, http.StatusNotFound, err)
+		fail(ctx, http.StatusNotFound, err)
+		return
 	}
 
 	ctx.JSON(http.StatusOK, perf)
@@ -136,54 +158,124 @@ func (c *controller) GetPerf(ctx *gin.Context) {
 
 // QueryNode godoc
 // @Summary Query node
-// @Description Query node
+// @Description Query the nodes by address and label selector, e.g. env=prod,role in (db,cache),!deprecated
 // @Tags nodes
 // @Accept json
 // @Produce json
-// @Param q query string true "ID search by q"
-// @Success 200 {object} model.Node
-// @Failure 400 {object} util.HTTPError
-// @Failure 404 {object} util.HTTPError
-// @Failure 500 {object} util.HTTPError
+// @Param q query string false "Address search by q"
+// @Param selector query string false "Label selector"
+// @Success 200 {array} model.Node
+// @Failure 400 {object} util.Problem
+// @Failure 404 {object} util.Problem
+// @Failure 500 {object} util.Problem
 // @Router /nodes [get]
 func (c *controller) QueryNode(ctx *gin.Context) {
 	q := ctx.Request.URL.Query().Get("q")
 
-	node, err := model.QueryNode(q)
+	selector, err := labels.Parse(ctx.Request.URL.Query().Get("selector"))
 	if err != nil {
-		util.NewError(ctx, http.StatusNotFound, err)
+		fail(ctx, http.StatusBadRequest, err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, node)
+	nodes, err := model.QueryNode(ctx.Request.Context(), q, selector)
+	if err != nil {
+		fail(ctx, http.StatusNotFound, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, nodes)
+}
+
+// SetLabels godoc
+// @Summary Set node labels
+// @Description Replace the labels of node
+// @Tags nodes
+// @Accept json
+// @Produce json
+// @Param id path uint true "Node ID"
+// @Param labels body object true "Labels"
+// @Param If-Match header string true "ETag of the node, or *"
+// @Success 200 {object} model.Node
+// @Failure 400 {object} util.Problem
+// @Failure 403 {object} util.Problem
+// @Failure 404 {object} util.Problem
+// @Failure 412 {object} util.Problem
+// @Failure 428 {object} util.Problem
+// @Failure 500 {object} util.Problem
+// @Router /nodes/{id}/labels [put]
+func (c *controller) SetLabels(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	var l map[string]string
+	if err := ctx.ShouldBindJSON(&l); err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	c.updateLabels(ctx, uint(id), func() (model.Node, error) {
+		return model.SetLabels(ctx.Request.Context(), uint(id), l)
+	})
+}
+
+// PatchLabels godoc
+// @Summary Patch node labels
+// @Description Merge the labels into those of node, removing the ones set to null
+// @Tags nodes
+// @Accept json
+// @Produce json
+// @Param id path uint true "Node ID"
+// @Param labels body object true "Labels"
+// @Param If-Match header string true "ETag of the node, or *"
+// @Success 200 {object} model.Node
+// @Failure 400 {object} util.Problem
+// @Failure 403 {object} util.Problem
+// @Failure 404 {object} util.Problem
+// @Failure 412 {object} util.Problem
+// @Failure 428 {object} util.Problem
+// @Failure 500 {object} util.Problem
+// @Router /nodes/{id}/labels [patch]
+func (c *controller) PatchLabels(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	var l map[string]*string
+	if err := ctx.ShouldBindJSON(&l); err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	c.updateLabels(ctx, uint(id), func() (model.Node, error) {
+		return model.PatchLabels(ctx.Request.Context(), uint(id), l)
+	})
 }
 
 // AddNode godoc
 // @Summary Add node
-// @Description Add node
+// @Description Not implemented, the nodes are added by POST /nodes/import
 // @Tags nodes
 // @Accept json
 // @Produce json
 // @Param id path uint true "Node ID"
-// @Success 200 {object} model.Node
-// @Failure 400 {object} util.HTTPError
-// @Failure 404 {object} util.HTTPError
-// @Failure 500 {object} util.HTTPError
+// @Failure 400 {object} util.Problem
+// @Failure 501 {object} util.Problem
 // @Router /nodes [put]
 func (c *controller) AddNode(ctx *gin.Context) {
 	param := ctx.Param("id")
 
-	id, err := strconv.ParseUint(param, 10, 64)
-	if err != nil {
-		util.NewError(ctx, http.StatusBadRequest, err)
-	}
-
-	node, err := model.AddNode(uint(id))
-	if err != nil {
-		util.NewError(ctx, http.StatusNotFound, err)
+	if _, err := strconv.ParseUint(param, 10, 64); err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
 	}
 
-	ctx.JSON(http.StatusOK, node)
+	fail(ctx, http.StatusNotImplemented, errors.Wrap(errNotImplemented, "use POST /nodes/import"))
 }
 
 // DelNode godoc
@@ -193,23 +285,61 @@ func (c *controller) AddNode(ctx *gin.Context) {
 // @Accept json
 // @Produce json
 // @Param id path uint true "Node ID"
+// @Param If-Match header string true "ETag of the node, or *"
 // @Success 200 {object} model.Node
-// @Failure 400 {object} util.HTTPError
-// @Failure 404 {object} util.HTTPError
-// @Failure 500 {object} util.HTTPError
+// @Failure 400 {object} util.Problem
+// @Failure 403 {object} util.Problem
+// @Failure 404 {object} util.Problem
+// @Failure 412 {object} util.Problem
+// @Failure 428 {object} util.Problem
+// @Failure 500 {object} util.Problem
 // @Router /nodes [delete]
 func (c *controller) DelNode(ctx *gin.Context) {
 	param := ctx.Param("id")
 
 	id, err := strconv.ParseUint(param, 10, 64)
 	if err != nil {
-		util.NewError(ctx, http.StatusBadRequest, err)
+		fail(ctx, http.StatusBadRequest, err)
+		return
 	}
 
-	node, err := model.DelNode(uint(id))
+	if !precondition(ctx) {
+		return
+	}
+
+	node, err := model.DelNode(ctx.Request.Context(), uint(id))
 	if err != nil {
-		util.NewError(ctx, http.StatusNotFound, err)
+		fail(ctx, http.StatusNotFound, err)
+		return
+	}
+
+	audit.SetBefore(ctx, node)
+
+	ctx.JSON(http.StatusOK, node)
+}
+
+// updateLabels records the labels of the node before and after update.
+func (c *controller) updateLabels(ctx *gin.Context, id uint, update func() (model.Node, error)) {
+	if !precondition(ctx) {
+		return
 	}
 
+	before, err := model.GetNode(ctx.Request.Context(), id)
+	if err != nil {
+		fail(ctx, http.StatusNotFound, err)
+		return
+	}
+
+	audit.SetBefore(ctx, before.Labels)
+
+	node, err := update()
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	audit.SetAfter(ctx, node.Labels)
+
+	ctx.Header("ETag", etag(node.Version))
 	ctx.JSON(http.StatusOK, node)
 }
@@ -0,0 +1,195 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strconv"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/craftslab/metalflow/audit"
+	"github.com/craftslab/metalflow/model"
+)
+
+const (
+	jsonPatch  = "application/json-patch+json"
+	mergePatch = "application/merge-patch+json"
+	maxPatch   = 1 << 16
+)
+
+var (
+	errMediaType = errors.New("unsupported media type, use " + mergePatch + " or " + jsonPatch)
+)
+
+// PatchNode godoc
+// @Summary Patch node
+// @Description Change the comments, labels or region of node with a JSON merge patch (RFC 7396) or a JSON patch (RFC 6902)
+// @Tags nodes
+// @Accept application/merge-patch+json
+// @Accept application/json-patch+json
+// @Produce json
+// @Param id path uint true "Node ID"
+// @Param If-Match header string true "ETag of the node, or *"
+// @Param patch body object true "Patch of model.NodeFields"
+// @Success 200 {object} model.Node
+// @Failure 400 {object} util.Problem
+// @Failure 403 {object} util.Problem
+// @Failure 404 {object} util.Problem
+// @Failure 412 {object} util.Problem
+// @Failure 415 {object} util.Problem
+// @Failure 428 {object} util.Problem
+// @Router /nodes/{id} [patch]
+func (c *controller) PatchNode(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	apply, err := readPatch(ctx)
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	if !precondition(ctx) {
+		return
+	}
+
+	var before model.NodeFields
+
+	node, err := model.PatchNode(ctx.Request.Context(), uint(id), func(f *model.NodeFields) error {
+		before = *f
+		return apply(f)
+	})
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	audit.SetBefore(ctx, before)
+	audit.SetAfter(ctx, model.NodeFields{Comments: node.Comments, Labels: node.Labels, Region: node.Region})
+
+	ctx.Header("ETag", etag(node.Version))
+	ctx.JSON(http.StatusOK, node)
+}
+
+// PatchAccount godoc
+// @Summary Patch account
+// @Description Change the avatar, displayname or email of account with a JSON merge patch (RFC 7396) or a JSON patch (RFC 6902), which only admins may do, or the owner through the ID "self"
+// @Tags accounts
+// @Accept application/merge-patch+json
+// @Accept application/json-patch+json
+// @Produce json
+// @Param id path string true "Account ID, or self"
+// @Param If-Match header string true "ETag of the account, or *"
+// @Param patch body object true "Patch of model.AccountFields"
+// @Success 200 {object} model.Account
+// @Failure 400 {object} util.Problem
+// @Failure 403 {object} util.Problem
+// @Failure 404 {object} util.Problem
+// @Failure 412 {object} util.Problem
+// @Failure 415 {object} util.Problem
+// @Failure 428 {object} util.Problem
+// @Router /accounts/{id} [patch]
+func (c *controller) PatchAccount(ctx *gin.Context) {
+	id, err := accountId(ctx)
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	apply, err := readPatch(ctx)
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	if !precondition(ctx) {
+		return
+	}
+
+	var before model.AccountFields
+
+	account, err := model.PatchAccount(ctx.Request.Context(), uint(id), func(f *model.AccountFields) error {
+		before = *f
+		return apply(f)
+	})
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	audit.SetBefore(ctx, before)
+	audit.SetAfter(ctx, model.AccountFields{Avatar: account.Avatar, Displayname: account.Displayname, Email: account.Email})
+
+	ctx.Header("ETag", etag(account.Version))
+	ctx.JSON(http.StatusOK, account)
+}
+
+// readPatch reads the patch of the request by its content type. The returned
+// function applies it to fields, a pointer to a struct whose JSON document is
+// patched, failing if the result has any other field.
+func readPatch(ctx *gin.Context) (func(fields interface{}) error, error) {
+	buf, err := ioutil.ReadAll(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxPatch))
+	if err != nil {
+		return nil, err
+	}
+
+	var patch func([]byte) ([]byte, error)
+
+	switch ctx.ContentType() {
+	case mergePatch:
+		if !json.Valid(buf) {
+			return nil, errors.New("invalid merge patch")
+		}
+		patch = func(doc []byte) ([]byte, error) {
+			return jsonpatch.MergePatch(doc, buf)
+		}
+	case jsonPatch:
+		p, err := jsonpatch.DecodePatch(buf)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid json patch")
+		}
+		patch = p.Apply
+	default:
+		return nil, errMediaType
+	}
+
+	return func(fields interface{}) error {
+		doc, err := json.Marshal(fields)
+		if err != nil {
+			return err
+		}
+
+		if doc, err = patch(doc); err != nil {
+			return errors.Wrap(err, "failed to apply patch")
+		}
+
+		// The fields removed by the patch are reset.
+		v := reflect.ValueOf(fields).Elem()
+		v.Set(reflect.Zero(v.Type()))
+
+		d := json.NewDecoder(bytes.NewReader(doc))
+		d.DisallowUnknownFields()
+
+		return d.Decode(fields)
+	}, nil
+}
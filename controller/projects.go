@@ -0,0 +1,206 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/craftslab/metalflow/audit"
+	"github.com/craftslab/metalflow/model"
+)
+
+type memberRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+// GetProject godoc
+// @Summary Get project by ID
+// @Description Get project by ID
+// @Tags projects
+// @Accept json
+// @Produce json
+// @Param id path uint true "Project ID"
+// @Success 200 {object} model.Project
+// @Failure 400 {object} util.Problem
+// @Failure 404 {object} util.Problem
+// @Failure 500 {object} util.Problem
+// @Router /projects/{id} [get]
+func (c *controller) GetProject(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	project, err := model.GetProject(ctx.Request.Context(), uint(id))
+	if err != nil {
+		fail(ctx, http.StatusNotFound, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, project)
+}
+
+// QueryProject godoc
+// @Summary Query project
+// @Description Query the projects of the caller
+// @Tags projects
+// @Accept json
+// @Produce json
+// @Success 200 {array} model.Project
+// @Failure 500 {object} util.Problem
+// @Router /projects [get]
+func (c *controller) QueryProject(ctx *gin.Context) {
+	projects, err := model.QueryProject(ctx.Request.Context())
+	if err != nil {
+		fail(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, projects)
+}
+
+// AddProject godoc
+// @Summary Add project
+// @Description Add project, which only admins may do
+// @Tags projects
+// @Accept json
+// @Produce json
+// @Param project body model.Project true "Project"
+// @Success 200 {object} model.Project
+// @Failure 400 {object} util.Problem
+// @Failure 403 {object} util.Problem
+// @Failure 500 {object} util.Problem
+// @Router /projects [post]
+func (c *controller) AddProject(ctx *gin.Context) {
+	var p model.Project
+	if err := ctx.ShouldBindJSON(&p); err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	project, err := model.AddProject(ctx.Request.Context(), p)
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	audit.SetAfter(ctx, project)
+
+	ctx.JSON(http.StatusOK, project)
+}
+
+// QueryMember godoc
+// @Summary Query project member
+// @Description Query the members of project
+// @Tags projects
+// @Accept json
+// @Produce json
+// @Param id path uint true "Project ID"
+// @Success 200 {array} model.Member
+// @Failure 400 {object} util.Problem
+// @Failure 404 {object} util.Problem
+// @Failure 500 {object} util.Problem
+// @Router /projects/{id}/members [get]
+func (c *controller) QueryMember(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	members, err := model.QueryMember(ctx.Request.Context(), uint(id))
+	if err != nil {
+		fail(ctx, http.StatusNotFound, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, members)
+}
+
+// SetMember godoc
+// @Summary Set project member
+// @Description Add member to project or change its role, which the maintainers of project may do
+// @Tags projects
+// @Accept json
+// @Produce json
+// @Param id path uint true "Project ID"
+// @Param username path string true "Username"
+// @Param role body controller.memberRequest true "Project role, i.e. maintainer or viewer"
+// @Success 200 {object} model.Member
+// @Failure 400 {object} util.Problem
+// @Failure 403 {object} util.Problem
+// @Failure 500 {object} util.Problem
+// @Router /projects/{id}/members/{username} [put]
+func (c *controller) SetMember(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	var r memberRequest
+	if err := ctx.ShouldBindJSON(&r); err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	member, err := model.SetMember(ctx.Request.Context(), model.Member{
+		Project:  uint(id),
+		Role:     r.Role,
+		Username: ctx.Param("username"),
+	})
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	audit.SetAfter(ctx, member)
+
+	ctx.JSON(http.StatusOK, member)
+}
+
+// DelMember godoc
+// @Summary Delete project member
+// @Description Delete member from project, which the maintainers of project may do
+// @Tags projects
+// @Accept json
+// @Produce json
+// @Param id path uint true "Project ID"
+// @Param username path string true "Username"
+// @Success 200 {object} model.Member
+// @Failure 400 {object} util.Problem
+// @Failure 403 {object} util.Problem
+// @Failure 404 {object} util.Problem
+// @Failure 500 {object} util.Problem
+// @Router /projects/{id}/members/{username} [delete]
+func (c *controller) DelMember(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	member, err := model.DelMember(ctx.Request.Context(), uint(id), ctx.Param("username"))
+	if err != nil {
+		fail(ctx, http.StatusNotFound, err)
+		return
+	}
+
+	audit.SetBefore(ctx, member)
+
+	ctx.JSON(http.StatusOK, member)
+}
@@ -0,0 +1,206 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/craftslab/metalflow/audit"
+	"github.com/craftslab/metalflow/schedule"
+)
+
+// AddSchedule godoc
+// @Summary Add schedule
+// @Description Add a schedule dispatching command to the nodes of its project matching its selector, at the times of its cron expression
+// @Tags schedules
+// @Accept json
+// @Produce json
+// @Param schedule body schedule.Schedule true "Schedule"
+// @Success 200 {object} schedule.Schedule
+// @Failure 400 {object} util.Problem
+// @Failure 403 {object} util.Problem
+// @Failure 500 {object} util.Problem
+// @Router /schedules [post]
+func (c *controller) AddSchedule(ctx *gin.Context) {
+	var s schedule.Schedule
+	if err := ctx.ShouldBindJSON(&s); err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	s.Creator = c.identity(ctx)
+
+	buf, err := c.schedule.Add(ctx.Request.Context(), &s)
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	audit.SetAfter(ctx, buf)
+
+	ctx.JSON(http.StatusOK, buf)
+}
+
+// GetSchedule godoc
+// @Summary Get schedule by ID
+// @Description Get schedule by ID
+// @Tags schedules
+// @Accept json
+// @Produce json
+// @Param id path uint true "Schedule ID"
+// @Success 200 {object} schedule.Schedule
+// @Failure 400 {object} util.Problem
+// @Failure 404 {object} util.Problem
+// @Failure 500 {object} util.Problem
+// @Router /schedules/{id} [get]
+func (c *controller) GetSchedule(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	buf, err := c.schedule.Get(ctx.Request.Context(), uint(id))
+	if err != nil {
+		fail(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, buf)
+}
+
+// QuerySchedule godoc
+// @Summary Query schedule
+// @Description Query the schedules of the projects of the caller
+// @Tags schedules
+// @Accept json
+// @Produce json
+// @Success 200 {array} schedule.Schedule
+// @Failure 500 {object} util.Problem
+// @Router /schedules [get]
+func (c *controller) QuerySchedule(ctx *gin.Context) {
+	buf, err := c.schedule.Query(ctx.Request.Context())
+	if err != nil {
+		fail(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, buf)
+}
+
+// UpdateSchedule godoc
+// @Summary Update schedule
+// @Description Update schedule, which is next run at the first time of its cron expression from now
+// @Tags schedules
+// @Accept json
+// @Produce json
+// @Param id path uint true "Schedule ID"
+// @Param schedule body schedule.Schedule true "Schedule"
+// @Success 200 {object} schedule.Schedule
+// @Failure 400 {object} util.Problem
+// @Failure 403 {object} util.Problem
+// @Failure 404 {object} util.Problem
+// @Failure 500 {object} util.Problem
+// @Router /schedules/{id} [put]
+func (c *controller) UpdateSchedule(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	var s schedule.Schedule
+	if err := ctx.ShouldBindJSON(&s); err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	if before, err := c.schedule.Get(ctx.Request.Context(), uint(id)); err == nil {
+		audit.SetBefore(ctx, before)
+	}
+
+	buf, err := c.schedule.Update(ctx.Request.Context(), uint(id), &s)
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	audit.SetAfter(ctx, buf)
+
+	ctx.JSON(http.StatusOK, buf)
+}
+
+// DelSchedule godoc
+// @Summary Delete schedule
+// @Description Delete schedule
+// @Tags schedules
+// @Accept json
+// @Produce json
+// @Param id path uint true "Schedule ID"
+// @Success 200 {object} schedule.Schedule
+// @Failure 400 {object} util.Problem
+// @Failure 403 {object} util.Problem
+// @Failure 404 {object} util.Problem
+// @Failure 500 {object} util.Problem
+// @Router /schedules/{id} [delete]
+func (c *controller) DelSchedule(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	buf, err := c.schedule.Delete(ctx.Request.Context(), uint(id))
+	if err != nil {
+		fail(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	audit.SetBefore(ctx, buf)
+
+	ctx.JSON(http.StatusOK, buf)
+}
+
+// QueryScheduleRun godoc
+// @Summary Query schedule run
+// @Description Query the runs of schedule, newest first
+// @Tags schedules
+// @Accept json
+// @Produce json
+// @Param id path uint true "Schedule ID"
+// @Param limit query int false "Limit"
+// @Success 200 {array} schedule.Run
+// @Failure 400 {object} util.Problem
+// @Failure 404 {object} util.Problem
+// @Failure 500 {object} util.Problem
+// @Router /schedules/{id}/runs [get]
+func (c *controller) QueryScheduleRun(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		fail(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	limit, _ := strconv.Atoi(ctx.Query("limit"))
+
+	buf, err := c.schedule.QueryRun(ctx.Request.Context(), uint(id), limit)
+	if err != nil {
+		fail(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, buf)
+}
@@ -0,0 +1,78 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ctl
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/craftslab/metalflow/model"
+)
+
+func (c *ctl) initAccounts(app *kingpin.Application, cmds map[string]func(context.Context) error) {
+	accounts := app.Command("accounts", "Manage accounts")
+
+	get := accounts.Command("get", "Get account, or the own one")
+	getId := get.Arg("id", "Account ID").Uint()
+
+	cmds[get.FullCommand()] = func(ctx context.Context) error {
+		var a *model.Account
+		var err error
+		if *getId == 0 {
+			a, err = c.client.GetSelfAccount(ctx)
+		} else {
+			a, err = c.client.GetAccount(ctx, *getId)
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to get account")
+		}
+		return c.printAccount(a)
+	}
+
+	find := accounts.Command("find", "Find account by username")
+	q := find.Arg("q", "Username search").Required().String()
+
+	cmds[find.FullCommand()] = func(ctx context.Context) error {
+		a, err := c.client.QueryAccount(ctx, *q)
+		if err != nil {
+			return errors.Wrap(err, "failed to find account")
+		}
+		return c.printAccount(a)
+	}
+
+	set := accounts.Command("set", "Update account")
+	setId := set.Arg("id", "Account ID").Required().Uint()
+	fields := set.Flag("field", "Field, one of avatar, displayname or email, e.g. email=john@example.com").Required().StringMap()
+	version := set.Flag("if-version", "Version the account was read at, else any").Uint()
+
+	cmds[set.FullCommand()] = func(ctx context.Context) error {
+		a, err := c.client.PatchAccount(ctx, *setId, *version, *fields)
+		if err != nil {
+			return errors.Wrap(err, "failed to update account")
+		}
+		return c.printAccount(a)
+	}
+}
+
+func (c *ctl) printAccount(a *model.Account) error {
+	a.Password = ""
+
+	return c.print(a, func() [][]string {
+		return [][]string{
+			{"ID", "USERNAME", "DISPLAYNAME", "EMAIL", "ROLE"},
+			{id(a.Id), a.Username, a.Displayname, a.Email, a.Role},
+		}
+	})
+}
@@ -0,0 +1,166 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ctl
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"gopkg.in/yaml.v3"
+
+	"github.com/craftslab/metalflow/client"
+	"github.com/craftslab/metalflow/config"
+)
+
+const (
+	configName = ".metalctl.yml"
+	timeout    = 30 * time.Second
+)
+
+// Config is the local config file, keeping the token of the last login.
+type Config struct {
+	Expire   time.Time `yaml:"expire"`
+	Server   string    `yaml:"server"`
+	Token    string    `yaml:"token"`
+	Username string    `yaml:"username"`
+}
+
+// ctl is a run of metalctl, writing to out.
+type ctl struct {
+	client     client.Client
+	config     *Config
+	configFile string
+	in         io.Reader
+	out        io.Writer
+	output     string
+	reader     *bufio.Reader
+}
+
+func Run() error {
+	return run(os.Args[1:], os.Stdin, os.Stdout)
+}
+
+func run(args []string, in io.Reader, out io.Writer) error {
+	c := &ctl{in: in, out: out}
+
+	app := kingpin.New("metalctl", "Metal Flow CLI").Version(config.Version + "-build-" + config.Build)
+	app.Flag("config-file", "Config file keeping the token").Default(defaultConfigFile()).Envar("METALCTL_CONFIG").StringVar(&c.configFile)
+	app.Flag("output", "Output format (table, json or yaml)").Short('o').Default(formatTable).EnumVar(&c.output, formatTable, formatJson, formatYaml)
+	server := app.Flag("server", "URL of the master").Envar("METALCTL_SERVER").String()
+
+	cmds := map[string]func(context.Context) error{}
+
+	c.initLogin(app, cmds)
+	c.initNodes(app, cmds)
+	c.initAccounts(app, cmds)
+	c.initTasks(app, cmds)
+
+	// Help and version end parsing early instead of exiting
+	done := false
+
+	app.Writer(out)
+	app.Terminate(func(int) { done = true })
+
+	cmd, err := app.Parse(args)
+	if done {
+		return nil
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "failed to parse")
+	}
+
+	fn, ok := cmds[cmd]
+	if !ok {
+		return nil
+	}
+
+	if err := c.load(); err != nil {
+		return errors.Wrap(err, "failed to load config")
+	}
+
+	if *server != "" {
+		c.config.Server = *server
+	}
+
+	cfg := client.DefaultConfig()
+	cfg.Addr = c.config.Server
+	cfg.Expire = c.config.Expire
+	cfg.HttpClient = &http.Client{Timeout: timeout}
+	cfg.Token = c.config.Token
+
+	c.client = client.New(cfg)
+
+	if err := fn(context.Background()); err != nil {
+		return err
+	}
+
+	return c.save()
+}
+
+func defaultConfigFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return configName
+	}
+
+	return filepath.Join(home, configName)
+}
+
+func (c *ctl) load() error {
+	c.config = &Config{Server: client.DefaultConfig().Addr}
+
+	buf, err := ioutil.ReadFile(c.configFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrap(err, "failed to read")
+	}
+
+	if err := yaml.Unmarshal(buf, c.config); err != nil {
+		return errors.Wrap(err, "failed to unmarshal")
+	}
+
+	return nil
+}
+
+// save keeps the token, renewed or issued by the command, for the next one.
+func (c *ctl) save() error {
+	token, expire := c.client.Token()
+	if token == c.config.Token && expire.Equal(c.config.Expire) {
+		return nil
+	}
+
+	c.config.Expire = expire
+	c.config.Token = token
+
+	buf, err := yaml.Marshal(c.config)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal")
+	}
+
+	if err := ioutil.WriteFile(c.configFile, buf, 0600); err != nil {
+		return errors.Wrap(err, "failed to write config")
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ctl
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/craftslab/metalflow/model"
+	"github.com/craftslab/metalflow/router"
+)
+
+func TestCtl(t *testing.T) {
+	r := router.New(router.DefaultConfig())
+	err := r.Init()
+	assert.Equal(t, nil, err)
+
+	srv := httptest.NewServer(r.Handler())
+	defer srv.Close()
+
+	file := filepath.Join(t.TempDir(), "metalctl.yml")
+
+	ctl := func(args ...string) (string, error) {
+		var out bytes.Buffer
+		err := run(append([]string{"--config-file", file}, args...), strings.NewReader("admin\n"), &out)
+		return out.String(), err
+	}
+
+	_, err = ctl("nodes", "ls")
+	assert.NotEqual(t, nil, err)
+
+	out, err := ctl("--server", srv.URL, "login", "--username", "admin")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "Password: Logged in to "+srv.URL+" as admin\n", out)
+
+	out, err = ctl("nodes", "ls", "-o", "json", "--selector", "env=dev")
+	assert.Equal(t, nil, err)
+
+	var nodes []model.Node
+	err = json.Unmarshal([]byte(out), &nodes)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(nodes))
+
+	out, err = ctl("nodes", "get", "1")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, strings.HasPrefix(out, "ID  ADDRESS"))
+
+	out, err = ctl("nodes", "add", "10.0.0.1", "--region", "Xian", "--label", "env=test", "--dry-run", "-o", "yaml")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, strings.Contains(out, "created: 1"))
+
+	out, err = ctl("nodes", "add", "10.0.0.1", "--region", "Xian", "--project", "1", "-o", "json")
+	assert.Equal(t, nil, err)
+
+	var report model.ImportReport
+	err = json.Unmarshal([]byte(out), &report)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, report.Created)
+
+	_, err = ctl("nodes", "rm", strconv.Itoa(int(report.Rows[0].Id)))
+	assert.Equal(t, nil, err)
+
+	_, err = ctl("nodes", "get", strconv.Itoa(int(report.Rows[0].Id)))
+	assert.NotEqual(t, nil, err)
+
+	csv := filepath.Join(t.TempDir(), "nodes.csv")
+
+	_, err = ctl("nodes", "export", "--selector", "env=dev", "--file", csv)
+	assert.Equal(t, nil, err)
+
+	out, err = ctl("nodes", "import", csv, "--dry-run", "-o", "yaml")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, strings.Contains(out, "updated: 1"))
+
+	// A server answering without applying them is not taken for a success.
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte("{}"))
+	}))
+	defer stub.Close()
+
+	_, err = ctl("--server", stub.URL, "nodes", "rm", "1")
+	assert.NotEqual(t, nil, err)
+
+	_, err = ctl("--server", stub.URL, "nodes", "add", "10.0.0.2", "--region", "Xian")
+	assert.NotEqual(t, nil, err)
+
+	out, err = ctl("accounts", "get")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, strings.Contains(out, "admin"))
+
+	_, err = ctl("tasks", "ls")
+	assert.Equal(t, nil, err)
+
+	_, err = ctl("logout")
+	assert.Equal(t, nil, err)
+
+	_, err = ctl("nodes", "ls")
+	assert.NotEqual(t, nil, err)
+}
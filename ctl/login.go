@@ -0,0 +1,98 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ctl
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh/terminal"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/craftslab/metalflow/client"
+)
+
+func (c *ctl) initLogin(app *kingpin.Application, cmds map[string]func(context.Context) error) {
+	login := app.Command("login", "Log in to the master, keeping the token in the config file")
+	username := login.Flag("username", "Username").Short('u').Required().String()
+	password := login.Flag("password", "Password, else prompted").Short('p').Envar("METALCTL_PASSWORD").String()
+	otp := login.Flag("otp", "TOTP or recovery code, else prompted if required").String()
+
+	cmds[login.FullCommand()] = func(ctx context.Context) error {
+		var err error
+
+		if *password == "" {
+			if *password, err = c.prompt("Password: ", true); err != nil {
+				return err
+			}
+		}
+
+		err = c.client.Login(ctx, *username, *password)
+		if err == client.ErrOtpRequired {
+			if *otp == "" {
+				if *otp, err = c.prompt("Code: ", false); err != nil {
+					return err
+				}
+			}
+			err = c.client.LoginOtp(ctx, *otp)
+		}
+
+		if err != nil {
+			return errors.Wrap(err, "failed to login")
+		}
+
+		c.config.Username = *username
+
+		_, _ = fmt.Fprintln(c.out, "Logged in to "+c.config.Server+" as "+*username)
+
+		return nil
+	}
+
+	logout := app.Command("logout", "Forget the token in the config file")
+
+	cmds[logout.FullCommand()] = func(context.Context) error {
+		c.config.Username = ""
+		c.client = client.New(&client.Config{})
+		return nil
+	}
+}
+
+// prompt reads a line of the input, without echo if secret and the input is
+// a terminal.
+func (c *ctl) prompt(label string, secret bool) (string, error) {
+	_, _ = fmt.Fprint(c.out, label)
+
+	if f, ok := c.in.(*os.File); ok && secret && terminal.IsTerminal(int(f.Fd())) {
+		buf, err := terminal.ReadPassword(int(f.Fd()))
+		_, _ = fmt.Fprintln(c.out)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to read")
+		}
+		return string(buf), nil
+	}
+
+	if c.reader == nil {
+		c.reader = bufio.NewReader(c.in)
+	}
+
+	line, err := c.reader.ReadString('\n')
+	if err != nil && line == "" {
+		return "", errors.Wrap(err, "failed to read")
+	}
+
+	return strings.TrimSpace(line), nil
+}
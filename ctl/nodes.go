@@ -0,0 +1,180 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ctl
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/craftslab/metalflow/client"
+	"github.com/craftslab/metalflow/model"
+)
+
+func (c *ctl) initNodes(app *kingpin.Application, cmds map[string]func(context.Context) error) {
+	nodes := app.Command("nodes", "Manage nodes")
+
+	ls := nodes.Command("ls", "List nodes")
+	q := ls.Flag("q", "Address search").String()
+	selector := ls.Flag("selector", "Label selector, e.g. env=prod,role in (db,cache)").Short('l').String()
+
+	cmds[ls.FullCommand()] = func(ctx context.Context) error {
+		buf, err := c.client.QueryNode(ctx, *q, *selector)
+		if err != nil {
+			return errors.Wrap(err, "failed to list nodes")
+		}
+		return c.printNodes(buf)
+	}
+
+	get := nodes.Command("get", "Get node")
+	getId := get.Arg("id", "Node ID").Required().Uint()
+
+	cmds[get.FullCommand()] = func(ctx context.Context) error {
+		n, err := c.client.GetNode(ctx, *getId)
+		if err != nil {
+			return errors.Wrap(err, "failed to get node")
+		}
+		return c.printNodes([]model.Node{*n})
+	}
+
+	add := nodes.Command("add", "Add node, or update the node of its address")
+	address := add.Arg("address", "Address").Required().String()
+	comments := add.Flag("comments", "Comments").String()
+	labels := add.Flag("label", "Label, e.g. env=prod").StringMap()
+	project := add.Flag("project", "Project ID").Uint()
+	region := add.Flag("region", "Region").Required().String()
+	addDryRun := add.Flag("dry-run", "Validate only").Bool()
+
+	cmds[add.FullCommand()] = func(ctx context.Context) error {
+		n := model.Node{Address: *address, Comments: *comments, Labels: *labels, Project: *project, Region: *region}
+		return c.importNode(func() (*model.ImportReport, error) {
+			return c.client.ImportNode(ctx, []model.Node{n}, *addDryRun)
+		})
+	}
+
+	imp := nodes.Command("import", "Add or update the nodes of a file in CSV, JSON or YAML")
+	file := imp.Arg("file", "File of nodes").Required().ExistingFile()
+	impFormat := imp.Flag("format", "Format of the file (csv, json or yaml), else taken from its extension").
+		Enum(client.FormatCsv, client.FormatJson, client.FormatYaml)
+	impDryRun := imp.Flag("dry-run", "Validate only").Bool()
+
+	cmds[imp.FullCommand()] = func(ctx context.Context) error {
+		f, err := os.Open(*file)
+		if err != nil {
+			return errors.Wrap(err, "failed to open")
+		}
+		defer func() { _ = f.Close() }()
+		format := *impFormat
+		if format == "" {
+			format = fileFormat(*file)
+		}
+		return c.importNode(func() (*model.ImportReport, error) {
+			return c.client.ImportNodeData(ctx, format, f, *impDryRun)
+		})
+	}
+
+	exp := nodes.Command("export", "Export the nodes in CSV, JSON or YAML")
+	expFormat := exp.Flag("format", "Format (csv, json or yaml)").Default(client.FormatCsv).
+		Enum(client.FormatCsv, client.FormatJson, client.FormatYaml)
+	expSelector := exp.Flag("selector", "Label selector, e.g. env=prod").Short('l').String()
+	expFile := exp.Flag("file", "File to write, else the output").Short('f').String()
+
+	cmds[exp.FullCommand()] = func(ctx context.Context) error {
+		w := c.out
+		if *expFile != "" {
+			f, err := os.Create(*expFile)
+			if err != nil {
+				return errors.Wrap(err, "failed to create")
+			}
+			defer func() { _ = f.Close() }()
+			w = f
+		}
+		if err := c.client.ExportNode(ctx, *expFormat, *expSelector, w); err != nil {
+			return errors.Wrap(err, "failed to export nodes")
+		}
+		return nil
+	}
+
+	rm := nodes.Command("rm", "Remove node")
+	rmId := rm.Arg("id", "Node ID").Required().Uint()
+	version := rm.Flag("if-version", "Version the node was read at, else any").Uint()
+
+	cmds[rm.FullCommand()] = func(ctx context.Context) error {
+		n, err := c.client.DelNode(ctx, *rmId, *version)
+		if err != nil {
+			return errors.Wrap(err, "failed to remove node")
+		}
+		// The masters which do not remove the nodes answer an empty one.
+		if n.Id != *rmId || n.Address == "" {
+			return errors.Errorf("failed to remove node: node %d not removed by the server", *rmId)
+		}
+		return c.printNodes([]model.Node{*n})
+	}
+}
+
+// importNode prints the report of fn, which shall apply some rows.
+func (c *ctl) importNode(fn func() (*model.ImportReport, error)) error {
+	report, err := fn()
+	if err != nil && err != client.ErrRowsFailed {
+		return errors.Wrap(err, "failed to import nodes")
+	}
+
+	// The masters which do not import the nodes answer an empty report.
+	if err == nil && report.Created+report.Updated == 0 {
+		return errors.New("failed to import nodes: none applied by the server")
+	}
+
+	if e := c.print(report, func() [][]string {
+		rows := [][]string{{"ROW", "ADDRESS", "ACTION", "ID", "ERROR"}}
+		for _, r := range report.Rows {
+			rows = append(rows, []string{strconv.Itoa(r.Row), r.Address, r.Action, id(r.Id), r.Error})
+		}
+		return rows
+	}); e != nil {
+		return e
+	}
+
+	return err
+}
+
+func (c *ctl) printNodes(nodes []model.Node) error {
+	return c.print(nodes, func() [][]string {
+		rows := [][]string{{"ID", "ADDRESS", "REGION", "PROJECT", "LIFECYCLE", "HEALTH", "LABELS"}}
+		for _, n := range nodes {
+			rows = append(rows, []string{id(n.Id), n.Address, n.Region, id(n.Project), n.Lifecycle, n.Health, join(n.Labels)})
+		}
+		return rows
+	})
+}
+
+// fileFormat is the format of file by its extension, where YAML also reads
+// JSON.
+func fileFormat(file string) string {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".csv":
+		return client.FormatCsv
+	case ".json":
+		return client.FormatJson
+	}
+
+	return client.FormatYaml
+}
+
+func id(v uint) string {
+	return strconv.FormatUint(uint64(v), 10)
+}
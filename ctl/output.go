@@ -0,0 +1,75 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ctl
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	formatJson  = "json"
+	formatTable = "table"
+	formatYaml  = "yaml"
+)
+
+// print writes v in the output format, as the rows returned by table for the
+// table format. The first row is the header.
+func (c *ctl) print(v interface{}, table func() [][]string) error {
+	switch c.output {
+	case formatJson:
+		e := json.NewEncoder(c.out)
+		e.SetIndent("", "  ")
+		return e.Encode(v)
+	case formatYaml:
+		// Through JSON, for the fields to keep their JSON names.
+		buf, err := json.Marshal(v)
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal")
+		}
+		var doc interface{}
+		if err := yaml.Unmarshal(buf, &doc); err != nil {
+			return errors.Wrap(err, "failed to unmarshal")
+		}
+		e := yaml.NewEncoder(c.out)
+		e.SetIndent(2)
+		return e.Encode(doc)
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+
+	for _, row := range table() {
+		_, _ = fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+
+	return w.Flush()
+}
+
+// join formats labels as a selector, e.g. env=prod,role=db.
+func join(labels map[string]string) string {
+	buf := make([]string, 0, len(labels))
+
+	for k, v := range labels {
+		buf = append(buf, k+"="+v)
+	}
+
+	sort.Strings(buf)
+
+	return strings.Join(buf, ",")
+}
@@ -0,0 +1,170 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ctl
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/craftslab/metalflow/flow"
+)
+
+const (
+	pollInterval = 2 * time.Second
+)
+
+// Tasks are run as flows, a one-off command as a flow of a single step.
+func (c *ctl) initTasks(app *kingpin.Application, cmds map[string]func(context.Context) error) {
+	tasks := app.Command("tasks", "Run commands on nodes")
+
+	run := tasks.Command("run", "Run command, or the flow spec of file, on the nodes matching selector")
+	command := run.Arg("command", "Command").Strings()
+	file := run.Flag("file", "Flow spec in YAML or JSON").Short('f').ExistingFile()
+	name := run.Flag("name", "Name of the task").Default("run").String()
+	project := run.Flag("project", "Project ID").Uint()
+	selector := run.Flag("selector", "Label selector").Short('l').String()
+
+	cmds[run.FullCommand()] = func(ctx context.Context) error {
+		spec, err := taskSpec(*file, *name, *project, *selector, *command)
+		if err != nil {
+			return err
+		}
+		f, err := c.client.SubmitFlow(ctx, spec)
+		if err != nil {
+			return errors.Wrap(err, "failed to run task")
+		}
+		return c.printFlows([]flow.Flow{*f})
+	}
+
+	ls := tasks.Command("ls", "List tasks")
+
+	cmds[ls.FullCommand()] = func(ctx context.Context) error {
+		buf, err := c.client.QueryFlow(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to list tasks")
+		}
+		return c.printFlows(buf)
+	}
+
+	logs := tasks.Command("logs", "Print the output of the steps of task on every node")
+	logsId := logs.Arg("id", "Task ID").Required().Uint()
+	follow := logs.Flag("follow", "Wait for the task to finish").Short('F').Bool()
+
+	cmds[logs.FullCommand()] = func(ctx context.Context) error {
+		return c.logs(ctx, *logsId, *follow)
+	}
+}
+
+func taskSpec(file, name string, project uint, selector string, command []string) (*flow.Spec, error) {
+	if file != "" {
+		buf, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read")
+		}
+		return flow.Parse(buf)
+	}
+
+	if len(command) == 0 {
+		return nil, errors.New("missing command or file")
+	}
+
+	return &flow.Spec{
+		Name:     name,
+		Project:  project,
+		Selector: selector,
+		Steps: []flow.StepSpec{
+			{Command: strings.Join(command, " "), Name: name, Type: flow.StepDispatch},
+		},
+	}, nil
+}
+
+// logs prints the steps of the task once finished, until it is if follow.
+func (c *ctl) logs(ctx context.Context, id uint, follow bool) error {
+	printed := map[string]bool{}
+
+	for {
+		s, err := c.client.GetFlow(ctx, id)
+		if err != nil {
+			return errors.Wrap(err, "failed to get task")
+		}
+
+		var steps []flow.Step
+		for _, st := range s.Steps {
+			key := fmt.Sprintf("%d/%s", st.Node, st.Name)
+			if !printed[key] && (!follow || finished(st.State)) {
+				printed[key] = true
+				steps = append(steps, st)
+			}
+		}
+
+		if err := c.printSteps(steps); err != nil {
+			return err
+		}
+
+		if !follow || finished(s.Flow.State) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+func finished(state string) bool {
+	switch state {
+	case flow.StateAborted, flow.StateFailed, flow.StateSkipped, flow.StateSucceeded:
+		return true
+	}
+
+	return false
+}
+
+func (c *ctl) printSteps(steps []flow.Step) error {
+	if c.output != formatTable {
+		if len(steps) == 0 {
+			return nil
+		}
+		return c.print(steps, nil)
+	}
+
+	for _, st := range steps {
+		_, _ = fmt.Fprintf(c.out, "==> node %d, step %s: %s\n", st.Node, st.Name, st.State)
+		if st.Output != "" {
+			_, _ = fmt.Fprintln(c.out, strings.TrimRight(st.Output, "\n"))
+		}
+		if st.Error != "" {
+			_, _ = fmt.Fprintln(c.out, "error: "+st.Error)
+		}
+	}
+
+	return nil
+}
+
+func (c *ctl) printFlows(flows []flow.Flow) error {
+	return c.print(flows, func() [][]string {
+		rows := [][]string{{"ID", "NAME", "PROJECT", "STATE", "CREATOR", "CREATED"}}
+		for _, f := range flows {
+			rows = append(rows, []string{id(f.Id), f.Name, id(f.Project), f.State, f.Creator, f.CreatedAt.Format(time.RFC3339)})
+		}
+		return rows
+	})
+}
@@ -0,0 +1,153 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/craftslab/metalflow/tracing"
+)
+
+type Etcd interface {
+	Open() error
+	Close()
+	Client() *clientv3.Client
+
+	Get(ctx context.Context, key string) (string, error)
+	List(ctx context.Context, prefix string) (map[string]string, error)
+	Put(ctx context.Context, key, val string) error
+	Delete(ctx context.Context, key string) error
+}
+
+type Config struct {
+	DialTimeout time.Duration
+	Host        string
+	Port        string
+}
+
+type etcd struct {
+	client *clientv3.Client
+	config *Config
+}
+
+var (
+	ErrNotFound = errors.New("key not found")
+)
+
+func New(_ context.Context, config *Config) Etcd {
+	return &etcd{
+		client: nil,
+		config: config,
+	}
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		DialTimeout: 5 * time.Second,
+		Host:        "127.0.0.1",
+		Port:        "2379",
+	}
+}
+
+func (e *etcd) Open() error {
+	c, err := clientv3.New(clientv3.Config{
+		DialTimeout: e.config.DialTimeout,
+		Endpoints:   []string{e.config.Host + ":" + e.config.Port},
+	})
+
+	if err != nil {
+		return errors.Wrap(err, "failed to open")
+	}
+
+	e.client = c
+
+	return nil
+}
+
+func (e *etcd) Close() {
+	if e.client != nil {
+		_ = e.client.Close()
+		e.client = nil
+	}
+}
+
+func (e *etcd) Client() *clientv3.Client {
+	return e.client
+}
+
+func (e *etcd) Get(ctx context.Context, key string) (string, error) {
+	ctx, span := tracing.Start(ctx, "etcd.get", attribute.String("etcd.key", key))
+
+	resp, err := e.client.Get(ctx, key)
+	if err == nil && len(resp.Kvs) == 0 {
+		err = ErrNotFound
+	}
+
+	tracing.End(span, err)
+
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get")
+	}
+
+	return string(resp.Kvs[0].Value), nil
+}
+
+func (e *etcd) List(ctx context.Context, prefix string) (map[string]string, error) {
+	ctx, span := tracing.Start(ctx, "etcd.list", attribute.String("etcd.prefix", prefix))
+
+	resp, err := e.client.Get(ctx, prefix, clientv3.WithPrefix())
+	tracing.End(span, err)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list")
+	}
+
+	buf := make(map[string]string, len(resp.Kvs))
+
+	for _, kv := range resp.Kvs {
+		buf[string(kv.Key)] = string(kv.Value)
+	}
+
+	return buf, nil
+}
+
+func (e *etcd) Put(ctx context.Context, key, val string) error {
+	ctx, span := tracing.Start(ctx, "etcd.put", attribute.String("etcd.key", key))
+
+	_, err := e.client.Put(ctx, key, val)
+	tracing.End(span, err)
+
+	if err != nil {
+		return errors.Wrap(err, "failed to put")
+	}
+
+	return nil
+}
+
+func (e *etcd) Delete(ctx context.Context, key string) error {
+	ctx, span := tracing.Start(ctx, "etcd.delete", attribute.String("etcd.key", key))
+
+	_, err := e.client.Delete(ctx, key)
+	tracing.End(span, err)
+
+	if err != nil {
+		return errors.Wrap(err, "failed to delete")
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEtcd(t *testing.T) {
+	e := New(context.Background(), DefaultConfig())
+	assert.NotEqual(t, nil, e)
+
+	err := e.Open()
+	assert.Equal(t, nil, err)
+
+	defer e.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = e.Put(ctx, "/metalflow/test/key", "val")
+	assert.Equal(t, nil, err)
+
+	val, err := e.Get(ctx, "/metalflow/test/key")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "val", val)
+
+	kvs, err := e.List(ctx, "/metalflow/test/")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "val", kvs["/metalflow/test/key"])
+
+	err = e.Delete(ctx, "/metalflow/test/key")
+	assert.Equal(t, nil, err)
+
+	_, err = e.Get(ctx, "/metalflow/test/key")
+	assert.Equal(t, true, errors.Is(err, ErrNotFound))
+}
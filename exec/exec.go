@@ -0,0 +1,199 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exec
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/craftslab/metalflow/logger"
+	"github.com/craftslab/metalflow/model"
+)
+
+const (
+	StateFailed    = "failed"
+	StateRunning   = "running"
+	StateSucceeded = "succeeded"
+
+	// AllowAll permits any command to a role.
+	AllowAll = "*"
+
+	// metachars chain or redirect commands in a shell, so that a command
+	// allowed by prefix could run another one.
+	metachars = ";&|`$()<>\\\n"
+)
+
+// Exec runs commands on the workers of nodes, streaming their output to the
+// caller, and records their exit code and duration. The commands of a role
+// are limited by Allow of its config.
+type Exec interface {
+	Run(ctx context.Context, req *Request, fn func(Event) error) (*Execution, error)
+	Query(ctx context.Context, node uint) ([]Execution, error)
+}
+
+// Config allows the commands of Allow to every role, either all of them with
+// AllowAll, or those equal to or starting with one of them followed by a
+// space, without shell metacharacters.
+type Config struct {
+	Allow    map[string][]string
+	Store    Store
+	Streamer Streamer
+	Timeout  time.Duration
+}
+
+// Request is the command run on node by creator having role.
+type Request struct {
+	Command string `json:"command" binding:"required"`
+	Creator string `json:"-"`
+	Node    uint   `json:"-"`
+	Role    string `json:"-"`
+}
+
+// Execution is the record of a command run on a node. Duration is in
+// milliseconds.
+type Execution struct {
+	Address    string    `json:"address"`
+	Code       int       `json:"code"`
+	Command    string    `json:"command"`
+	Creator    string    `json:"creator"`
+	Duration   int64     `json:"duration"`
+	Error      string    `json:"error"`
+	FinishedAt time.Time `json:"finishedAt"`
+	Id         uint      `gorm:"primarykey" json:"id"`
+	Node       uint      `gorm:"index" json:"node"`
+	StartedAt  time.Time `json:"startedAt"`
+	State      string    `json:"state"`
+}
+
+type exec struct {
+	config *Config
+}
+
+var (
+	ErrNotAllowed = errors.New("command not allowed")
+)
+
+func New(config *Config) Exec {
+	return &exec{
+		config: config,
+	}
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Allow: map[string][]string{
+			model.RoleAdmin: {AllowAll},
+		},
+		Store:    NewMemoryStore(),
+		Streamer: nil,
+		Timeout:  time.Minute,
+	}
+}
+
+// Run returns an error without running the command if it is not allowed, or
+// the node is not found or writable. Otherwise, the execution is recorded
+// whether the command succeeds or not.
+func (e *exec) Run(ctx context.Context, req *Request, fn func(Event) error) (*Execution, error) {
+	command := strings.TrimSpace(req.Command)
+	if command == "" {
+		return nil, errors.New("invalid command")
+	}
+
+	if !Allowed(e.config.Allow[req.Role], command) {
+		return nil, ErrNotAllowed
+	}
+
+	n, err := model.GetNode(ctx, req.Node)
+	if err != nil {
+		return nil, err
+	}
+
+	if !model.ScopeOf(ctx).CanWrite(n.Project) {
+		return nil, model.ErrForbidden
+	}
+
+	x := &Execution{
+		Address:   n.Address,
+		Command:   command,
+		Creator:   req.Creator,
+		Node:      n.Id,
+		StartedAt: time.Now(),
+		State:     StateRunning,
+	}
+
+	if err := e.config.Store.AddExecution(ctx, x); err != nil {
+		return nil, errors.Wrap(err, "failed to add")
+	}
+
+	c, cancel := context.WithTimeout(ctx, e.config.Timeout)
+	defer cancel()
+
+	if e.config.Streamer == nil {
+		err = errors.New("no streamer")
+	} else {
+		x.Code, err = e.config.Streamer.Stream(c, n.Address, fmt.Sprintf("exec-%d", x.Id), command, fn)
+	}
+
+	x.FinishedAt = time.Now()
+	x.Duration = x.FinishedAt.Sub(x.StartedAt).Milliseconds()
+	x.State = StateSucceeded
+
+	if err != nil {
+		x.Error = err.Error()
+		x.State = StateFailed
+	} else if x.Code != 0 {
+		x.Error = fmt.Sprintf("exit code %d", x.Code)
+		x.State = StateFailed
+	}
+
+	// The caller may have gone meanwhile, which is recorded all the same.
+	if err := e.config.Store.PutExecution(context.Background(), x); err != nil {
+		logger.Error(ctx, "failed to put execution", "id", x.Id, "error", err)
+	}
+
+	return x, nil
+}
+
+func (e *exec) Query(ctx context.Context, node uint) ([]Execution, error) {
+	if _, err := model.GetNode(ctx, node); err != nil {
+		return nil, err
+	}
+
+	return e.config.Store.QueryExecution(ctx, node)
+}
+
+// Allowed tells if command is one of allow, which is checked by the flows
+// and schedules too before dispatching it.
+func Allowed(allow []string, command string) bool {
+	for _, item := range allow {
+		if item == AllowAll {
+			return true
+		}
+	}
+
+	if strings.ContainsAny(command, metachars) {
+		return false
+	}
+
+	for _, item := range allow {
+		if command == item || strings.HasPrefix(command, item+" ") {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,142 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exec
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/craftslab/metalflow/etcd"
+	"github.com/craftslab/metalflow/model"
+)
+
+func TestAllowed(t *testing.T) {
+	allow := []string{"uptime", "systemctl status"}
+
+	assert.Equal(t, true, Allowed(allow, "uptime"))
+	assert.Equal(t, true, Allowed(allow, "systemctl status sshd"))
+	assert.Equal(t, false, Allowed(allow, "uptimes"))
+	assert.Equal(t, false, Allowed(allow, "systemctl stop sshd"))
+	assert.Equal(t, false, Allowed(allow, "uptime; reboot"))
+	assert.Equal(t, false, Allowed(allow, "uptime $(reboot)"))
+	assert.Equal(t, false, Allowed(nil, "uptime"))
+	assert.Equal(t, true, Allowed([]string{AllowAll}, "uptime | wc -l"))
+}
+
+func TestExec(t *testing.T) {
+	ctx := model.WithScope(context.Background(), &model.Scope{All: true})
+
+	c := DefaultConfig()
+	c.Allow[model.RoleUser] = []string{"uptime"}
+	c.Streamer = StreamerFunc(func(_ context.Context, host, id, command string, fn func(Event) error) (int, error) {
+		_ = fn(Event{Data: host + " " + id + "\n", Stream: StreamStdout})
+		_ = fn(Event{Data: command + "\n", Stream: StreamStderr})
+		if command == "false" {
+			return 1, nil
+		}
+		return 0, nil
+	})
+
+	e := New(c)
+
+	var buf []Event
+
+	x, err := e.Run(ctx, &Request{Command: "uptime", Creator: "admin", Node: 1, Role: model.RoleAdmin}, func(v Event) error {
+		buf = append(buf, v)
+		return nil
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, StateSucceeded, x.State)
+	assert.Equal(t, 0, x.Code)
+	assert.Equal(t, []Event{
+		{Data: "127.0.0.2 exec-1\n", Stream: StreamStdout},
+		{Data: "uptime\n", Stream: StreamStderr},
+	}, buf)
+
+	x, err = e.Run(ctx, &Request{Command: "false", Node: 1, Role: model.RoleAdmin}, func(Event) error { return nil })
+	assert.Equal(t, nil, err)
+	assert.Equal(t, StateFailed, x.State)
+	assert.Equal(t, 1, x.Code)
+
+	_, err = e.Run(ctx, &Request{Command: "reboot", Node: 1, Role: model.RoleUser}, nil)
+	assert.Equal(t, ErrNotAllowed, err)
+
+	_, err = e.Run(ctx, &Request{Command: "uptime", Node: 100, Role: model.RoleUser}, nil)
+	assert.Equal(t, model.ErrNodeNotFound, err)
+
+	scoped := model.WithScope(ctx, &model.Scope{Projects: map[uint]string{1: model.ProjectRoleViewer}})
+
+	_, err = e.Run(scoped, &Request{Command: "uptime", Node: 1, Role: model.RoleUser}, nil)
+	assert.Equal(t, model.ErrForbidden, err)
+
+	executions, err := e.Query(ctx, 1)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(executions))
+	assert.Equal(t, "false", executions[0].Command)
+}
+
+func TestEtcdStreamer(t *testing.T) {
+	e := etcd.New(context.Background(), etcd.DefaultConfig())
+
+	err := e.Open()
+	assert.Equal(t, nil, err)
+
+	defer e.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The worker answers the command with its output and exit code.
+	go func() {
+		w := e.Client().Watch(ctx, fmt.Sprintf(execKey, "test", "1"))
+		for r := range w {
+			for _, ev := range r.Events {
+				if ev.Type != clientv3.EventTypePut {
+					continue
+				}
+				for i, v := range []Event{
+					{Data: string(ev.Kv.Value), Stream: StreamStdout},
+					{Data: "done", Stream: StreamStderr},
+					{Code: 2, Stream: StreamExit},
+				} {
+					buf, _ := json.Marshal(v)
+					_ = e.Put(ctx, fmt.Sprintf(streamKey+"%d", "test", "1", i), string(buf))
+				}
+				return
+			}
+		}
+	}()
+
+	// Let the worker watch first.
+	time.Sleep(100 * time.Millisecond)
+
+	var buf []string
+
+	code, err := NewEtcdStreamer(e).Stream(ctx, "test", "1", "uptime", func(v Event) error {
+		buf = append(buf, v.Stream+" "+v.Data)
+		return nil
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, code)
+	assert.Equal(t, []string{"stdout uptime", "stderr done"}, buf)
+
+	kvs, err := e.List(ctx, "/metalflow/worker/test/")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(kvs))
+}
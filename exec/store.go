@@ -0,0 +1,135 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exec
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/craftslab/metalflow/postgres"
+)
+
+// Store persists the executions.
+type Store interface {
+	AddExecution(ctx context.Context, execution *Execution) error
+	PutExecution(ctx context.Context, execution *Execution) error
+	QueryExecution(ctx context.Context, node uint) ([]Execution, error)
+}
+
+type memoryStore struct {
+	executions map[uint]Execution
+	mutex      sync.RWMutex
+}
+
+type postgresStore struct {
+	postgres postgres.Postgres
+}
+
+func (Execution) TableName() string {
+	return "executions"
+}
+
+func NewMemoryStore() Store {
+	return &memoryStore{
+		executions: map[uint]Execution{},
+	}
+}
+
+func NewPostgresStore(p postgres.Postgres) (Store, error) {
+	if err := p.Migrate(&Execution{}); err != nil {
+		return nil, errors.Wrap(err, "failed to migrate execution")
+	}
+
+	return &postgresStore{postgres: p}, nil
+}
+
+func (m *memoryStore) AddExecution(_ context.Context, execution *Execution) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	execution.Id = uint(len(m.executions)) + 1
+	m.executions[execution.Id] = *execution
+
+	return nil
+}
+
+func (m *memoryStore) PutExecution(_ context.Context, execution *Execution) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.executions[execution.Id]; !ok {
+		return errors.New("invalid id")
+	}
+
+	m.executions[execution.Id] = *execution
+
+	return nil
+}
+
+func (m *memoryStore) QueryExecution(_ context.Context, node uint) ([]Execution, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	buf := make([]Execution, 0)
+
+	for _, v := range m.executions {
+		if v.Node == node {
+			buf = append(buf, v)
+		}
+	}
+
+	sort.Slice(buf, func(i, j int) bool {
+		return buf[i].Id > buf[j].Id
+	})
+
+	return buf, nil
+}
+
+func (p *postgresStore) AddExecution(ctx context.Context, execution *Execution) error {
+	p.postgres.WithContext(ctx).Create(execution)
+
+	if execution.Id == 0 {
+		return errors.New("failed to create")
+	}
+
+	return nil
+}
+
+func (p *postgresStore) PutExecution(ctx context.Context, execution *Execution) error {
+	var x Execution
+
+	p.postgres.WithContext(ctx).Raw(&x, `UPDATE executions SET code = ?, duration = ?, error = ?, finished_at = ?, state = ?
+WHERE id = ? RETURNING id`, execution.Code, execution.Duration, execution.Error, execution.FinishedAt,
+		execution.State, execution.Id)
+
+	if x.Id == 0 {
+		return errors.New("failed to put")
+	}
+
+	return nil
+}
+
+func (p *postgresStore) QueryExecution(ctx context.Context, node uint) ([]Execution, error) {
+	buf := make([]Execution, 0)
+
+	p.postgres.WithContext(ctx).Query(&buf, &postgres.Filter{
+		Cond:   "node = ?",
+		Values: []interface{}{node},
+		Order:  "id desc",
+	})
+
+	return buf, nil
+}
@@ -0,0 +1,114 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exec
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/craftslab/metalflow/etcd"
+)
+
+const (
+	StreamExit   = "exit"
+	StreamStderr = "stderr"
+	StreamStdout = "stdout"
+
+	execKey   = "/metalflow/worker/%s/exec/%s"
+	streamKey = "/metalflow/worker/%s/stream/%s/"
+)
+
+// Streamer runs command on the worker of host, calling fn with its output as
+// it is written, and returns its exit code. The command is cancelled once ctx
+// is done, or fn fails.
+type Streamer interface {
+	Stream(ctx context.Context, host, id, command string, fn func(Event) error) (int, error)
+}
+
+type StreamerFunc func(ctx context.Context, host, id, command string, fn func(Event) error) (int, error)
+
+// Event is a chunk of the output of a command on Stream, or its exit code on
+// StreamExit.
+type Event struct {
+	Code   int    `json:"code,omitempty"`
+	Data   string `json:"data,omitempty"`
+	Stream string `json:"stream"`
+}
+
+type etcdStreamer struct {
+	etcd etcd.Etcd
+}
+
+func (f StreamerFunc) Stream(ctx context.Context, host, id, command string, fn func(Event) error) (int, error) {
+	return f(ctx, host, id, command, fn)
+}
+
+// NewEtcdStreamer puts the command at /metalflow/worker/{HOST}/exec/{ID}, and
+// watches the events the worker puts under /metalflow/worker/{HOST}/stream/{ID}/
+// in order, until StreamExit. The worker shall cancel the command once its key
+// is deleted.
+func NewEtcdStreamer(e etcd.Etcd) Streamer {
+	return &etcdStreamer{etcd: e}
+}
+
+func (e *etcdStreamer) Stream(ctx context.Context, host, id, command string, fn func(Event) error) (int, error) {
+	exec := fmt.Sprintf(execKey, host, id)
+	stream := fmt.Sprintf(streamKey, host, id)
+
+	defer func() {
+		_ = e.etcd.Delete(context.Background(), exec)
+		_, _ = e.etcd.Client().Delete(context.Background(), stream, clientv3.WithPrefix())
+	}()
+
+	resp, err := e.etcd.Client().Put(ctx, exec, command)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to put")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Watch from the revision of the command, so that no event is missed.
+	w := e.etcd.Client().Watch(ctx, stream, clientv3.WithPrefix(), clientv3.WithRev(resp.Header.Revision))
+
+	for r := range w {
+		if err := r.Err(); err != nil {
+			return 0, errors.Wrap(err, "failed to watch")
+		}
+		for _, ev := range r.Events {
+			if ev.Type != clientv3.EventTypePut {
+				continue
+			}
+			var v Event
+			if err := json.Unmarshal(ev.Kv.Value, &v); err != nil {
+				return 0, errors.Wrap(err, "invalid event")
+			}
+			if v.Stream == StreamExit {
+				return v.Code, nil
+			}
+			if err := fn(v); err != nil {
+				return 0, errors.Wrap(err, "failed to stream")
+			}
+		}
+	}
+
+	if ctx.Err() != nil {
+		return 0, errors.Wrap(ctx.Err(), "failed to wait")
+	}
+
+	return 0, errors.New("watch closed")
+}
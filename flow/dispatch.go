@@ -0,0 +1,172 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/craftslab/metalflow/etcd"
+)
+
+const (
+	dispatchKey = "/metalflow/worker/%s/dispatch/%s"
+	fetchKey    = "/metalflow/worker/%s/fetch/%s"
+	resultKey   = "/metalflow/worker/%s/result/%s"
+)
+
+// Dispatcher runs command on the worker of host and returns its output. The
+// id is the same when a command is dispatched again after a restart, so that
+// workers may tell.
+type Dispatcher interface {
+	Dispatch(ctx context.Context, host, id, command string) (string, error)
+}
+
+type DispatcherFunc func(ctx context.Context, host, id, command string) (string, error)
+
+// Fetcher has the worker of host fetch an artifact, and returns its output
+// once the checksum of the written file matches that of the artifact.
+type Fetcher interface {
+	Fetch(ctx context.Context, host, id string, fetch *Fetch) (string, error)
+}
+
+type FetcherFunc func(ctx context.Context, host, id string, fetch *Fetch) (string, error)
+
+// Fetch tells the worker to download Url, whose SHA-256 is Sha256, to Path
+// with Mode and Owner unless empty.
+type Fetch struct {
+	Mode   string `json:"mode,omitempty"`
+	Owner  string `json:"owner,omitempty"`
+	Path   string `json:"path"`
+	Sha256 string `json:"sha256"`
+	Url    string `json:"url"`
+}
+
+// Result is written by the worker once the command has exited, or the file
+// is fetched, with the SHA-256 of the file then.
+type Result struct {
+	Code   int    `json:"code"`
+	Output string `json:"output"`
+	Sha256 string `json:"sha256,omitempty"`
+}
+
+type etcdDispatcher struct {
+	etcd etcd.Etcd
+}
+
+func (f DispatcherFunc) Dispatch(ctx context.Context, host, id, command string) (string, error) {
+	return f(ctx, host, id, command)
+}
+
+func (f FetcherFunc) Fetch(ctx context.Context, host, id string, fetch *Fetch) (string, error) {
+	return f(ctx, host, id, fetch)
+}
+
+// NewEtcdDispatcher puts the command at /metalflow/worker/{HOST}/dispatch/{ID}
+// and waits for its result at /metalflow/worker/{HOST}/result/{ID}.
+func NewEtcdDispatcher(e etcd.Etcd) Dispatcher {
+	return &etcdDispatcher{etcd: e}
+}
+
+// NewEtcdFetcher puts the fetch at /metalflow/worker/{HOST}/fetch/{ID} and
+// waits for its result at /metalflow/worker/{HOST}/result/{ID}.
+func NewEtcdFetcher(e etcd.Etcd) Fetcher {
+	return &etcdDispatcher{etcd: e}
+}
+
+func (e *etcdDispatcher) Dispatch(ctx context.Context, host, id, command string) (string, error) {
+	r, err := e.roundTrip(ctx, fmt.Sprintf(dispatchKey, host, id), fmt.Sprintf(resultKey, host, id), command)
+	if err != nil {
+		return "", err
+	}
+
+	if r.Code != 0 {
+		return r.Output, errors.Errorf("exit code %d", r.Code)
+	}
+
+	return r.Output, nil
+}
+
+func (e *etcdDispatcher) Fetch(ctx context.Context, host, id string, fetch *Fetch) (string, error) {
+	buf, err := json.Marshal(fetch)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal")
+	}
+
+	r, err := e.roundTrip(ctx, fmt.Sprintf(fetchKey, host, id), fmt.Sprintf(resultKey, host, id), string(buf))
+	if err != nil {
+		return "", err
+	}
+
+	if r.Code != 0 {
+		return r.Output, errors.Errorf("exit code %d", r.Code)
+	}
+
+	if r.Sha256 != fetch.Sha256 {
+		return r.Output, errors.Errorf("checksum mismatch: %s", r.Sha256)
+	}
+
+	return r.Output, nil
+}
+
+// roundTrip puts val at key and waits for the result at result, then deletes
+// both.
+func (e *etcdDispatcher) roundTrip(ctx context.Context, key, result, val string) (*Result, error) {
+	defer func() {
+		_ = e.etcd.Delete(context.Background(), key)
+		_ = e.etcd.Delete(context.Background(), result)
+	}()
+
+	resp, err := e.etcd.Client().Put(ctx, key, val)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to put")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Watch from the revision of the dispatch, so that a result written in
+	// between is not missed.
+	w := e.etcd.Client().Watch(ctx, result, clientv3.WithRev(resp.Header.Revision))
+
+	for r := range w {
+		if err := r.Err(); err != nil {
+			return nil, errors.Wrap(err, "failed to watch")
+		}
+		for _, ev := range r.Events {
+			if ev.Type == clientv3.EventTypePut {
+				return parseResult(ev.Kv.Value)
+			}
+		}
+	}
+
+	if ctx.Err() != nil {
+		return nil, errors.Wrap(ctx.Err(), "failed to wait")
+	}
+
+	return nil, errors.New("watch closed")
+}
+
+func parseResult(buf []byte) (*Result, error) {
+	var r Result
+
+	if err := json.Unmarshal(buf, &r); err != nil {
+		return nil, errors.Wrap(err, "invalid result")
+	}
+
+	return &r, nil
+}
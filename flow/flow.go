@@ -0,0 +1,769 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/craftslab/metalflow/exec"
+	"github.com/craftslab/metalflow/labels"
+	"github.com/craftslab/metalflow/logger"
+	"github.com/craftslab/metalflow/model"
+)
+
+const (
+	StateAborted   = "aborted"
+	StateFailed    = "failed"
+	StatePaused    = "paused"
+	StatePending   = "pending"
+	StateRunning   = "running"
+	StateSkipped   = "skipped"
+	StateSucceeded = "succeeded"
+
+	healthy = "running"
+)
+
+// Engine runs the flows in the background and persists their progress, so
+// that Start resumes the unfinished ones after a restart. The running steps
+// of a node are run again then, hence commands shall be idempotent.
+type Engine interface {
+	Start(ctx context.Context) error
+	Stop()
+
+	Submit(ctx context.Context, spec *Spec, creator string) (*Flow, error)
+	Get(ctx context.Context, id uint) (*Status, error)
+	Query(ctx context.Context) ([]Flow, error)
+	Pause(ctx context.Context, id uint) (*Flow, error)
+	Resume(ctx context.Context, id uint) (*Flow, error)
+	Abort(ctx context.Context, id uint) (*Flow, error)
+}
+
+// Config allows the commands of Allow to every role, like the config of
+// exec does, and the fetch steps to the paths under Paths of the role.
+type Config struct {
+	Allow      map[string][]string
+	Artifacts  Artifacts
+	Dispatcher Dispatcher
+	Fetcher    Fetcher
+	Interval   time.Duration
+	Paths      map[string][]string
+	Store      Store
+}
+
+// Artifacts returns the URL the workers download the artifact of sum in
+// project from.
+type Artifacts interface {
+	URL(ctx context.Context, project uint, sum string) (string, error)
+}
+
+// Flow is a submitted spec with its target nodes, which are run in batches
+// of the spec. Batch is the index of the current batch.
+type Flow struct {
+	Batch     int       `json:"batch"`
+	CreatedAt time.Time `json:"createdAt"`
+	Creator   string    `json:"creator"`
+	Error     string    `json:"error"`
+	Id        uint      `gorm:"primarykey" json:"id"`
+	Name      string    `json:"name"`
+	Nodes     Uints     `gorm:"type:text" json:"nodes" swaggertype:"array,integer"`
+	Project   uint      `gorm:"index" json:"project"`
+	Spec      string    `json:"spec"`
+	State     string    `gorm:"index" json:"state"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Step is the state of a step on a node.
+type Step struct {
+	Error      string    `json:"error"`
+	FinishedAt time.Time `json:"finishedAt"`
+	FlowId     uint      `gorm:"primaryKey;autoIncrement:false" json:"flowId"`
+	Name       string    `gorm:"primaryKey" json:"name"`
+	Node       uint      `gorm:"primaryKey;autoIncrement:false" json:"node"`
+	Output     string    `json:"output"`
+	StartedAt  time.Time `json:"startedAt"`
+	State      string    `json:"state"`
+}
+
+type Status struct {
+	Flow  Flow   `json:"flow"`
+	Steps []Step `json:"steps"`
+}
+
+type engine struct {
+	cancel context.CancelFunc
+	config *Config
+	ctx    context.Context
+	mutex  sync.Mutex
+	runs   map[uint]*run
+	wg     sync.WaitGroup
+}
+
+// run is a flow being run. It is paused while resume is not nil.
+type run struct {
+	aborted bool
+	cancel  context.CancelFunc
+	done    chan struct{}
+	flow    Flow
+	mutex   sync.Mutex
+	resume  chan struct{}
+	steps   map[string]*Step
+}
+
+var (
+	ErrNotFound = errors.New("invalid id")
+	ErrState    = errors.New("invalid state")
+)
+
+func New(config *Config) Engine {
+	return &engine{
+		config: config,
+		runs:   map[uint]*run{},
+	}
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Allow: map[string][]string{
+			model.RoleAdmin: {exec.AllowAll},
+		},
+		Artifacts:  nil,
+		Dispatcher: nil,
+		Fetcher:    nil,
+		Interval:   5 * time.Second,
+		Paths: map[string][]string{
+			model.RoleAdmin: {"/"},
+		},
+		Store: NewMemoryStore(),
+	}
+}
+
+func (e *engine) Start(ctx context.Context) error {
+	// The flows are run on behalf of their creators, once submitted.
+	e.mutex.Lock()
+	e.ctx, e.cancel = context.WithCancel(model.WithScope(ctx, &model.Scope{All: true}))
+	e.mutex.Unlock()
+
+	buf, err := e.config.Store.QueryFlow(ctx, StateRunning, StatePaused)
+	if err != nil {
+		return errors.Wrap(err, "failed to query")
+	}
+
+	for i := range buf {
+		steps, err := e.config.Store.QueryStep(ctx, buf[i].Id)
+		if err != nil {
+			return errors.Wrap(err, "failed to query step")
+		}
+		logger.Info(ctx, "resuming flow", "id", buf[i].Id, "state", buf[i].State, "batch", buf[i].Batch)
+		e.launch(buf[i], steps)
+	}
+
+	return nil
+}
+
+func (e *engine) Stop() {
+	e.mutex.Lock()
+	if e.cancel != nil {
+		e.cancel()
+	}
+	e.mutex.Unlock()
+
+	e.wg.Wait()
+}
+
+// Submit targets the nodes of the project of spec matching its selector.
+func (e *engine) Submit(ctx context.Context, spec *Spec, creator string) (*Flow, error) {
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+
+	if _, err := model.GetProject(ctx, spec.Project); err != nil {
+		return nil, errors.New("invalid project")
+	}
+
+	if !model.ScopeOf(ctx).CanWrite(spec.Project) {
+		return nil, model.ErrForbidden
+	}
+
+	if err := e.allow(ctx, spec); err != nil {
+		return nil, err
+	}
+
+	selector, _ := labels.Parse(spec.Selector)
+
+	nodes, err := model.QueryNode(ctx, "", selector)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query node")
+	}
+
+	f := Flow{
+		Creator: creator,
+		Name:    spec.Name,
+		Nodes:   Uints{},
+		Project: spec.Project,
+		State:   StateRunning,
+	}
+
+	// The nodes in maintenance, draining or out of service are not targeted.
+	for _, n := range nodes {
+		if n.Project == spec.Project && n.Dispatchable() {
+			f.Nodes = append(f.Nodes, n.Id)
+		}
+	}
+
+	if len(f.Nodes) == 0 {
+		return nil, errors.New("no node matched")
+	}
+
+	buf, err := yaml.Marshal(spec)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal")
+	}
+
+	f.Spec = string(buf)
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.ctx == nil {
+		return nil, errors.New("engine not started")
+	}
+
+	if err := e.config.Store.AddFlow(ctx, &f); err != nil {
+		return nil, errors.Wrap(err, "failed to add")
+	}
+
+	steps := make([]Step, 0, len(f.Nodes)*len(spec.Steps))
+
+	for _, n := range f.Nodes {
+		for _, st := range spec.Steps {
+			s := Step{FlowId: f.Id, Name: st.Name, Node: n, State: StatePending}
+			if err := e.config.Store.PutStep(ctx, &s); err != nil {
+				return nil, errors.Wrap(err, "failed to put step")
+			}
+			steps = append(steps, s)
+		}
+	}
+
+	e.launchLocked(f, steps)
+
+	return &f, nil
+}
+
+func (e *engine) Get(ctx context.Context, id uint) (*Status, error) {
+	f, err := e.config.Store.GetFlow(ctx, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get")
+	}
+
+	if f == nil || !model.ScopeOf(ctx).CanRead(f.Project) {
+		return nil, ErrNotFound
+	}
+
+	steps, err := e.config.Store.QueryStep(ctx, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query step")
+	}
+
+	return &Status{Flow: *f, Steps: steps}, nil
+}
+
+func (e *engine) Query(ctx context.Context) ([]Flow, error) {
+	buf, err := e.config.Store.QueryFlow(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query")
+	}
+
+	flows := make([]Flow, 0, len(buf))
+
+	for _, f := range buf {
+		if model.ScopeOf(ctx).CanRead(f.Project) {
+			flows = append(flows, f)
+		}
+	}
+
+	return flows, nil
+}
+
+// Pause lets the running steps finish but starts no more.
+func (e *engine) Pause(ctx context.Context, id uint) (*Flow, error) {
+	r, err := e.find(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.resume != nil || r.aborted {
+		return nil, ErrState
+	}
+
+	r.resume = make(chan struct{})
+	r.flow.State = StatePaused
+
+	return e.save(r.flow)
+}
+
+func (e *engine) Resume(ctx context.Context, id uint) (*Flow, error) {
+	r, err := e.find(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.resume == nil || r.aborted {
+		return nil, ErrState
+	}
+
+	close(r.resume)
+	r.resume = nil
+	r.flow.State = StateRunning
+
+	return e.save(r.flow)
+}
+
+// Abort cancels the running steps and waits for the flow to stop.
+func (e *engine) Abort(ctx context.Context, id uint) (*Flow, error) {
+	r, err := e.find(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mutex.Lock()
+	r.aborted = true
+	r.mutex.Unlock()
+
+	r.cancel()
+	<-r.done
+
+	f, err := e.config.Store.GetFlow(ctx, id)
+	if err != nil || f == nil {
+		return nil, errors.Wrap(err, "failed to get")
+	}
+
+	return f, nil
+}
+
+// find returns the run of id, which the scope of ctx shall be able to write.
+func (e *engine) find(ctx context.Context, id uint) (*run, error) {
+	e.mutex.Lock()
+	r, ok := e.runs[id]
+	e.mutex.Unlock()
+
+	if !ok {
+		f, err := e.config.Store.GetFlow(ctx, id)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get")
+		}
+		if f == nil || !model.ScopeOf(ctx).CanRead(f.Project) {
+			return nil, ErrNotFound
+		}
+		return nil, ErrState
+	}
+
+	r.mutex.Lock()
+	project := r.flow.Project
+	r.mutex.Unlock()
+
+	if !model.ScopeOf(ctx).CanRead(project) {
+		return nil, ErrNotFound
+	}
+
+	if !model.ScopeOf(ctx).CanWrite(project) {
+		return nil, model.ErrForbidden
+	}
+
+	return r, nil
+}
+
+// allow checks the commands and fetched paths of spec against the role of
+// the scope of ctx. The setuid and setgid bits are left to admins.
+func (e *engine) allow(ctx context.Context, spec *Spec) error {
+	s := model.ScopeOf(ctx)
+	if s == nil {
+		return model.ErrForbidden
+	}
+
+	for _, st := range spec.Steps {
+		switch st.Type {
+		case StepDispatch:
+			if !exec.Allowed(e.config.Allow[s.Role], st.Command) {
+				return errors.Wrapf(exec.ErrNotAllowed, "step %q", st.Name)
+			}
+		case StepFetch:
+			if !within(e.config.Paths[s.Role], st.Path) {
+				return errors.Wrapf(model.ErrForbidden, "path of step %q", st.Name)
+			}
+			if m, _ := strconv.ParseUint(st.Mode, 8, 32); m&setid != 0 && s.Role != model.RoleAdmin {
+				return errors.Wrapf(model.ErrForbidden, "mode of step %q", st.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (e *engine) launch(f Flow, steps []Step) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.launchLocked(f, steps)
+}
+
+func (e *engine) launchLocked(f Flow, steps []Step) {
+	ctx, cancel := context.WithCancel(e.ctx)
+
+	r := &run{
+		cancel: cancel,
+		done:   make(chan struct{}),
+		flow:   f,
+		steps:  map[string]*Step{},
+	}
+
+	if f.State == StatePaused {
+		r.resume = make(chan struct{})
+	}
+
+	for i := range steps {
+		s := steps[i]
+		// The steps running when the master stopped are run again.
+		if s.State == StateRunning {
+			s.State = StatePending
+		}
+		r.steps[key(s.Node, s.Name)] = &s
+	}
+
+	e.runs[f.Id] = r
+	e.wg.Add(1)
+
+	go func() {
+		defer e.wg.Done()
+		defer close(r.done)
+		defer cancel()
+
+		e.exec(ctx, r)
+
+		e.mutex.Lock()
+		delete(e.runs, f.Id)
+		e.mutex.Unlock()
+	}()
+}
+
+func (e *engine) exec(ctx context.Context, r *run) {
+	spec, err := Parse([]byte(r.flow.Spec))
+	if err != nil {
+		e.finish(r, StateFailed, err.Error())
+		return
+	}
+
+	levels, _ := spec.levels()
+	batches := split(r.flow.Nodes, spec.Batch.Size)
+
+	for {
+		if err := r.wait(ctx); err != nil {
+			break
+		}
+
+		r.mutex.Lock()
+		b := r.flow.Batch
+		r.mutex.Unlock()
+
+		if b >= len(batches) {
+			break
+		}
+
+		var wg sync.WaitGroup
+
+		for _, n := range batches[b] {
+			wg.Add(1)
+			go func(n uint) {
+				defer wg.Done()
+				e.runNode(ctx, r, n, levels)
+			}(n)
+		}
+
+		wg.Wait()
+
+		if ctx.Err() != nil {
+			break
+		}
+
+		if n := r.failed(); n > spec.Batch.MaxFailures {
+			e.finish(r, StateFailed, fmt.Sprintf("%d nodes failed", n))
+			return
+		}
+
+		r.mutex.Lock()
+		r.flow.Batch++
+		_, err := e.save(r.flow)
+		r.mutex.Unlock()
+
+		if err != nil {
+			logger.Error(ctx, "failed to save flow", "id", r.flow.Id, "error", err)
+		}
+	}
+
+	r.mutex.Lock()
+	aborted := r.aborted
+	r.mutex.Unlock()
+
+	switch {
+	case aborted:
+		e.finish(r, StateAborted, "")
+	case ctx.Err() != nil:
+		// Stopped, to be resumed by the next start.
+	default:
+		e.finish(r, StateSucceeded, "")
+	}
+}
+
+func (e *engine) runNode(ctx context.Context, r *run, id uint, levels [][]StepSpec) {
+	for _, level := range levels {
+		if r.wait(ctx) != nil {
+			return
+		}
+
+		// The node may have left active since the previous level.
+		node, err := model.GetNode(model.WithScope(context.Background(), &model.Scope{All: true}), id)
+
+		var wg sync.WaitGroup
+
+		for _, st := range level {
+			s := r.step(id, st.Name)
+			if s.State != StatePending {
+				continue
+			}
+
+			switch {
+			case err != nil:
+				e.update(r, s, StateFailed, "", err)
+			case !node.Dispatchable():
+				e.update(r, s, StateSkipped, "", errors.New("node is "+node.Lifecycle))
+			case !r.succeeded(id, st.Needs):
+				e.update(r, s, StateSkipped, "", nil)
+			default:
+				wg.Add(1)
+				go func(st StepSpec, s Step) {
+					defer wg.Done()
+					e.runStep(ctx, r, node, st, s)
+				}(st, s)
+			}
+		}
+
+		wg.Wait()
+	}
+}
+
+func (e *engine) runStep(ctx context.Context, r *run, node model.Node, st StepSpec, s Step) {
+	s.StartedAt = time.Now()
+	e.update(r, s, StateRunning, "", nil)
+
+	c, cancel := context.WithTimeout(ctx, st.Timeout)
+	defer cancel()
+
+	var out string
+	var err error
+
+	switch st.Type {
+	case StepDispatch:
+		if e.config.Dispatcher == nil {
+			err = errors.New("no dispatcher")
+			break
+		}
+		out, err = e.config.Dispatcher.Dispatch(c, node.Address, fmt.Sprintf("%d-%d-%s", s.FlowId, s.Node, s.Name), st.Command)
+	case StepFetch:
+		out, err = e.fetch(c, r.flow.Project, node, fmt.Sprintf("%d-%d-%s", s.FlowId, s.Node, s.Name), &st)
+	case StepHealth:
+		err = e.health(c, node.Id)
+	}
+
+	if ctx.Err() != nil {
+		r.mutex.Lock()
+		aborted := r.aborted
+		r.mutex.Unlock()
+		if !aborted {
+			return
+		}
+		err = errors.New("aborted")
+	}
+
+	s.FinishedAt = time.Now()
+
+	if err != nil {
+		e.update(r, s, StateFailed, out, err)
+	} else {
+		e.update(r, s, StateSucceeded, out, nil)
+	}
+}
+
+func (e *engine) fetch(ctx context.Context, project uint, node model.Node, id string, st *StepSpec) (string, error) {
+	if e.config.Artifacts == nil || e.config.Fetcher == nil {
+		return "", errors.New("no fetcher")
+	}
+
+	url, err := e.config.Artifacts.URL(ctx, project, st.Artifact)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get artifact")
+	}
+
+	return e.config.Fetcher.Fetch(ctx, node.Address, id, &Fetch{
+		Mode:   st.Mode,
+		Owner:  st.Owner,
+		Path:   st.Path,
+		Sha256: st.Artifact,
+		Url:    url,
+	})
+}
+
+// health waits for the node to be healthy.
+func (e *engine) health(ctx context.Context, id uint) error {
+	t := time.NewTicker(e.config.Interval)
+	defer t.Stop()
+
+	for {
+		if h, err := model.GetHealth(ctx, id); err == nil && h == healthy {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.New("not healthy")
+		case <-t.C:
+		}
+	}
+}
+
+func (e *engine) update(r *run, s Step, state, output string, err error) {
+	s.State = state
+	s.Output = output
+
+	if err != nil {
+		s.Error = err.Error()
+	}
+
+	r.mutex.Lock()
+	*r.steps[key(s.Node, s.Name)] = s
+	r.mutex.Unlock()
+
+	if err := e.config.Store.PutStep(context.Background(), &s); err != nil {
+		logger.Error(context.Background(), "failed to save step", "flow", s.FlowId, "node", s.Node, "step", s.Name, "error", err)
+	}
+}
+
+func (e *engine) finish(r *run, state, message string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.flow.State = state
+	r.flow.Error = message
+
+	if _, err := e.save(r.flow); err != nil {
+		logger.Error(context.Background(), "failed to save flow", "id", r.flow.Id, "error", err)
+	}
+
+	logger.Info(context.Background(), "flow finished", "id", r.flow.Id, "state", state)
+}
+
+func (e *engine) save(f Flow) (*Flow, error) {
+	if err := e.config.Store.PutFlow(context.Background(), &f); err != nil {
+		return nil, errors.Wrap(err, "failed to put")
+	}
+
+	return &f, nil
+}
+
+// wait blocks while the run is paused.
+func (r *run) wait(ctx context.Context) error {
+	r.mutex.Lock()
+	ch := r.resume
+	r.mutex.Unlock()
+
+	if ch == nil {
+		return ctx.Err()
+	}
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *run) step(node uint, name string) Step {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return *r.steps[key(node, name)]
+}
+
+func (r *run) succeeded(node uint, needs []string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, item := range needs {
+		if r.steps[key(node, item)].State != StateSucceeded {
+			return false
+		}
+	}
+
+	return true
+}
+
+// failed returns the number of nodes with a failed step.
+func (r *run) failed() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	nodes := map[uint]bool{}
+
+	for _, s := range r.steps {
+		if s.State == StateFailed {
+			nodes[s.Node] = true
+		}
+	}
+
+	return len(nodes)
+}
+
+func split(nodes []uint, size int) [][]uint {
+	if size <= 0 {
+		size = len(nodes)
+	}
+
+	var buf [][]uint
+
+	for len(nodes) > 0 {
+		n := size
+		if n > len(nodes) {
+			n = len(nodes)
+		}
+		buf = append(buf, nodes[:n])
+		nodes = nodes[n:]
+	}
+
+	return buf
+}
+
+func key(node uint, name string) string {
+	return fmt.Sprintf("%d/%s", node, name)
+}
@@ -0,0 +1,302 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/craftslab/metalflow/exec"
+	"github.com/craftslab/metalflow/model"
+)
+
+type recorder struct {
+	block chan struct{}
+	calls []string
+	fail  string
+	mutex sync.Mutex
+}
+
+func (r *recorder) Dispatch(ctx context.Context, host, _, command string) (string, error) {
+	r.mutex.Lock()
+	r.calls = append(r.calls, host+" "+command)
+	block := r.block
+	r.mutex.Unlock()
+
+	if block != nil {
+		select {
+		case <-block:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+
+	if host == r.fail {
+		return "", errors.New("exit code 1")
+	}
+
+	return "ok", nil
+}
+
+func (r *recorder) count() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return len(r.calls)
+}
+
+func wait(t *testing.T, e Engine, id uint, state string) *Status {
+	for i := 0; i < 200; i++ {
+		s, err := e.Get(model.WithScope(context.Background(), &model.Scope{All: true}), id)
+		assert.Equal(t, nil, err)
+		if s.Flow.State == state {
+			return s
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("flow %d not %s", id, state)
+
+	return nil
+}
+
+func TestEngine(t *testing.T) {
+	ctx := model.WithScope(context.Background(), &model.Scope{All: true, Role: model.RoleAdmin})
+
+	var buf []model.Node
+	for _, item := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"} {
+		buf = append(buf, model.Node{Address: item, Labels: map[string]string{"role": "web"}, Project: 1})
+	}
+
+	_, err := model.ImportNode(ctx, buf, false)
+	assert.Equal(t, nil, err)
+
+	r := &recorder{fail: "10.0.0.2"}
+
+	c := DefaultConfig()
+	c.Dispatcher = r
+	c.Interval = 10 * time.Millisecond
+
+	e := New(c)
+	err = e.Start(ctx)
+	assert.Equal(t, nil, err)
+
+	spec, err := Parse([]byte(`
+name: restart
+project: 1
+selector: role=web
+batch: {size: 2}
+steps:
+  - {name: stop, type: dispatch, command: stop}
+  - {name: start, type: dispatch, command: start, needs: [stop]}
+`))
+	assert.Equal(t, nil, err)
+
+	// The first batch fails on 10.0.0.2, so the second one is not run.
+	f, err := e.Submit(ctx, spec, "admin")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 4, len(f.Nodes))
+
+	s := wait(t, e, f.Id, StateFailed)
+	assert.Equal(t, "1 nodes failed", s.Flow.Error)
+	assert.Equal(t, 3, r.count())
+
+	states := map[string]int{}
+	for _, item := range s.Steps {
+		states[item.State]++
+	}
+	assert.Equal(t, map[string]int{StateSucceeded: 2, StateFailed: 1, StateSkipped: 1, StatePending: 4}, states)
+
+	_, err = e.Pause(ctx, f.Id)
+	assert.Equal(t, ErrState, err)
+
+	// A viewer can neither submit nor control flows.
+	viewer := model.WithScope(ctx, &model.Scope{Projects: map[uint]string{1: model.ProjectRoleViewer}})
+	_, err = e.Submit(viewer, spec, "john")
+	assert.Equal(t, model.ErrForbidden, err)
+
+	// A maintainer cannot dispatch the commands its role is not allowed.
+	maintainer := model.WithScope(ctx, &model.Scope{
+		Projects: map[uint]string{1: model.ProjectRoleMaintainer},
+		Role:     model.RoleUser,
+	})
+	_, err = e.Submit(maintainer, spec, "john")
+	assert.Equal(t, true, errors.Is(err, exec.ErrNotAllowed))
+
+	c.Allow[model.RoleUser] = []string{"stop", "start"}
+	spec.Steps[0].Command = "stop; reboot"
+	_, err = e.Submit(maintainer, spec, "john")
+	assert.Equal(t, true, errors.Is(err, exec.ErrNotAllowed))
+	spec.Steps[0].Command = "stop"
+
+	// Paused between the steps, then resumed after a restart.
+	r.fail = ""
+	r.calls = nil
+	r.block = make(chan struct{})
+	spec.Batch.MaxFailures = 1
+
+	f, err = e.Submit(ctx, spec, "admin")
+	assert.Equal(t, nil, err)
+
+	_, err = e.Pause(viewer, f.Id)
+	assert.Equal(t, model.ErrForbidden, err)
+
+	for r.count() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	_, err = e.Pause(ctx, f.Id)
+	assert.Equal(t, nil, err)
+
+	close(r.block)
+
+	for done := 0; done < 2; {
+		time.Sleep(time.Millisecond)
+		s, _ := e.Get(ctx, f.Id)
+		done = 0
+		for _, item := range s.Steps {
+			if item.State == StateSucceeded {
+				done++
+			}
+		}
+	}
+
+	e.Stop()
+
+	assert.Equal(t, 2, r.count())
+
+	e = New(c)
+	err = e.Start(ctx)
+	assert.Equal(t, nil, err)
+
+	s = wait(t, e, f.Id, StatePaused)
+	assert.Equal(t, 0, s.Flow.Batch)
+
+	_, err = e.Resume(ctx, f.Id)
+	assert.Equal(t, nil, err)
+
+	s = wait(t, e, f.Id, StateSucceeded)
+	assert.Equal(t, 8, r.count())
+
+	for _, item := range s.Steps {
+		assert.Equal(t, StateSucceeded, item.State)
+	}
+
+	// Aborted while a step is running.
+	r.block = make(chan struct{})
+
+	f, err = e.Submit(ctx, spec, "admin")
+	assert.Equal(t, nil, err)
+
+	f, err = e.Abort(ctx, f.Id)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, StateAborted, f.State)
+
+	flows, _ := e.Query(viewer)
+	assert.Equal(t, 3, len(flows))
+
+	flows, _ = e.Query(model.WithScope(ctx, &model.Scope{}))
+	assert.Equal(t, 0, len(flows))
+
+	e.Stop()
+}
+
+func TestFetch(t *testing.T) {
+	ctx := model.WithScope(context.Background(), &model.Scope{All: true, Role: model.RoleAdmin})
+
+	var buf []model.Node
+	for _, item := range []string{"10.0.0.1", "10.0.0.2"} {
+		buf = append(buf, model.Node{Address: item, Labels: map[string]string{"role": "cfg"}, Project: 1})
+	}
+
+	_, err := model.ImportNode(ctx, buf, false)
+	assert.Equal(t, nil, err)
+
+	c := DefaultConfig()
+	c.Interval = 10 * time.Millisecond
+	c.Artifacts = artifacts{}
+	c.Fetcher = FetcherFunc(func(_ context.Context, host, _ string, fetch *Fetch) (string, error) {
+		if fetch.Url != "http://master/"+sum || fetch.Path != "/etc/app.conf" || fetch.Mode != "0600" {
+			return "", errors.New("invalid fetch")
+		}
+		if host == "10.0.0.2" {
+			return "", errors.New("checksum mismatch")
+		}
+		return "", nil
+	})
+
+	e := New(c)
+	err = e.Start(ctx)
+	assert.Equal(t, nil, err)
+
+	defer e.Stop()
+
+	spec, err := Parse([]byte(`
+name: config
+project: 1
+selector: role=cfg
+batch: {maxFailures: 1}
+steps:
+  - {name: fetch, type: fetch, artifact: ` + sum + `, path: /etc/app.conf, mode: "0600"}
+`))
+	assert.Equal(t, nil, err)
+
+	f, err := e.Submit(ctx, spec, "admin")
+	assert.Equal(t, nil, err)
+
+	s := wait(t, e, f.Id, StateSucceeded)
+
+	states := map[string]string{}
+	for _, item := range s.Steps {
+		states[item.State] = item.Error
+	}
+	assert.Equal(t, map[string]string{StateSucceeded: "", StateFailed: "checksum mismatch"}, states)
+
+	// Users fetch under their paths, without the setuid and setgid bits.
+	user := model.WithScope(ctx, &model.Scope{All: true, Role: model.RoleUser})
+	c.Paths[model.RoleUser] = []string{"/srv/app"}
+
+	for _, item := range []struct {
+		path string
+		mode string
+		err  error
+	}{
+		{"/etc/app.conf", "0600", model.ErrForbidden},
+		{"/srv/application", "0600", model.ErrForbidden},
+		{"/srv/app/bin", "4755", model.ErrForbidden},
+		{"/srv/app/app.conf", "0600", nil},
+	} {
+		spec.Steps[0].Path = item.path
+		spec.Steps[0].Mode = item.mode
+		_, err = e.Submit(user, spec, "john")
+		assert.Equal(t, true, errors.Is(err, item.err), item.path)
+	}
+
+	// Admins may set them.
+	admin := model.WithScope(ctx, &model.Scope{All: true, Role: model.RoleAdmin})
+	spec.Steps[0].Path = "/usr/local/bin/app"
+	spec.Steps[0].Mode = "4755"
+	_, err = e.Submit(admin, spec, "admin")
+	assert.Equal(t, nil, err)
+}
+
+type artifacts struct{}
+
+func (artifacts) URL(_ context.Context, _ uint, sum string) (string, error) {
+	return "http://master/" + sum, nil
+}
@@ -0,0 +1,224 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/craftslab/metalflow/labels"
+)
+
+const (
+	StepDispatch = "dispatch"
+	StepFetch    = "fetch"
+	StepHealth   = "health"
+
+	defaultTimeout = 10 * time.Minute
+
+	// setid is the setuid and setgid bits of a mode, which only admins may
+	// set.
+	setid = 06000
+)
+
+// Spec is a flow as written by operators. The steps form a DAG by their
+// needs, which is run on every target node, one batch of nodes after the
+// other.
+type Spec struct {
+	Batch    Batch      `json:"batch" yaml:"batch"`
+	Name     string     `json:"name" yaml:"name"`
+	Project  uint       `json:"project" yaml:"project"`
+	Selector string     `json:"selector" yaml:"selector"`
+	Steps    []StepSpec `json:"steps" yaml:"steps"`
+}
+
+// Batch is the number of nodes run at once, or all of them if zero, and the
+// number of failed nodes tolerated before the flow fails.
+type Batch struct {
+	MaxFailures int `json:"maxFailures" yaml:"maxFailures"`
+	Size        int `json:"size" yaml:"size"`
+}
+
+// StepSpec either dispatches Command to the node, has the node fetch the
+// artifact of SHA-256 Artifact to Path with Mode and Owner, or waits for the
+// node to be healthy, within Timeout.
+type StepSpec struct {
+	Artifact string        `json:"artifact,omitempty" yaml:"artifact"`
+	Command  string        `json:"command" yaml:"command"`
+	Mode     string        `json:"mode,omitempty" yaml:"mode" example:"0644"`
+	Name     string        `json:"name" yaml:"name"`
+	Needs    []string      `json:"needs" yaml:"needs"`
+	Owner    string        `json:"owner,omitempty" yaml:"owner" example:"root:root"`
+	Path     string        `json:"path,omitempty" yaml:"path"`
+	Timeout  time.Duration `json:"timeout" yaml:"timeout" swaggertype:"string" example:"10m"`
+	Type     string        `json:"type" yaml:"type"`
+}
+
+var (
+	sha256Pattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+)
+
+// Parse parses a spec in YAML, or JSON.
+func Parse(buf []byte) (*Spec, error) {
+	var s Spec
+
+	if err := yaml.Unmarshal(buf, &s); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal")
+	}
+
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+func (s *Spec) validate() error {
+	if s.Name == "" {
+		return errors.New("missing name")
+	}
+
+	if _, err := labels.Parse(s.Selector); err != nil {
+		return errors.Wrap(err, "invalid selector")
+	}
+
+	if s.Batch.Size < 0 || s.Batch.MaxFailures < 0 {
+		return errors.New("invalid batch")
+	}
+
+	if len(s.Steps) == 0 {
+		return errors.New("missing steps")
+	}
+
+	for i := range s.Steps {
+		st := &s.Steps[i]
+		if st.Name == "" {
+			return errors.Errorf("missing name of step %d", i+1)
+		}
+		switch st.Type {
+		case StepDispatch:
+			if st.Command == "" {
+				return errors.Errorf("missing command of step %q", st.Name)
+			}
+		case StepFetch:
+			if err := st.validateFetch(); err != nil {
+				return err
+			}
+		case StepHealth:
+		default:
+			return errors.Errorf("invalid type of step %q", st.Name)
+		}
+		if st.Timeout < 0 {
+			return errors.Errorf("invalid timeout of step %q", st.Name)
+		}
+		if st.Timeout == 0 {
+			st.Timeout = defaultTimeout
+		}
+	}
+
+	_, err := s.levels()
+
+	return err
+}
+
+func (st *StepSpec) validateFetch() error {
+	if !sha256Pattern.MatchString(st.Artifact) {
+		return errors.Errorf("invalid artifact of step %q", st.Name)
+	}
+
+	if !strings.HasPrefix(st.Path, "/") || path.Clean(st.Path) != st.Path {
+		return errors.Errorf("invalid path of step %q", st.Name)
+	}
+
+	if st.Mode != "" {
+		if m, err := strconv.ParseUint(st.Mode, 8, 32); err != nil || m > 07777 {
+			return errors.Errorf("invalid mode of step %q", st.Name)
+		}
+	}
+
+	if strings.ContainsAny(st.Owner, " \t\n/") {
+		return errors.Errorf("invalid owner of step %q", st.Name)
+	}
+
+	return nil
+}
+
+// within tells if p is one of prefixes or under one of them.
+func within(prefixes []string, p string) bool {
+	for _, item := range prefixes {
+		item = path.Clean(item)
+		if p == item || item == "/" || strings.HasPrefix(p, item+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// levels sorts the steps into levels, each of which only needs the steps of
+// the levels before it.
+func (s *Spec) levels() ([][]StepSpec, error) {
+	index := map[string]int{}
+
+	for i, st := range s.Steps {
+		if _, ok := index[st.Name]; ok {
+			return nil, errors.Errorf("duplicate step %q", st.Name)
+		}
+		index[st.Name] = i
+	}
+
+	done := make([]bool, len(s.Steps))
+
+	var buf [][]StepSpec
+
+	for n := 0; n < len(s.Steps); {
+		var next []int
+		for i, st := range s.Steps {
+			if done[i] {
+				continue
+			}
+			ready := true
+			for _, need := range st.Needs {
+				k, ok := index[need]
+				if !ok {
+					return nil, errors.Errorf("unknown step %q needed by %q", need, st.Name)
+				}
+				if !done[k] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				next = append(next, i)
+			}
+		}
+		if len(next) == 0 {
+			return nil, errors.New("cyclic needs")
+		}
+		l := make([]StepSpec, len(next))
+		for k, i := range next {
+			done[i] = true
+			l[k] = s.Steps[i]
+		}
+		buf = append(buf, l)
+		n += len(next)
+	}
+
+	return buf, nil
+}
@@ -0,0 +1,73 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	sum = "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+)
+
+func TestParse(t *testing.T) {
+	s, err := Parse([]byte(`
+name: upgrade
+selector: env=prod
+batch:
+  size: 2
+  maxFailures: 1
+steps:
+  - name: healthy
+    type: health
+    needs: [upgrade]
+  - name: drain
+    type: dispatch
+    command: systemctl stop app
+    timeout: 1m
+  - name: upgrade
+    type: dispatch
+    command: apt-get upgrade -y
+    needs: [drain]
+`))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, time.Minute, s.Steps[1].Timeout)
+	assert.Equal(t, defaultTimeout, s.Steps[0].Timeout)
+
+	_, err = Parse([]byte("name: a\nsteps:\n  - {name: b, type: fetch, artifact: " + sum + ", path: /etc/a, mode: '0644', owner: 'root:root'}\n"))
+	assert.Equal(t, nil, err)
+
+	levels, err := s.levels()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, len(levels))
+	assert.Equal(t, "drain", levels[0][0].Name)
+	assert.Equal(t, "healthy", levels[2][0].Name)
+
+	for _, item := range []string{
+		"name: a\nsteps:\n  - {name: b, type: dispatch}\n",
+		"name: a\nsteps:\n  - {name: b, type: reboot}\n",
+		"name: a\nsteps:\n  - {name: b, type: health, needs: [c]}\n",
+		"name: a\nsteps:\n  - {name: b, type: health, needs: [c]}\n  - {name: c, type: health, needs: [b]}\n",
+		"name: a\nselector: 'env in (a'\nsteps:\n  - {name: b, type: health}\n",
+		"name: a\nsteps:\n  - {name: b, type: fetch, artifact: abc, path: /etc/a}\n",
+		"name: a\nsteps:\n  - {name: b, type: fetch, artifact: " + sum + ", path: etc/a}\n",
+		"name: a\nsteps:\n  - {name: b, type: fetch, artifact: " + sum + ", path: /srv/../etc/a}\n",
+		"name: a\nsteps:\n  - {name: b, type: fetch, artifact: " + sum + ", path: /etc/a, mode: '0999'}\n",
+	} {
+		_, err = Parse([]byte(item))
+		assert.NotEqual(t, nil, err, item)
+	}
+}
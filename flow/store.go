@@ -0,0 +1,291 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"database/sql/driver"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/craftslab/metalflow/postgres"
+)
+
+// Store persists the flows and the state of their steps, from which the
+// engine resumes after a restart. GetFlow returns nil if not found.
+type Store interface {
+	AddFlow(ctx context.Context, flow *Flow) error
+	PutFlow(ctx context.Context, flow *Flow) error
+	GetFlow(ctx context.Context, id uint) (*Flow, error)
+	QueryFlow(ctx context.Context, states ...string) ([]Flow, error)
+	PutStep(ctx context.Context, step *Step) error
+	QueryStep(ctx context.Context, flow uint) ([]Step, error)
+}
+
+// Uints is stored as comma separated text.
+type Uints []uint
+
+type memoryStore struct {
+	flows map[uint]Flow
+	mutex sync.RWMutex
+	steps map[uint][]Step
+}
+
+type postgresStore struct {
+	postgres postgres.Postgres
+}
+
+func (Flow) TableName() string {
+	return "flows"
+}
+
+func (Step) TableName() string {
+	return "flow_steps"
+}
+
+func NewMemoryStore() Store {
+	return &memoryStore{
+		flows: map[uint]Flow{},
+		steps: map[uint][]Step{},
+	}
+}
+
+func NewPostgresStore(p postgres.Postgres) (Store, error) {
+	if err := p.Migrate(&Flow{}); err != nil {
+		return nil, errors.Wrap(err, "failed to migrate flow")
+	}
+
+	if err := p.Migrate(&Step{}); err != nil {
+		return nil, errors.Wrap(err, "failed to migrate step")
+	}
+
+	return &postgresStore{postgres: p}, nil
+}
+
+func (u Uints) Value() (driver.Value, error) {
+	buf := make([]string, len(u))
+
+	for i, v := range u {
+		buf[i] = strconv.FormatUint(uint64(v), 10)
+	}
+
+	return strings.Join(buf, ","), nil
+}
+
+func (u *Uints) Scan(src interface{}) error {
+	var s string
+
+	switch v := src.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	case nil:
+	default:
+		return errors.New("invalid type")
+	}
+
+	*u = Uints{}
+
+	for _, item := range strings.Split(s, ",") {
+		if item == "" {
+			continue
+		}
+		v, err := strconv.ParseUint(item, 10, 64)
+		if err != nil {
+			return errors.Wrap(err, "failed to parse")
+		}
+		*u = append(*u, uint(v))
+	}
+
+	return nil
+}
+
+func (m *memoryStore) AddFlow(_ context.Context, flow *Flow) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	flow.Id = uint(len(m.flows)) + 1
+	flow.CreatedAt = time.Now()
+	flow.UpdatedAt = flow.CreatedAt
+	m.flows[flow.Id] = *flow
+
+	return nil
+}
+
+func (m *memoryStore) PutFlow(_ context.Context, flow *Flow) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.flows[flow.Id]; !ok {
+		return errors.New("invalid id")
+	}
+
+	flow.UpdatedAt = time.Now()
+	m.flows[flow.Id] = *flow
+
+	return nil
+}
+
+func (m *memoryStore) GetFlow(_ context.Context, id uint) (*Flow, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	f, ok := m.flows[id]
+	if !ok {
+		return nil, nil
+	}
+
+	return &f, nil
+}
+
+func (m *memoryStore) QueryFlow(_ context.Context, states ...string) ([]Flow, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	buf := make([]Flow, 0)
+
+	for _, v := range m.flows {
+		if len(states) == 0 || contains(states, v.State) {
+			buf = append(buf, v)
+		}
+	}
+
+	sort.Slice(buf, func(i, j int) bool {
+		return buf[i].Id > buf[j].Id
+	})
+
+	return buf, nil
+}
+
+func (m *memoryStore) PutStep(_ context.Context, step *Step) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	buf := m.steps[step.FlowId]
+
+	for i := range buf {
+		if buf[i].Node == step.Node && buf[i].Name == step.Name {
+			buf[i] = *step
+			return nil
+		}
+	}
+
+	m.steps[step.FlowId] = append(buf, *step)
+
+	return nil
+}
+
+func (m *memoryStore) QueryStep(_ context.Context, flow uint) ([]Step, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	buf := make([]Step, len(m.steps[flow]))
+	copy(buf, m.steps[flow])
+
+	return buf, nil
+}
+
+func (p *postgresStore) AddFlow(ctx context.Context, flow *Flow) error {
+	p.postgres.WithContext(ctx).Create(flow)
+
+	if flow.Id == 0 {
+		return errors.New("failed to create")
+	}
+
+	return nil
+}
+
+func (p *postgresStore) PutFlow(ctx context.Context, flow *Flow) error {
+	var f Flow
+
+	p.postgres.WithContext(ctx).Raw(&f, `UPDATE flows SET batch = ?, state = ?, error = ?, updated_at = ?
+WHERE id = ? RETURNING id`, flow.Batch, flow.State, flow.Error, time.Now(), flow.Id)
+
+	if f.Id == 0 {
+		return errors.New("failed to put")
+	}
+
+	return nil
+}
+
+func (p *postgresStore) GetFlow(ctx context.Context, id uint) (*Flow, error) {
+	var f Flow
+
+	p.postgres.WithContext(ctx).Read(&f, "id = ?", id)
+
+	if f.Id == 0 {
+		return nil, nil
+	}
+
+	return &f, nil
+}
+
+func (p *postgresStore) QueryFlow(ctx context.Context, states ...string) ([]Flow, error) {
+	buf := make([]Flow, 0)
+	filter := &postgres.Filter{Order: "id desc"}
+
+	if len(states) != 0 {
+		filter.Cond = "state IN ?"
+		filter.Values = []interface{}{states}
+	}
+
+	p.postgres.WithContext(ctx).Query(&buf, filter)
+
+	return buf, nil
+}
+
+func (p *postgresStore) PutStep(ctx context.Context, step *Step) error {
+	var s Step
+
+	p.postgres.WithContext(ctx).Raw(&s, `INSERT INTO flow_steps (flow_id, node, name, state, output, error, started_at, finished_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (flow_id, node, name) DO UPDATE SET
+state = EXCLUDED.state, output = EXCLUDED.output, error = EXCLUDED.error,
+started_at = EXCLUDED.started_at, finished_at = EXCLUDED.finished_at
+RETURNING flow_id`, step.FlowId, step.Node, step.Name, step.State, step.Output, step.Error, step.StartedAt,
+		step.FinishedAt)
+
+	if s.FlowId == 0 {
+		return errors.New("failed to put")
+	}
+
+	return nil
+}
+
+func (p *postgresStore) QueryStep(ctx context.Context, flow uint) ([]Step, error) {
+	buf := make([]Step, 0)
+
+	p.postgres.WithContext(ctx).Query(&buf, &postgres.Filter{
+		Cond:   "flow_id = ?",
+		Values: []interface{}{flow},
+		Order:  "node, name",
+	})
+
+	return buf, nil
+}
+
+func contains(buf []string, s string) bool {
+	for _, item := range buf {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
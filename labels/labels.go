@@ -0,0 +1,259 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package labels
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	maxName   = 63
+	maxPrefix = 253
+)
+
+type Operator string
+
+const (
+	DoesNotExist Operator = "!"
+	Equals       Operator = "="
+	Exists       Operator = ""
+	In           Operator = "in"
+	NotEquals    Operator = "!="
+	NotIn        Operator = "notin"
+)
+
+// Requirement is a single term of a selector, e.g. env=prod, role in (db,cache)
+// or !deprecated.
+type Requirement struct {
+	Key      string
+	Operator Operator
+	Values   []string
+}
+
+// Selector matches the labels meeting all of its requirements, the same way
+// as Kubernetes does. The empty selector matches everything.
+type Selector []Requirement
+
+var (
+	name  = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9_.-]*[A-Za-z0-9])?$`)
+	set   = regexp.MustCompile(`^(\S+)\s+(in|notin)\s*\((.*)\)$`)
+	value = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9_.-]*[A-Za-z0-9])?)?$`)
+)
+
+// Parse parses a comma separated list of requirements.
+func Parse(s string) (Selector, error) {
+	buf := Selector{}
+
+	for _, item := range split(s) {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			if strings.TrimSpace(s) == "" {
+				break
+			}
+			return nil, errors.New("empty requirement")
+		}
+
+		r, err := parse(item)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid requirement %q", item)
+		}
+
+		buf = append(buf, r)
+	}
+
+	return buf, nil
+}
+
+// Validate checks that the keys and values of labels are well-formed.
+func Validate(labels map[string]string) error {
+	for k, v := range labels {
+		if err := validateKey(k); err != nil {
+			return errors.Wrapf(err, "invalid key %q", k)
+		}
+		if err := validateValue(v); err != nil {
+			return errors.Wrapf(err, "invalid value %q", v)
+		}
+	}
+
+	return nil
+}
+
+func (s Selector) Empty() bool {
+	return len(s) == 0
+}
+
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, r := range s {
+		if !r.Matches(labels) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s Selector) String() string {
+	buf := make([]string, len(s))
+
+	for i, r := range s {
+		buf[i] = r.String()
+	}
+
+	return strings.Join(buf, ",")
+}
+
+func (r Requirement) Matches(labels map[string]string) bool {
+	v, ok := labels[r.Key]
+
+	switch r.Operator {
+	case DoesNotExist:
+		return !ok
+	case Equals:
+		return ok && v == r.Values[0]
+	case Exists:
+		return ok
+	case In:
+		return ok && contains(r.Values, v)
+	case NotEquals:
+		return !ok || v != r.Values[0]
+	case NotIn:
+		return !ok || !contains(r.Values, v)
+	}
+
+	return false
+}
+
+func (r Requirement) String() string {
+	switch r.Operator {
+	case DoesNotExist:
+		return "!" + r.Key
+	case Exists:
+		return r.Key
+	case In, NotIn:
+		return r.Key + " " + string(r.Operator) + " (" + strings.Join(r.Values, ",") + ")"
+	}
+
+	return r.Key + string(r.Operator) + r.Values[0]
+}
+
+func parse(s string) (Requirement, error) {
+	if m := set.FindStringSubmatch(s); m != nil {
+		values := strings.Split(m[3], ",")
+		for i := range values {
+			values[i] = strings.TrimSpace(values[i])
+			if err := validateValue(values[i]); err != nil {
+				return Requirement{}, err
+			}
+		}
+		sort.Strings(values)
+		return Requirement{Key: m[1], Operator: Operator(m[2]), Values: values}, validateKey(m[1])
+	}
+
+	if strings.HasPrefix(s, "!") && !strings.Contains(s, "=") {
+		key := strings.TrimSpace(s[1:])
+		return Requirement{Key: key, Operator: DoesNotExist}, validateKey(key)
+	}
+
+	op := Operator("")
+	key, val := s, ""
+
+	for _, item := range []string{"!=", "==", "="} {
+		if i := strings.Index(s, item); i >= 0 {
+			op = Equals
+			if item == "!=" {
+				op = NotEquals
+			}
+			key, val = strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+len(item):])
+			break
+		}
+	}
+
+	if err := validateKey(key); err != nil {
+		return Requirement{}, err
+	}
+
+	if op == Exists {
+		return Requirement{Key: key, Operator: Exists}, nil
+	}
+
+	if err := validateValue(val); err != nil {
+		return Requirement{}, err
+	}
+
+	return Requirement{Key: key, Operator: op, Values: []string{val}}, nil
+}
+
+// split splits s at the commas outside of parentheses.
+func split(s string) []string {
+	var buf []string
+
+	depth, start := 0, 0
+
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				buf = append(buf, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(buf, s[start:])
+}
+
+// validateKey accepts an optional DNS subdomain prefix and a name, e.g.
+// metalflow.io/role.
+func validateKey(key string) error {
+	n := key
+
+	if i := strings.LastIndex(key, "/"); i >= 0 {
+		prefix := key[:i]
+		if prefix == "" || len(prefix) > maxPrefix || !name.MatchString(prefix) {
+			return errors.New("invalid prefix")
+		}
+		n = key[i+1:]
+	}
+
+	if n == "" || len(n) > maxName || !name.MatchString(n) {
+		return errors.New("invalid name")
+	}
+
+	return nil
+}
+
+func validateValue(v string) error {
+	if len(v) > maxName || !value.MatchString(v) {
+		return errors.New("invalid value")
+	}
+
+	return nil
+}
+
+func contains(values []string, v string) bool {
+	for _, item := range values {
+		if item == v {
+			return true
+		}
+	}
+
+	return false
+}
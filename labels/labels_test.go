@@ -0,0 +1,57 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package labels
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	s, err := Parse("env=prod, role in (db,cache),!deprecated")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, len(s))
+	assert.Equal(t, "env=prod,role in (cache,db),!deprecated", s.String())
+
+	assert.Equal(t, true, s.Matches(map[string]string{"env": "prod", "role": "db"}))
+	assert.Equal(t, false, s.Matches(map[string]string{"env": "prod", "role": "web"}))
+	assert.Equal(t, false, s.Matches(map[string]string{"env": "prod", "role": "db", "deprecated": ""}))
+	assert.Equal(t, false, s.Matches(map[string]string{"role": "cache"}))
+
+	s, err = Parse("metalflow.io/zone!=a,gpu,tier notin (1)")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, s.Matches(map[string]string{"gpu": "", "tier": "2"}))
+	assert.Equal(t, false, s.Matches(map[string]string{"gpu": "", "metalflow.io/zone": "a"}))
+	assert.Equal(t, false, s.Matches(map[string]string{}))
+
+	s, err = Parse("")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, s.Empty())
+	assert.Equal(t, true, s.Matches(nil))
+
+	for _, item := range []string{"env=", "=prod", "env=prod,", "role in (db", "-env", "env=a b"} {
+		_, err = Parse(item)
+		if item == "env=" {
+			assert.Equal(t, nil, err)
+		} else {
+			assert.NotEqual(t, nil, err, item)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert.Equal(t, nil, Validate(map[string]string{"env": "prod", "metalflow.io/role": ""}))
+	assert.NotEqual(t, nil, Validate(map[string]string{"/role": "db"}))
+	assert.NotEqual(t, nil, Validate(map[string]string{"env": "prod!"}))
+}
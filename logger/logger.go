@@ -0,0 +1,259 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+type Logger interface {
+	Debug(ctx context.Context, msg string, kv ...interface{})
+	Info(ctx context.Context, msg string, kv ...interface{})
+	Warn(ctx context.Context, msg string, kv ...interface{})
+	Error(ctx context.Context, msg string, kv ...interface{})
+	Enabled(level Level) bool
+}
+
+type Config struct {
+	Format string
+	Level  string
+	Output io.Writer
+}
+
+type logger struct {
+	format string
+	level  Level
+	mutex  sync.Mutex
+	output io.Writer
+}
+
+type requestIdKey struct{}
+
+var (
+	levels = []string{"debug", "info", "warn", "error"}
+
+	std Logger = &logger{
+		format: FormatText,
+		level:  LevelInfo,
+		output: os.Stderr,
+	}
+)
+
+func New(config *Config) (Logger, error) {
+	level, err := ParseLevel(config.Level)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse level")
+	}
+
+	if config.Format != FormatJSON && config.Format != FormatText {
+		return nil, errors.New("invalid format " + config.Format)
+	}
+
+	return &logger{
+		format: config.Format,
+		level:  level,
+		output: config.Output,
+	}, nil
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Format: FormatText,
+		Level:  levels[LevelInfo],
+		Output: os.Stderr,
+	}
+}
+
+// Init replaces the logger used by the package level functions.
+func Init(config *Config) error {
+	l, err := New(config)
+	if err != nil {
+		return errors.Wrap(err, "failed to new")
+	}
+
+	std = l
+
+	return nil
+}
+
+func ParseLevel(name string) (Level, error) {
+	for i := range levels {
+		if strings.EqualFold(name, levels[i]) {
+			return Level(i), nil
+		}
+	}
+
+	return LevelInfo, errors.New("invalid level " + name)
+}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return "unknown"
+	}
+
+	return levels[l]
+}
+
+// WithRequestId returns a copy of ctx carrying the request id, which is added
+// to every entry logged with it.
+func WithRequestId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, id)
+}
+
+func RequestId(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+
+	id, _ := ctx.Value(requestIdKey{}).(string)
+
+	return id
+}
+
+func Debug(ctx context.Context, msg string, kv ...interface{}) {
+	std.Debug(ctx, msg, kv...)
+}
+
+func Info(ctx context.Context, msg string, kv ...interface{}) {
+	std.Info(ctx, msg, kv...)
+}
+
+func Warn(ctx context.Context, msg string, kv ...interface{}) {
+	std.Warn(ctx, msg, kv...)
+}
+
+func Error(ctx context.Context, msg string, kv ...interface{}) {
+	std.Error(ctx, msg, kv...)
+}
+
+func Enabled(level Level) bool {
+	return std.Enabled(level)
+}
+
+func (l *logger) Debug(ctx context.Context, msg string, kv ...interface{}) {
+	l.log(ctx, LevelDebug, msg, kv)
+}
+
+func (l *logger) Info(ctx context.Context, msg string, kv ...interface{}) {
+	l.log(ctx, LevelInfo, msg, kv)
+}
+
+func (l *logger) Warn(ctx context.Context, msg string, kv ...interface{}) {
+	l.log(ctx, LevelWarn, msg, kv)
+}
+
+func (l *logger) Error(ctx context.Context, msg string, kv ...interface{}) {
+	l.log(ctx, LevelError, msg, kv)
+}
+
+func (l *logger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+func (l *logger) log(ctx context.Context, level Level, msg string, kv []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		kv = append([]interface{}{"traceId", sc.TraceID().String(), "spanId", sc.SpanID().String()}, kv...)
+	}
+
+	if id := RequestId(ctx); id != "" {
+		kv = append([]interface{}{"requestId", id}, kv...)
+	}
+
+	var buf bytes.Buffer
+
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+
+	if l.format == FormatJSON {
+		buf.WriteString(`{"time":` + quote(now) + `,"level":` + quote(level.String()) + `,"msg":` + quote(msg))
+		for i := 0; i < len(kv); i += 2 {
+			k, v := pair(kv, i)
+			b, err := json.Marshal(value(v))
+			if err != nil {
+				b = []byte(quote(fmt.Sprint(v)))
+			}
+			buf.WriteString("," + quote(k) + ":" + string(b))
+		}
+		buf.WriteString("}\n")
+	} else {
+		buf.WriteString(now + " " + strings.ToUpper(level.String()) + " " + msg)
+		for i := 0; i < len(kv); i += 2 {
+			k, v := pair(kv, i)
+			s := fmt.Sprint(value(v))
+			if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+				s = quote(s)
+			}
+			buf.WriteString(" " + k + "=" + s)
+		}
+		buf.WriteString("\n")
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	_, _ = l.output.Write(buf.Bytes())
+}
+
+func pair(kv []interface{}, i int) (key string, val interface{}) {
+	if i+1 >= len(kv) {
+		return "!BADKEY", kv[i]
+	}
+
+	return fmt.Sprint(kv[i]), kv[i+1]
+}
+
+func value(v interface{}) interface{} {
+	switch t := v.(type) {
+	case error:
+		return t.Error()
+	case time.Duration:
+		return t.String()
+	case fmt.Stringer:
+		return t.String()
+	}
+
+	return v
+}
+
+func quote(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
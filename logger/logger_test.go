@@ -0,0 +1,67 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	_, err := New(&Config{Format: "xml", Level: "info"})
+	assert.NotEqual(t, nil, err)
+
+	_, err = New(&Config{Format: FormatJSON, Level: "trace"})
+	assert.NotEqual(t, nil, err)
+
+	l, err := New(DefaultConfig())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, l.Enabled(LevelDebug))
+	assert.Equal(t, true, l.Enabled(LevelWarn))
+}
+
+func TestJSON(t *testing.T) {
+	var buf bytes.Buffer
+
+	l, _ := New(&Config{Format: FormatJSON, Level: "debug", Output: &buf})
+	ctx := WithRequestId(context.Background(), "1234")
+
+	l.Info(ctx, "hello", "id", 1, "error", errors.New("failed"))
+
+	var m map[string]interface{}
+	err := json.Unmarshal(buf.Bytes(), &m)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "info", m["level"])
+	assert.Equal(t, "hello", m["msg"])
+	assert.Equal(t, "1234", m["requestId"])
+	assert.Equal(t, float64(1), m["id"])
+	assert.Equal(t, "failed", m["error"])
+}
+
+func TestText(t *testing.T) {
+	var buf bytes.Buffer
+
+	l, _ := New(&Config{Format: FormatText, Level: "warn", Output: &buf})
+
+	l.Info(context.Background(), "skipped")
+	assert.Equal(t, 0, buf.Len())
+
+	l.Warn(context.Background(), "hello", "path", "/nodes", "msg", "a b")
+	assert.Equal(t, true, strings.HasSuffix(buf.String(), ` WARN hello path=/nodes msg="a b"`+"\n"))
+}
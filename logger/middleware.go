@@ -0,0 +1,101 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logger
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	RequestIdHeader = "X-Request-ID"
+
+	maxRequestId = 128
+)
+
+// RequestIdMiddleware honors the X-Request-ID header of incoming requests, or
+// generates one, and sets it on the response and the request context.
+func RequestIdMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id := ctx.GetHeader(RequestIdHeader)
+		if !validRequestId(id) {
+			id = newRequestId()
+		}
+
+		ctx.Header(RequestIdHeader, id)
+		ctx.Request = ctx.Request.WithContext(WithRequestId(ctx.Request.Context(), id))
+
+		ctx.Next()
+	}
+}
+
+// AccessLog logs every request once handled, including the user returned by
+// identity.
+func AccessLog(identity func(*gin.Context) string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+		path := ctx.Request.URL.Path
+
+		ctx.Next()
+
+		status := ctx.Writer.Status()
+
+		kv := []interface{}{
+			"method", ctx.Request.Method,
+			"path", path,
+			"status", status,
+			"latency", time.Since(start),
+			"clientIp", ctx.ClientIP(),
+			"user", identity(ctx),
+			"size", ctx.Writer.Size(),
+		}
+
+		if len(ctx.Errors) != 0 {
+			kv = append(kv, "errors", ctx.Errors.String())
+		}
+
+		switch {
+		case status >= http.StatusInternalServerError:
+			Error(ctx.Request.Context(), "request", kv...)
+		case status >= http.StatusBadRequest:
+			Warn(ctx.Request.Context(), "request", kv...)
+		default:
+			Info(ctx.Request.Context(), "request", kv...)
+		}
+	}
+}
+
+func validRequestId(id string) bool {
+	if id == "" || len(id) > maxRequestId {
+		return false
+	}
+
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newRequestId() string {
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+
+	return hex.EncodeToString(buf)
+}
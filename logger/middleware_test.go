@@ -0,0 +1,60 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logger
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+
+	gin.SetMode(gin.TestMode)
+
+	_ = Init(&Config{Format: FormatText, Level: "info", Output: &buf})
+	defer func() {
+		_ = Init(DefaultConfig())
+	}()
+
+	var id string
+
+	r := gin.New()
+	r.Use(RequestIdMiddleware(), AccessLog(func(*gin.Context) string { return "admin" }))
+	r.GET("/", func(ctx *gin.Context) {
+		id = RequestId(ctx.Request.Context())
+		ctx.Status(http.StatusOK)
+	})
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set(RequestIdHeader, "abcd")
+	r.ServeHTTP(rec, req)
+	assert.Equal(t, "abcd", id)
+	assert.Equal(t, "abcd", rec.Header().Get(RequestIdHeader))
+	assert.Equal(t, true, strings.Contains(buf.String(), "requestId=abcd method=GET path=/ status=200"))
+	assert.Equal(t, true, strings.Contains(buf.String(), "user=admin"))
+
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/", nil)
+	req.Header.Set(RequestIdHeader, "bad id")
+	r.ServeHTTP(rec, req)
+	assert.Equal(t, 32, len(id))
+	assert.Equal(t, id, rec.Header().Get(RequestIdHeader))
+}
@@ -31,7 +31,7 @@ import (
 // @license.url http://www.apache.org/licenses/LICENSE-2.0.html
 
 // @host localhost:9080
-// @BasePath /
+// @BasePath /api/v1
 // @query.collection.format multi
 
 // @securityDefinitions.basic BasicAuth
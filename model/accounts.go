@@ -13,19 +13,41 @@
 package model
 
 import (
+	"context"
+	"net/mail"
+	"net/url"
+	"sync"
+
 	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/craftslab/metalflow/logger"
+	"github.com/craftslab/metalflow/tracing"
+	"github.com/craftslab/metalflow/util"
 )
 
+// Account of a user. Password is the bcrypt hash of the password, which is
+// never served, or empty if the user can only log in through single sign-on
+// as Subject at Issuer.
 type Account struct {
 	Avatar      string `json:"avatar"`
 	Displayname string `json:"displayname"`
 	Email       string `json:"email"`
 	Id          uint   `json:"id"`
+	Issuer      string `json:"issuer,omitempty"`
 	Name        string `json:"name"`
-	Password    string `json:"password"`
+	Password    string `json:"-"`
+	Role        string `json:"role"`
+	Subject     string `json:"subject,omitempty"`
 	Username    string `json:"username"`
+	Version     uint   `json:"version"`
 }
 
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
 var accounts = []Account{
 	{
 		Avatar:      "https://user-images.githubusercontent.com/334891/29999089-2837c968-9009-11e7-92c1-6a7540a594d5.png",
@@ -33,8 +55,10 @@ var accounts = []Account{
 		Email:       "",
 		Id:          0,
 		Name:        "Administrator",
-		Password:    "admin",
+		Password:    "$2a$10$YQANEpkTKXc1SnvC8spOfOmZjbJ7cI.d1Z5IJN7tUqyzqYpxV1zwe",
+		Role:        RoleAdmin,
 		Username:    "admin",
+		Version:     1,
 	},
 	{
 		Avatar:      "https://user-images.githubusercontent.com/334891/29999089-2837c968-9009-11e7-92c1-6a7540a594d5.png",
@@ -42,21 +66,33 @@ var accounts = []Account{
 		Email:       "john.doe@example.com",
 		Id:          1,
 		Name:        "John Doe",
-		Password:    "john",
+		Password:    "$2a$10$mq7V3//CT4Tv2tgYZlGznOhqGNS1NXY5T52F.UivKciOl8z0VLEd.",
+		Role:        RoleUser,
 		Username:    "john",
+		Version:     1,
 	},
 }
 
-var selfId uint = 0
+var accountMutex sync.RWMutex
+
+var (
+	ErrAccountNotFound = errors.New("invalid id")
+)
+
+func GetAccount(ctx context.Context, id uint) (Account, error) {
+	_, span := tracing.Start(ctx, "model.GetAccount")
+	defer span.End()
+
+	accountMutex.RLock()
+	defer accountMutex.RUnlock()
 
-func GetAccount(id uint) (Account, error) {
 	var a Account
 	var f bool
 
 	f = false
 
 	for _, v := range accounts {
-		if id == v.Id {
+		if id == v.Id && ScopeOf(ctx).canSee(v.Username) {
 			a = v
 			f = true
 			break
@@ -64,34 +100,41 @@ func GetAccount(id uint) (Account, error) {
 	}
 
 	if !f {
-		return Account{}, errors.New("invalid id")
+		logger.Debug(ctx, "account not found", "id", id)
+		return Account{}, ErrAccountNotFound
 	}
 
 	return a, nil
 }
 
-func GetSelfAccount() (Account, error) {
-	var a Account
-	var f bool
+// GetSelfAccount returns the account of the user of the scope.
+func GetSelfAccount(ctx context.Context) (Account, error) {
+	_, span := tracing.Start(ctx, "model.GetSelfAccount")
+	defer span.End()
 
-	f = false
+	accountMutex.RLock()
+	defer accountMutex.RUnlock()
 
-	for _, v := range accounts {
-		if selfId == v.Id {
-			a = v
-			f = true
-			break
+	if s := ScopeOf(ctx); s != nil && s.Username != "" {
+		for _, v := range accounts {
+			if s.Username == v.Username {
+				return v, nil
+			}
 		}
 	}
 
-	if !f {
-		return Account{}, errors.New("invalid id")
-	}
+	logger.Debug(ctx, "self account not found")
 
-	return a, nil
+	return Account{}, ErrAccountNotFound
 }
 
-func QueryAccount(q string) (Account, error) {
+func QueryAccount(ctx context.Context, q string) (Account, error) {
+	_, span := tracing.Start(ctx, "model.QueryAccount")
+	defer span.End()
+
+	accountMutex.RLock()
+	defer accountMutex.RUnlock()
+
 	if q == "" {
 		return Account{}, errors.New("invalid query")
 	}
@@ -99,7 +142,7 @@ func QueryAccount(q string) (Account, error) {
 	var buf Account
 
 	for k, v := range accounts {
-		if q == v.Username {
+		if q == v.Username && ScopeOf(ctx).canSee(v.Username) {
 			buf = accounts[k]
 			break
 		}
@@ -107,3 +150,169 @@ func QueryAccount(q string) (Account, error) {
 
 	return buf, nil
 }
+
+// QuerySubjectAccount returns the account bound to subject at issuer, or an
+// empty one.
+func QuerySubjectAccount(ctx context.Context, issuer, subject string) (Account, error) {
+	_, span := tracing.Start(ctx, "model.QuerySubjectAccount")
+	defer span.End()
+
+	accountMutex.RLock()
+	defer accountMutex.RUnlock()
+
+	if issuer == "" || subject == "" {
+		return Account{}, errors.New("invalid subject")
+	}
+
+	for _, v := range accounts {
+		if issuer == v.Issuer && subject == v.Subject && ScopeOf(ctx).canSee(v.Username) {
+			return v, nil
+		}
+	}
+
+	return Account{}, nil
+}
+
+// LinkAccount binds the account of username to subject at issuer. Only the
+// accounts of users which can only log in through single sign-on and are not
+// bound yet may be, and never those of admins.
+func LinkAccount(ctx context.Context, username, issuer, subject string) (Account, error) {
+	_, span := tracing.Start(ctx, "model.LinkAccount")
+	defer span.End()
+
+	accountMutex.Lock()
+	defer accountMutex.Unlock()
+
+	if s := ScopeOf(ctx); s == nil || !s.All {
+		return Account{}, ErrForbidden
+	}
+
+	if issuer == "" || subject == "" {
+		return Account{}, errors.New("invalid subject")
+	}
+
+	for k, v := range accounts {
+		if username != v.Username {
+			continue
+		}
+		if v.Password != "" || v.Subject != "" || v.Role == RoleAdmin {
+			return Account{}, ErrForbidden
+		}
+		accounts[k].Issuer = issuer
+		accounts[k].Subject = subject
+		accounts[k].Version++
+		return accounts[k], nil
+	}
+
+	return Account{}, ErrAccountNotFound
+}
+
+// AddAccount adds an account without password, which can only log in through
+// single sign-on, e.g. OIDC.
+func AddAccount(ctx context.Context, a Account) (Account, error) {
+	_, span := tracing.Start(ctx, "model.AddAccount")
+	defer span.End()
+
+	accountMutex.Lock()
+	defer accountMutex.Unlock()
+
+	if a.Username == "" {
+		return Account{}, errors.New("invalid username")
+	}
+
+	for _, v := range accounts {
+		if a.Username == v.Username {
+			return Account{}, errors.New("duplicate username")
+		}
+		if v.Id >= a.Id {
+			a.Id = v.Id + 1
+		}
+	}
+
+	a.Password = ""
+	a.Version = 1
+	accounts = append(accounts, a)
+
+	return a, nil
+}
+
+// CheckPassword tells if password matches the hash of the account, which has
+// none if it can only log in through single sign-on.
+func (a *Account) CheckPassword(password string) bool {
+	if a.Password == "" || password == "" {
+		return false
+	}
+
+	return bcrypt.CompareHashAndPassword([]byte(a.Password), []byte(password)) == nil
+}
+
+// AccountFields are the profile fields of an account.
+type AccountFields struct {
+	Avatar      string `json:"avatar"`
+	Displayname string `json:"displayname"`
+	Email       string `json:"email"`
+}
+
+// PatchAccount lets patch change the profile of the account, which only its
+// owner and admins may do. The profile is validated before it is applied.
+func PatchAccount(ctx context.Context, id uint, patch func(*AccountFields) error) (Account, error) {
+	_, span := tracing.Start(ctx, "model.PatchAccount")
+	defer span.End()
+
+	accountMutex.Lock()
+	defer accountMutex.Unlock()
+
+	s := ScopeOf(ctx)
+
+	for k, v := range accounts {
+		if id != v.Id || !s.canSee(v.Username) {
+			continue
+		}
+		if !s.All && s.Username != v.Username {
+			return Account{}, ErrForbidden
+		}
+		if err := checkVersion(ctx, v.Version); err != nil {
+			return Account{}, err
+		}
+		f := AccountFields{
+			Avatar:      v.Avatar,
+			Displayname: v.Displayname,
+			Email:       v.Email,
+		}
+		if err := patch(&f); err != nil {
+			return Account{}, err
+		}
+		if err := f.validate(); err != nil {
+			return Account{}, err
+		}
+		accounts[k].Avatar = f.Avatar
+		accounts[k].Displayname = f.Displayname
+		accounts[k].Email = f.Email
+		accounts[k].Version++
+		return accounts[k], nil
+	}
+
+	logger.Debug(ctx, "account not found", "id", id)
+
+	return Account{}, ErrAccountNotFound
+}
+
+func (f *AccountFields) validate() error {
+	if f.Displayname == "" || len(f.Displayname) > maxName {
+		return util.Invalid("displayname", errors.New("invalid displayname"))
+	}
+
+	if f.Email != "" {
+		if a, err := mail.ParseAddress(f.Email); err != nil || a.Address != f.Email {
+			return util.Invalid("email", errors.New("invalid email"))
+		}
+	}
+
+	if f.Avatar != "" {
+		if u, err := url.Parse(f.Avatar); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return util.Invalid("avatar", errors.New("invalid avatar"))
+		}
+	}
+
+	return nil
+}
@@ -13,25 +13,103 @@
 package model
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func TestGetAccount(t *testing.T) {
-	_, err := GetAccount(1)
+	ctx := WithScope(context.Background(), &Scope{All: true})
+
+	_, err := GetAccount(ctx, 1)
 	assert.Equal(t, nil, err)
 }
 
 func TestGetSelfAccount(t *testing.T) {
-	_, err := GetSelfAccount()
+	_, err := GetSelfAccount(context.Background())
+	assert.Equal(t, ErrAccountNotFound, err)
+
+	a, err := GetSelfAccount(WithScope(context.Background(), &Scope{Username: "john"}))
 	assert.Equal(t, nil, err)
+	assert.Equal(t, uint(1), a.Id)
+	assert.Equal(t, true, a.CheckPassword("john"))
+	assert.Equal(t, false, a.CheckPassword("admin"))
 }
 
 func TestQueryAccount(t *testing.T) {
-	_, err := QueryAccount("")
+	ctx := WithScope(context.Background(), &Scope{All: true})
+
+	_, err := QueryAccount(ctx, "")
+	assert.NotEqual(t, nil, err)
+
+	_, err = QueryAccount(ctx, "admin")
+	assert.Equal(t, nil, err)
+}
+
+func TestAddAccount(t *testing.T) {
+	ctx := WithScope(context.Background(), &Scope{All: true})
+
+	_, err := AddAccount(ctx, Account{Username: "admin"})
+	assert.NotEqual(t, nil, err)
+
+	a, err := AddAccount(ctx, Account{Password: "jane", Role: RoleUser, Username: "jane"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint(2), a.Id)
+	assert.Equal(t, "", a.Password)
+
+	b, err := QueryAccount(ctx, "jane")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, a, b)
+}
+
+func TestLinkAccount(t *testing.T) {
+	ctx := WithScope(context.Background(), &Scope{All: true})
+
+	_, err := LinkAccount(ctx, "admin", "https://idp", "admin")
+	assert.Equal(t, ErrForbidden, err)
+
+	_, err = AddAccount(ctx, Account{Role: RoleUser, Username: "kate"})
+	assert.Equal(t, nil, err)
+
+	_, err = LinkAccount(WithScope(context.Background(), &Scope{Username: "kate"}), "kate", "https://idp", "kate")
+	assert.Equal(t, ErrForbidden, err)
+
+	a, err := LinkAccount(ctx, "kate", "https://idp", "kate")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "kate", a.Subject)
+
+	_, err = LinkAccount(ctx, "kate", "https://idp", "mallory")
+	assert.Equal(t, ErrForbidden, err)
+
+	b, err := QuerySubjectAccount(ctx, "https://idp", "kate")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, a, b)
+
+	b, err = QuerySubjectAccount(ctx, "https://other", "kate")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "", b.Username)
+}
+
+func TestPatchAccount(t *testing.T) {
+	ctx := WithScope(context.Background(), &Scope{Projects: map[uint]string{1: ProjectRoleViewer}, Username: "jane"})
+
+	_, err := PatchAccount(ctx, 1, func(f *AccountFields) error { return nil })
+	assert.Equal(t, ErrForbidden, err)
+
+	ctx = WithScope(context.Background(), &Scope{Username: "john"})
+
+	_, err = PatchAccount(ctx, 1, func(f *AccountFields) error {
+		f.Avatar = "ftp://example.com/john.png"
+		return nil
+	})
 	assert.NotEqual(t, nil, err)
 
-	_, err = QueryAccount("admin")
+	a, err := PatchAccount(ctx, 1, func(f *AccountFields) error {
+		f.Displayname = "John"
+		return nil
+	})
 	assert.Equal(t, nil, err)
+	assert.Equal(t, "John", a.Displayname)
+	assert.Equal(t, uint(2), a.Version)
 }
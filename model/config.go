@@ -13,6 +13,8 @@
 package model
 
 import (
+	"context"
+
 	"github.com/craftslab/metalflow/config"
 )
 
@@ -20,6 +22,6 @@ var (
 	Version = config.Version + "-build-" + config.Build
 )
 
-func ServerVersion() (string, error) {
+func ServerVersion(_ context.Context) (string, error) {
 	return Version, nil
 }
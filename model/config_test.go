@@ -13,12 +13,13 @@
 package model
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func TestServerVersion(t *testing.T) {
-	_, err := ServerVersion()
+	_, err := ServerVersion(context.Background())
 	assert.Equal(t, nil, err)
 }
@@ -0,0 +1,182 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"context"
+	"net"
+	"regexp"
+
+	"github.com/pkg/errors"
+
+	"github.com/craftslab/metalflow/labels"
+	"github.com/craftslab/metalflow/tracing"
+)
+
+const (
+	ImportCreated = "created"
+	ImportFailed  = "failed"
+	ImportUpdated = "updated"
+)
+
+// ImportRow is the outcome of a row, numbered from 1.
+type ImportRow struct {
+	Action  string `json:"action"`
+	Address string `json:"address"`
+	Error   string `json:"error,omitempty"`
+	Id      uint   `json:"id"`
+	Row     int    `json:"row"`
+}
+
+type ImportReport struct {
+	Created int         `json:"created"`
+	DryRun  bool        `json:"dryRun"`
+	Failed  int         `json:"failed"`
+	Rows    []ImportRow `json:"rows"`
+	Updated int         `json:"updated"`
+}
+
+var hostname = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?)*$`)
+
+// ImportNode creates the nodes of new addresses and updates the inventory and
+// labels of the others. Nothing is applied if any row fails or on dry run.
+func ImportNode(ctx context.Context, buf []Node, dryRun bool) (ImportReport, error) {
+	_, span := tracing.Start(ctx, "model.ImportNode")
+	defer span.End()
+
+	projectMutex.RLock()
+	defer projectMutex.RUnlock()
+
+	nodeMutex.Lock()
+	defer nodeMutex.Unlock()
+
+	report := ImportReport{DryRun: dryRun, Rows: make([]ImportRow, 0, len(buf))}
+
+	index := map[string]int{}
+	for k, v := range nodes {
+		index[v.Address] = k
+	}
+
+	var next uint
+	for _, v := range nodes {
+		if v.Id >= next {
+			next = v.Id + 1
+		}
+	}
+
+	seen := map[string]int{}
+	updates := make([]Node, 0, len(buf))
+
+	for i, n := range buf {
+		row := ImportRow{Action: ImportCreated, Address: n.Address, Row: i + 1}
+
+		k, found := index[n.Address]
+
+		if err := validateNode(ctx, n, seen); err != nil {
+			row.Action = ImportFailed
+			row.Error = err.Error()
+		} else if found && !ScopeOf(ctx).CanWrite(nodes[k].Project) {
+			row.Action = ImportFailed
+			row.Error = ErrForbidden.Error()
+		}
+
+		seen[n.Address] = i + 1
+
+		switch {
+		case row.Action == ImportFailed:
+			report.Failed++
+		case found:
+			row.Action = ImportUpdated
+			row.Id = nodes[k].Id
+			report.Updated++
+		default:
+			row.Id = next
+			next++
+			report.Created++
+		}
+
+		n.Id = row.Id
+		updates = append(updates, n)
+		report.Rows = append(report.Rows, row)
+	}
+
+	if dryRun || report.Failed > 0 {
+		return report, nil
+	}
+
+	for _, n := range updates {
+		if k, found := index[n.Address]; found {
+			nodes[k].Asset = n.Asset
+			nodes[k].Comments = n.Comments
+			nodes[k].Info = n.Info
+			nodes[k].Labels = clone(n).Labels
+			nodes[k].Project = n.Project
+			nodes[k].Region = n.Region
+			nodes[k].Version++
+		} else {
+			nodes = append(nodes, Node{
+				Address:   n.Address,
+				Asset:     n.Asset,
+				Comments:  n.Comments,
+				Id:        n.Id,
+				Info:      n.Info,
+				Labels:    clone(n).Labels,
+				Lifecycle: LifecycleActive,
+				Project:   n.Project,
+				Region:    n.Region,
+				Version:   1,
+			})
+		}
+	}
+
+	return report, nil
+}
+
+// validateNode checks a row, given the rows seen before it by address. The
+// caller holds projectMutex.
+func validateNode(ctx context.Context, n Node, seen map[string]int) error {
+	if n.Address == "" {
+		return errors.New("missing address")
+	}
+
+	if net.ParseIP(n.Address) == nil && !hostname.MatchString(n.Address) {
+		return errors.New("invalid address")
+	}
+
+	if row, ok := seen[n.Address]; ok {
+		return errors.Errorf("duplicate address of row %d", row)
+	}
+
+	if err := labels.Validate(n.Labels); err != nil {
+		return err
+	}
+
+	found := false
+
+	for _, v := range projects {
+		if n.Project == v.Id {
+			found = true
+			break
+		}
+	}
+
+	if !found || !ScopeOf(ctx).CanRead(n.Project) {
+		return errors.New("invalid project")
+	}
+
+	if !ScopeOf(ctx).CanWrite(n.Project) {
+		return ErrForbidden
+	}
+
+	return nil
+}
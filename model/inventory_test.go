@@ -0,0 +1,65 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportNode(t *testing.T) {
+	ctx := WithScope(context.Background(), &Scope{All: true})
+
+	buf := []Node{
+		{Address: "127.0.0.2", Labels: map[string]string{"env": "prod", "role": "cache"}, Project: 1, Region: "Beijing"},
+		{Address: "10.0.0.1", Labels: map[string]string{"env": "prod", "role": "db"}},
+		{Address: "10.0.0.1"},
+		{Address: "bad address"},
+		{Address: "10.0.0.2", Project: 9},
+	}
+
+	report, err := ImportNode(ctx, buf, false)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, report.Created)
+	assert.Equal(t, 1, report.Updated)
+	assert.Equal(t, 3, report.Failed)
+	assert.Equal(t, "duplicate address of row 2", report.Rows[2].Error)
+
+	n, _ := GetNode(ctx, 1)
+	assert.Equal(t, "Xian", n.Region)
+
+	report, err = ImportNode(ctx, buf[:2], true)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, report.Failed)
+
+	n, _ = GetNode(ctx, 1)
+	assert.Equal(t, "Xian", n.Region)
+
+	report, err = ImportNode(ctx, buf[:2], false)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, ImportUpdated, report.Rows[0].Action)
+	assert.Equal(t, ImportCreated, report.Rows[1].Action)
+
+	n, _ = GetNode(ctx, 1)
+	assert.Equal(t, "Beijing", n.Region)
+
+	n, err = GetNode(ctx, report.Rows[1].Id)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "prod", n.Labels["env"])
+
+	ctx = WithScope(ctx, &Scope{Projects: map[uint]string{1: ProjectRoleViewer}})
+	report, _ = ImportNode(ctx, []Node{{Address: "10.0.0.3", Project: 1}}, false)
+	assert.Equal(t, ErrForbidden.Error(), report.Rows[0].Error)
+}
@@ -0,0 +1,199 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/craftslab/metalflow/tracing"
+	"github.com/craftslab/metalflow/util"
+)
+
+const (
+	LifecycleActive         = "active"
+	LifecycleDecommissioned = "decommissioned"
+	LifecycleDraining       = "draining"
+	LifecycleMaintenance    = "maintenance"
+	LifecycleProvisioning   = "provisioning"
+	LifecycleRetired        = "retired"
+
+	system = "system"
+)
+
+// Maintenance is the reason a node is in maintenance, which it leaves at Until
+// unless it is zero.
+type Maintenance struct {
+	Reason string    `json:"reason"`
+	Until  time.Time `json:"until"`
+}
+
+// Transition is a change of the lifecycle of a node.
+type Transition struct {
+	Actor  string    `json:"actor"`
+	At     time.Time `json:"at"`
+	From   string    `json:"from"`
+	Node   uint      `json:"node"`
+	Reason string    `json:"reason"`
+	To     string    `json:"to"`
+}
+
+// transitions are the states a node may go to from each state.
+var transitions = map[string][]string{
+	LifecycleProvisioning:   {LifecycleActive, LifecycleDecommissioned},
+	LifecycleActive:         {LifecycleMaintenance, LifecycleDraining, LifecycleDecommissioned},
+	LifecycleMaintenance:    {LifecycleActive, LifecycleDraining, LifecycleDecommissioned},
+	LifecycleDraining:       {LifecycleActive, LifecycleMaintenance, LifecycleDecommissioned},
+	LifecycleDecommissioned: {LifecycleProvisioning, LifecycleRetired},
+	LifecycleRetired:        {},
+}
+
+// history is guarded by nodeMutex.
+var history = map[uint][]Transition{}
+
+var (
+	ErrTransition = errors.New("invalid transition")
+)
+
+// Dispatchable tells whether commands may be dispatched to the node, which
+// only active nodes are.
+func (n Node) Dispatchable() bool {
+	return n.Lifecycle == LifecycleActive
+}
+
+func CanTransition(from, to string) bool {
+	for _, item := range transitions[from] {
+		if item == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetLifecycle moves the node to state to, with the reason recorded in its
+// history.
+func SetLifecycle(ctx context.Context, id uint, to, reason string) (Node, error) {
+	_, span := tracing.Start(ctx, "model.SetLifecycle")
+	defer span.End()
+
+	if _, ok := transitions[to]; !ok {
+		return Node{}, util.Invalid("state", errors.New("invalid lifecycle "+to))
+	}
+
+	if to == LifecycleMaintenance {
+		return EnterMaintenance(ctx, id, reason, time.Time{})
+	}
+
+	return updateNode(ctx, id, func(n *Node) error {
+		return transit(ctx, n, to, reason)
+	})
+}
+
+// EnterMaintenance suspends the dispatch to the node until ExitMaintenance,
+// or until if it is not zero.
+func EnterMaintenance(ctx context.Context, id uint, reason string, until time.Time) (Node, error) {
+	_, span := tracing.Start(ctx, "model.EnterMaintenance")
+	defer span.End()
+
+	if reason == "" {
+		return Node{}, util.Invalid("reason", errors.New("missing reason"))
+	}
+
+	if !until.IsZero() && !until.After(time.Now()) {
+		return Node{}, util.Invalid("until", errors.New("invalid until"))
+	}
+
+	return updateNode(ctx, id, func(n *Node) error {
+		if err := transit(ctx, n, LifecycleMaintenance, reason); err != nil {
+			return err
+		}
+		n.Maintenance = &Maintenance{Reason: reason, Until: until}
+		return nil
+	})
+}
+
+func ExitMaintenance(ctx context.Context, id uint) (Node, error) {
+	_, span := tracing.Start(ctx, "model.ExitMaintenance")
+	defer span.End()
+
+	return updateNode(ctx, id, func(n *Node) error {
+		if n.Lifecycle != LifecycleMaintenance {
+			return ErrTransition
+		}
+		return transit(ctx, n, LifecycleActive, "maintenance exited")
+	})
+}
+
+// QueryTransition returns the history of the node, oldest first.
+func QueryTransition(ctx context.Context, id uint) ([]Transition, error) {
+	_, span := tracing.Start(ctx, "model.QueryTransition")
+	defer span.End()
+
+	if _, err := findNode(ctx, id); err != nil {
+		return nil, err
+	}
+
+	nodeMutex.RLock()
+	defer nodeMutex.RUnlock()
+
+	buf := make([]Transition, len(history[id]))
+	copy(buf, history[id])
+
+	return buf, nil
+}
+
+// transit moves n to state to, if allowed. The caller holds nodeMutex.
+func transit(ctx context.Context, n *Node, to, reason string) error {
+	if !CanTransition(n.Lifecycle, to) {
+		return ErrTransition
+	}
+
+	actor := system
+	if s := ScopeOf(ctx); s != nil && s.Username != "" {
+		actor = s.Username
+	}
+
+	history[n.Id] = append(history[n.Id], Transition{
+		Actor:  actor,
+		At:     time.Now(),
+		From:   n.Lifecycle,
+		Node:   n.Id,
+		Reason: reason,
+		To:     to,
+	})
+
+	n.Lifecycle = to
+	n.Maintenance = nil
+
+	return nil
+}
+
+// expireMaintenance moves the nodes whose maintenance has expired back to
+// active.
+func expireMaintenance() {
+	now := time.Now()
+
+	nodeMutex.Lock()
+	defer nodeMutex.Unlock()
+
+	for k := range nodes {
+		m := nodes[k].Maintenance
+		if m != nil && !m.Until.IsZero() && now.After(m.Until) {
+			_ = transit(context.Background(), &nodes[k], LifecycleActive, "maintenance expired")
+			nodes[k].Version++
+		}
+	}
+}
@@ -0,0 +1,59 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLifecycle(t *testing.T) {
+	ctx := WithScope(context.Background(), &Scope{All: true, Username: "admin"})
+
+	_, err := SetLifecycle(ctx, 0, LifecycleRetired, "")
+	assert.Equal(t, ErrTransition, err)
+
+	_, err = EnterMaintenance(ctx, 0, "", time.Time{})
+	assert.NotEqual(t, nil, err)
+
+	n, err := EnterMaintenance(ctx, 0, "kernel upgrade", time.Now().Add(50*time.Millisecond))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, LifecycleMaintenance, n.Lifecycle)
+	assert.Equal(t, false, n.Dispatchable())
+
+	// The maintenance expires by itself.
+	time.Sleep(100 * time.Millisecond)
+
+	n, _ = GetNode(ctx, 0)
+	assert.Equal(t, LifecycleActive, n.Lifecycle)
+	assert.Equal(t, (*Maintenance)(nil), n.Maintenance)
+
+	_, err = ExitMaintenance(ctx, 0)
+	assert.Equal(t, ErrTransition, err)
+
+	_, err = SetLifecycle(ctx, 0, LifecycleDraining, "replace disk")
+	assert.Equal(t, nil, err)
+
+	_, err = SetLifecycle(ctx, 0, LifecycleActive, "")
+	assert.Equal(t, nil, err)
+
+	buf, err := QueryTransition(ctx, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 4, len(buf))
+	assert.Equal(t, "admin", buf[0].Actor)
+	assert.Equal(t, "system", buf[1].Actor)
+	assert.Equal(t, LifecycleDraining, buf[2].To)
+}
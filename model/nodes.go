@@ -13,18 +13,31 @@
 package model
 
 import (
+	"context"
+	"sync"
+
 	"github.com/pkg/errors"
+
+	"github.com/craftslab/metalflow/labels"
+	"github.com/craftslab/metalflow/logger"
+	"github.com/craftslab/metalflow/tracing"
+	"github.com/craftslab/metalflow/util"
 )
 
 type Node struct {
-	Address  string `json:"address"`
-	Asset    string `json:"asset"`
-	Comments string `json:"comments"`
-	Health   string `json:"health"`
-	Id       uint   `json:"id"`
-	Info     string `json:"info"`
-	Perf     string `json:"perf"`
-	Region   string `json:"region"`
+	Address     string            `json:"address"`
+	Asset       string            `json:"asset"`
+	Comments    string            `json:"comments"`
+	Health      string            `json:"health"`
+	Id          uint              `json:"id"`
+	Info        string            `json:"info"`
+	Labels      map[string]string `json:"labels"`
+	Lifecycle   string            `json:"lifecycle"`
+	Maintenance *Maintenance      `json:"maintenance,omitempty"`
+	Perf        string            `json:"perf"`
+	Project     uint              `json:"project"`
+	Region      string            `json:"region"`
+	Version     uint              `json:"version"`
 }
 
 var nodes = []Node{
@@ -50,8 +63,15 @@ var nodes = []Node{
 				}
 			}
 		"`,
-		Perf:   "High",
-		Region: "Shanghai",
+		Labels: map[string]string{
+			"env":  "dev",
+			"role": "db",
+		},
+		Lifecycle: LifecycleActive,
+		Perf:      "High",
+		Project:   0,
+		Region:    "Shanghai",
+		Version:   1,
 	},
 	{
 		Address:  "127.0.0.2",
@@ -75,118 +95,296 @@ var nodes = []Node{
 				}
 			}
 		"`,
-		Perf:   "Low",
-		Region: "Xian",
+		Labels: map[string]string{
+			"env":  "prod",
+			"role": "cache",
+		},
+		Lifecycle: LifecycleActive,
+		Perf:      "Low",
+		Project:   1,
+		Region:    "Xian",
+		Version:   1,
 	},
 }
 
-func GetNode(id uint) (Node, error) {
-	var f bool
-	var n Node
+const (
+	maxComments = 1024
+	maxName     = 64
+)
 
-	f = false
+var nodeMutex sync.RWMutex
 
-	for _, v := range nodes {
-		if id == v.Id {
-			f = true
-			n = v
-			break
-		}
-	}
+var (
+	ErrNodeNotFound = errors.New("invalid id")
+)
+
+func GetNode(ctx context.Context, id uint) (Node, error) {
+	_, span := tracing.Start(ctx, "model.GetNode")
+	defer span.End()
 
-	if !f {
-		return Node{}, errors.New("invalid id")
+	n, err := findNode(ctx, id)
+	if err != nil {
+		return Node{}, err
 	}
 
 	return n, nil
 }
 
-func GetHealth(id uint) (string, error) {
-	var f bool
-	var n Node
+func GetHealth(ctx context.Context, id uint) (string, error) {
+	_, span := tracing.Start(ctx, "model.GetHealth")
+	defer span.End()
 
-	f = false
+	n, err := findNode(ctx, id)
+	if err != nil {
+		return "", err
+	}
 
-	for _, v := range nodes {
-		if id == v.Id {
-			f = true
-			n = v
-			break
-		}
+	return n.Health, nil
+}
+
+func GetInfo(ctx context.Context, id uint) (string, error) {
+	_, span := tracing.Start(ctx, "model.GetInfo")
+	defer span.End()
+
+	n, err := findNode(ctx, id)
+	if err != nil {
+		return "", err
 	}
 
-	if !f {
-		return "", errors.New("invalid id")
+	return n.Info, nil
+}
+
+func GetPerf(ctx context.Context, id uint) (string, error) {
+	_, span := tracing.Start(ctx, "model.GetPerf")
+	defer span.End()
+
+	n, err := findNode(ctx, id)
+	if err != nil {
+		return "", err
 	}
 
-	return n.Health, nil
+	return n.Perf, nil
 }
 
-func GetInfo(id uint) (string, error) {
-	var f bool
-	var n Node
+// QueryNode returns the nodes of address q, or of any address if q is empty,
+// whose labels match selector.
+func QueryNode(ctx context.Context, q string, selector labels.Selector) ([]Node, error) {
+	_, span := tracing.Start(ctx, "model.QueryNode")
+	defer span.End()
 
-	f = false
+	expireMaintenance()
+
+	nodeMutex.RLock()
+	defer nodeMutex.RUnlock()
+
+	buf := make([]Node, 0)
 
 	for _, v := range nodes {
-		if id == v.Id {
-			f = true
-			n = v
-			break
+		if (q == "" || q == v.Address) && selector.Matches(v.Labels) && ScopeOf(ctx).CanRead(v.Project) {
+			buf = append(buf, clone(v))
 		}
 	}
 
-	if !f {
-		return "", errors.New("invalid id")
+	return buf, nil
+}
+
+// SetLabels replaces the labels of the node.
+func SetLabels(ctx context.Context, id uint, l map[string]string) (Node, error) {
+	_, span := tracing.Start(ctx, "model.SetLabels")
+	defer span.End()
+
+	if err := labels.Validate(l); err != nil {
+		return Node{}, err
 	}
 
-	return n.Info, nil
+	return updateNode(ctx, id, func(n *Node) error {
+		n.Labels = map[string]string{}
+		for k, v := range l {
+			n.Labels[k] = v
+		}
+		return nil
+	})
 }
 
-func GetPerf(id uint) (string, error) {
-	var f bool
-	var n Node
+// PatchLabels merges l into the labels of the node, removing those set to nil.
+func PatchLabels(ctx context.Context, id uint, l map[string]*string) (Node, error) {
+	_, span := tracing.Start(ctx, "model.PatchLabels")
+	defer span.End()
 
-	f = false
+	buf := map[string]string{}
 
-	for _, v := range nodes {
-		if id == v.Id {
-			f = true
-			n = v
-			break
+	for k, v := range l {
+		if v != nil {
+			buf[k] = *v
 		}
 	}
 
-	if !f {
-		return "", errors.New("invalid id")
+	if err := labels.Validate(buf); err != nil {
+		return Node{}, err
 	}
 
-	return n.Perf, nil
+	return updateNode(ctx, id, func(n *Node) error {
+		if n.Labels == nil {
+			n.Labels = map[string]string{}
+		}
+		for k, v := range l {
+			if v == nil {
+				delete(n.Labels, k)
+			} else {
+				n.Labels[k] = *v
+			}
+		}
+		return nil
+	})
+}
+
+// NodeFields are the fields of a node its users may change.
+type NodeFields struct {
+	Comments string            `json:"comments"`
+	Labels   map[string]string `json:"labels"`
+	Region   string            `json:"region"`
 }
 
-func QueryNode(q string) (Node, error) {
-	if q == "" {
-		return Node{}, errors.New("invalid query")
+// PatchNode lets patch change the fields of the node, which are validated
+// before they are applied.
+func PatchNode(ctx context.Context, id uint, patch func(*NodeFields) error) (Node, error) {
+	_, span := tracing.Start(ctx, "model.PatchNode")
+	defer span.End()
+
+	return updateNode(ctx, id, func(n *Node) error {
+		f := NodeFields{
+			Comments: n.Comments,
+			Labels:   n.Labels,
+			Region:   n.Region,
+		}
+		if err := patch(&f); err != nil {
+			return err
+		}
+		if err := f.validate(); err != nil {
+			return err
+		}
+		n.Comments = f.Comments
+		n.Labels = f.Labels
+		n.Region = f.Region
+		return nil
+	})
+}
+
+func (f *NodeFields) validate() error {
+	if len(f.Comments) > maxComments {
+		return util.Invalid("comments", errors.New("invalid comments: too long"))
+	}
+
+	if f.Region == "" || len(f.Region) > maxName {
+		return util.Invalid("region", errors.New("invalid region"))
+	}
+
+	if f.Labels == nil {
+		f.Labels = map[string]string{}
 	}
 
-	var buf Node
+	if err := labels.Validate(f.Labels); err != nil {
+		return util.Invalid("labels", errors.Wrap(err, "invalid labels"))
+	}
+
+	return nil
+}
+
+// DelNode removes the node, if the scope of ctx can write to it at the
+// version of ctx, and returns it as it was.
+func DelNode(ctx context.Context, id uint) (Node, error) {
+	_, span := tracing.Start(ctx, "model.DelNode")
+	defer span.End()
+
+	expireMaintenance()
+
+	nodeMutex.Lock()
+	defer nodeMutex.Unlock()
 
 	for k, v := range nodes {
-		if q == v.Address {
-			buf = nodes[k]
-			break
+		if id != v.Id || !ScopeOf(ctx).CanRead(v.Project) {
+			continue
+		}
+		if !ScopeOf(ctx).CanWrite(v.Project) {
+			return Node{}, ErrForbidden
+		}
+		if err := checkVersion(ctx, v.Version); err != nil {
+			return Node{}, err
 		}
+		nodes = append(nodes[:k], nodes[k+1:]...)
+		return clone(v), nil
 	}
 
-	return buf, nil
+	logger.Debug(ctx, "node not found", "id", id)
+
+	return Node{}, ErrNodeNotFound
+}
+
+// findNode returns the node of id, if it is in the scope of ctx.
+func findNode(ctx context.Context, id uint) (Node, error) {
+	expireMaintenance()
+
+	nodeMutex.RLock()
+	defer nodeMutex.RUnlock()
+
+	for _, v := range nodes {
+		if id == v.Id && ScopeOf(ctx).CanRead(v.Project) {
+			return clone(v), nil
+		}
+	}
+
+	logger.Debug(ctx, "node not found", "id", id)
+
+	return Node{}, ErrNodeNotFound
 }
 
-func AddNode(id uint) (Node, error) {
-	// TODO
-	return Node{}, nil
+// updateNode applies update to the node of id, if the scope of ctx can write
+// to it at the version of ctx, and bumps its version. The node is left
+// unchanged if update fails.
+func updateNode(ctx context.Context, id uint, update func(*Node) error) (Node, error) {
+	expireMaintenance()
+
+	nodeMutex.Lock()
+	defer nodeMutex.Unlock()
+
+	for k, v := range nodes {
+		if id != v.Id || !ScopeOf(ctx).CanRead(v.Project) {
+			continue
+		}
+		if !ScopeOf(ctx).CanWrite(v.Project) {
+			return Node{}, ErrForbidden
+		}
+		if err := checkVersion(ctx, v.Version); err != nil {
+			return Node{}, err
+		}
+		n := clone(v)
+		if err := update(&n); err != nil {
+			return Node{}, err
+		}
+		n.Version++
+		nodes[k] = n
+		return clone(n), nil
+	}
+
+	logger.Debug(ctx, "node not found", "id", id)
+
+	return Node{}, ErrNodeNotFound
 }
 
-func DelNode(id uint) (Node, error) {
-	// TODO
-	return Node{}, nil
+// clone copies n with its labels and maintenance, which the callers may keep.
+func clone(n Node) Node {
+	l := make(map[string]string, len(n.Labels))
+
+	for k, v := range n.Labels {
+		l[k] = v
+	}
+
+	n.Labels = l
+
+	if n.Maintenance != nil {
+		m := *n.Maintenance
+		n.Maintenance = &m
+	}
+
+	return n
 }
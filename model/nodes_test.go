@@ -13,9 +13,12 @@
 package model
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/craftslab/metalflow/labels"
 )
 
 func TestGetNode(t *testing.T) {
@@ -37,3 +40,64 @@ func TestGetPerf(t *testing.T) {
 func TestQueryNode(t *testing.T) {
 	assert.Equal(t, nil, nil)
 }
+
+func TestLabels(t *testing.T) {
+	ctx := WithScope(context.Background(), &Scope{All: true})
+
+	s, _ := labels.Parse("env in (dev,prod),role!=db")
+	buf, err := QueryNode(ctx, "", s)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(buf))
+	assert.Equal(t, uint(1), buf[0].Id)
+
+	zone := "a"
+	n, err := PatchLabels(ctx, 1, map[string]*string{"env": nil, "zone": &zone})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, map[string]string{"role": "cache", "zone": "a"}, n.Labels)
+
+	_, err = SetLabels(ctx, 1, map[string]string{"env": "prod!"})
+	assert.NotEqual(t, nil, err)
+
+	n, err = SetLabels(ctx, 1, map[string]string{"env": "prod", "role": "cache"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(n.Labels))
+
+	ctx = WithScope(ctx, &Scope{Projects: map[uint]string{1: ProjectRoleViewer}})
+	_, err = SetLabels(ctx, 1, map[string]string{})
+	assert.Equal(t, ErrForbidden, err)
+}
+
+func TestVersion(t *testing.T) {
+	ctx := WithScope(context.Background(), &Scope{All: true})
+
+	n, _ := GetNode(ctx, 1)
+
+	_, err := SetLabels(WithVersion(ctx, n.Version+1), 1, n.Labels)
+	assert.Equal(t, ErrConflict, err)
+
+	m, err := SetLabels(WithVersion(ctx, n.Version), 1, n.Labels)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, n.Version+1, m.Version)
+
+	_, err = DelNode(WithVersion(ctx, n.Version), 1)
+	assert.Equal(t, ErrConflict, err)
+}
+
+func TestDelNode(t *testing.T) {
+	ctx := WithScope(context.Background(), &Scope{All: true})
+
+	saved := append([]Node{}, nodes...)
+	defer func() { nodes = saved }()
+
+	n, _ := GetNode(ctx, 1)
+
+	m, err := DelNode(WithVersion(ctx, n.Version), 1)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, n.Address, m.Address)
+
+	_, err = GetNode(ctx, 1)
+	assert.Equal(t, ErrNodeNotFound, err)
+
+	_, err = DelNode(ctx, 1)
+	assert.Equal(t, ErrNodeNotFound, err)
+}
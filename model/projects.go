@@ -0,0 +1,308 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/craftslab/metalflow/logger"
+	"github.com/craftslab/metalflow/tracing"
+)
+
+const (
+	ProjectRoleMaintainer = "maintainer"
+	ProjectRoleViewer     = "viewer"
+)
+
+// Project is a tenant owning nodes, which its members see according to their
+// project role.
+type Project struct {
+	Description string `json:"description"`
+	Id          uint   `json:"id"`
+	Name        string `json:"name"`
+}
+
+type Member struct {
+	Project  uint   `json:"project"`
+	Role     string `json:"role"`
+	Username string `json:"username"`
+}
+
+// Scope limits the model to the projects of the caller, mapped to its role
+// in each. A context without scope is denied everything, so that the master
+// itself, e.g. its scheduler, passes an unlimited scope of All explicitly.
+type Scope struct {
+	All      bool
+	Projects map[uint]string
+	Role     string
+	Username string
+}
+
+type scopeKey struct{}
+
+var projects = []Project{
+	{
+		Description: "Default project",
+		Id:          0,
+		Name:        "default",
+	},
+	{
+		Description: "Lab project",
+		Id:          1,
+		Name:        "lab",
+	},
+}
+
+var members = []Member{
+	{
+		Project:  1,
+		Role:     ProjectRoleMaintainer,
+		Username: "john",
+	},
+}
+
+var projectMutex sync.RWMutex
+
+var (
+	ErrForbidden       = errors.New("permission denied")
+	ErrProjectNotFound = errors.New("invalid id")
+)
+
+func WithScope(ctx context.Context, scope *Scope) context.Context {
+	return context.WithValue(ctx, scopeKey{}, scope)
+}
+
+func ScopeOf(ctx context.Context) *Scope {
+	s, _ := ctx.Value(scopeKey{}).(*Scope)
+	return s
+}
+
+func (s *Scope) CanRead(project uint) bool {
+	if s == nil {
+		return false
+	}
+
+	if s.All {
+		return true
+	}
+
+	_, ok := s.Projects[project]
+
+	return ok
+}
+
+func (s *Scope) CanWrite(project uint) bool {
+	if s == nil {
+		return false
+	}
+
+	if s.All {
+		return true
+	}
+
+	return s.Projects[project] == ProjectRoleMaintainer
+}
+
+// canSee tells if the account of username is the caller or shares a project
+// with it.
+func (s *Scope) canSee(username string) bool {
+	if s == nil {
+		return false
+	}
+
+	if s.All || s.Username == username {
+		return true
+	}
+
+	projectMutex.RLock()
+	defer projectMutex.RUnlock()
+
+	for _, v := range members {
+		if username == v.Username && s.CanRead(v.Project) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func GetProject(ctx context.Context, id uint) (Project, error) {
+	_, span := tracing.Start(ctx, "model.GetProject")
+	defer span.End()
+
+	projectMutex.RLock()
+	defer projectMutex.RUnlock()
+
+	for _, v := range projects {
+		if id == v.Id && ScopeOf(ctx).CanRead(id) {
+			return v, nil
+		}
+	}
+
+	logger.Debug(ctx, "project not found", "id", id)
+
+	return Project{}, ErrProjectNotFound
+}
+
+func QueryProject(ctx context.Context) ([]Project, error) {
+	_, span := tracing.Start(ctx, "model.QueryProject")
+	defer span.End()
+
+	projectMutex.RLock()
+	defer projectMutex.RUnlock()
+
+	buf := make([]Project, 0)
+
+	for _, v := range projects {
+		if ScopeOf(ctx).CanRead(v.Id) {
+			buf = append(buf, v)
+		}
+	}
+
+	return buf, nil
+}
+
+// AddProject adds a project, which only unlimited scopes may do.
+func AddProject(ctx context.Context, p Project) (Project, error) {
+	_, span := tracing.Start(ctx, "model.AddProject")
+	defer span.End()
+
+	if s := ScopeOf(ctx); s == nil || !s.All {
+		return Project{}, ErrForbidden
+	}
+
+	projectMutex.Lock()
+	defer projectMutex.Unlock()
+
+	if p.Name == "" {
+		return Project{}, errors.New("invalid name")
+	}
+
+	p.Id = 0
+
+	for _, v := range projects {
+		if p.Name == v.Name {
+			return Project{}, errors.New("duplicate name")
+		}
+		if v.Id >= p.Id {
+			p.Id = v.Id + 1
+		}
+	}
+
+	projects = append(projects, p)
+
+	return p, nil
+}
+
+func QueryMember(ctx context.Context, project uint) ([]Member, error) {
+	_, span := tracing.Start(ctx, "model.QueryMember")
+	defer span.End()
+
+	if !ScopeOf(ctx).CanRead(project) {
+		return nil, ErrProjectNotFound
+	}
+
+	projectMutex.RLock()
+	defer projectMutex.RUnlock()
+
+	buf := make([]Member, 0)
+
+	for _, v := range members {
+		if project == v.Project {
+			buf = append(buf, v)
+		}
+	}
+
+	return buf, nil
+}
+
+// SetMember adds a member to a project or changes its role, which the
+// maintainers of the project may do.
+func SetMember(ctx context.Context, m Member) (Member, error) {
+	_, span := tracing.Start(ctx, "model.SetMember")
+	defer span.End()
+
+	if m.Role != ProjectRoleMaintainer && m.Role != ProjectRoleViewer {
+		return Member{}, errors.New("invalid role")
+	}
+
+	if _, err := GetProject(ctx, m.Project); err != nil {
+		return Member{}, err
+	}
+
+	if !ScopeOf(ctx).CanWrite(m.Project) {
+		return Member{}, ErrForbidden
+	}
+
+	projectMutex.Lock()
+	defer projectMutex.Unlock()
+
+	for k, v := range members {
+		if m.Project == v.Project && m.Username == v.Username {
+			members[k] = m
+			return m, nil
+		}
+	}
+
+	members = append(members, m)
+
+	return m, nil
+}
+
+func DelMember(ctx context.Context, project uint, username string) (Member, error) {
+	_, span := tracing.Start(ctx, "model.DelMember")
+	defer span.End()
+
+	if !ScopeOf(ctx).CanRead(project) {
+		return Member{}, ErrProjectNotFound
+	}
+
+	if !ScopeOf(ctx).CanWrite(project) {
+		return Member{}, ErrForbidden
+	}
+
+	projectMutex.Lock()
+	defer projectMutex.Unlock()
+
+	for k, v := range members {
+		if project == v.Project && username == v.Username {
+			members = append(members[:k], members[k+1:]...)
+			return v, nil
+		}
+	}
+
+	return Member{}, errors.New("invalid username")
+}
+
+// QueryMembership returns the projects of username mapped to its role in
+// each, regardless of scope, for building the scope at login.
+func QueryMembership(ctx context.Context, username string) (map[uint]string, error) {
+	_, span := tracing.Start(ctx, "model.QueryMembership")
+	defer span.End()
+
+	projectMutex.RLock()
+	defer projectMutex.RUnlock()
+
+	buf := map[uint]string{}
+
+	for _, v := range members {
+		if username == v.Username {
+			buf[v.Project] = v.Role
+		}
+	}
+
+	return buf, nil
+}
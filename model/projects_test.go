@@ -0,0 +1,84 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScope(t *testing.T) {
+	ctx := WithScope(context.Background(), &Scope{Projects: map[uint]string{1: ProjectRoleViewer}})
+
+	_, err := GetNode(ctx, 0)
+	assert.NotEqual(t, nil, err)
+
+	n, err := GetNode(ctx, 1)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint(1), n.Project)
+
+	buf, _ := QueryNode(ctx, "127.0.0.1", nil)
+	assert.Equal(t, 0, len(buf))
+
+	_, err = DelNode(ctx, 1)
+	assert.Equal(t, ErrForbidden, err)
+
+	p, _ := QueryProject(ctx)
+	assert.Equal(t, 1, len(p))
+
+	_, err = AddProject(ctx, Project{Name: "test"})
+	assert.Equal(t, ErrForbidden, err)
+
+	_, err = SetMember(ctx, Member{Project: 1, Role: ProjectRoleViewer, Username: "jane"})
+	assert.Equal(t, ErrForbidden, err)
+
+	// Without a scope, nothing is allowed.
+	_, err = GetNode(context.Background(), 0)
+	assert.Equal(t, ErrNodeNotFound, err)
+
+	_, err = DelNode(context.Background(), 0)
+	assert.Equal(t, ErrNodeNotFound, err)
+
+	_, err = AddProject(context.Background(), Project{Name: "test"})
+	assert.Equal(t, ErrForbidden, err)
+}
+
+func TestProject(t *testing.T) {
+	ctx := WithScope(context.Background(), &Scope{All: true})
+
+	p, err := AddProject(ctx, Project{Name: "ops"})
+	assert.Equal(t, nil, err)
+
+	_, err = AddProject(ctx, Project{Name: "ops"})
+	assert.NotEqual(t, nil, err)
+
+	_, err = SetMember(ctx, Member{Project: p.Id, Role: "owner", Username: "jane"})
+	assert.NotEqual(t, nil, err)
+
+	_, err = SetMember(ctx, Member{Project: p.Id, Role: ProjectRoleViewer, Username: "jane"})
+	assert.Equal(t, nil, err)
+
+	m, _ := QueryMembership(ctx, "jane")
+	assert.Equal(t, ProjectRoleViewer, m[p.Id])
+
+	buf, _ := QueryMember(ctx, p.Id)
+	assert.Equal(t, 1, len(buf))
+
+	_, err = DelMember(ctx, p.Id, "jane")
+	assert.Equal(t, nil, err)
+
+	m, _ = QueryMembership(ctx, "jane")
+	assert.Equal(t, 0, len(m))
+}
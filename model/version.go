@@ -0,0 +1,40 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+type versionKey struct{}
+
+var (
+	ErrConflict = errors.New("version conflict")
+)
+
+// WithVersion makes the updates with ctx fail with ErrConflict unless the item
+// is still at version, e.g. as given by If-Match.
+func WithVersion(ctx context.Context, version uint) context.Context {
+	return context.WithValue(ctx, versionKey{}, version)
+}
+
+// checkVersion tells whether an item at version may be updated with ctx.
+func checkVersion(ctx context.Context, version uint) error {
+	if v, ok := ctx.Value(versionKey{}).(uint); ok && v != version {
+		return ErrConflict
+	}
+
+	return nil
+}
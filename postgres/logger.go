@@ -0,0 +1,65 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+
+	"github.com/craftslab/metalflow/logger"
+)
+
+const (
+	slowThreshold = 200 * time.Millisecond
+)
+
+// gormLogger forwards GORM logs to the logger package, so that statements
+// carry the request id of the context they were run with.
+type gormLogger struct{}
+
+func (g gormLogger) LogMode(gormlogger.LogLevel) gormlogger.Interface {
+	return g
+}
+
+func (g gormLogger) Info(ctx context.Context, msg string, data ...interface{}) {
+	logger.Info(ctx, fmt.Sprintf(msg, data...))
+}
+
+func (g gormLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
+	logger.Warn(ctx, fmt.Sprintf(msg, data...))
+}
+
+func (g gormLogger) Error(ctx context.Context, msg string, data ...interface{}) {
+	logger.Error(ctx, fmt.Sprintf(msg, data...))
+}
+
+func (g gormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	elapsed := time.Since(begin)
+
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound):
+		sql, rows := fc()
+		logger.Error(ctx, "query failed", "sql", sql, "rows", rows, "elapsed", elapsed, "error", err)
+	case elapsed > slowThreshold:
+		sql, rows := fc()
+		logger.Warn(ctx, "slow query", "sql", sql, "rows", rows, "elapsed", elapsed)
+	case logger.Enabled(logger.LevelDebug):
+		sql, rows := fc()
+		logger.Debug(ctx, "query", "sql", sql, "rows", rows, "elapsed", elapsed)
+	}
+}
@@ -14,80 +14,276 @@ package postgres
 
 import (
 	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/pkg/errors"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+
+	"github.com/craftslab/metalflow/logger"
 )
 
 type Postgres interface {
 	Open() error
 	Close()
+	WithContext(ctx context.Context) Postgres
 
 	Migrate(model interface{}) error
 	Create(model interface{})
 	Read(model, cond, value interface{})
+	Query(model interface{}, filter *Filter)
 	Update(model interface{}, column string, value interface{})
 	Delete(model, cond, value interface{})
+	Raw(model interface{}, sql string, values ...interface{})
 }
 
 type Config struct {
-	Db   string
-	Host string
-	Pass string
-	Port string
-	User string
+	ApplicationName   string
+	Backoff           time.Duration
+	Db                string
+	Dsn               string
+	HealthInterval    time.Duration
+	Host              string
+	MaxBackoff        time.Duration
+	Pass              string
+	Port              string
+	PrimaryAfterWrite bool
+	Replicas          []Config
+	Retry             int
+	SearchPath        string
+	SslCert           string
+	SslKey            string
+	SslMode           string
+	SslRootCert       string
+	TimeZone          string
+	User              string
+}
+
+type Filter struct {
+	Cond   string
+	Values []interface{}
+	Order  string
+	Limit  int
+	Offset int
 }
 
 type _postgres struct {
-	config   *Config
-	database *gorm.DB
+	config  *Config
+	context context.Context
+	pool    *pool
+}
+
+type pool struct {
+	cancel   context.CancelFunc
+	next     uint32
+	primary  *gorm.DB
+	replicas []*replica
+	wg       sync.WaitGroup
+}
+
+type replica struct {
+	config  *Config
+	healthy int32
+	mutex   sync.RWMutex
+	db      *gorm.DB
 }
 
+type session struct {
+	wrote int32
+}
+
+type sessionKey struct{}
+
+var (
+	ErrNotOpen = errors.New("not open")
+)
+
 const (
-	dsn = "sslmode=disable TimeZone=Asia/Shanghai"
+	healthInterval = 10 * time.Second
+	pingTimeout    = 3 * time.Second
 )
 
-func New(_ context.Context, config *Config) Postgres {
+func New(ctx context.Context, config *Config) Postgres {
 	return &_postgres{
-		config:   config,
-		database: nil,
+		config:  config,
+		context: ctx,
+		pool:    &pool{},
 	}
 }
 
 func DefaultConfig() *Config {
 	return &Config{
-		Db:   "metalflow",
-		Host: "127.0.0.1",
-		Pass: "",
-		Port: "5432",
-		User: "",
+		ApplicationName:   "metalflow",
+		Backoff:           time.Second,
+		Db:                "metalflow",
+		Dsn:               "",
+		HealthInterval:    healthInterval,
+		Host:              "127.0.0.1",
+		MaxBackoff:        30 * time.Second,
+		Pass:              "",
+		Port:              "5432",
+		PrimaryAfterWrite: true,
+		Replicas:          nil,
+		Retry:             0,
+		SearchPath:        "",
+		SslCert:           "",
+		SslKey:            "",
+		SslMode:           "disable",
+		SslRootCert:       "",
+		TimeZone:          "Asia/Shanghai",
+		User:              "",
+	}
+}
+
+// DSN returns the connection string for config. A non-empty Dsn, either in
+// keyword/value or URL form, overrides all the other connection settings.
+func DSN(config *Config) string {
+	if config.Dsn != "" {
+		return config.Dsn
 	}
+
+	params := []struct {
+		key string
+		val string
+	}{
+		{"host", config.Host},
+		{"port", config.Port},
+		{"user", config.User},
+		{"password", config.Pass},
+		{"dbname", config.Db},
+		{"sslmode", config.SslMode},
+		{"sslrootcert", config.SslRootCert},
+		{"sslcert", config.SslCert},
+		{"sslkey", config.SslKey},
+		{"TimeZone", config.TimeZone},
+		{"application_name", config.ApplicationName},
+		{"search_path", config.SearchPath},
+	}
+
+	var buf []string
+
+	for _, item := range params {
+		if item.val != "" {
+			buf = append(buf, item.key+"="+quote(item.val))
+		}
+	}
+
+	return strings.Join(buf, " ")
+}
+
+// NewSession returns a copy of ctx which tracks the writes done within one
+// request, so that later reads can be pinned to the primary.
+func NewSession(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sessionKey{}, &session{})
+}
+
+func quote(val string) string {
+	if val != "" && !strings.ContainsAny(val, " '\\") {
+		return val
+	}
+
+	r := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+	return "'" + r.Replace(val) + "'"
 }
 
 func (p *_postgres) Open() error {
-	host := "host=" + p.config.Host + " "
-	port := "port=" + p.config.Port + " "
-	user := "user=" + p.config.User + " "
-	pass := "password=" + p.config.Pass + " "
-	dbname := "dbname=" + p.config.Db + " "
+	var db *gorm.DB
+	var err error
+
+	backoff := p.config.Backoff
+
+	for i := 0; ; i++ {
+		db, err = gorm.Open(postgres.Open(DSN(p.config)), &gorm.Config{Logger: gormLogger{}})
+		if err == nil || i >= p.config.Retry {
+			break
+		}
+
+		logger.Warn(p.context, "failed to open postgres, retrying", "attempt", i+1, "attempts", p.config.Retry+1, "backoff", backoff, "error", err)
+
+		select {
+		case <-p.context.Done():
+			return errors.Wrap(p.context.Err(), "failed to open")
+		case <-time.After(backoff):
+		}
+
+		if backoff *= 2; p.config.MaxBackoff > 0 && backoff > p.config.MaxBackoff {
+			backoff = p.config.MaxBackoff
+		}
+	}
 
-	db, err := gorm.Open(postgres.Open(host+port+user+pass+dbname+dsn), &gorm.Config{})
 	if err != nil {
 		return errors.Wrap(err, "failed to open")
 	}
 
-	p.database = db
+	if err = registerTracing(db); err != nil {
+		return errors.Wrap(err, "failed to register tracing")
+	}
+
+	p.pool.primary = db
+	p.pool.replicas = make([]*replica, len(p.config.Replicas))
+
+	for i := range p.config.Replicas {
+		p.pool.replicas[i] = &replica{config: &p.config.Replicas[i]}
+		p.pool.replicas[i].check(p.context)
+	}
+
+	if len(p.pool.replicas) != 0 {
+		interval := p.config.HealthInterval
+		if interval <= 0 {
+			interval = healthInterval
+		}
+		ctx, cancel := context.WithCancel(p.context)
+		p.pool.cancel = cancel
+		p.pool.wg.Add(1)
+		go p.pool.watch(ctx, interval)
+	}
 
 	return nil
 }
 
 func (p *_postgres) Close() {
-	// PASS
+	if p.pool.cancel != nil {
+		p.pool.cancel()
+		p.pool.wg.Wait()
+		p.pool.cancel = nil
+	}
+
+	for _, item := range p.pool.replicas {
+		item.close()
+	}
+
+	p.pool.replicas = nil
+
+	if p.pool.primary == nil {
+		return
+	}
+
+	if db, err := p.pool.primary.DB(); err == nil {
+		_ = db.Close()
+	}
+
+	p.pool.primary = nil
+}
+
+func (p *_postgres) WithContext(ctx context.Context) Postgres {
+	return &_postgres{
+		config:  p.config,
+		context: ctx,
+		pool:    p.pool,
+	}
 }
 
 func (p *_postgres) Migrate(model interface{}) error {
-	if err := p.database.AutoMigrate(model); err != nil {
+	db, err := p.writer()
+	if err != nil {
+		return errors.Wrap(err, "failed to migrate")
+	}
+
+	if err := db.AutoMigrate(model); err != nil {
 		return errors.Wrap(err, "failed to migrate")
 	}
 
@@ -95,17 +291,202 @@ func (p *_postgres) Migrate(model interface{}) error {
 }
 
 func (p *_postgres) Create(model interface{}) {
-	p.database.Create(model)
+	p.write(func(db *gorm.DB) *gorm.DB {
+		return db.Create(model)
+	})
 }
 
 func (p *_postgres) Read(model, cond, value interface{}) {
-	p.database.First(model, cond, value)
+	p.read(func(db *gorm.DB) *gorm.DB {
+		return db.First(model, cond, value)
+	})
+}
+
+func (p *_postgres) Query(model interface{}, filter *Filter) {
+	p.read(func(db *gorm.DB) *gorm.DB {
+		if filter == nil {
+			return db.Find(model)
+		}
+		if filter.Cond != "" {
+			db = db.Where(filter.Cond, filter.Values...)
+		}
+		if filter.Order != "" {
+			db = db.Order(filter.Order)
+		}
+		if filter.Limit > 0 {
+			db = db.Limit(filter.Limit)
+		}
+		if filter.Offset > 0 {
+			db = db.Offset(filter.Offset)
+		}
+		return db.Find(model)
+	})
 }
 
 func (p *_postgres) Update(model interface{}, column string, value interface{}) {
-	p.database.Model(model).Update(column, value)
+	p.write(func(db *gorm.DB) *gorm.DB {
+		return db.Model(model).Update(column, value)
+	})
 }
 
 func (p *_postgres) Delete(model, cond, value interface{}) {
-	p.database.Delete(model, cond, value)
+	p.write(func(db *gorm.DB) *gorm.DB {
+		return db.Delete(model, cond, value)
+	})
+}
+
+// Raw runs sql on the primary and scans the rows it returns into model.
+func (p *_postgres) Raw(model interface{}, sql string, values ...interface{}) {
+	p.write(func(db *gorm.DB) *gorm.DB {
+		return db.Raw(sql, values...).Scan(model)
+	})
+}
+
+// write runs fn on the primary, and leaves model untouched if it is not open.
+func (p *_postgres) write(fn func(*gorm.DB) *gorm.DB) {
+	db, err := p.writer()
+	if err != nil {
+		logger.Error(p.context, "failed to write", "error", err)
+		return
+	}
+
+	fn(db)
+}
+
+func (p *_postgres) writer() (*gorm.DB, error) {
+	if p.pool.primary == nil {
+		return nil, ErrNotOpen
+	}
+
+	p.markWrite()
+
+	return p.pool.primary.WithContext(p.context), nil
+}
+
+func (p *_postgres) markWrite() {
+	if s, ok := p.context.Value(sessionKey{}).(*session); ok {
+		atomic.StoreInt32(&s.wrote, 1)
+	}
+}
+
+// read runs fn on a healthy replica and falls back to the primary if there is
+// none, if the replica fails, or if the session has already written.
+func (p *_postgres) read(fn func(*gorm.DB) *gorm.DB) {
+	r, err := p.reader()
+	if err != nil {
+		logger.Error(p.context, "failed to read", "error", err)
+		return
+	}
+
+	if r != nil {
+		res := fn(r.get().WithContext(p.context))
+		if res.Error == nil || errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return
+		}
+
+		logger.Warn(p.context, "failed to read from replica, falling back to primary", "host", r.config.Host, "error", res.Error)
+		atomic.StoreInt32(&r.healthy, 0)
+	}
+
+	fn(p.pool.primary.WithContext(p.context))
+}
+
+// reader returns a healthy replica, or nil to read from the primary.
+func (p *_postgres) reader() (*replica, error) {
+	if p.pool.primary == nil {
+		return nil, ErrNotOpen
+	}
+
+	if p.config.PrimaryAfterWrite {
+		if s, ok := p.context.Value(sessionKey{}).(*session); ok && atomic.LoadInt32(&s.wrote) == 1 {
+			return nil, nil
+		}
+	}
+
+	n := len(p.pool.replicas)
+	start := atomic.AddUint32(&p.pool.next, 1)
+
+	for i := 0; i < n; i++ {
+		r := p.pool.replicas[(int(start)+i)%n]
+		if atomic.LoadInt32(&r.healthy) == 1 {
+			return r, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (p *pool) watch(ctx context.Context, interval time.Duration) {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, item := range p.replicas {
+				item.check(ctx)
+			}
+		}
+	}
+}
+
+func (r *replica) get() *gorm.DB {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.db
+}
+
+func (r *replica) check(ctx context.Context) {
+	db := r.get()
+
+	if db == nil {
+		var err error
+		if db, err = gorm.Open(postgres.Open(DSN(r.config)), &gorm.Config{Logger: gormLogger{}}); err != nil {
+			logger.Warn(ctx, "failed to open replica", "host", r.config.Host, "error", err)
+			atomic.StoreInt32(&r.healthy, 0)
+			return
+		}
+		if err = registerTracing(db); err != nil {
+			logger.Warn(ctx, "failed to register tracing", "host", r.config.Host, "error", err)
+		}
+		r.mutex.Lock()
+		r.db = db
+		r.mutex.Unlock()
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		atomic.StoreInt32(&r.healthy, 0)
+		return
+	}
+
+	c, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+
+	if err := sqlDB.PingContext(c); err != nil {
+		atomic.StoreInt32(&r.healthy, 0)
+		return
+	}
+
+	atomic.StoreInt32(&r.healthy, 1)
+}
+
+func (r *replica) close() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.db == nil {
+		return
+	}
+
+	if db, err := r.db.DB(); err == nil {
+		_ = db.Close()
+	}
+
+	r.db = nil
 }
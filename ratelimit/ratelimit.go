@@ -0,0 +1,241 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/craftslab/metalflow/logger"
+	"github.com/craftslab/metalflow/util"
+)
+
+type RateLimit interface {
+	Login(subject func(ctx *gin.Context) string) gin.HandlerFunc
+	Token() gin.HandlerFunc
+}
+
+type Config struct {
+	Login   Login
+	Lockout Lockout
+	Store   Store
+	Token   Limit
+}
+
+type Login struct {
+	Ip   Limit
+	User Limit
+}
+
+// Limit allows Count requests per Window; a zero Count disables it.
+type Limit struct {
+	Count  int64
+	Window time.Duration
+}
+
+// Lockout locks a username or IP out once Threshold logins have failed within
+// Window, for Base doubled on every further failure up to Max.
+type Lockout struct {
+	Base      time.Duration
+	Max       time.Duration
+	Threshold int64
+	Window    time.Duration
+}
+
+type ratelimit struct {
+	config *Config
+}
+
+func New(config *Config) RateLimit {
+	return &ratelimit{
+		config: config,
+	}
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Login: Login{
+			Ip:   Limit{Count: 30, Window: time.Minute},
+			User: Limit{Count: 10, Window: time.Minute},
+		},
+		Lockout: Lockout{
+			Base:      time.Minute,
+			Max:       time.Hour,
+			Threshold: 5,
+			Window:    24 * time.Hour,
+		},
+		Store: NewMemoryStore(),
+		Token: Limit{Count: 600, Window: time.Minute},
+	}
+}
+
+// Login limits the login attempts per IP and per username, and locks out the
+// ones which failed too often. The IP is that of the peer, unless the router
+// resolved it from the headers of trusted proxies. The username is that
+// subject returns for the request, e.g. as bound by the login handler, in
+// lower case; a nil subject limits per IP only.
+func (r *ratelimit) Login(subject func(ctx *gin.Context) string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		user := ""
+		if subject != nil {
+			user = strings.ToLower(subject(ctx))
+		}
+
+		r.login(ctx, user)
+	}
+}
+
+func (r *ratelimit) login(ctx *gin.Context, user string) {
+	c := ctx.Request.Context()
+
+	keys := []string{"ip:" + ctx.ClientIP()}
+	if user != "" {
+		keys = append(keys, "user:"+user)
+	}
+
+	for _, key := range keys {
+		if n, ttl := r.get(c, "lock:"+key); n > 0 {
+			reject(ctx, ttl, "too many failed logins")
+			return
+		}
+	}
+
+	limits := []Limit{r.config.Login.Ip, r.config.Login.User}
+
+	for i, key := range keys {
+		if ok, ttl := r.allow(c, "login:"+key, limits[i]); !ok {
+			reject(ctx, ttl, "too many requests")
+			return
+		}
+	}
+
+	ctx.Next()
+
+	switch ctx.Writer.Status() {
+	case http.StatusOK:
+		for _, key := range keys {
+			r.delete(c, "fail:"+key)
+		}
+	case http.StatusUnauthorized:
+		for _, key := range keys {
+			r.fail(c, key)
+		}
+	}
+}
+
+// Token limits the requests per token, so it shall follow the JWT middleware.
+func (r *ratelimit) Token() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		t := token(ctx)
+		if t == "" {
+			ctx.Next()
+			return
+		}
+
+		sum := sha256.Sum256([]byte(t))
+
+		if ok, ttl := r.allow(ctx.Request.Context(), "token:"+hex.EncodeToString(sum[:]), r.config.Token); !ok {
+			reject(ctx, ttl, "request quota exceeded")
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+func (r *ratelimit) allow(ctx context.Context, key string, limit Limit) (bool, time.Duration) {
+	if limit.Count <= 0 {
+		return true, 0
+	}
+
+	n, ttl, err := r.config.Store.Incr(ctx, key, limit.Window)
+	if err != nil {
+		logger.Error(ctx, "failed to count requests", "key", key, "error", err)
+		return true, 0
+	}
+
+	return n <= limit.Count, ttl
+}
+
+func (r *ratelimit) fail(ctx context.Context, key string) {
+	l := r.config.Lockout
+	if l.Threshold <= 0 {
+		return
+	}
+
+	n, _, err := r.config.Store.Incr(ctx, "fail:"+key, l.Window)
+	if err != nil {
+		logger.Error(ctx, "failed to count failures", "key", key, "error", err)
+		return
+	}
+
+	if n < l.Threshold {
+		return
+	}
+
+	d := l.Max
+	if shift := n - l.Threshold; shift < 62 && l.Base<<shift > 0 && l.Base<<shift < l.Max {
+		d = l.Base << shift
+	}
+
+	logger.Warn(ctx, "login locked out", "key", key, "failures", n, "duration", d)
+
+	if _, _, err := r.config.Store.Incr(ctx, "lock:"+key, d); err != nil {
+		logger.Error(ctx, "failed to lock out", "key", key, "error", err)
+	}
+}
+
+func (r *ratelimit) get(ctx context.Context, key string) (int64, time.Duration) {
+	n, ttl, err := r.config.Store.Get(ctx, key)
+	if err != nil {
+		logger.Error(ctx, "failed to get counter", "key", key, "error", err)
+		return 0, 0
+	}
+
+	return n, ttl
+}
+
+func (r *ratelimit) delete(ctx context.Context, key string) {
+	if err := r.config.Store.Delete(ctx, key); err != nil {
+		logger.Error(ctx, "failed to delete counter", "key", key, "error", err)
+	}
+}
+
+func reject(ctx *gin.Context, ttl time.Duration, msg string) {
+	ctx.Header("Retry-After", strconv.FormatInt(int64(math.Ceil(ttl.Seconds())), 10))
+	util.NewError(ctx, http.StatusTooManyRequests, errors.New(msg))
+	ctx.Abort()
+}
+
+func token(ctx *gin.Context) string {
+	if h := ctx.GetHeader("Authorization"); h != "" {
+		return strings.TrimPrefix(h, "Bearer ")
+	}
+
+	if t := ctx.Query("token"); t != "" {
+		return t
+	}
+
+	t, _ := ctx.Cookie("jwt")
+
+	return t
+}
@@ -0,0 +1,145 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func login(r *gin.Engine, user, pass string) *httptest.ResponseRecorder {
+	data := url.Values{}
+	data.Set("username", user)
+	data.Set("password", pass)
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/login", bytes.NewBufferString(data.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestLogin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	c := DefaultConfig()
+	c.Login.Ip.Count = 100
+	c.Lockout.Threshold = 3
+
+	subject := func(ctx *gin.Context) string {
+		return ctx.PostForm("username")
+	}
+
+	r := gin.New()
+	r.POST("/login", New(c).Login(subject), func(ctx *gin.Context) {
+		if ctx.PostForm("username") == "admin" && ctx.PostForm("password") == "admin" {
+			ctx.Status(http.StatusOK)
+		} else {
+			ctx.Status(http.StatusUnauthorized)
+		}
+	})
+
+	assert.Equal(t, http.StatusUnauthorized, login(r, "john", "bad").Code)
+	assert.Equal(t, http.StatusUnauthorized, login(r, "john", "bad").Code)
+	assert.Equal(t, http.StatusOK, login(r, "admin", "admin").Code)
+	assert.Equal(t, http.StatusUnauthorized, login(r, "john", "bad").Code)
+
+	rec := login(r, "john", "john")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "60", rec.Header().Get("Retry-After"))
+
+	n, ttl, _ := c.Store.Get(context.Background(), "lock:user:john")
+	assert.Equal(t, int64(1), n)
+	assert.Equal(t, true, ttl > 59*time.Second)
+
+	_ = c.Store.Delete(context.Background(), "lock:user:john")
+	_ = c.Store.Delete(context.Background(), "lock:ip:")
+	assert.Equal(t, http.StatusUnauthorized, login(r, "john", "bad").Code)
+
+	_, ttl, _ = c.Store.Get(context.Background(), "lock:user:john")
+	assert.Equal(t, true, ttl > 119*time.Second)
+
+	_ = c.Store.Delete(context.Background(), "lock:ip:")
+	_ = c.Store.Delete(context.Background(), "login:user:admin")
+
+	c.Login.User.Count = 1
+	assert.Equal(t, http.StatusOK, login(r, "admin", "admin").Code)
+	assert.Equal(t, http.StatusTooManyRequests, login(r, "admin", "admin").Code)
+}
+
+func TestLoginCase(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	c := DefaultConfig()
+	c.Login.Ip.Count = 100
+	c.Lockout.Threshold = 2
+
+	subject := func(ctx *gin.Context) string {
+		return ctx.GetHeader("X-Username")
+	}
+
+	r := gin.New()
+	r.POST("/login", New(c).Login(subject), func(ctx *gin.Context) {
+		ctx.Status(http.StatusUnauthorized)
+	})
+
+	login := func(user string) int {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/login", nil)
+		req.Header.Set("X-Username", user)
+		r.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// The usernames differing in case share their limits.
+	assert.Equal(t, http.StatusUnauthorized, login("john"))
+	assert.Equal(t, http.StatusUnauthorized, login("John"))
+
+	_ = c.Store.Delete(context.Background(), "lock:ip:")
+	assert.Equal(t, http.StatusTooManyRequests, login("JOHN"))
+	assert.Equal(t, http.StatusUnauthorized, login("jane"))
+}
+
+func TestToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	c := DefaultConfig()
+	c.Token = Limit{Count: 2, Window: time.Minute}
+
+	r := gin.New()
+	r.GET("/nodes", New(c).Token(), func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+
+	get := func(token string) int {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/nodes", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		r.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, get("a"))
+	assert.Equal(t, http.StatusOK, get("a"))
+	assert.Equal(t, http.StatusTooManyRequests, get("a"))
+	assert.Equal(t, http.StatusOK, get("b"))
+}
@@ -0,0 +1,259 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/craftslab/metalflow/etcd"
+	"github.com/craftslab/metalflow/postgres"
+)
+
+const (
+	etcdPrefix = "/metalflow/ratelimit/"
+	etcdRetry  = 10
+
+	sweepSize = 10000
+)
+
+// Store keeps fixed-window counters. Incr increments the counter of key,
+// starting a new window if it has expired, and returns the count and the time
+// left in the window. Sharing a store lets several masters enforce the same
+// limits.
+type Store interface {
+	Incr(ctx context.Context, key string, window time.Duration) (int64, time.Duration, error)
+	Get(ctx context.Context, key string) (int64, time.Duration, error)
+	Delete(ctx context.Context, key string) error
+}
+
+type Counter struct {
+	Key       string `gorm:"primaryKey"`
+	Count     int64
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+type counter struct {
+	count   int64
+	expires time.Time
+}
+
+type memoryStore struct {
+	counters map[string]*counter
+	mutex    sync.Mutex
+}
+
+type postgresStore struct {
+	postgres postgres.Postgres
+}
+
+type etcdStore struct {
+	etcd etcd.Etcd
+}
+
+func (Counter) TableName() string {
+	return "rate_limits"
+}
+
+func NewMemoryStore() Store {
+	return &memoryStore{
+		counters: map[string]*counter{},
+	}
+}
+
+func NewPostgresStore(p postgres.Postgres) (Store, error) {
+	if err := p.Migrate(&Counter{}); err != nil {
+		return nil, errors.Wrap(err, "failed to migrate")
+	}
+
+	return &postgresStore{postgres: p}, nil
+}
+
+func NewEtcdStore(e etcd.Etcd) Store {
+	return &etcdStore{etcd: e}
+}
+
+func (m *memoryStore) Incr(_ context.Context, key string, window time.Duration) (int64, time.Duration, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+
+	if len(m.counters) >= sweepSize {
+		for k, v := range m.counters {
+			if !now.Before(v.expires) {
+				delete(m.counters, k)
+			}
+		}
+	}
+
+	c, ok := m.counters[key]
+	if !ok || !now.Before(c.expires) {
+		c = &counter{expires: now.Add(window)}
+		m.counters[key] = c
+	}
+
+	c.count++
+
+	return c.count, c.expires.Sub(now), nil
+}
+
+func (m *memoryStore) Get(_ context.Context, key string) (int64, time.Duration, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+
+	c, ok := m.counters[key]
+	if !ok || !now.Before(c.expires) {
+		return 0, 0, nil
+	}
+
+	return c.count, c.expires.Sub(now), nil
+}
+
+func (m *memoryStore) Delete(_ context.Context, key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.counters, key)
+
+	return nil
+}
+
+func (p *postgresStore) Incr(ctx context.Context, key string, window time.Duration) (int64, time.Duration, error) {
+	now := time.Now()
+
+	var c Counter
+
+	p.postgres.WithContext(ctx).Raw(&c, `INSERT INTO rate_limits (key, count, expires_at) VALUES (?, 1, ?)
+ON CONFLICT (key) DO UPDATE SET
+count = CASE WHEN rate_limits.expires_at <= ? THEN 1 ELSE rate_limits.count + 1 END,
+expires_at = CASE WHEN rate_limits.expires_at <= ? THEN EXCLUDED.expires_at ELSE rate_limits.expires_at END
+RETURNING key, count, expires_at`, key, now.Add(window), now, now)
+
+	if c.Key == "" {
+		return 0, 0, errors.New("failed to increment")
+	}
+
+	return c.Count, c.ExpiresAt.Sub(now), nil
+}
+
+func (p *postgresStore) Get(ctx context.Context, key string) (int64, time.Duration, error) {
+	var c Counter
+
+	now := time.Now()
+
+	p.postgres.WithContext(ctx).Raw(&c, "SELECT key, count, expires_at FROM rate_limits WHERE key = ? AND expires_at > ?", key, now)
+
+	if c.Key == "" {
+		return 0, 0, nil
+	}
+
+	return c.Count, c.ExpiresAt.Sub(now), nil
+}
+
+func (p *postgresStore) Delete(ctx context.Context, key string) error {
+	p.postgres.WithContext(ctx).Delete(&Counter{}, "key = ?", key)
+
+	return nil
+}
+
+func (e *etcdStore) Incr(ctx context.Context, key string, window time.Duration) (int64, time.Duration, error) {
+	cli := e.etcd.Client()
+	k := etcdPrefix + key
+
+	for i := 0; i < etcdRetry; i++ {
+		resp, err := cli.Get(ctx, k)
+		if err != nil {
+			return 0, 0, errors.Wrap(err, "failed to get")
+		}
+
+		if len(resp.Kvs) == 0 {
+			lease, err := cli.Grant(ctx, int64(math.Ceil(window.Seconds())))
+			if err != nil {
+				return 0, 0, errors.Wrap(err, "failed to grant")
+			}
+			txn, err := cli.Txn(ctx).
+				If(clientv3.Compare(clientv3.CreateRevision(k), "=", 0)).
+				Then(clientv3.OpPut(k, "1", clientv3.WithLease(lease.ID))).
+				Commit()
+			if err != nil {
+				return 0, 0, errors.Wrap(err, "failed to commit")
+			}
+			if txn.Succeeded {
+				return 1, window, nil
+			}
+			_, _ = cli.Revoke(ctx, lease.ID)
+			continue
+		}
+
+		kv := resp.Kvs[0]
+
+		n, err := strconv.ParseInt(string(kv.Value), 10, 64)
+		if err != nil {
+			return 0, 0, errors.Wrap(err, "failed to parse")
+		}
+
+		txn, err := cli.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(k), "=", kv.ModRevision)).
+			Then(clientv3.OpPut(k, strconv.FormatInt(n+1, 10), clientv3.WithIgnoreLease())).
+			Commit()
+		if err != nil {
+			return 0, 0, errors.Wrap(err, "failed to commit")
+		}
+
+		if txn.Succeeded {
+			return n + 1, e.ttl(ctx, clientv3.LeaseID(kv.Lease)), nil
+		}
+	}
+
+	return 0, 0, errors.New("failed to increment")
+}
+
+func (e *etcdStore) Get(ctx context.Context, key string) (int64, time.Duration, error) {
+	resp, err := e.etcd.Client().Get(ctx, etcdPrefix+key)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to get")
+	}
+
+	if len(resp.Kvs) == 0 {
+		return 0, 0, nil
+	}
+
+	n, err := strconv.ParseInt(string(resp.Kvs[0].Value), 10, 64)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to parse")
+	}
+
+	return n, e.ttl(ctx, clientv3.LeaseID(resp.Kvs[0].Lease)), nil
+}
+
+func (e *etcdStore) Delete(ctx context.Context, key string) error {
+	return e.etcd.Delete(ctx, etcdPrefix+key)
+}
+
+func (e *etcdStore) ttl(ctx context.Context, id clientv3.LeaseID) time.Duration {
+	resp, err := e.etcd.Client().TimeToLive(ctx, id)
+	if err != nil || resp.TTL < 0 {
+		return 0
+	}
+
+	return time.Duration(resp.TTL) * time.Second
+}
@@ -0,0 +1,75 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/craftslab/metalflow/etcd"
+)
+
+func testStore(t *testing.T, s Store) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_ = s.Delete(ctx, "test")
+
+	n, ttl, err := s.Incr(ctx, "test", time.Minute)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(1), n)
+	assert.Equal(t, true, ttl > 0 && ttl <= time.Minute)
+
+	n, _, err = s.Incr(ctx, "test", time.Minute)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(2), n)
+
+	n, ttl, err = s.Get(ctx, "test")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(2), n)
+	assert.Equal(t, true, ttl > 0)
+
+	err = s.Delete(ctx, "test")
+	assert.Equal(t, nil, err)
+
+	n, _, err = s.Get(ctx, "test")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(0), n)
+}
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore()
+	testStore(t, s)
+
+	n, _, _ := s.Incr(context.Background(), "expired", time.Nanosecond)
+	assert.Equal(t, int64(1), n)
+
+	time.Sleep(time.Millisecond)
+
+	n, _, _ = s.Incr(context.Background(), "expired", time.Nanosecond)
+	assert.Equal(t, int64(1), n)
+}
+
+func TestEtcdStore(t *testing.T) {
+	e := etcd.New(context.Background(), etcd.DefaultConfig())
+
+	err := e.Open()
+	assert.Equal(t, nil, err)
+
+	defer e.Close()
+
+	testStore(t, NewEtcdStore(e))
+}
@@ -0,0 +1,187 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package router
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// Cors is the CORS policy of all routes, overridden by Groups for the route
+// groups under the given path prefixes, e.g. "/swagger".
+type Cors struct {
+	CorsPolicy
+	Groups map[string]CorsPolicy
+}
+
+// CorsPolicy allows cross-origin requests from AllowOrigins, which are exact
+// origins, "*" for any origin, or wildcard subdomains like
+// "https://*.example.com". No origin is allowed if it is empty.
+type CorsPolicy struct {
+	AllowCredentials bool
+	AllowHeaders     []string
+	AllowMethods     []string
+	AllowOrigins     []string
+	ExposeHeaders    []string
+	MaxAge           time.Duration
+}
+
+type corsGroup struct {
+	handler gin.HandlerFunc
+	prefix  string
+}
+
+func DefaultCors() *Cors {
+	return &Cors{
+		CorsPolicy: CorsPolicy{
+			AllowCredentials: false,
+			AllowHeaders:     []string{"Authorization", "Content-Type", "If-Match", "If-None-Match", "X-Request-ID"},
+			AllowMethods:     []string{"DELETE", "GET", "PATCH", "POST", "PUT"},
+			AllowOrigins:     []string{},
+			ExposeHeaders:    []string{"Content-Type", "Deprecation", "ETag", "Link", "Retry-After", "Sunset", "X-Request-ID"},
+			MaxAge:           12 * time.Hour,
+		},
+		Groups: map[string]CorsPolicy{},
+	}
+}
+
+// Inherit fills the methods, headers and max age not set in p from parent.
+func (p CorsPolicy) Inherit(parent CorsPolicy) CorsPolicy {
+	if len(p.AllowHeaders) == 0 {
+		p.AllowHeaders = parent.AllowHeaders
+	}
+
+	if len(p.AllowMethods) == 0 {
+		p.AllowMethods = parent.AllowMethods
+	}
+
+	if len(p.ExposeHeaders) == 0 {
+		p.ExposeHeaders = parent.ExposeHeaders
+	}
+
+	if p.MaxAge == 0 {
+		p.MaxAge = parent.MaxAge
+	}
+
+	return p
+}
+
+// Middleware applies the policy of the longest group prefix matching the
+// request path, or the default one.
+func (c *Cors) Middleware() (gin.HandlerFunc, error) {
+	def, err := c.CorsPolicy.handler()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to build default policy")
+	}
+
+	var groups []corsGroup
+
+	for prefix, policy := range c.Groups {
+		h, err := policy.Inherit(c.CorsPolicy).handler()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to build policy of "+prefix)
+		}
+		groups = append(groups, corsGroup{handler: h, prefix: "/" + strings.Trim(prefix, "/")})
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return len(groups[i].prefix) > len(groups[j].prefix)
+	})
+
+	return func(ctx *gin.Context) {
+		path := ctx.Request.URL.Path
+
+		for _, g := range groups {
+			if path == g.prefix || strings.HasPrefix(path, g.prefix+"/") {
+				g.handler(ctx)
+				return
+			}
+		}
+
+		def(ctx)
+	}, nil
+}
+
+func (p CorsPolicy) handler() (gin.HandlerFunc, error) {
+	var exact []string
+	var wildcard [][2]string
+
+	for _, item := range p.AllowOrigins {
+		if item == "*" {
+			if p.AllowCredentials {
+				return nil, errors.New("credentials not allowed for any origin")
+			}
+			return cors.New(cors.Config{
+				AllowAllOrigins: true,
+				AllowHeaders:    p.AllowHeaders,
+				AllowMethods:    p.AllowMethods,
+				ExposeHeaders:   p.ExposeHeaders,
+				MaxAge:          p.MaxAge,
+			}), nil
+		}
+
+		u, err := url.Parse(item)
+		if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+			return nil, errors.New("invalid origin " + item)
+		}
+
+		if strings.HasPrefix(u.Host, "*.") {
+			wildcard = append(wildcard, [2]string{u.Scheme, strings.ToLower(u.Host[1:])})
+		} else if strings.Contains(u.Host, "*") {
+			return nil, errors.New("invalid wildcard origin " + item)
+		} else {
+			exact = append(exact, strings.ToLower(u.Scheme+"://"+u.Host))
+		}
+	}
+
+	return cors.New(cors.Config{
+		AllowCredentials: p.AllowCredentials,
+		AllowHeaders:     p.AllowHeaders,
+		AllowMethods:     p.AllowMethods,
+		AllowOriginFunc: func(origin string) bool {
+			return matchOrigin(origin, exact, wildcard)
+		},
+		ExposeHeaders: p.ExposeHeaders,
+		MaxAge:        p.MaxAge,
+	}), nil
+}
+
+func matchOrigin(origin string, exact []string, wildcard [][2]string) bool {
+	origin = strings.ToLower(origin)
+
+	for _, item := range exact {
+		if origin == item {
+			return true
+		}
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+
+	for _, item := range wildcard {
+		// "*.example.com" matches any subdomain, but not example.com itself.
+		if u.Scheme == item[0] && strings.HasSuffix(u.Host, item[1]) && len(u.Host) > len(item[1]) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,75 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	c := DefaultCors()
+	c.AllowCredentials = true
+	c.AllowOrigins = []string{"https://metalview.example.com", "https://*.metal.example.com"}
+	c.Groups["/swagger"] = CorsPolicy{AllowOrigins: []string{"*"}}
+
+	h, err := c.Middleware()
+	assert.Equal(t, nil, err)
+
+	e := gin.New()
+	e.Use(h)
+	e.GET("/nodes/1", func(ctx *gin.Context) { ctx.Status(http.StatusOK) })
+	e.GET("/swagger/index.html", func(ctx *gin.Context) { ctx.Status(http.StatusOK) })
+
+	preflight := func(path, origin string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("OPTIONS", path, nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", "GET")
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := preflight("/nodes/1", "https://metalview.example.com")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "https://metalview.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", rec.Header().Get("Access-Control-Allow-Credentials"))
+
+	rec = preflight("/nodes/1", "https://a.b.metal.example.com")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec = preflight("/nodes/1", "https://metal.example.com")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = preflight("/nodes/1", "https://evil.com")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = preflight("/swagger/index.html", "https://evil.com")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "*", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "", rec.Header().Get("Access-Control-Allow-Credentials"))
+
+	c.Groups["/swagger"] = CorsPolicy{AllowCredentials: true, AllowOrigins: []string{"*"}}
+	_, err = c.Middleware()
+	assert.NotEqual(t, nil, err)
+
+	c.Groups["/swagger"] = CorsPolicy{AllowOrigins: []string{"metalview.example.com"}}
+	_, err = c.Middleware()
+	assert.NotEqual(t, nil, err)
+}
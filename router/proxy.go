@@ -0,0 +1,106 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package router
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+const (
+	forwardedFor = "X-Forwarded-For"
+	realIp       = "X-Real-Ip"
+)
+
+// Proxies are the addresses or CIDRs of the proxies trusted to tell the
+// address of the client by X-Forwarded-For or X-Real-Ip, which are ignored
+// from anyone else.
+type Proxies []string
+
+// Middleware resolves the address of the client, walking X-Forwarded-For
+// from the right for as long as the hops are trusted proxies, and leaves it
+// as the only one of X-Forwarded-For. Hence gin.Context.ClientIP, which
+// takes the first one, returns it for the rate limits, audit and logs.
+func (p Proxies) Middleware() (gin.HandlerFunc, error) {
+	nets := make([]*net.IPNet, 0, len(p))
+
+	for _, item := range p {
+		if !strings.Contains(item, "/") {
+			if ip := net.ParseIP(item); ip != nil && ip.To4() != nil {
+				item += "/32"
+			} else {
+				item += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid proxy")
+		}
+		nets = append(nets, n)
+	}
+
+	trusted := func(ip net.IP) bool {
+		for _, n := range nets {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(ctx *gin.Context) {
+		client := remoteIp(ctx.Request)
+		h := ctx.Request.Header
+
+		var hops []string
+		for _, v := range h.Values(forwardedFor) {
+			for _, item := range strings.Split(v, ",") {
+				hops = append(hops, strings.TrimSpace(item))
+			}
+		}
+
+		if len(hops) == 0 && h.Get(realIp) != "" {
+			hops = []string{strings.TrimSpace(h.Get(realIp))}
+		}
+
+		for i := len(hops) - 1; i >= 0 && client != nil && trusted(client); i-- {
+			ip := net.ParseIP(hops[i])
+			if ip == nil {
+				break
+			}
+			client = ip
+		}
+
+		h.Del(forwardedFor)
+		h.Del(realIp)
+
+		if client != nil {
+			h.Set(forwardedFor, client.String())
+		}
+
+		ctx.Next()
+	}, nil
+}
+
+func remoteIp(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(strings.TrimSpace(r.RemoteAddr))
+	if err != nil {
+		return nil
+	}
+
+	return net.ParseIP(host)
+}
@@ -0,0 +1,57 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxies(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	_, err := Proxies{"10.0.0.0/33"}.Middleware()
+	assert.NotEqual(t, nil, err)
+
+	h, err := Proxies{"10.0.0.0/8", "192.168.0.1"}.Middleware()
+	assert.Equal(t, nil, err)
+
+	e := gin.New()
+	e.Use(h)
+	e.GET("/ip", func(ctx *gin.Context) { ctx.String(http.StatusOK, ctx.ClientIP()) })
+
+	ip := func(remote string, header map[string]string) string {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/ip", nil)
+		req.RemoteAddr = remote + ":1234"
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		e.ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+
+	// Spoofed by a client.
+	assert.Equal(t, "1.2.3.4", ip("1.2.3.4", map[string]string{"X-Forwarded-For": "5.6.7.8"}))
+	assert.Equal(t, "1.2.3.4", ip("1.2.3.4", map[string]string{"X-Real-Ip": "5.6.7.8"}))
+
+	// Told by trusted proxies, ignoring the hops the client made up.
+	assert.Equal(t, "5.6.7.8", ip("10.0.0.1", map[string]string{"X-Forwarded-For": "5.6.7.8"}))
+	assert.Equal(t, "5.6.7.8", ip("10.0.0.1", map[string]string{"X-Forwarded-For": "9.9.9.9, 5.6.7.8, 192.168.0.1"}))
+	assert.Equal(t, "5.6.7.8", ip("192.168.0.1", map[string]string{"X-Real-Ip": "5.6.7.8"}))
+	assert.Equal(t, "10.0.0.1", ip("10.0.0.1", nil))
+}
@@ -14,21 +14,28 @@ package router
 
 import (
 	"context"
-	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
-	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
-	"github.com/swaggo/files"
-	"github.com/swaggo/gin-swagger"
 
+	"github.com/craftslab/metalflow/artifact"
+	"github.com/craftslab/metalflow/audit"
 	"github.com/craftslab/metalflow/auth"
+	"github.com/craftslab/metalflow/cluster"
 	"github.com/craftslab/metalflow/controller"
+	"github.com/craftslab/metalflow/docs/v1"
+	"github.com/craftslab/metalflow/exec"
+	"github.com/craftslab/metalflow/flow"
+	"github.com/craftslab/metalflow/logger"
+	"github.com/craftslab/metalflow/postgres"
+	"github.com/craftslab/metalflow/ratelimit"
+	"github.com/craftslab/metalflow/schedule"
+	"github.com/craftslab/metalflow/tracing"
 	"github.com/craftslab/metalflow/util"
 )
 
@@ -38,38 +45,97 @@ const (
 
 type Router interface {
 	Init() error
+	Handler() http.Handler
 	Run() error
 }
 
 type Config struct {
-	Addr string
+	Addr      string
+	Artifact  *artifact.Config
+	Auth      *auth.Config
+	Cluster   *cluster.Config
+	Cors      *Cors
+	Exec      *exec.Config
+	Flow      *flow.Config
+	Postgres  postgres.Postgres
+	Proxies   Proxies
+	RateLimit *ratelimit.Config
+	Schedule  *schedule.Config
+	Sunset    time.Time
 }
 
 type router struct {
-	auth   auth.Auth
-	config *Config
-	engine *gin.Engine
+	artifact artifact.Artifacts
+	audit    audit.Store
+	auth     auth.Auth
+	cluster  cluster.Cluster
+	config   *Config
+	engine   *gin.Engine
+	exec     exec.Exec
+	flow     flow.Engine
+	schedule schedule.Scheduler
 }
 
 func New(config *Config) Router {
 	return &router{
-		auth:   nil,
-		config: config,
-		engine: nil,
+		artifact: nil,
+		audit:    nil,
+		auth:     nil,
+		cluster:  nil,
+		config:   config,
+		engine:   nil,
+		exec:     nil,
+		flow:     nil,
+		schedule: nil,
 	}
 }
 
 func DefaultConfig() *Config {
 	return &Config{
-		Addr: ":9080",
+		Addr:      ":9080",
+		Artifact:  artifact.DefaultConfig(),
+		Auth:      auth.DefaultConfig(),
+		Cluster:   cluster.DefaultConfig(),
+		Cors:      DefaultCors(),
+		Exec:      exec.DefaultConfig(),
+		Flow:      flow.DefaultConfig(),
+		Postgres:  nil,
+		Proxies:   Proxies{},
+		RateLimit: ratelimit.DefaultConfig(),
+		Schedule:  schedule.DefaultConfig(),
+		Sunset:    time.Time{},
 	}
 }
 
 func (r *router) Init() error {
+	if err := r.initAudit(); err != nil {
+		return errors.Wrap(err, "failed to init audit")
+	}
+
 	if err := r.initAuth(); err != nil {
 		return errors.Wrap(err, "failed to init auth")
 	}
 
+	if err := r.initArtifact(); err != nil {
+		return errors.Wrap(err, "failed to init artifact")
+	}
+
+	if err := r.initExec(); err != nil {
+		return errors.Wrap(err, "failed to init exec")
+	}
+
+	if err := r.initFlow(); err != nil {
+		return errors.Wrap(err, "failed to init flow")
+	}
+
+	if err := r.initSchedule(); err != nil {
+		return errors.Wrap(err, "failed to init schedule")
+	}
+
+	if err := r.initCluster(); err != nil {
+		return errors.Wrap(err, "failed to init cluster")
+	}
+
 	if err := r.initRoute(); err != nil {
 		return errors.Wrap(err, "failed to init route")
 	}
@@ -81,8 +147,31 @@ func (r *router) Init() error {
 	return nil
 }
 
+func (r *router) initAudit() error {
+	if r.config.Postgres == nil {
+		r.audit = audit.NewMemoryStore()
+		return nil
+	}
+
+	var err error
+
+	if r.audit, err = audit.NewPostgresStore(r.config.Postgres); err != nil {
+		return errors.Wrap(err, "failed to new store")
+	}
+
+	return nil
+}
+
 func (r *router) initAuth() error {
-	r.auth = auth.New(auth.DefaultConfig())
+	if r.config.Postgres != nil {
+		s, err := auth.NewPostgresTotpStore(r.config.Postgres)
+		if err != nil {
+			return errors.Wrap(err, "failed to new totp store")
+		}
+		r.config.Auth.TotpStore = s
+	}
+
+	r.auth = auth.New(r.config.Auth)
 	if r.auth == nil {
 		return errors.New("failed to new Auth")
 	}
@@ -94,80 +183,290 @@ func (r *router) initAuth() error {
 	return nil
 }
 
+// initArtifact keeps the artifacts in PostgreSQL if any, while their content
+// is kept in the blobs of the config.
+func (r *router) initArtifact() error {
+	if r.config.Postgres != nil {
+		s, err := artifact.NewPostgresStore(r.config.Postgres)
+		if err != nil {
+			return errors.Wrap(err, "failed to new store")
+		}
+		r.config.Artifact.Store = s
+	}
+
+	r.artifact = artifact.New(r.config.Artifact)
+	if r.artifact == nil {
+		return errors.New("failed to new artifact")
+	}
+
+	return nil
+}
+
+func (r *router) initExec() error {
+	if r.config.Postgres != nil {
+		s, err := exec.NewPostgresStore(r.config.Postgres)
+		if err != nil {
+			return errors.Wrap(err, "failed to new store")
+		}
+		r.config.Exec.Store = s
+	}
+
+	r.exec = exec.New(r.config.Exec)
+	if r.exec == nil {
+		return errors.New("failed to new exec")
+	}
+
+	return nil
+}
+
+func (r *router) initFlow() error {
+	if r.config.Postgres != nil {
+		s, err := flow.NewPostgresStore(r.config.Postgres)
+		if err != nil {
+			return errors.Wrap(err, "failed to new store")
+		}
+		r.config.Flow.Store = s
+	}
+
+	r.config.Flow.Allow = r.config.Exec.Allow
+	r.config.Flow.Artifacts = r.artifact
+
+	r.flow = flow.New(r.config.Flow)
+	if r.flow == nil {
+		return errors.New("failed to new flow")
+	}
+
+	return nil
+}
+
+func (r *router) initSchedule() error {
+	if r.config.Postgres != nil {
+		s, err := schedule.NewPostgresStore(r.config.Postgres)
+		if err != nil {
+			return errors.Wrap(err, "failed to new store")
+		}
+		r.config.Schedule.Store = s
+	}
+
+	r.config.Schedule.Allow = r.config.Exec.Allow
+
+	r.schedule = schedule.New(r.config.Schedule)
+	if r.schedule == nil {
+		return errors.New("failed to new schedule")
+	}
+
+	return nil
+}
+
+// initCluster runs the flows and schedules on the leader only.
+func (r *router) initCluster() error {
+	r.config.Cluster.Jobs = []cluster.Job{r.flow, r.schedule}
+
+	r.cluster = cluster.New(r.config.Cluster)
+	if r.cluster == nil {
+		return errors.New("failed to new cluster")
+	}
+
+	if err := r.cluster.Start(context.Background()); err != nil {
+		return errors.Wrap(err, "failed to start cluster")
+	}
+
+	return nil
+}
+
 func (r *router) initRoute() error {
 	r.engine = gin.New()
 	if r.engine == nil {
 		return errors.New("failed to new gin")
 	}
 
-	r.engine.Use(cors.New(cors.Config{
-		AllowCredentials: true,
-		AllowHeaders:     []string{"*"},
-		AllowMethods:     []string{"DELETE", "GET", "PATCH", "POST", "PUT"},
-		AllowOrigins:     []string{"*"},
-		AllowOriginFunc: func(origin string) bool {
-			return true
-		},
-		ExposeHeaders: []string{"Content-Type"},
-		MaxAge:        24 * time.Hour,
-	}))
+	p, err := r.config.Proxies.Middleware()
+	if err != nil {
+		return errors.Wrap(err, "failed to init proxies")
+	}
+
+	c, err := r.config.Cors.Middleware()
+	if err != nil {
+		return errors.Wrap(err, "failed to init cors")
+	}
+
+	r.engine.Use(p)
+	r.engine.Use(c)
 
-	r.engine.Use(gin.Logger())
+	r.engine.Use(logger.RequestIdMiddleware())
+	r.engine.Use(tracing.Middleware())
+	r.engine.Use(logger.AccessLog(auth.Identity))
 	r.engine.Use(gin.Recovery())
+	r.engine.Use(util.Problems())
+
+	// Track writes per request so that reads following them go to the primary.
+	r.engine.Use(func(ctx *gin.Context) {
+		ctx.Request = ctx.Request.WithContext(postgres.NewSession(ctx.Request.Context()))
+		ctx.Next()
+	})
 
 	return nil
 }
 
 func (r *router) setRoute() error {
-	ctrl := controller.New(controller.DefaultConfig())
+	cfg := controller.DefaultConfig()
+	cfg.Artifact = r.artifact
+	cfg.Audit = r.audit
+	cfg.Cluster = r.cluster
+	cfg.Exec = r.exec
+	cfg.Flow = r.flow
+	cfg.Identity = auth.Identity
+	cfg.Role = auth.Role
+	cfg.Schedule = r.schedule
+
+	ctrl := controller.New(cfg)
 	if ctrl == nil {
 		return errors.New("failed to new controller")
 	}
 
-	au := r.engine.Group("/auth")
-	au.POST("login", r.auth.Middleware().LoginHandler)
-	au.GET("refresh", r.auth.Middleware().RefreshHandler)
+	recorder := audit.Middleware(r.audit, auth.Identity)
+	limiter := ratelimit.New(r.config.RateLimit)
+
+	versions := []version{
+		{doc: v1.Doc, name: "v1", set: r.setV1},
+	}
+
+	for _, v := range versions {
+		v.set(r.engine.Group("/api/"+v.name), ctrl, limiter, recorder)
+	}
+
+	// The routes before versioning are kept as deprecated aliases of v1.
+	r.setV1(r.engine.Group("", deprecated("/api/v1", r.config.Sunset)), ctrl, limiter, recorder)
+
+	r.engine.GET("/swagger/*any", swagger(versions))
+
+	r.engine.NoRoute(r.auth.Middleware().MiddlewareFunc(), func(ctx *gin.Context) {
+		util.NewError(ctx, http.StatusNotFound, errors.New("Page not found"))
+	})
+
+	return nil
+}
 
-	ac := r.engine.Group("/accounts")
-	ac.Use(r.auth.Middleware().MiddlewareFunc())
+// setV1 sets the routes of v1. The recorder precedes the authentication and
+// rate limits of every group, so that the requests they reject are audited.
+func (r *router) setV1(g *gin.RouterGroup, ctrl controller.Controller, limiter ratelimit.RateLimit, recorder gin.HandlerFunc) {
+	au := g.Group("/auth")
+	au.Use(recorder)
+	au.POST("login", limiter.Login(r.auth.LoginSubject), r.auth.Login)
+	au.POST("login/otp", limiter.Login(r.auth.MfaSubject), r.auth.LoginOtp)
+	au.GET("refresh", limiter.Token(), r.auth.Middleware().RefreshHandler)
+	au.GET("oidc/login", r.auth.OidcLogin)
+	au.GET("oidc/callback", limiter.Login(nil), r.auth.OidcCallback)
+
+	tp := au.Group("/totp")
+	tp.Use(r.auth.Middleware().MiddlewareFunc(), limiter.Token())
+	tp.POST("", r.auth.TotpEnroll)
+	tp.POST("verify", r.auth.TotpVerify)
+	tp.DELETE("", r.auth.TotpDisable)
+
+	ac := g.Group("/accounts")
+	ac.Use(recorder, r.auth.Middleware().MiddlewareFunc(), auth.RequireAdminOrSelf(), auth.Scope(), limiter.Token())
 	ac.GET(":id", ctrl.GetAccount)
 	ac.GET("/", ctrl.QueryAccount)
-
-	c := r.engine.Group("/config")
-	c.Use(r.auth.Middleware().MiddlewareFunc())
+	ac.PATCH(":id", ctrl.PatchAccount)
+
+	ar := g.Group("/artifacts")
+	// The content is downloaded by the workers through signed URLs, without a token.
+	ar.GET(":sha256/content", recorder, ctrl.GetArtifactContent)
+	ar.Use(recorder, r.auth.Middleware().MiddlewareFunc(), auth.Scope(), limiter.Token())
+	ar.GET(":sha256", ctrl.GetArtifact)
+	ar.GET("/", ctrl.QueryArtifact)
+	ar.POST("/", ctrl.AddArtifact)
+
+	ad := g.Group("/audit")
+	ad.Use(recorder, r.auth.Middleware().MiddlewareFunc(), auth.RequireAdmin(), limiter.Token())
+	ad.GET("/", ctrl.QueryAudit)
+
+	c := g.Group("/config")
+	c.Use(recorder, r.auth.Middleware().MiddlewareFunc(), auth.RequireAdmin(), limiter.Token())
 	c.GET("server/version", ctrl.GetServerVersion)
 
-	n := r.engine.Group("/nodes")
-	n.Use(r.auth.Middleware().MiddlewareFunc())
+	cl := g.Group("/cluster")
+	cl.Use(recorder, r.auth.Middleware().MiddlewareFunc(), limiter.Token())
+	cl.GET("leader", ctrl.GetLeader)
+
+	n := g.Group("/nodes")
+	n.Use(recorder, r.auth.Middleware().MiddlewareFunc(), auth.Scope(), limiter.Token())
+	// GET /nodes/export is served by GetNode, and POST /nodes/import by
+	// ImportNode as :id, since they conflict with :id.
+	// The routes of :id precede those below it for gin to report their path.
 	n.GET(":id", ctrl.GetNode)
 	n.GET(":id/health", ctrl.GetHealth)
 	n.GET(":id/info", ctrl.GetInfo)
 	n.GET(":id/perf", ctrl.GetPerf)
+	n.GET(":id/transitions", ctrl.QueryTransition)
+	n.GET(":id/exec", ctrl.QueryExec)
 	n.GET("/", ctrl.QueryNode)
 	n.PUT(":id", ctrl.AddNode)
+	n.PUT(":id/labels", ctrl.SetLabels)
+	n.PUT(":id/lifecycle", ctrl.SetLifecycle)
+	n.PUT(":id/maintenance", ctrl.EnterMaintenance)
+	n.PATCH(":id", ctrl.PatchNode)
+	n.PATCH(":id/labels", ctrl.PatchLabels)
 	n.DELETE(":id", ctrl.DelNode)
+	n.DELETE(":id/maintenance", ctrl.ExitMaintenance)
+	n.POST(":id/exec", ctrl.ExecNode)
+	n.POST(":id", ctrl.ImportNode)
+
+	// Flows are run by the leader, which alone can pause, resume or abort them,
+	// and audits the requests forwarded to it.
+	f := g.Group("/flows")
+	f.Use(r.cluster.Forward(), recorder, r.auth.Middleware().MiddlewareFunc(), auth.Scope(), limiter.Token())
+	f.GET(":id", ctrl.GetFlow)
+	f.GET("/", ctrl.QueryFlow)
+	f.POST("/", ctrl.SubmitFlow)
+	f.POST(":id/pause", ctrl.PauseFlow)
+	f.POST(":id/resume", ctrl.ResumeFlow)
+	f.POST(":id/abort", ctrl.AbortFlow)
+
+	p := g.Group("/projects")
+	p.Use(recorder, r.auth.Middleware().MiddlewareFunc(), auth.Scope(), limiter.Token())
+	p.GET(":id", ctrl.GetProject)
+	p.GET("/", ctrl.QueryProject)
+	p.POST("/", ctrl.AddProject)
+	p.GET(":id/members", ctrl.QueryMember)
+	p.PUT(":id/members/:username", ctrl.SetMember)
+	p.DELETE(":id/members/:username", ctrl.DelMember)
+
+	s := g.Group("/schedules")
+	s.Use(recorder, r.auth.Middleware().MiddlewareFunc(), auth.Scope(), limiter.Token())
+	s.GET(":id", ctrl.GetSchedule)
+	s.GET(":id/runs", ctrl.QueryScheduleRun)
+	s.GET("/", ctrl.QuerySchedule)
+	s.POST("/", ctrl.AddSchedule)
+	s.PUT(":id", ctrl.UpdateSchedule)
+	s.DELETE(":id", ctrl.DelSchedule)
+}
 
-	r.engine.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
-
-	r.engine.NoRoute(r.auth.Middleware().MiddlewareFunc(), func(ctx *gin.Context) {
-		util.NewError(ctx, http.StatusNotFound, errors.New("Page not found"))
-	})
-
-	return nil
+// Handler returns the handler of the routes, once initialized.
+func (r *router) Handler() http.Handler {
+	return r.engine
 }
 
 func (r *router) Run() error {
+	// The output of commands is streamed for as long as they may run.
+	write := 10 * time.Second
+	if t := r.config.Exec.Timeout + timeout; t > write {
+		write = t
+	}
+
 	srv := &http.Server{
 		Addr:           r.config.Addr,
 		Handler:        r.engine,
 		ReadTimeout:    10 * time.Second,
-		WriteTimeout:   10 * time.Second,
+		WriteTimeout:   write,
 		MaxHeaderBytes: 1 << 20,
 	}
 
 	go func() {
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("failed to listen and serve: %v", err)
+			logger.Error(context.Background(), "failed to listen and serve", "error", err)
+			os.Exit(1)
 		}
 	}()
 
@@ -180,12 +479,18 @@ func (r *router) Run() error {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	log.Println("shutdown server...")
+	logger.Info(context.Background(), "shutdown server")
 
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
-	if err := srv.Shutdown(ctx); err != nil {
+	err := srv.Shutdown(ctx)
+
+	// Resign once the requests are served, or the shutdown timed out, for
+	// another master to take over.
+	r.cluster.Stop()
+
+	if err != nil {
 		return errors.Wrap(err, "failed to shutdown")
 	}
 
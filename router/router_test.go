@@ -14,15 +14,29 @@ package router
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/craftslab/metalflow/artifact"
+	"github.com/craftslab/metalflow/audit"
+	"github.com/craftslab/metalflow/auth"
+	"github.com/craftslab/metalflow/cluster"
 	"github.com/craftslab/metalflow/config"
+	"github.com/craftslab/metalflow/exec"
+	"github.com/craftslab/metalflow/flow"
+	"github.com/craftslab/metalflow/logger"
+	"github.com/craftslab/metalflow/model"
+	"github.com/craftslab/metalflow/schedule"
+	"github.com/craftslab/metalflow/util"
 )
 
 var (
@@ -37,12 +51,36 @@ type Response struct {
 
 func TestRouter(t *testing.T) {
 	r := &router{
+		audit:  nil,
 		auth:   nil,
 		config: DefaultConfig(),
 		engine: nil,
 	}
 
-	err := r.initAuth()
+	r.config.Exec.Streamer = exec.StreamerFunc(func(_ context.Context, _, _, command string, fn func(exec.Event) error) (int, error) {
+		return 0, fn(exec.Event{Data: command + "\n", Stream: exec.StreamStdout})
+	})
+	r.config.Sunset = time.Date(2027, time.June, 30, 0, 0, 0, 0, time.UTC)
+
+	err := r.initAudit()
+	assert.Equal(t, nil, err)
+
+	err = r.initAuth()
+	assert.Equal(t, nil, err)
+
+	err = r.initArtifact()
+	assert.Equal(t, nil, err)
+
+	err = r.initExec()
+	assert.Equal(t, nil, err)
+
+	err = r.initFlow()
+	assert.Equal(t, nil, err)
+
+	err = r.initSchedule()
+	assert.Equal(t, nil, err)
+
+	err = r.initCluster()
 	assert.Equal(t, nil, err)
 
 	err = r.initRoute()
@@ -54,7 +92,52 @@ func TestRouter(t *testing.T) {
 	testAuth(r, t)
 	testAccounts(r, t)
 	testConfig(r, t)
+	testCluster(r, t)
+	testVersions(r, t)
 	testNodes(r, t)
+	testExec(r, t)
+	testArtifacts(r, t)
+	testProjects(r, t)
+	testFlows(r, t)
+	testSchedules(r, t)
+	testAudit(r, t)
+}
+
+func TestEnroll(t *testing.T) {
+	c := DefaultConfig()
+	c.Auth.Totp.RequiredRoles = []string{model.RoleAdmin}
+
+	r := New(c)
+	err := r.Init()
+	assert.Equal(t, nil, err)
+
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		r.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do("POST", "/api/v1/auth/login", "", `{"username":"admin","password":"admin"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var resp Response
+	err = json.Unmarshal(rec.Body.Bytes(), &resp)
+	assert.Equal(t, nil, err)
+
+	// The token of a user to enroll only allows the enrollment API.
+	rec = do("GET", "/api/v1/nodes/1", resp.Token, "")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = do("POST", "/api/v1/auth/totp", resp.Token, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = do("POST", "/api/v1/auth/totp/verify", resp.Token, `{"otp":"000000"}`)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
 }
 
 func testAuth(r *router, t *testing.T) {
@@ -76,6 +159,20 @@ func testAuth(r *router, t *testing.T) {
 
 	token = resp.Token
 
+	// Test: /auth/login with an invalid password
+	rec = httptest.NewRecorder()
+	data.Set("password", "invalid")
+	req, _ = http.NewRequest("POST", "/auth/login", bytes.NewBufferString(data.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; param=value")
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, util.ProblemType, rec.Header().Get("Content-Type"))
+
+	var p util.Problem
+	err = json.NewDecoder(rec.Body).Decode(&p)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, auth.CodeInvalidCredentials, p.Code)
+
 	// Test: /auth/refresh
 	rec = httptest.NewRecorder()
 	req, _ = http.NewRequest("GET", "/auth/refresh", nil)
@@ -109,6 +206,31 @@ func testAccounts(r *router, t *testing.T) {
 	r.engine.ServeHTTP(rec, req)
 	assert.Equal(t, http.StatusOK, rec.Code)
 	assert.NotEqual(t, nil, rec.Body.String())
+
+	// Test: PATCH /accounts/1
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("PATCH", "/accounts/1", bytes.NewBufferString(`{"email":"john@example.com"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Set("If-Match", `"1"`)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `"2"`, rec.Header().Get("ETag"))
+
+	// Test: PATCH /accounts/1 with an invalid email
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("PATCH", "/accounts/1", bytes.NewBufferString(`[{"op":"replace","path":"/email","value":"john"}]`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json-patch+json")
+	req.Header.Set("If-Match", `"2"`)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	var p util.Problem
+	err := json.NewDecoder(rec.Body).Decode(&p)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, util.CodeValidationFailed, p.Code)
+	assert.Equal(t, []util.FieldError{{Field: "email", Message: "invalid email"}}, p.Errors)
 }
 
 func testConfig(r *router, t *testing.T) {
@@ -121,6 +243,40 @@ func testConfig(r *router, t *testing.T) {
 	assert.Equal(t, "\""+config.Version+"-build-"+config.Build+"\"", rec.Body.String())
 }
 
+func testVersions(r *router, t *testing.T) {
+	// Test: GET /api/v1/nodes/1
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/nodes/1", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "", rec.Header().Get("Deprecation"))
+
+	// Test: GET /nodes/1 as a deprecated alias of v1
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/nodes/1", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "true", rec.Header().Get("Deprecation"))
+	assert.Equal(t, "Wed, 30 Jun 2027 00:00:00 GMT", rec.Header().Get("Sunset"))
+	assert.Equal(t, `</api/v1/nodes/1>; rel="successor-version"`, rec.Header().Get("Link"))
+
+	// Test: GET /swagger/v1/doc.json
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/swagger/v1/doc.json", nil)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, true, strings.Contains(rec.Body.String(), `"basePath": "/api/v1"`))
+
+	// Test: GET /swagger/index.html
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/swagger/index.html", nil)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusFound, rec.Code)
+	assert.Equal(t, "/swagger/v1/index.html", rec.Header().Get("Location"))
+}
+
 func testNodes(r *router, t *testing.T) {
 	// Test: GET /nodes/1
 	rec := httptest.NewRecorder()
@@ -130,6 +286,17 @@ func testNodes(r *router, t *testing.T) {
 	assert.Equal(t, http.StatusOK, rec.Code)
 	assert.NotEqual(t, nil, rec.Body.String())
 
+	tag := rec.Header().Get("ETag")
+	assert.NotEqual(t, "", tag)
+
+	// Test: GET /nodes/1 with If-None-Match
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/nodes/1", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("If-None-Match", tag)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+
 	// Test: GET /nodes/1/health
 	rec = httptest.NewRecorder()
 	req, _ = http.NewRequest("GET", "/nodes/1/health", nil)
@@ -138,6 +305,21 @@ func testNodes(r *router, t *testing.T) {
 	assert.Equal(t, http.StatusOK, rec.Code)
 	assert.NotEqual(t, nil, rec.Body.String())
 
+	// Test: GET /nodes/100/health
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/nodes/100/health", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set(logger.RequestIdHeader, "test")
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	var p util.Problem
+	err := json.NewDecoder(rec.Body).Decode(&p)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "node.not_found", p.Code)
+	assert.Equal(t, "/nodes/100/health", p.Instance)
+	assert.Equal(t, "test", p.RequestId)
+
 	// Test: GET /nodes/1/info
 	rec = httptest.NewRecorder()
 	req, _ = http.NewRequest("GET", "/nodes/1/info", nil)
@@ -156,9 +338,503 @@ func testNodes(r *router, t *testing.T) {
 
 	// Test: /nodes/?q=127.0.0.1
 	rec = httptest.NewRecorder()
-	req, _ = http.NewRequest("GET", "/accounts/?q=127.0.0.1", nil)
+	req, _ = http.NewRequest("GET", "/nodes/?q=127.0.0.1", nil)
 	req.Header.Set("Authorization", "Bearer "+token)
 	r.engine.ServeHTTP(rec, req)
 	assert.Equal(t, http.StatusOK, rec.Code)
 	assert.NotEqual(t, nil, rec.Body.String())
+
+	// Test: PUT /nodes/1/maintenance without If-Match
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("PUT", "/nodes/1/maintenance", bytes.NewBufferString(`{"reason":"kernel upgrade"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusPreconditionRequired, rec.Code)
+
+	// Test: PUT /nodes/1/maintenance
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("PUT", "/nodes/1/maintenance", bytes.NewBufferString(`{"reason":"kernel upgrade"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", tag)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEqual(t, tag, rec.Header().Get("ETag"))
+
+	// Test: PUT /nodes/1/maintenance with a stale If-Match
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("PUT", "/nodes/1/maintenance", bytes.NewBufferString(`{"reason":"kernel upgrade"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", tag)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+
+	// Test: PUT /nodes/1/lifecycle to retired
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("PUT", "/nodes/1/lifecycle", bytes.NewBufferString(`{"state":"retired"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("If-Match", "*")
+	req.Header.Set("Content-Type", "application/json")
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	// Test: DELETE /nodes/1/maintenance
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/nodes/1/maintenance", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("If-Match", "*")
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Test: GET /nodes/1/transitions
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/nodes/1/transitions", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Test: PATCH /nodes/1 with a merge patch
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("PATCH", "/nodes/1", bytes.NewBufferString(`{"comments":"rack 4","labels":{"tier":"gold"}}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Set("If-Match", "*")
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Test: PATCH /nodes/1 with a JSON patch
+	rec = httptest.NewRecorder()
+	body := `[{"op":"test","path":"/comments","value":"rack 4"},{"op":"remove","path":"/labels/tier"},{"op":"replace","path":"/region","value":"Beijing"}]`
+	req, _ = http.NewRequest("PATCH", "/nodes/1", bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json-patch+json")
+	req.Header.Set("If-Match", "*")
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var n model.Node
+	err = json.Unmarshal(rec.Body.Bytes(), &n)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "Beijing", n.Region)
+	assert.Equal(t, "", n.Labels["tier"])
+
+	// Test: PATCH /nodes/1 of a field which cannot be changed
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("PATCH", "/nodes/1", bytes.NewBufferString(`{"address":"10.0.0.1"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Set("If-Match", "*")
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// Test: PATCH /nodes/1 as JSON
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("PATCH", "/nodes/1", bytes.NewBufferString(`{"comments":""}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", "*")
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+
+	// Test: PATCH /nodes/1/labels
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("PATCH", "/nodes/1/labels", bytes.NewBufferString(`{"zone":"a","role":null}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("If-Match", "*")
+	req.Header.Set("Content-Type", "application/json")
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Test: GET /nodes/?selector=zone=a,!role
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/nodes/?selector="+url.QueryEscape("zone=a,!role"), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var nodes []model.Node
+	err = json.Unmarshal(rec.Body.Bytes(), &nodes)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(nodes))
+	assert.Equal(t, uint(1), nodes[0].Id)
+
+	// Test: POST /nodes/import?dryRun=true
+	rec = httptest.NewRecorder()
+	body = "address,region,labels\n10.0.0.1,Shanghai,\"env=prod,role=db\"\n127.0.0.1,Shanghai,\n"
+	req, _ = http.NewRequest("POST", "/nodes/import?dryRun=true", bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "text/csv")
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var report model.ImportReport
+	err = json.Unmarshal(rec.Body.Bytes(), &report)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, report.Created)
+	assert.Equal(t, 1, report.Updated)
+
+	// Test: POST /nodes/import?format=yaml
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/nodes/import?format=yaml", bytes.NewBufferString("- address: 10.0.0.1\n  project: 9\n"))
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	// Test: GET /nodes/export?format=csv
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/nodes/export?format=csv", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "address,asset,comments,info,labels,project,region", strings.SplitN(rec.Body.String(), "\n", 2)[0])
+
+	// Test: GET /nodes/?selector=role+in+(db
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/nodes/?selector="+url.QueryEscape("role in (db"), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func testExec(r *router, t *testing.T) {
+	// Test: POST /nodes/1/exec
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/nodes/1/exec", bytes.NewBufferString(`{"command":"uptime"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/event-stream", rec.Header().Get("Content-Type"))
+	assert.Equal(t, true, strings.HasPrefix(rec.Body.String(), "event:stdout\ndata:uptime\ndata:\n\nevent:exit\n"))
+
+	// Test: POST /nodes/100/exec
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/nodes/100/exec", bytes.NewBufferString(`{"command":"uptime"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// Test: GET /nodes/1/exec
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/nodes/1/exec", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var buf []exec.Execution
+	err := json.Unmarshal(rec.Body.Bytes(), &buf)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(buf))
+	assert.Equal(t, exec.StateSucceeded, buf[0].State)
+}
+
+func testArtifacts(r *router, t *testing.T) {
+	// Test: POST /artifacts/?project=1&name=foo.txt
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/artifacts/?project=1&name=foo.txt", bytes.NewBufferString("foo"))
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var a artifact.Artifact
+	err := json.Unmarshal(rec.Body.Bytes(), &a)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae", a.Sha256)
+
+	// Test: GET /artifacts/{sha256}/content without a token
+	u, err := url.Parse(a.Url)
+	assert.Equal(t, nil, err)
+
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", u.RequestURI(), nil)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "foo", rec.Body.String())
+	assert.Equal(t, "attachment; filename=foo.txt", rec.Header().Get("Content-Disposition"))
+
+	// Test: GET /artifacts/{sha256}/content with an invalid signature
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/artifacts/"+a.Sha256+"/content?project=1&expires=1&signature=00", nil)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// Test: GET /artifacts/{sha256} without a token
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/artifacts/"+a.Sha256+"?project=1", nil)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// Test: GET /artifacts/
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/artifacts/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Test: GET /artifacts/{sha256} of unknown content
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/artifacts/"+strings.Repeat("0", 64)+"?project=1", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// Test: GET /artifacts/{sha256} without a project
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/artifacts/"+a.Sha256, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func testProjects(r *router, t *testing.T) {
+	// Test: GET /projects/
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/projects/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var projects []model.Project
+	err := json.Unmarshal(rec.Body.Bytes(), &projects)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(projects))
+
+	// A member only sees its projects, and nothing but them.
+	rec = httptest.NewRecorder()
+	data := url.Values{}
+	data.Set("username", "john")
+	data.Set("password", "john")
+	req, _ = http.NewRequest("POST", "/auth/login", bytes.NewBufferString(data.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; param=value")
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var resp Response
+	err = json.Unmarshal(rec.Body.Bytes(), &resp)
+	assert.Equal(t, nil, err)
+
+	get := func(path string) int {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer "+resp.Token)
+		r.engine.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, get("/nodes/1"))
+	assert.Equal(t, http.StatusNotFound, get("/nodes/0"))
+	assert.Equal(t, http.StatusOK, get("/projects/1/members"))
+	assert.Equal(t, http.StatusNotFound, get("/projects/0"))
+	assert.Equal(t, http.StatusForbidden, get("/accounts/0"))
+	assert.Equal(t, http.StatusForbidden, get("/accounts/?q=admin"))
+	assert.Equal(t, http.StatusForbidden, get("/config/server/version"))
+
+	// Test: GET /accounts/self
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/accounts/self", nil)
+	req.Header.Set("Authorization", "Bearer "+resp.Token)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, true, strings.Contains(rec.Body.String(), `"username":"john"`))
+	assert.Equal(t, false, strings.Contains(rec.Body.String(), "password"))
+
+	// Test: PUT /projects/1/members/admin
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("PUT", "/projects/1/members/admin", bytes.NewBufferString(`{"role":"viewer"}`))
+	req.Header.Set("Authorization", "Bearer "+resp.Token)
+	req.Header.Set("Content-Type", "application/json")
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Test: DELETE /projects/1/members/admin
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/projects/1/members/admin", nil)
+	req.Header.Set("Authorization", "Bearer "+resp.Token)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Test: POST /projects/
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/projects/", bytes.NewBufferString(`{"name":"team"}`))
+	req.Header.Set("Authorization", "Bearer "+resp.Token)
+	req.Header.Set("Content-Type", "application/json")
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func testCluster(r *router, t *testing.T) {
+	// Test: GET /cluster/leader
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/cluster/leader", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var s cluster.Status
+	err := json.Unmarshal(rec.Body.Bytes(), &s)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, s.IsLeader)
+	assert.Equal(t, s.Id, s.Leader)
+}
+
+func testFlows(r *router, t *testing.T) {
+	// Test: POST /flows/
+	rec := httptest.NewRecorder()
+	body := "name: reboot\nproject: 1\nsteps:\n  - {name: reboot, type: dispatch, command: reboot}\n"
+	req, _ := http.NewRequest("POST", "/flows/", bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/yaml")
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var f flow.Flow
+	err := json.Unmarshal(rec.Body.Bytes(), &f)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "admin", f.Creator)
+
+	// Test: GET /flows/1
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/flows/"+strconv.Itoa(int(f.Id)), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Test: POST /flows/ with cyclic needs
+	rec = httptest.NewRecorder()
+	body = "name: loop\nsteps:\n  - {name: a, type: health, needs: [a]}\n"
+	req, _ = http.NewRequest("POST", "/flows/", bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// Test: POST /flows/99/pause
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/flows/99/pause", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func testSchedules(r *router, t *testing.T) {
+	// Test: POST /schedules/
+	rec := httptest.NewRecorder()
+	body := `{"name":"rotate","command":"logrotate","cron":"0 2 * * *","timezone":"Asia/Shanghai","project":1}`
+	req, _ := http.NewRequest("POST", "/schedules/", bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var s schedule.Schedule
+	err := json.Unmarshal(rec.Body.Bytes(), &s)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "admin", s.Creator)
+	assert.Equal(t, false, s.NextRun.IsZero())
+
+	// Test: GET /schedules/1/runs
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/schedules/"+strconv.Itoa(int(s.Id))+"/runs", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Test: POST /schedules/ with invalid cron
+	rec = httptest.NewRecorder()
+	body = `{"name":"bad","command":"true","cron":"61 * * * *"}`
+	req, _ = http.NewRequest("POST", "/schedules/", bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// Test: DELETE /schedules/1
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/schedules/"+strconv.Itoa(int(s.Id)), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Test: GET /schedules/1
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/schedules/"+strconv.Itoa(int(s.Id)), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func testAudit(r *router, t *testing.T) {
+	// Test: DELETE /nodes/1
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/nodes/1", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("If-Match", "*")
+	req.Header.Set("X-Request-ID", "audit")
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Test: GET /audit/?actor=admin&requestId=audit
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/audit/?actor=admin&requestId=audit", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var entries []audit.Entry
+	err := json.Unmarshal(rec.Body.Bytes(), &entries)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "DELETE /nodes/:id", entries[0].Action)
+	assert.Equal(t, audit.OutcomeSuccess, entries[0].Outcome)
+
+	// Test: GET /nodes/1
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/nodes/1", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// Test: DELETE /nodes/2 and GET /audit/ rejected before their handlers
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/nodes/2", nil)
+	req.Header.Set("X-Request-ID", "rejected")
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/audit/", nil)
+	req.Header.Set("Authorization", "Bearer forged")
+	req.Header.Set("X-Request-ID", "rejected")
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/audit/?requestId=rejected", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	err = json.Unmarshal(rec.Body.Bytes(), &entries)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(entries))
+	for _, item := range entries {
+		assert.Equal(t, audit.Anonymous, item.Actor)
+		assert.Equal(t, audit.OutcomeFailure, item.Outcome)
+		assert.Equal(t, http.StatusUnauthorized, item.Status)
+	}
+
+	// Test: PUT /nodes/1
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("PUT", "/nodes/1", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+
+	// Test: GET /audit/?format=jsonl
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/audit/?format=jsonl", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.engine.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/x-ndjson", rec.Header().Get("Content-Type"))
 }
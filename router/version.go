@@ -0,0 +1,79 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package router
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/swaggo/files"
+	"github.com/swaggo/gin-swagger"
+
+	"github.com/craftslab/metalflow/controller"
+	"github.com/craftslab/metalflow/ratelimit"
+)
+
+// version is a version of the REST API, mounted at /api/<name>. Versions are
+// served side by side: a new one sets its routes with set, reusing the
+// handlers it keeps, and serves its swagger spec returned by doc at
+// /swagger/<name>/index.html.
+type version struct {
+	doc  func() string
+	name string
+	set  func(*gin.RouterGroup, controller.Controller, ratelimit.RateLimit, gin.HandlerFunc)
+}
+
+// deprecated announces the routes it guards as superseded by those under
+// successor, to be removed at sunset if it is set.
+func deprecated(successor string, sunset time.Time) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Header("Deprecation", "true")
+		if !sunset.IsZero() {
+			ctx.Header("Sunset", sunset.UTC().Format(http.TimeFormat))
+		}
+		ctx.Header("Link", "<"+successor+ctx.Request.URL.Path+`>; rel="successor-version"`)
+		ctx.Next()
+	}
+}
+
+// swagger serves the swagger UI and spec of every version, redirecting the
+// other paths to the UI of the latest one.
+func swagger(versions []version) gin.HandlerFunc {
+	handlers := map[string]gin.HandlerFunc{}
+	docs := map[string]func() string{}
+
+	for _, v := range versions {
+		handlers[v.name] = ginSwagger.WrapHandler(swaggerFiles.Handler, ginSwagger.URL("/swagger/"+v.name+"/doc.json"))
+		docs[v.name] = v.doc
+	}
+
+	latest := "/swagger/" + versions[len(versions)-1].name + "/index.html"
+
+	return func(ctx *gin.Context) {
+		buf := strings.SplitN(strings.TrimPrefix(ctx.Param("any"), "/"), "/", 2)
+		h, ok := handlers[buf[0]]
+		if !ok || len(buf) != 2 || buf[1] == "" {
+			ctx.Redirect(http.StatusFound, latest)
+			return
+		}
+
+		if buf[1] == "doc.json" {
+			ctx.Data(http.StatusOK, "application/json; charset=utf-8", []byte(docs[buf[0]]()))
+			return
+		}
+
+		h(ctx)
+	}
+}
@@ -0,0 +1,46 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schedule
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/craftslab/metalflow/etcd"
+	"github.com/craftslab/metalflow/flow"
+)
+
+const (
+	dispatchKey = "/metalflow/worker/%s/dispatch"
+)
+
+type etcdDispatcher struct {
+	etcd etcd.Etcd
+}
+
+// NewEtcdDispatcher puts the command at /metalflow/worker/{HOST}/dispatch,
+// the key the workers watch for the commands of the master. The workers do
+// not answer there, so a run succeeds on a node once the command is put.
+func NewEtcdDispatcher(e etcd.Etcd) flow.Dispatcher {
+	return &etcdDispatcher{etcd: e}
+}
+
+func (e *etcdDispatcher) Dispatch(ctx context.Context, host, _, command string) (string, error) {
+	if err := e.etcd.Put(ctx, fmt.Sprintf(dispatchKey, host), command); err != nil {
+		return "", errors.Wrap(err, "failed to put")
+	}
+
+	return "", nil
+}
@@ -0,0 +1,540 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/robfig/cron/v3"
+
+	"github.com/craftslab/metalflow/exec"
+	"github.com/craftslab/metalflow/flow"
+	"github.com/craftslab/metalflow/labels"
+	"github.com/craftslab/metalflow/logger"
+	"github.com/craftslab/metalflow/model"
+)
+
+const (
+	MissedRunOnce = "runOnce"
+	MissedSkip    = "skip"
+
+	StateFailed    = "failed"
+	StateRunning   = "running"
+	StateSkipped   = "skipped"
+	StateSucceeded = "succeeded"
+
+	defaultTimeout = 10 * time.Minute
+	maxParallel    = 16
+)
+
+// Scheduler dispatches the command of every schedule to the nodes of its
+// project matching its selector, at the times of its cron expression in its
+// timezone. The runs missed by more than Grace, e.g. while the master was
+// down, are skipped or run once according to the schedule.
+type Scheduler interface {
+	Start(ctx context.Context) error
+	Stop()
+
+	Add(ctx context.Context, schedule *Schedule) (*Schedule, error)
+	Update(ctx context.Context, id uint, schedule *Schedule) (*Schedule, error)
+	Delete(ctx context.Context, id uint) (*Schedule, error)
+	Get(ctx context.Context, id uint) (*Schedule, error)
+	Query(ctx context.Context) ([]Schedule, error)
+	QueryRun(ctx context.Context, id uint, limit int) ([]Run, error)
+}
+
+// Config allows the commands of Allow to every role, like the config of
+// exec does.
+type Config struct {
+	Allow      map[string][]string
+	Dispatcher flow.Dispatcher
+	Grace      time.Duration
+	Interval   time.Duration
+	Store      Store
+}
+
+type Schedule struct {
+	Command   string    `json:"command"`
+	CreatedAt time.Time `json:"createdAt"`
+	Creator   string    `json:"creator"`
+	Cron      string    `json:"cron" example:"0 2 * * *"`
+	Id        uint      `gorm:"primarykey" json:"id"`
+	LastRun   time.Time `json:"lastRun"`
+	Missed    string    `json:"missed" enums:"skip,runOnce"`
+	Name      string    `json:"name"`
+	NextRun   time.Time `json:"nextRun"`
+	Paused    bool      `json:"paused"`
+	Project   uint      `gorm:"index" json:"project"`
+	Selector  string    `json:"selector"`
+	Timeout   string    `json:"timeout" example:"10m"`
+	Timezone  string    `json:"timezone" example:"Asia/Shanghai"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Run is a run of a schedule, with the number of nodes it succeeded and
+// failed on, and the first error.
+type Run struct {
+	Error       string    `json:"error"`
+	Failed      int       `json:"failed"`
+	FinishedAt  time.Time `json:"finishedAt"`
+	Id          uint      `gorm:"primarykey" json:"id"`
+	ScheduleId  uint      `gorm:"index" json:"scheduleId"`
+	ScheduledAt time.Time `json:"scheduledAt"`
+	StartedAt   time.Time `json:"startedAt"`
+	State       string    `json:"state"`
+	Succeeded   int       `json:"succeeded"`
+}
+
+type scheduler struct {
+	cancel  context.CancelFunc
+	config  *Config
+	ctx     context.Context
+	mutex   sync.Mutex
+	running map[uint]bool
+	runs    sync.WaitGroup
+	wg      sync.WaitGroup
+}
+
+var (
+	ErrNotFound = errors.New("invalid id")
+)
+
+func New(config *Config) Scheduler {
+	return &scheduler{
+		config:  config,
+		running: map[uint]bool{},
+	}
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Allow: map[string][]string{
+			model.RoleAdmin: {exec.AllowAll},
+		},
+		Dispatcher: nil,
+		Grace:      time.Minute,
+		Interval:   10 * time.Second,
+		Store:      NewMemoryStore(),
+	}
+}
+
+func (s *scheduler) Start(ctx context.Context) error {
+	buf, err := s.config.Store.QuerySchedule(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to query")
+	}
+
+	// The runs of the last master to stop did not finish.
+	for _, item := range buf {
+		runs, err := s.config.Store.QueryRun(ctx, item.Id, 1)
+		if err != nil {
+			return errors.Wrap(err, "failed to query run")
+		}
+		if len(runs) != 0 && runs[0].State == StateRunning {
+			runs[0].State = StateFailed
+			runs[0].Error = "interrupted"
+			_ = s.config.Store.PutRun(ctx, &runs[0])
+		}
+	}
+
+	s.mutex.Lock()
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.mutex.Unlock()
+
+	s.wg.Add(1)
+
+	go func() {
+		defer s.wg.Done()
+
+		t := time.NewTicker(s.config.Interval)
+		defer t.Stop()
+
+		for {
+			s.tick(time.Now())
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-t.C:
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (s *scheduler) Stop() {
+	s.mutex.Lock()
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mutex.Unlock()
+
+	s.wg.Wait()
+	s.runs.Wait()
+}
+
+func (s *scheduler) Add(ctx context.Context, schedule *Schedule) (*Schedule, error) {
+	if err := s.validate(ctx, schedule); err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	schedule.Id = 0
+	schedule.LastRun = time.Time{}
+	schedule.NextRun = next(schedule, time.Now())
+
+	if err := s.config.Store.AddSchedule(ctx, schedule); err != nil {
+		return nil, errors.Wrap(err, "failed to add")
+	}
+
+	return schedule, nil
+}
+
+func (s *scheduler) Update(ctx context.Context, id uint, schedule *Schedule) (*Schedule, error) {
+	if err := s.validate(ctx, schedule); err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	old, err := s.find(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule.CreatedAt = old.CreatedAt
+	schedule.Creator = old.Creator
+	schedule.Id = old.Id
+	schedule.LastRun = old.LastRun
+	schedule.NextRun = next(schedule, time.Now())
+
+	if err := s.config.Store.PutSchedule(ctx, schedule); err != nil {
+		return nil, errors.Wrap(err, "failed to put")
+	}
+
+	return schedule, nil
+}
+
+func (s *scheduler) Delete(ctx context.Context, id uint) (*Schedule, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	old, err := s.find(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.config.Store.DelSchedule(ctx, id); err != nil {
+		return nil, errors.Wrap(err, "failed to delete")
+	}
+
+	return old, nil
+}
+
+func (s *scheduler) Get(ctx context.Context, id uint) (*Schedule, error) {
+	buf, err := s.config.Store.GetSchedule(ctx, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get")
+	}
+
+	if buf == nil || !model.ScopeOf(ctx).CanRead(buf.Project) {
+		return nil, ErrNotFound
+	}
+
+	return buf, nil
+}
+
+func (s *scheduler) Query(ctx context.Context) ([]Schedule, error) {
+	buf, err := s.config.Store.QuerySchedule(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query")
+	}
+
+	schedules := make([]Schedule, 0, len(buf))
+
+	for _, item := range buf {
+		if model.ScopeOf(ctx).CanRead(item.Project) {
+			schedules = append(schedules, item)
+		}
+	}
+
+	return schedules, nil
+}
+
+// QueryRun returns the last runs of the schedule, newest first.
+func (s *scheduler) QueryRun(ctx context.Context, id uint, limit int) ([]Run, error) {
+	if _, err := s.Get(ctx, id); err != nil {
+		return nil, err
+	}
+
+	return s.config.Store.QueryRun(ctx, id, limit)
+}
+
+// find returns the schedule of id, which the scope of ctx shall be able to
+// write.
+func (s *scheduler) find(ctx context.Context, id uint) (*Schedule, error) {
+	old, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !model.ScopeOf(ctx).CanWrite(old.Project) {
+		return nil, model.ErrForbidden
+	}
+
+	return old, nil
+}
+
+func (s *scheduler) validate(ctx context.Context, schedule *Schedule) error {
+	if schedule.Name == "" {
+		return errors.New("missing name")
+	}
+
+	if schedule.Command == "" {
+		return errors.New("missing command")
+	}
+
+	if _, err := cron.ParseStandard(schedule.Cron); err != nil {
+		return errors.Wrap(err, "invalid cron")
+	}
+
+	if schedule.Timezone == "" {
+		schedule.Timezone = "UTC"
+	}
+
+	if _, err := time.LoadLocation(schedule.Timezone); err != nil {
+		return errors.Wrap(err, "invalid timezone")
+	}
+
+	if _, err := labels.Parse(schedule.Selector); err != nil {
+		return errors.Wrap(err, "invalid selector")
+	}
+
+	switch schedule.Missed {
+	case "":
+		schedule.Missed = MissedSkip
+	case MissedRunOnce, MissedSkip:
+	default:
+		return errors.New("invalid missed")
+	}
+
+	if schedule.Timeout != "" {
+		if d, err := time.ParseDuration(schedule.Timeout); err != nil || d <= 0 {
+			return errors.New("invalid timeout")
+		}
+	}
+
+	if _, err := model.GetProject(ctx, schedule.Project); err != nil {
+		return errors.New("invalid project")
+	}
+
+	if !model.ScopeOf(ctx).CanWrite(schedule.Project) {
+		return model.ErrForbidden
+	}
+
+	if sc := model.ScopeOf(ctx); sc == nil || !exec.Allowed(s.config.Allow[sc.Role], schedule.Command) {
+		return exec.ErrNotAllowed
+	}
+
+	return nil
+}
+
+// tick starts the schedules due at now.
+func (s *scheduler) tick(now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	buf, err := s.config.Store.QuerySchedule(s.ctx)
+	if err != nil {
+		logger.Error(s.ctx, "failed to query schedule", "error", err)
+		return
+	}
+
+	for i := range buf {
+		item := buf[i]
+		if item.Paused || item.NextRun.After(now) {
+			continue
+		}
+
+		at := item.NextRun
+		missed := now.Sub(at) > s.config.Grace
+
+		// Move on first, so that a restart does not run it again.
+		item.NextRun = next(&item, now)
+		if err := s.config.Store.PutSchedule(s.ctx, &item); err != nil {
+			logger.Error(s.ctx, "failed to put schedule", "id", item.Id, "error", err)
+			continue
+		}
+
+		switch {
+		case missed && item.Missed == MissedSkip:
+			s.skip(item.Id, at, "missed")
+		case s.running[item.Id]:
+			s.skip(item.Id, at, "previous run not finished")
+		default:
+			s.running[item.Id] = true
+			s.runs.Add(1)
+			go func() {
+				defer s.runs.Done()
+				s.run(item, at)
+			}()
+		}
+	}
+}
+
+func (s *scheduler) skip(id uint, at time.Time, reason string) {
+	now := time.Now()
+
+	r := Run{
+		Error:       reason,
+		FinishedAt:  now,
+		ScheduleId:  id,
+		ScheduledAt: at,
+		StartedAt:   now,
+		State:       StateSkipped,
+	}
+
+	if err := s.config.Store.AddRun(context.Background(), &r); err != nil {
+		logger.Error(context.Background(), "failed to add run", "schedule", id, "error", err)
+	}
+}
+
+func (s *scheduler) run(item Schedule, at time.Time) {
+	defer func() {
+		s.mutex.Lock()
+		delete(s.running, item.Id)
+		s.mutex.Unlock()
+	}()
+
+	ctx := context.Background()
+
+	r := Run{
+		ScheduleId:  item.Id,
+		ScheduledAt: at,
+		StartedAt:   time.Now(),
+		State:       StateRunning,
+	}
+
+	if err := s.config.Store.AddRun(ctx, &r); err != nil {
+		logger.Error(ctx, "failed to add run", "schedule", item.Id, "error", err)
+		return
+	}
+
+	s.dispatch(item, &r)
+
+	r.FinishedAt = time.Now()
+
+	if r.Error != "" {
+		r.State = StateFailed
+	} else {
+		r.State = StateSucceeded
+	}
+
+	if err := s.config.Store.PutRun(ctx, &r); err != nil {
+		logger.Error(ctx, "failed to put run", "schedule", item.Id, "error", err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if buf, err := s.config.Store.GetSchedule(ctx, item.Id); err == nil && buf != nil {
+		buf.LastRun = r.StartedAt
+		_ = s.config.Store.PutSchedule(ctx, buf)
+	}
+
+	logger.Info(ctx, "schedule run", "schedule", item.Id, "run", r.Id, "state", r.State,
+		"succeeded", r.Succeeded, "failed", r.Failed)
+}
+
+// dispatch runs the command on the target nodes, at most maxParallel at once.
+func (s *scheduler) dispatch(item Schedule, r *Run) {
+	selector, _ := labels.Parse(item.Selector)
+
+	nodes, err := model.QueryNode(model.WithScope(context.Background(), &model.Scope{All: true}), "", selector)
+	if err != nil {
+		r.Error = err.Error()
+		return
+	}
+
+	timeout := defaultTimeout
+	if item.Timeout != "" {
+		timeout, _ = time.ParseDuration(item.Timeout)
+	}
+
+	if s.config.Dispatcher == nil {
+		r.Error = "no dispatcher"
+		return
+	}
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+
+	sem := make(chan struct{}, maxParallel)
+
+	for _, n := range nodes {
+		// Nodes in maintenance, draining or out of service are left alone.
+		if n.Project != item.Project || !n.Dispatchable() {
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(n model.Node) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			ctx, cancel := context.WithTimeout(s.ctx, timeout)
+			defer cancel()
+
+			id := fmt.Sprintf("schedule-%d-%d-%d", item.Id, r.Id, n.Id)
+			_, err := s.config.Dispatcher.Dispatch(ctx, n.Address, id, item.Command)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if err != nil {
+				r.Failed++
+				if r.Error == "" {
+					r.Error = n.Address + ": " + err.Error()
+				}
+			} else {
+				r.Succeeded++
+			}
+		}(n)
+	}
+
+	wg.Wait()
+}
+
+// next returns the first time of the schedule after t.
+func next(schedule *Schedule, t time.Time) time.Time {
+	c, err := cron.ParseStandard(schedule.Cron)
+	if err != nil {
+		return time.Time{}
+	}
+
+	loc, err := time.LoadLocation(schedule.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	return c.Next(t.In(loc))
+}
@@ -0,0 +1,147 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schedule
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/craftslab/metalflow/etcd"
+	"github.com/craftslab/metalflow/exec"
+	"github.com/craftslab/metalflow/flow"
+	"github.com/craftslab/metalflow/model"
+)
+
+func TestScheduler(t *testing.T) {
+	ctx := model.WithScope(context.Background(), &model.Scope{All: true, Role: model.RoleAdmin})
+
+	var calls int32
+
+	c := DefaultConfig()
+	c.Dispatcher = flow.DispatcherFunc(func(_ context.Context, host, _, command string) (string, error) {
+		atomic.AddInt32(&calls, 1)
+		return "", nil
+	})
+	c.Interval = time.Hour
+
+	s := New(c).(*scheduler)
+	err := s.Start(ctx)
+	assert.Equal(t, nil, err)
+
+	defer s.Stop()
+
+	item, err := s.Add(ctx, &Schedule{
+		Command:  "collect",
+		Cron:     "0 2 * * *",
+		Name:     "nightly",
+		Project:  1,
+		Timezone: "Asia/Shanghai",
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, MissedSkip, item.Missed)
+
+	loc, _ := time.LoadLocation("Asia/Shanghai")
+	assert.Equal(t, 2, item.NextRun.In(loc).Hour())
+
+	for _, bad := range []*Schedule{
+		{Command: "collect", Cron: "0 25 * * *", Name: "bad", Project: 1},
+		{Command: "collect", Cron: "@daily", Name: "bad", Project: 1, Timezone: "Mars/Olympus"},
+		{Command: "collect", Cron: "@daily", Name: "bad", Project: 1, Missed: "never"},
+		{Command: "collect", Cron: "@daily", Name: "bad", Project: 9},
+	} {
+		_, err = s.Add(ctx, bad)
+		assert.NotEqual(t, nil, err)
+	}
+
+	viewer := model.WithScope(ctx, &model.Scope{Projects: map[uint]string{1: model.ProjectRoleViewer}})
+	_, err = s.Add(viewer, &Schedule{Command: "collect", Cron: "@daily", Name: "viewer", Project: 1})
+	assert.Equal(t, model.ErrForbidden, err)
+
+	// A maintainer can only schedule the commands its role is allowed.
+	maintainer := model.WithScope(ctx, &model.Scope{
+		Projects: map[uint]string{1: model.ProjectRoleMaintainer},
+		Role:     model.RoleUser,
+	})
+	_, err = s.Add(maintainer, &Schedule{Command: "collect", Cron: "@daily", Name: "user", Project: 1})
+	assert.Equal(t, exec.ErrNotAllowed, err)
+	_, err = s.Update(maintainer, item.Id, &Schedule{Command: "reboot", Cron: "@daily", Name: "user", Project: 1})
+	assert.Equal(t, exec.ErrNotAllowed, err)
+
+	// Due within the grace period, so it runs.
+	now := item.NextRun.Add(time.Second)
+	s.tick(now)
+	s.runs.Wait()
+
+	runs, err := s.QueryRun(ctx, item.Id, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(runs))
+	assert.Equal(t, StateSucceeded, runs[0].State)
+	assert.Equal(t, int(atomic.LoadInt32(&calls)), runs[0].Succeeded)
+
+	item, _ = s.Get(ctx, item.Id)
+	assert.Equal(t, true, item.NextRun.After(now))
+	assert.Equal(t, false, item.LastRun.IsZero())
+
+	// Missed by a day, so it is skipped, or run once.
+	now = item.NextRun.Add(24 * time.Hour)
+	s.tick(now)
+	s.runs.Wait()
+
+	runs, _ = s.QueryRun(ctx, item.Id, 0)
+	assert.Equal(t, 2, len(runs))
+	assert.Equal(t, StateSkipped, runs[0].State)
+
+	item.Missed = MissedRunOnce
+	item, err = s.Update(ctx, item.Id, item)
+	assert.Equal(t, nil, err)
+
+	s.tick(item.NextRun.Add(24 * time.Hour))
+	s.runs.Wait()
+
+	runs, _ = s.QueryRun(ctx, item.Id, 0)
+	assert.Equal(t, 3, len(runs))
+	assert.Equal(t, StateSucceeded, runs[0].State)
+
+	_, err = s.Delete(viewer, item.Id)
+	assert.Equal(t, model.ErrForbidden, err)
+
+	_, err = s.Delete(ctx, item.Id)
+	assert.Equal(t, nil, err)
+
+	_, err = s.Get(ctx, item.Id)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestEtcdDispatcher(t *testing.T) {
+	e := etcd.New(context.Background(), etcd.DefaultConfig())
+
+	err := e.Open()
+	assert.Equal(t, nil, err)
+
+	defer e.Close()
+
+	ctx := context.Background()
+
+	defer func() { _ = e.Delete(ctx, "/metalflow/worker/test/dispatch") }()
+
+	_, err = NewEtcdDispatcher(e).Dispatch(ctx, "test", "schedule-1-1-1", "uptime")
+	assert.Equal(t, nil, err)
+
+	val, err := e.Get(ctx, "/metalflow/worker/test/dispatch")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "uptime", val)
+}
@@ -0,0 +1,283 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schedule
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/craftslab/metalflow/postgres"
+)
+
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// Store keeps the schedules and their runs. GetSchedule returns nil if not
+// found.
+type Store interface {
+	AddSchedule(ctx context.Context, schedule *Schedule) error
+	PutSchedule(ctx context.Context, schedule *Schedule) error
+	GetSchedule(ctx context.Context, id uint) (*Schedule, error)
+	DelSchedule(ctx context.Context, id uint) error
+	QuerySchedule(ctx context.Context) ([]Schedule, error)
+	AddRun(ctx context.Context, run *Run) error
+	PutRun(ctx context.Context, run *Run) error
+	QueryRun(ctx context.Context, schedule uint, limit int) ([]Run, error)
+}
+
+type memoryStore struct {
+	mutex     sync.RWMutex
+	next      uint
+	runs      []Run
+	schedules map[uint]Schedule
+}
+
+type postgresStore struct {
+	postgres postgres.Postgres
+}
+
+func (Schedule) TableName() string {
+	return "schedules"
+}
+
+func (Run) TableName() string {
+	return "schedule_runs"
+}
+
+func NewMemoryStore() Store {
+	return &memoryStore{
+		schedules: map[uint]Schedule{},
+	}
+}
+
+func NewPostgresStore(p postgres.Postgres) (Store, error) {
+	if err := p.Migrate(&Schedule{}); err != nil {
+		return nil, errors.Wrap(err, "failed to migrate schedule")
+	}
+
+	if err := p.Migrate(&Run{}); err != nil {
+		return nil, errors.Wrap(err, "failed to migrate run")
+	}
+
+	return &postgresStore{postgres: p}, nil
+}
+
+func (m *memoryStore) AddSchedule(_ context.Context, schedule *Schedule) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.next++
+	schedule.Id = m.next
+	schedule.CreatedAt = time.Now()
+	schedule.UpdatedAt = schedule.CreatedAt
+	m.schedules[schedule.Id] = *schedule
+
+	return nil
+}
+
+func (m *memoryStore) PutSchedule(_ context.Context, schedule *Schedule) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.schedules[schedule.Id]; !ok {
+		return errors.New("invalid id")
+	}
+
+	schedule.UpdatedAt = time.Now()
+	m.schedules[schedule.Id] = *schedule
+
+	return nil
+}
+
+func (m *memoryStore) GetSchedule(_ context.Context, id uint) (*Schedule, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	s, ok := m.schedules[id]
+	if !ok {
+		return nil, nil
+	}
+
+	return &s, nil
+}
+
+func (m *memoryStore) DelSchedule(_ context.Context, id uint) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.schedules, id)
+
+	return nil
+}
+
+func (m *memoryStore) QuerySchedule(_ context.Context) ([]Schedule, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	buf := make([]Schedule, 0, len(m.schedules))
+
+	for _, v := range m.schedules {
+		buf = append(buf, v)
+	}
+
+	sort.Slice(buf, func(i, j int) bool {
+		return buf[i].Id < buf[j].Id
+	})
+
+	return buf, nil
+}
+
+func (m *memoryStore) AddRun(_ context.Context, run *Run) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	run.Id = uint(len(m.runs)) + 1
+	m.runs = append(m.runs, *run)
+
+	return nil
+}
+
+func (m *memoryStore) PutRun(_ context.Context, run *Run) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if run.Id == 0 || int(run.Id) > len(m.runs) {
+		return errors.New("invalid id")
+	}
+
+	m.runs[run.Id-1] = *run
+
+	return nil
+}
+
+func (m *memoryStore) QueryRun(_ context.Context, schedule uint, limit int) ([]Run, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	limit = limitOf(limit)
+	buf := make([]Run, 0)
+
+	for i := len(m.runs) - 1; i >= 0 && len(buf) < limit; i-- {
+		if m.runs[i].ScheduleId == schedule {
+			buf = append(buf, m.runs[i])
+		}
+	}
+
+	return buf, nil
+}
+
+func (p *postgresStore) AddSchedule(ctx context.Context, schedule *Schedule) error {
+	p.postgres.WithContext(ctx).Create(schedule)
+
+	if schedule.Id == 0 {
+		return errors.New("failed to create")
+	}
+
+	return nil
+}
+
+func (p *postgresStore) PutSchedule(ctx context.Context, schedule *Schedule) error {
+	var s Schedule
+
+	p.postgres.WithContext(ctx).Raw(&s, `UPDATE schedules SET command = ?, cron = ?, last_run = ?, missed = ?, name = ?,
+next_run = ?, paused = ?, project = ?, selector = ?, timeout = ?, timezone = ?, updated_at = ?
+WHERE id = ? RETURNING id`, schedule.Command, schedule.Cron, schedule.LastRun, schedule.Missed, schedule.Name,
+		schedule.NextRun, schedule.Paused, schedule.Project, schedule.Selector, schedule.Timeout, schedule.Timezone,
+		time.Now(), schedule.Id)
+
+	if s.Id == 0 {
+		return errors.New("failed to put")
+	}
+
+	return nil
+}
+
+func (p *postgresStore) GetSchedule(ctx context.Context, id uint) (*Schedule, error) {
+	var s Schedule
+
+	p.postgres.WithContext(ctx).Read(&s, "id = ?", id)
+
+	if s.Id == 0 {
+		return nil, nil
+	}
+
+	return &s, nil
+}
+
+func (p *postgresStore) DelSchedule(ctx context.Context, id uint) error {
+	p.postgres.WithContext(ctx).Delete(&Schedule{}, "id = ?", id)
+
+	return nil
+}
+
+func (p *postgresStore) QuerySchedule(ctx context.Context) ([]Schedule, error) {
+	buf := make([]Schedule, 0)
+
+	p.postgres.WithContext(ctx).Query(&buf, &postgres.Filter{Order: "id"})
+
+	return buf, nil
+}
+
+func (p *postgresStore) AddRun(ctx context.Context, run *Run) error {
+	p.postgres.WithContext(ctx).Create(run)
+
+	if run.Id == 0 {
+		return errors.New("failed to create")
+	}
+
+	return nil
+}
+
+func (p *postgresStore) PutRun(ctx context.Context, run *Run) error {
+	var r Run
+
+	p.postgres.WithContext(ctx).Raw(&r, `UPDATE schedule_runs SET error = ?, failed = ?, finished_at = ?, state = ?,
+succeeded = ? WHERE id = ? RETURNING id`, run.Error, run.Failed, run.FinishedAt, run.State, run.Succeeded, run.Id)
+
+	if r.Id == 0 {
+		return errors.New("failed to put")
+	}
+
+	return nil
+}
+
+func (p *postgresStore) QueryRun(ctx context.Context, schedule uint, limit int) ([]Run, error) {
+	buf := make([]Run, 0)
+
+	p.postgres.WithContext(ctx).Query(&buf, &postgres.Filter{
+		Cond:   "schedule_id = ?",
+		Values: []interface{}{schedule},
+		Order:  "id desc",
+		Limit:  limitOf(limit),
+	})
+
+	return buf, nil
+}
+
+func limitOf(limit int) int {
+	if limit <= 0 {
+		return defaultLimit
+	}
+
+	if limit > maxLimit {
+		return maxLimit
+	}
+
+	return limit
+}
@@ -10,6 +10,10 @@ go env -w GOPROXY=https://goproxy.cn,direct
 
 CGO_ENABLED=0 GOARCH=amd64 GOOS=linux go build -ldflags "$ldflags" -o bin/$target main.go
 CGO_ENABLED=0 GOARCH=amd64 GOOS=windows go build -ldflags "$ldflags" -o bin/$target.exe main.go
+CGO_ENABLED=0 GOARCH=amd64 GOOS=linux go build -ldflags "$ldflags" -o bin/metalctl ./metalctl
+CGO_ENABLED=0 GOARCH=amd64 GOOS=windows go build -ldflags "$ldflags" -o bin/metalctl.exe ./metalctl
 
 upx bin/$target
 upx bin/$target.exe
+upx bin/metalctl
+upx bin/metalctl.exe
@@ -1,7 +1,7 @@
 #!/bin/bash
 
 # USAGE: https://github.com/swaggo/swag/blob/master/README.md
-# WEB: http://127.0.0.1:9080/swagger/index.html
+# WEB: http://127.0.0.1:9080/swagger/v1/index.html
 
 release=1.7.0
 
@@ -15,6 +15,6 @@ curl -L https://github.com/swaggo/swag/releases/download/v${release}/swag_${rele
 tar zxvf swag.tar.gz -C swag/
 rm -rf swag.tar.gz
 
-./swag/swag init
+./swag/swag init -o docs/v1
 
 rm -rf swag
@@ -0,0 +1,143 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	ExporterNone   = "none"
+	ExporterOtlp   = "otlp"
+	ExporterStdout = "stdout"
+
+	name = "github.com/craftslab/metalflow"
+)
+
+type Tracing interface {
+	Init() error
+	Shutdown(ctx context.Context) error
+}
+
+type Config struct {
+	Endpoint    string
+	Exporter    string
+	Insecure    bool
+	Output      io.Writer
+	SampleRatio float64
+	ServiceName string
+}
+
+type tracing struct {
+	config   *Config
+	context  context.Context
+	provider *sdktrace.TracerProvider
+}
+
+func New(ctx context.Context, config *Config) Tracing {
+	return &tracing{
+		config:   config,
+		context:  ctx,
+		provider: nil,
+	}
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Endpoint:    "127.0.0.1:4318",
+		Exporter:    ExporterNone,
+		Insecure:    true,
+		Output:      os.Stdout,
+		SampleRatio: 1,
+		ServiceName: "metalflow",
+	}
+}
+
+// Init installs the global tracer provider and the W3C trace context
+// propagator. With ExporterNone spans are still propagated but not exported.
+func (t *tracing) Init() error {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+
+	switch t.config.Exporter {
+	case ExporterNone, "":
+		return nil
+	case ExporterOtlp:
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(t.config.Endpoint)}
+		if t.config.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(t.context, opts...)
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(t.config.Output))
+	default:
+		return errors.New("invalid exporter " + t.config.Exporter)
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "failed to new exporter")
+	}
+
+	t.provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(t.config.ServiceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(t.config.SampleRatio))),
+	)
+
+	otel.SetTracerProvider(t.provider)
+
+	return nil
+}
+
+func (t *tracing) Shutdown(ctx context.Context) error {
+	if t.provider == nil {
+		return nil
+	}
+
+	if err := t.provider.Shutdown(ctx); err != nil {
+		return errors.Wrap(err, "failed to shutdown")
+	}
+
+	return nil
+}
+
+// Start starts a span as a child of the one in ctx, if any.
+func Start(ctx context.Context, span string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(name).Start(ctx, span, trace.WithAttributes(attrs...))
+}
+
+// End records err, if any, on span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
@@ -0,0 +1,85 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	traceId     = "4bf92f3577b34da6a3ce929d0e0e4736"
+	traceParent = "00-" + traceId + "-00f067aa0ba902b7-01"
+)
+
+func TestInit(t *testing.T) {
+	c := DefaultConfig()
+	c.Exporter = "invalid"
+
+	err := New(context.Background(), c).Init()
+	assert.NotEqual(t, nil, err)
+
+	c.Exporter = ExporterNone
+	tr := New(context.Background(), c)
+
+	err = tr.Init()
+	assert.Equal(t, nil, err)
+
+	err = tr.Shutdown(context.Background())
+	assert.Equal(t, nil, err)
+}
+
+func TestMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+
+	c := DefaultConfig()
+	c.Exporter = ExporterStdout
+	c.Output = &buf
+
+	tr := New(context.Background(), c)
+	err := tr.Init()
+	assert.Equal(t, nil, err)
+
+	gin.SetMode(gin.TestMode)
+
+	var sc trace.SpanContext
+
+	r := gin.New()
+	r.Use(Middleware())
+	r.GET("/nodes/:id", func(ctx *gin.Context) {
+		_, span := Start(ctx.Request.Context(), "model.GetNode")
+		sc = span.SpanContext()
+		End(span, nil)
+		ctx.Status(http.StatusOK)
+	})
+
+	req, _ := http.NewRequest("GET", "/nodes/1", nil)
+	req.Header.Set("traceparent", traceParent)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, traceId, sc.TraceID().String())
+
+	err = tr.Shutdown(context.Background())
+	assert.Equal(t, nil, err)
+
+	assert.Equal(t, true, strings.Contains(buf.String(), `"Name":"GET /nodes/:id"`))
+	assert.Equal(t, true, strings.Contains(buf.String(), `"Name":"model.GetNode"`))
+}
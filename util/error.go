@@ -2,7 +2,7 @@
 // you may not use this file except in compliance with the License.
 // You may obtain a copy of the License at
 //
-//     http://www.apache.org/licenses/LICENSE-2.0
+//	http://www.apache.org/licenses/LICENSE-2.0
 //
 // Unless required by applicable law or agreed to in writing, software
 // distributed under the License is distributed on an "AS IS" BASIS,
@@ -13,19 +13,181 @@
 package util
 
 import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"unicode"
+
 	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+
+	"github.com/craftslab/metalflow/logger"
+)
+
+const (
+	ProblemType = "application/problem+json"
+
+	CodeValidationFailed = "validation.failed"
+
+	typePrefix = "https://github.com/craftslab/metalflow#"
 )
 
-type HTTPError struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+// codes are the codes of the errors without one, by status.
+var codes = map[int]string{
+	http.StatusBadRequest:            "request.invalid",
+	http.StatusUnauthorized:          "auth.unauthorized",
+	http.StatusForbidden:             "auth.forbidden",
+	http.StatusNotFound:              "request.not_found",
+	http.StatusConflict:              "request.conflict",
+	http.StatusPreconditionFailed:    "request.precondition_failed",
+	http.StatusRequestEntityTooLarge: "request.too_large",
+	http.StatusUnsupportedMediaType:  "request.unsupported_media_type",
+	http.StatusPreconditionRequired:  "request.precondition_required",
+	http.StatusTooManyRequests:       "request.rate_limited",
+	http.StatusInternalServerError:   "server.internal",
+	http.StatusBadGateway:            "server.bad_gateway",
+	http.StatusServiceUnavailable:    "server.unavailable",
+}
+
+// Problem is the body of error responses, as of RFC 7807. Code is stable for
+// clients to rely on, unlike Detail.
+type Problem struct {
+	Code      string       `json:"code" example:"node.not_found"`
+	Detail    string       `json:"detail" example:"invalid id"`
+	Errors    []FieldError `json:"errors,omitempty"`
+	Instance  string       `json:"instance" example:"/nodes/42"`
+	RequestId string       `json:"requestId" example:"5f2b6c1e9a7d4e03b8c1d2e3f4a5b6c7"`
+	Status    int          `json:"status" example:"404"`
+	Title     string       `json:"title" example:"Not Found"`
+	Type      string       `json:"type" example:"https://github.com/craftslab/metalflow#node.not_found"`
+}
+
+// FieldError tells why a field of the request is invalid.
+type FieldError struct {
+	Field   string `json:"field" example:"email"`
+	Message string `json:"message" example:"invalid email"`
+}
+
+// Error is an error with its code, and the fields it is about if any.
+type Error struct {
+	Code   string
+	Err    error
+	Fields []FieldError
 }
 
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// WithCode annotates err with code.
+func WithCode(code string, err error) error {
+	return &Error{Code: code, Err: err}
+}
+
+// Invalid annotates err as failing the validation of field.
+func Invalid(field string, err error) error {
+	return &Error{
+		Code:   CodeValidationFailed,
+		Err:    err,
+		Fields: []FieldError{{Field: field, Message: err.Error()}},
+	}
+}
+
+// NewError records err with status on ctx and aborts it, for Problems to
+// answer once handled. Callers shall return right after it.
 func NewError(ctx *gin.Context, status int, err error) {
-	e := HTTPError{
-		Code:    status,
-		Message: err.Error(),
+	ctx.Status(status)
+	ctx.Abort()
+	_ = ctx.Error(err)
+}
+
+// Problems answers the last error recorded by the handlers as a Problem,
+// unless they have written a response already.
+func Problems() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Next()
+
+		if len(ctx.Errors) == 0 || ctx.Writer.Written() {
+			return
+		}
+
+		status := ctx.Writer.Status()
+		if status < http.StatusBadRequest {
+			status = http.StatusInternalServerError
+		}
+
+		buf, err := json.Marshal(NewProblem(ctx, status, ctx.Errors.Last().Err))
+		if err != nil {
+			ctx.Status(http.StatusInternalServerError)
+			return
+		}
+
+		ctx.Data(status, ProblemType, buf)
+	}
+}
+
+// NewProblem describes err answered with status to the request of ctx.
+func NewProblem(ctx *gin.Context, status int, err error) *Problem {
+	p := &Problem{
+		Code:      codes[status],
+		Detail:    err.Error(),
+		Instance:  ctx.Request.URL.Path,
+		RequestId: logger.RequestId(ctx.Request.Context()),
+		Status:    status,
+		Title:     http.StatusText(status),
+	}
+
+	var e *Error
+	var v validator.ValidationErrors
+
+	switch {
+	case errors.As(err, &e):
+		p.Code = e.Code
+		p.Errors = e.Fields
+	case errors.As(err, &v):
+		p.Code = CodeValidationFailed
+		for _, f := range v {
+			p.Errors = append(p.Errors, FieldError{Field: lowerFirst(f.Field()), Message: "failed on " + f.Tag()})
+		}
+		p.Detail = "invalid " + strings.Join(fieldNames(p.Errors), ", ")
+	}
+
+	if p.Code == "" {
+		if status >= http.StatusInternalServerError {
+			p.Code = "server.error"
+		} else {
+			p.Code = "request.failed"
+		}
 	}
 
-	ctx.JSON(status, e)
+	p.Type = typePrefix + p.Code
+
+	return p
+}
+
+func fieldNames(fields []FieldError) []string {
+	var names []string
+
+	for _, f := range fields {
+		names = append(names, f.Field)
+	}
+
+	return names
+}
+
+// lowerFirst turns the name of a struct field to its JSON name.
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+
+	r := []rune(s)
+	r[0] = unicode.ToLower(r[0])
+
+	return string(r)
 }
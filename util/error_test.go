@@ -0,0 +1,73 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProblems(t *testing.T) {
+	type request struct {
+		MfaToken string `json:"mfaToken" binding:"required"`
+	}
+
+	gin.SetMode(gin.TestMode)
+
+	e := gin.New()
+	e.Use(Problems())
+	e.POST("/bind", func(ctx *gin.Context) {
+		var r request
+		if err := ctx.ShouldBindJSON(&r); err != nil {
+			NewError(ctx, http.StatusBadRequest, err)
+			return
+		}
+		ctx.JSON(http.StatusOK, r)
+	})
+	e.GET("/error", func(ctx *gin.Context) {
+		NewError(ctx, http.StatusServiceUnavailable, errors.New("unavailable"))
+	})
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/bind", strings.NewReader("{}"))
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, ProblemType, rec.Header().Get("Content-Type"))
+
+	var p Problem
+	err := json.NewDecoder(rec.Body).Decode(&p)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, CodeValidationFailed, p.Code)
+	assert.Equal(t, []FieldError{{Field: "mfaToken", Message: "failed on required"}}, p.Errors)
+	assert.Equal(t, "/bind", p.Instance)
+
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/error", nil)
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	p = Problem{}
+	err = json.NewDecoder(rec.Body).Decode(&p)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "server.unavailable", p.Code)
+	assert.Equal(t, "Service Unavailable", p.Title)
+	assert.Equal(t, "unavailable", p.Detail)
+	assert.Equal(t, typePrefix+"server.unavailable", p.Type)
+}